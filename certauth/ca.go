@@ -6,7 +6,9 @@ import (
 	"crypto/tls"
 	"crypto/x509"
 	"crypto/x509/pkix"
+	"encoding/asn1"
 	"encoding/pem"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"math/big"
@@ -19,10 +21,87 @@ import (
 type CertificateAuthority struct {
 	Key         *rsa.PrivateKey
 	Certificate *x509.Certificate
+
+	// ExtKeyUsage is the set of extended key usages included in newly
+	// generated and cloned certificates. If it is empty, only ServerAuth is
+	// used. Note that the CA certificate itself needs to allow the usages.
+	ExtKeyUsage []x509.ExtKeyUsage
+
+	// CTPoison adds the critical Certificate Transparency poison extension
+	// (RFC 6962, section 3.1) to generated and cloned certificates, marking
+	// them as precertificates which are never submitted to a CT log. Clients
+	// which don't understand the extension reject such certificates, so it is
+	// only useful for testing how a client handles CT.
+	CTPoison bool
+
+	// LeafSubject contains attributes like Organization and Country to set in
+	// the subject of generated and cloned certificates. Attributes which are
+	// empty are not changed, the common name is always the one passed to
+	// NewCertificate or the one of the cloned certificate.
+	LeafSubject pkix.Name
+}
+
+// OIDs of the Certificate Transparency extensions, see RFC 6962.
+var (
+	oidCTPoison = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11129, 2, 4, 3}
+	oidSCTList  = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11129, 2, 4, 2}
+)
+
+// ctExtensions returns the CT extensions to include in new certificates.
+// Signed certificate timestamps are never included: they are only valid for
+// the certificate issued by the original CA.
+func (ca *CertificateAuthority) ctExtensions() []pkix.Extension {
+	if !ca.CTPoison {
+		return nil
+	}
+	return []pkix.Extension{{
+		Id:       oidCTPoison,
+		Critical: true,
+		Value:    asn1.NullBytes,
+	}}
 }
 
-// NewCA creates a new certificate authority.
+// extKeyUsage returns the extended key usages configured for new certificates.
+func (ca *CertificateAuthority) extKeyUsage() []x509.ExtKeyUsage {
+	if len(ca.ExtKeyUsage) == 0 {
+		return []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	}
+	return ca.ExtKeyUsage
+}
+
+// mergeExtKeyUsage returns list with all usages from add appended which are
+// not already included.
+func mergeExtKeyUsage(list, add []x509.ExtKeyUsage) []x509.ExtKeyUsage {
+	res := append([]x509.ExtKeyUsage{}, list...)
+	for _, usage := range add {
+		var found bool
+		for _, existing := range res {
+			if existing == usage {
+				found = true
+				break
+			}
+		}
+		if !found {
+			res = append(res, usage)
+		}
+	}
+	return res
+}
+
+// NewCA creates a new certificate authority with DefaultCASubject.
 func NewCA() (*CertificateAuthority, error) {
+	return NewCAWithSubject(DefaultCASubject)
+}
+
+// NewCAWithSubject creates a new certificate authority, subject is used for
+// the CA certificate and becomes the issuer of all certificates it creates.
+// It needs at least a common name or an organization.
+func NewCAWithSubject(subject pkix.Name) (*CertificateAuthority, error) {
+	err := validateSubject(subject)
+	if err != nil {
+		return nil, err
+	}
+
 	// adapter from https://golang.org/src/crypto/tls/generate_cert.go
 	key, err := rsa.GenerateKey(rand.Reader, 2048)
 	if err != nil {
@@ -31,15 +110,15 @@ func NewCA() (*CertificateAuthority, error) {
 
 	template := &x509.Certificate{
 		SerialNumber: big.NewInt(time.Now().UnixNano()),
-		Subject: pkix.Name{
-			Organization: []string{"Osmosis Interception Proxy CA"},
-		},
-		NotBefore: time.Now(),
-		NotAfter:  time.Now().Add(3650 * 24 * time.Hour), // 10 years
-
-		IsCA:                  true,
-		KeyUsage:              x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
-		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		Subject:      subject,
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(3650 * 24 * time.Hour), // 10 years
+
+		IsCA:     true,
+		KeyUsage: x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		// the EKU of a CA restricts the EKUs of the certificates it issues,
+		// so allow client certificates as well
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
 		BasicConstraintsValid: true,
 	}
 
@@ -74,6 +153,11 @@ func Load(certfile, keyfile string) (*CertificateAuthority, error) {
 		return nil, err
 	}
 
+	err = checkKeyPair(cert, key)
+	if err != nil {
+		return nil, fmt.Errorf("%v and %v: %v", certfile, keyfile, err)
+	}
+
 	ca := &CertificateAuthority{
 		Key:         key,
 		Certificate: cert,
@@ -82,6 +166,56 @@ func Load(certfile, keyfile string) (*CertificateAuthority, error) {
 	return ca, nil
 }
 
+// checkKeyPair returns an error unless key is the private key for the public
+// key in cert.
+func checkKeyPair(cert *x509.Certificate, key *rsa.PrivateKey) error {
+	pub, ok := cert.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return fmt.Errorf("CA certificate contains a %T public key, only RSA is supported", cert.PublicKey)
+	}
+	if pub.N.Cmp(key.N) != 0 || pub.E != key.E {
+		return errors.New("private key does not match the CA certificate")
+	}
+	return nil
+}
+
+// Verify generates a certificate and checks that it chains to the CA
+// certificate, so that a broken CA is detected before clients reject all
+// certificates presented by the proxy.
+func (ca *CertificateAuthority) Verify() error {
+	err := checkKeyPair(ca.Certificate, ca.Key)
+	if err != nil {
+		return err
+	}
+
+	const name = "verify.osmosis.invalid"
+	leaf, err := ca.NewCertificate(name, []string{name})
+	if err != nil {
+		return fmt.Errorf("generating test certificate: %v", err)
+	}
+
+	// the CT poison extension is handled by clients which accept it
+	var unhandled []asn1.ObjectIdentifier
+	for _, oid := range leaf.UnhandledCriticalExtensions {
+		if !oid.Equal(oidCTPoison) {
+			unhandled = append(unhandled, oid)
+		}
+	}
+	leaf.UnhandledCriticalExtensions = unhandled
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.Certificate)
+	_, err = leaf.Verify(x509.VerifyOptions{
+		DNSName:   name,
+		Roots:     roots,
+		KeyUsages: ca.extKeyUsage(),
+	})
+	if err != nil {
+		return fmt.Errorf("generated certificate does not chain to the CA certificate: %v", err)
+	}
+	return nil
+}
+
 // WriteCertificate creates filename and writes the certificate c to it,
 // encoded in PEM.
 func WriteCertificate(filename string, c *x509.Certificate) error {
@@ -162,25 +296,31 @@ func (ca *CertificateAuthority) CertificateAsPEM() []byte {
 }
 
 // NewCertificate creates a new certificate for the given host name or IP address.
+// The subject contains the attributes from LeafSubject.
 func (ca *CertificateAuthority) NewCertificate(commonName string, names []string) (*x509.Certificate, error) {
+	err := validateCountries(ca.LeafSubject.Country)
+	if err != nil {
+		return nil, err
+	}
+
 	// generate random 64 bit serial
 	serial := make([]byte, 8)
-	_, err := rand.Read(serial)
+	_, err = rand.Read(serial)
 	if err != nil {
 		panic(err)
 	}
 
 	template := &x509.Certificate{
 		SerialNumber: big.NewInt(0).SetBytes(serial),
-		Subject: pkix.Name{
-			CommonName: commonName,
-		},
-		NotBefore: time.Now(),
-		NotAfter:  time.Now().Add(3650 * 24 * time.Hour), // 10 years
+		Subject:      overrideSubject(pkix.Name{CommonName: commonName}, ca.LeafSubject, false),
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(3650 * 24 * time.Hour), // 10 years
 
 		KeyUsage:              x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature,
-		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		ExtKeyUsage:           ca.extKeyUsage(),
 		BasicConstraintsValid: true,
+
+		ExtraExtensions: ca.ctExtensions(),
 	}
 
 	for _, name := range names {
@@ -207,17 +347,25 @@ func (ca *CertificateAuthority) NewCertificate(commonName string, names []string
 }
 
 // Clone creates a new certificate based the certificate c and signs it with the CA.
+// The attributes set in LeafSubject replace the ones of c.
 func (ca *CertificateAuthority) Clone(c *x509.Certificate) (*x509.Certificate, error) {
+	err := validateCountries(ca.LeafSubject.Country)
+	if err != nil {
+		return nil, err
+	}
+
 	template := &x509.Certificate{
 		SerialNumber: c.SerialNumber,
-		Subject:      c.Subject,
+		Subject:      overrideSubject(c.Subject, ca.LeafSubject, false),
 		NotBefore:    c.NotBefore,
 		NotAfter:     c.NotAfter,
 
-		KeyUsage:    c.KeyUsage,
-		ExtKeyUsage: c.ExtKeyUsage,
+		KeyUsage: c.KeyUsage,
 
+		// Extensions is ignored when creating a certificate, so SCTs of the
+		// original certificate are not copied
 		Extensions:        c.Extensions,
+		ExtraExtensions:   ca.ctExtensions(),
 		PolicyIdentifiers: c.PolicyIdentifiers,
 
 		DNSNames:       c.DNSNames,
@@ -228,8 +376,19 @@ func (ca *CertificateAuthority) Clone(c *x509.Certificate) (*x509.Certificate, e
 		BasicConstraintsValid: true,
 	}
 
-	// make sure that all extra attributes are included in the new cert
-	template.Subject.ExtraNames = template.Subject.Names
+	// make sure that all extra attributes are included in the new cert,
+	// except for those replaced with LeafSubject
+	for _, attr := range c.Subject.Names {
+		if !leafSubjectOverrides(ca.LeafSubject, attr.Type) {
+			template.Subject.ExtraNames = append(template.Subject.ExtraNames, attr)
+		}
+	}
+
+	// add the usages configured for the CA, if any
+	template.ExtKeyUsage = c.ExtKeyUsage
+	if len(ca.ExtKeyUsage) > 0 {
+		template.ExtKeyUsage = mergeExtKeyUsage(c.ExtKeyUsage, ca.ExtKeyUsage)
+	}
 
 	template.Raw = nil
 	template.RawTBSCertificate = nil
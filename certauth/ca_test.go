@@ -1,9 +1,20 @@
 package certauth
 
 import (
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
 	"flag"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 )
 
 var updateGoldenFiles bool
@@ -50,3 +61,215 @@ func BenchmarkNewCertificate(b *testing.B) {
 		}
 	}
 }
+
+func TestNewCertificateClientAuth(t *testing.T) {
+	ca := TestNewCA(t)
+	ca.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth}
+
+	crt, err := ca.NewCertificate("localhost", []string{"localhost"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.Certificate)
+
+	serverCfg := &tls.Config{
+		Certificates: []tls.Certificate{*ca.TLSCert(crt)},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    pool,
+	}
+
+	clientCfg := &tls.Config{
+		Certificates: []tls.Certificate{*ca.TLSCert(crt)},
+		RootCAs:      pool,
+		ServerName:   "localhost",
+	}
+
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	errCh := make(chan error, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			errCh <- err
+			return
+		}
+		defer conn.Close()
+		errCh <- tls.Server(conn, serverCfg).Handshake()
+	}()
+
+	conn, err := tls.Dial("tcp", listener.Addr().String(), clientCfg)
+	if err != nil {
+		t.Fatalf("client handshake failed: %v", err)
+	}
+	defer conn.Close()
+
+	err = <-errCh
+	if err != nil {
+		t.Fatalf("server rejected client certificate: %v", err)
+	}
+}
+
+func TestCloneExtKeyUsage(t *testing.T) {
+	ca := TestCA(t)
+
+	crt, err := ca.NewCertificate("foo", []string{"foo"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ca.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+
+	cloned, err := ca.Clone(crt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth}
+	if len(cloned.ExtKeyUsage) != len(want) {
+		t.Fatalf("wrong ExtKeyUsage for cloned certificate, want %v, got %v", want, cloned.ExtKeyUsage)
+	}
+	for i := range want {
+		if cloned.ExtKeyUsage[i] != want[i] {
+			t.Fatalf("wrong ExtKeyUsage for cloned certificate, want %v, got %v", want, cloned.ExtKeyUsage)
+		}
+	}
+}
+
+// findExtension returns the extension with the id in c, if present.
+func findExtension(c *x509.Certificate, id asn1.ObjectIdentifier) (pkix.Extension, bool) {
+	for _, ext := range c.Extensions {
+		if ext.Id.Equal(id) {
+			return ext, true
+		}
+	}
+	return pkix.Extension{}, false
+}
+
+func TestCertificateTransparency(t *testing.T) {
+	ca := TestCA(t)
+
+	// an upstream certificate with embedded SCTs
+	upstreamCA := TestNewCA(t)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(23),
+		Subject:      pkix.Name{CommonName: "example.com"},
+		DNSNames:     []string{"example.com"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtraExtensions: []pkix.Extension{
+			{Id: oidSCTList, Value: []byte{0x04, 0x02, 0x00, 0x00}},
+		},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, upstreamCA.Certificate, upstreamCA.Key.Public(), upstreamCA.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	upstream, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := findExtension(upstream, oidSCTList); !ok {
+		t.Fatal("test certificate has no SCT list")
+	}
+
+	for _, poison := range []bool{false, true} {
+		ca.CTPoison = poison
+
+		generated, err := ca.NewCertificate("example.com", []string{"example.com"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		cloned, err := ca.Clone(upstream)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, crt := range []*x509.Certificate{generated, cloned} {
+			if _, ok := findExtension(crt, oidSCTList); ok {
+				t.Errorf("certificate for %v contains SCTs", crt.Subject.CommonName)
+			}
+
+			ext, ok := findExtension(crt, oidCTPoison)
+			if ok != poison {
+				t.Errorf("CTPoison %v: poison extension present: %v", poison, ok)
+			}
+			if ok && !ext.Critical {
+				t.Errorf("poison extension is not critical")
+			}
+		}
+	}
+}
+
+func TestLoadMismatchedKey(t *testing.T) {
+	tempdir, err := ioutil.TempDir("", "osmosis-certauth-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempdir)
+
+	ca := TestCA(t)
+	other := TestNewCA(t)
+
+	certfile := filepath.Join(tempdir, "ca.crt")
+	keyfile := filepath.Join(tempdir, "ca.key")
+
+	err = ca.Save(certfile, keyfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = Load(certfile, keyfile)
+	if err != nil {
+		t.Fatalf("loading matching files failed: %v", err)
+	}
+
+	err = WritePrivateKey(keyfile, other.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = Load(certfile, keyfile)
+	if err == nil {
+		t.Fatal("loading mismatched certificate and key succeeded")
+	}
+	if !strings.Contains(err.Error(), "does not match") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	ca := TestCA(t)
+	err := ca.Verify()
+	if err != nil {
+		t.Fatalf("valid CA: %v", err)
+	}
+
+	ca.CTPoison = true
+	err = ca.Verify()
+	if err != nil {
+		t.Fatalf("valid CA with CT poison: %v", err)
+	}
+
+	mismatched := &CertificateAuthority{
+		Certificate: ca.Certificate,
+		Key:         TestNewCA(t).Key,
+	}
+	err = mismatched.Verify()
+	if err == nil {
+		t.Fatal("CA with mismatched key passed verification")
+	}
+
+	// the test CA certificate doesn't allow client auth
+	ca.CTPoison = false
+	ca.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	err = ca.Verify()
+	if err == nil {
+		t.Fatal("CA certificate without client auth usage passed verification")
+	}
+}
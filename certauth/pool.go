@@ -0,0 +1,58 @@
+package certauth
+
+import (
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// LoadCertPool returns the system certificate pool with the certificates
+// read from paths added. Each path is either a PEM file or a directory, in
+// which all files are read and those not containing certificates are
+// ignored.
+func LoadCertPool(paths []string) (*x509.CertPool, error) {
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+
+	for _, path := range paths {
+		fi, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+
+		if !fi.IsDir() {
+			buf, err := ioutil.ReadFile(path)
+			if err != nil {
+				return nil, err
+			}
+
+			if !pool.AppendCertsFromPEM(buf) {
+				return nil, fmt.Errorf("no certificates found in %v", path)
+			}
+			continue
+		}
+
+		entries, err := ioutil.ReadDir(path)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, entry := range entries {
+			if !entry.Mode().IsRegular() {
+				continue
+			}
+
+			buf, err := ioutil.ReadFile(filepath.Join(path, entry.Name()))
+			if err != nil {
+				return nil, err
+			}
+			pool.AppendCertsFromPEM(buf)
+		}
+	}
+
+	return pool, nil
+}
@@ -0,0 +1,146 @@
+package certauth
+
+import (
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"strings"
+)
+
+// DefaultCASubject is the subject of CAs created with NewCA.
+var DefaultCASubject = pkix.Name{
+	Organization: []string{"Osmosis Interception Proxy CA"},
+}
+
+// CASubjects contains subjects resembling those of well-known root CAs, for
+// use with NewCAWithSubject in demonstrations. Certificates issued by the CA
+// carry the subject as their issuer.
+var CASubjects = map[string]pkix.Name{
+	"digicert": {
+		Country:            []string{"US"},
+		Organization:       []string{"DigiCert Inc"},
+		OrganizationalUnit: []string{"www.digicert.com"},
+		CommonName:         "DigiCert Global Root G2",
+	},
+	"globalsign": {
+		Organization:       []string{"GlobalSign"},
+		OrganizationalUnit: []string{"GlobalSign Root CA - R3"},
+		CommonName:         "GlobalSign",
+	},
+	"letsencrypt": {
+		Country:      []string{"US"},
+		Organization: []string{"Internet Security Research Group"},
+		CommonName:   "ISRG Root X1",
+	},
+}
+
+// validateSubject returns an error if the subject has neither a common name
+// nor an organization, or if a country is not a two letter code.
+func validateSubject(name pkix.Name) error {
+	if name.CommonName == "" && len(name.Organization) == 0 {
+		return fmt.Errorf("subject needs a common name (CN) or an organization (O)")
+	}
+
+	return validateCountries(name.Country)
+}
+
+// validateCountries returns an error if a country is not a two letter code.
+func validateCountries(countries []string) error {
+	for _, country := range countries {
+		if len(country) != 2 || strings.ToUpper(country) != country {
+			return fmt.Errorf("invalid country %q, want a two letter code like DE", country)
+		}
+	}
+	return nil
+}
+
+// ParseSubject parses a subject in the form "CN=name,O=organization,C=DE"
+// (also OU, L and ST), or returns the entry of CASubjects if s is one of its
+// names. Attributes may be given multiple times, except for CN.
+func ParseSubject(s string) (pkix.Name, error) {
+	if name, ok := CASubjects[s]; ok {
+		return name, nil
+	}
+
+	var name pkix.Name
+	for _, attr := range strings.Split(s, ",") {
+		parts := strings.SplitN(attr, "=", 2)
+		if len(parts) != 2 {
+			return pkix.Name{}, fmt.Errorf("invalid subject attribute %q, want KEY=value", attr)
+		}
+
+		key, value := strings.ToUpper(strings.TrimSpace(parts[0])), strings.TrimSpace(parts[1])
+		switch key {
+		case "CN":
+			if name.CommonName != "" {
+				return pkix.Name{}, fmt.Errorf("duplicate common name in subject")
+			}
+			name.CommonName = value
+		case "O":
+			name.Organization = append(name.Organization, value)
+		case "OU":
+			name.OrganizationalUnit = append(name.OrganizationalUnit, value)
+		case "C":
+			name.Country = append(name.Country, value)
+		case "L":
+			name.Locality = append(name.Locality, value)
+		case "ST":
+			name.Province = append(name.Province, value)
+		default:
+			return pkix.Name{}, fmt.Errorf("unknown subject attribute %q", key)
+		}
+	}
+
+	return name, validateSubject(name)
+}
+
+// overrideSubject returns name with all attributes which are set in override
+// replaced, the common name is only replaced if withCommonName is true.
+func overrideSubject(name, override pkix.Name, withCommonName bool) pkix.Name {
+	if len(override.Country) > 0 {
+		name.Country = override.Country
+	}
+	if len(override.Organization) > 0 {
+		name.Organization = override.Organization
+	}
+	if len(override.OrganizationalUnit) > 0 {
+		name.OrganizationalUnit = override.OrganizationalUnit
+	}
+	if len(override.Locality) > 0 {
+		name.Locality = override.Locality
+	}
+	if len(override.Province) > 0 {
+		name.Province = override.Province
+	}
+	if withCommonName && override.CommonName != "" {
+		name.CommonName = override.CommonName
+	}
+	return name
+}
+
+// OIDs of the subject attributes which can be set in LeafSubject.
+var (
+	oidCountry            = asn1.ObjectIdentifier{2, 5, 4, 6}
+	oidLocality           = asn1.ObjectIdentifier{2, 5, 4, 7}
+	oidProvince           = asn1.ObjectIdentifier{2, 5, 4, 8}
+	oidOrganization       = asn1.ObjectIdentifier{2, 5, 4, 10}
+	oidOrganizationalUnit = asn1.ObjectIdentifier{2, 5, 4, 11}
+)
+
+// leafSubjectOverrides returns true if the attribute with the OID typ is set
+// in override, see overrideSubject.
+func leafSubjectOverrides(override pkix.Name, typ asn1.ObjectIdentifier) bool {
+	switch {
+	case typ.Equal(oidCountry):
+		return len(override.Country) > 0
+	case typ.Equal(oidLocality):
+		return len(override.Locality) > 0
+	case typ.Equal(oidProvince):
+		return len(override.Province) > 0
+	case typ.Equal(oidOrganization):
+		return len(override.Organization) > 0
+	case typ.Equal(oidOrganizationalUnit):
+		return len(override.OrganizationalUnit) > 0
+	}
+	return false
+}
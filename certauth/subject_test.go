@@ -0,0 +1,108 @@
+package certauth
+
+import (
+	"crypto/x509/pkix"
+	"reflect"
+	"testing"
+)
+
+func TestParseSubject(t *testing.T) {
+	var tests = []struct {
+		s    string
+		want pkix.Name
+		err  bool
+	}{
+		{"CN=Example Root, O=Example Corp,C=DE", pkix.Name{CommonName: "Example Root", Organization: []string{"Example Corp"}, Country: []string{"DE"}}, false},
+		{"O=A,O=B,OU=Unit,L=Berlin,ST=Berlin", pkix.Name{Organization: []string{"A", "B"}, OrganizationalUnit: []string{"Unit"}, Locality: []string{"Berlin"}, Province: []string{"Berlin"}}, false},
+		{"letsencrypt", CASubjects["letsencrypt"], false},
+		{"C=DE", pkix.Name{}, true},
+		{"O=Example,C=Germany", pkix.Name{}, true},
+		{"CN=a,CN=b", pkix.Name{}, true},
+		{"X=y", pkix.Name{}, true},
+		{"Example Corp", pkix.Name{}, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.s, func(t *testing.T) {
+			name, err := ParseSubject(test.s)
+			if test.err {
+				if err == nil {
+					t.Fatalf("expected error not returned, got %+v", name)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(name, test.want) {
+				t.Errorf("wrong subject, want %+v, got %+v", test.want, name)
+			}
+		})
+	}
+}
+
+func TestCertificateSubject(t *testing.T) {
+	_, err := NewCAWithSubject(pkix.Name{Country: []string{"DE"}})
+	if err == nil {
+		t.Fatal("CA without common name and organization was created")
+	}
+
+	ca, err := NewCAWithSubject(CASubjects["digicert"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ca.Certificate.Subject.CommonName != "DigiCert Global Root G2" {
+		t.Errorf("wrong CA subject: %v", ca.Certificate.Subject)
+	}
+
+	ca.LeafSubject = pkix.Name{
+		Organization: []string{"Example Corp"},
+		Country:      []string{"DE"},
+		CommonName:   "ignored",
+	}
+
+	crt, err := ca.NewCertificate("www.example.com", []string{"www.example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(name string, subject pkix.Name) {
+		if subject.CommonName != "www.example.com" {
+			t.Errorf("%v: wrong common name %q", name, subject.CommonName)
+		}
+		if !reflect.DeepEqual(subject.Organization, []string{"Example Corp"}) {
+			t.Errorf("%v: wrong organization %q", name, subject.Organization)
+		}
+		if !reflect.DeepEqual(subject.Country, []string{"DE"}) {
+			t.Errorf("%v: wrong country %q", name, subject.Country)
+		}
+	}
+	check("generated", crt.Subject)
+
+	if crt.Issuer.String() != ca.Certificate.Subject.String() {
+		t.Errorf("wrong issuer %v", crt.Issuer)
+	}
+
+	// cloned certificates get the attributes as well
+	ca.LeafSubject = pkix.Name{}
+	orig, err := ca.NewCertificate("www.example.com", []string{"www.example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(orig.Subject.Organization) != 0 {
+		t.Fatalf("certificate without LeafSubject has organization %v", orig.Subject.Organization)
+	}
+
+	ca.LeafSubject = pkix.Name{Organization: []string{"Example Corp"}, Country: []string{"DE"}}
+	cloned, err := ca.Clone(orig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check("cloned", cloned.Subject)
+
+	ca.LeafSubject.Country = []string{"de"}
+	_, err = ca.NewCertificate("www.example.com", []string{"www.example.com"})
+	if err == nil {
+		t.Error("invalid country in LeafSubject was accepted")
+	}
+}
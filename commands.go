@@ -0,0 +1,317 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"strconv"
+
+	"github.com/dgraph-io/badger"
+	"github.com/fd0/osmosis/certauth"
+	"github.com/fd0/osmosis/proxy"
+	"github.com/fd0/osmosis/replay"
+	"github.com/fd0/osmosis/store"
+)
+
+// runCommand runs the subcommand given in args instead of the proxy.
+func runCommand(args []string) error {
+	switch args[0] {
+	case "save-session":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: save-session FILE")
+		}
+		return saveSession(opts.StoreDir, args[1])
+	case "load-session":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: load-session FILE")
+		}
+		return loadSession(opts.StoreDir, args[1])
+	case "export-http":
+		if len(args) != 3 {
+			return fmt.Errorf("usage: export-http ID FILE")
+		}
+		id, err := strconv.ParseUint(args[1], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid ID %q", args[1])
+		}
+		return exportHTTP(opts.StoreDir, id, args[2])
+	case "import-http":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: import-http FILE")
+		}
+		return importHTTP(opts.StoreDir, args[1])
+	case "import-logdir":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: import-logdir DIR")
+		}
+		return importLogdir(opts.StoreDir, args[1])
+	case "export-snippet":
+		if len(args) != 3 || (args[1] != "python" && args[1] != "httpie") {
+			return fmt.Errorf("usage: export-snippet python|httpie ID")
+		}
+		id, err := strconv.ParseUint(args[2], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid ID %q", args[2])
+		}
+		return exportSnippet(opts.StoreDir, args[1], id)
+	case "rebaseline":
+		return rebaseline(opts.StoreDir, args[1:])
+	case "compact":
+		return compact(opts.StoreDir)
+	case "self-test":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: self-test URL")
+		}
+		return selfTest(args[1])
+	default:
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+}
+
+// saveSession writes a snapshot of the store in storeDir to filename.
+func saveSession(storeDir, filename string) error {
+	s, err := store.New(storeDir)
+	if err != nil {
+		return fmt.Errorf("opening store: %v", err)
+	}
+	defer s.Close()
+
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+
+	err = s.Backup(f)
+	if err != nil {
+		_ = f.Close()
+		return fmt.Errorf("saving session: %v", err)
+	}
+
+	return f.Close()
+}
+
+// loadSession loads a snapshot from filename into the store in storeDir.
+func loadSession(storeDir, filename string) error {
+	s, err := store.New(storeDir)
+	if err != nil {
+		return fmt.Errorf("opening store: %v", err)
+	}
+	defer s.Close()
+
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	err = s.Restore(f)
+	if err != nil {
+		return fmt.Errorf("loading session: %v", err)
+	}
+
+	return nil
+}
+
+// exportHTTP writes the transaction id from the store in storeDir to filename
+// as a combined .http file.
+func exportHTTP(storeDir string, id uint64, filename string) error {
+	s, err := store.New(storeDir)
+	if err != nil {
+		return fmt.Errorf("opening store: %v", err)
+	}
+	defer s.Close()
+
+	txn, err := s.GetTxn(id)
+	if err != nil {
+		return fmt.Errorf("loading transaction %d: %v", id, err)
+	}
+
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+
+	err = store.WriteHTTPFile(f, txn)
+	if err != nil {
+		_ = f.Close()
+		return fmt.Errorf("exporting transaction: %v", err)
+	}
+
+	return f.Close()
+}
+
+// importHTTP adds the transaction in the .http file filename to the store in
+// storeDir.
+func importHTTP(storeDir, filename string) error {
+	s, err := store.New(storeDir)
+	if err != nil {
+		return fmt.Errorf("opening store: %v", err)
+	}
+	defer s.Close()
+
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	id, err := s.ImportHTTPFile(f)
+	if err != nil {
+		return fmt.Errorf("importing transaction: %v", err)
+	}
+
+	fmt.Printf("imported as transaction %d\n", id)
+	return nil
+}
+
+// importLogdir adds the transactions from the flat-file log directory dir to
+// the store in storeDir. It can be interrupted and started again.
+func importLogdir(storeDir, dir string) error {
+	s, err := store.New(storeDir)
+	if err != nil {
+		return fmt.Errorf("opening store: %v", err)
+	}
+	defer s.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sigchan := make(chan os.Signal, 1)
+	signal.Notify(sigchan, os.Interrupt)
+	defer signal.Stop(sigchan)
+	go func() {
+		select {
+		case <-sigchan:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	n, err := s.ImportFlatFiles(ctx, dir)
+	fmt.Printf("imported %d transactions\n", n)
+	if err == context.Canceled {
+		return fmt.Errorf("import interrupted, run the command again to resume")
+	}
+	if err != nil {
+		return fmt.Errorf("importing transactions: %v", err)
+	}
+
+	return nil
+}
+
+// exportSnippet prints the request of transaction id from the store in
+// storeDir as a Python script or an HTTPie command line, depending on format.
+func exportSnippet(storeDir, format string, id uint64) error {
+	s, err := store.New(storeDir)
+	if err != nil {
+		return fmt.Errorf("opening store: %v", err)
+	}
+	defer s.Close()
+
+	req, err := s.GetRequest(id, true)
+	if err == badger.ErrKeyNotFound {
+		req, err = s.GetRequest(id, false)
+	}
+	if err != nil {
+		return fmt.Errorf("loading request %d: %v", id, err)
+	}
+
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return err
+	}
+
+	switch format {
+	case "python":
+		fmt.Print(buildPythonRequests(req, body))
+	case "httpie":
+		fmt.Println(buildHTTPie(req, body))
+	}
+	return nil
+}
+
+// rebaselineConcurrency is the number of requests sent in parallel by the
+// rebaseline command.
+const rebaselineConcurrency = 4
+
+// rebaseline replays all transactions in the store in storeDir to hosts
+// matching one of the patterns (all if there are none) and stores the new
+// responses as the edited responses.
+func rebaseline(storeDir string, scope []string) error {
+	s, err := store.New(storeDir)
+	if err != nil {
+		return fmt.Errorf("opening store: %v", err)
+	}
+	defer s.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sigchan := make(chan os.Signal, 1)
+	signal.Notify(sigchan, os.Interrupt)
+	defer signal.Stop(sigchan)
+	go func() {
+		select {
+		case <-sigchan:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	r := replay.New(s, nil)
+	r.StripConditional = !opts.ReplayKeepConditional
+	r.StripCache = opts.ReplayStripCache
+
+	var replayed, skipped, failed int
+	_, err = r.Rebaseline(ctx, replay.RebaselineOptions{
+		Scope:       scope,
+		Concurrency: rebaselineConcurrency,
+		Progress: func(done, total int, result replay.BulkResult) {
+			switch {
+			case result.Err != nil:
+				failed++
+				fmt.Printf("[%d/%d] %d: %v\n", done, total, result.ID, result.Err)
+			case result.Skipped:
+				skipped++
+			default:
+				replayed++
+				fmt.Printf("[%d/%d] %d: %d\n", done, total, result.ID, result.StatusCode)
+			}
+		},
+	})
+	fmt.Printf("replayed %d transactions, %d skipped, %d failed\n", replayed, skipped, failed)
+	if err == context.Canceled {
+		return fmt.Errorf("rebaseline interrupted")
+	}
+	return err
+}
+
+// compact reclaims the disk space of deleted and overwritten transactions in
+// the store in storeDir.
+func compact(storeDir string) error {
+	s, err := store.New(storeDir)
+	if err != nil {
+		return fmt.Errorf("opening store: %v", err)
+	}
+	defer s.Close()
+
+	n, err := s.Compact()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("rewrote %d value log files\n", n)
+	return nil
+}
+
+// selfTest requests target through a proxy using the configured CA and
+// reports which stages work.
+func selfTest(target string) error {
+	ca, err := certauth.Load(opts.CertificateFilename, opts.KeyFilename)
+	if err != nil {
+		return fmt.Errorf("loading CA: %v", err)
+	}
+
+	return proxy.SelfTest(target, ca, nil, os.Stdout)
+}
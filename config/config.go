@@ -0,0 +1,179 @@
+// Package config loads settings from a configuration file and applies them
+// to a set of command line flags.
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/pflag"
+)
+
+// File contains the settings read from a configuration file. The keys are the
+// names of the command line flags (without the leading dashes), for example:
+//
+//	{
+//	    "listen": ["[::1]:8080", "127.0.0.1:8080"],
+//	    "upstream-proxy": "http://proxy.local:3128",
+//	    "max-request-body": 10485760
+//	}
+type File map[string]interface{}
+
+// Load reads the configuration file filename.
+func Load(filename string) (File, error) {
+	buf, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	return Parse(buf)
+}
+
+// Parse decodes a configuration file from buf.
+func Parse(buf []byte) (File, error) {
+	var f File
+	err := json.Unmarshal(buf, &f)
+	if err != nil {
+		return nil, fmt.Errorf("parsing config: %v", err)
+	}
+	return f, nil
+}
+
+// Set changes the option name in the configuration file filename to value and
+// writes the file, it is created if it does not exist yet. A nil value removes
+// the option.
+func Set(filename, name string, value interface{}) error {
+	f, err := Load(filename)
+	if os.IsNotExist(err) {
+		f, err = File{}, nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if value == nil {
+		delete(f, name)
+	} else {
+		f[name] = value
+	}
+
+	buf, err := json.MarshalIndent(f, "", "    ")
+	if err != nil {
+		return err
+	}
+	buf = append(buf, '\n')
+
+	// replace the file atomically so that it is never read half written
+	tmp, err := ioutil.TempFile(filepath.Dir(filename), filepath.Base(filename)+".tmp-")
+	if err != nil {
+		return err
+	}
+
+	_, err = tmp.Write(buf)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), filename)
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		return fmt.Errorf("writing config: %v", err)
+	}
+
+	return nil
+}
+
+// Apply sets the flags in fs to the values from the configuration file. Flags
+// which have been set on the command line are left alone, so they override
+// the file. Unknown keys are rejected.
+func (f File) Apply(fs *pflag.FlagSet) error {
+	// apply in a fixed order so that errors are reproducible
+	names := make([]string, 0, len(f))
+	for name := range f {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		flag := fs.Lookup(name)
+		if flag == nil {
+			return fmt.Errorf("unknown option %q in config", name)
+		}
+
+		if flag.Changed {
+			continue
+		}
+
+		values, err := flagValues(flag, f[name])
+		if err != nil {
+			return fmt.Errorf("option %q: %v", name, err)
+		}
+
+		for _, value := range values {
+			err = fs.Set(name, value)
+			if err != nil {
+				return fmt.Errorf("option %q: %v", name, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// flagValues converts a value decoded from JSON to the string representation
+// the flag expects. Lists for string array flags (which do not split values
+// on commas) are set one item at a time.
+func flagValues(flag *pflag.Flag, v interface{}) ([]string, error) {
+	if list, ok := v.([]interface{}); ok && flag.Value.Type() == "stringArray" {
+		values := make([]string, 0, len(list))
+		for _, item := range list {
+			s, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("list items must be strings, got %T", item)
+			}
+			values = append(values, s)
+		}
+		return values, nil
+	}
+
+	value, err := flagValue(v)
+	if err != nil {
+		return nil, err
+	}
+	return []string{value}, nil
+}
+
+// flagValue converts a value decoded from JSON to a single string.
+func flagValue(v interface{}) (string, error) {
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	case bool:
+		return fmt.Sprintf("%v", v), nil
+	case float64:
+		// avoid exponent notation for large integers
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case []interface{}:
+		items := make([]string, 0, len(v))
+		for _, item := range v {
+			s, ok := item.(string)
+			if !ok {
+				return "", fmt.Errorf("list items must be strings, got %T", item)
+			}
+			if strings.Contains(s, ",") {
+				return "", fmt.Errorf("list item %q must not contain a comma", s)
+			}
+			items = append(items, s)
+		}
+		return strings.Join(items, ","), nil
+	default:
+		return "", fmt.Errorf("unsupported value of type %T", v)
+	}
+}
@@ -0,0 +1,196 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/spf13/pflag"
+)
+
+type testOptions struct {
+	Listen  []string
+	Proxy   string
+	MaxBody int64
+	Verbose bool
+	Timeout time.Duration
+	Headers []string
+}
+
+func newFlagSet(opts *testOptions) *pflag.FlagSet {
+	fs := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	fs.StringSliceVar(&opts.Listen, "listen", []string{"[::1]:8080"}, "")
+	fs.StringVar(&opts.Proxy, "upstream-proxy", "", "")
+	fs.Int64Var(&opts.MaxBody, "max-request-body", 0, "")
+	fs.BoolVar(&opts.Verbose, "verbose", false, "")
+	fs.DurationVar(&opts.Timeout, "timeout", time.Minute, "")
+	fs.StringArrayVar(&opts.Headers, "header", nil, "")
+	return fs
+}
+
+const testConfig = `{
+	"listen": ["127.0.0.1:8080", "127.0.0.1:8081"],
+	"upstream-proxy": "http://proxy.local:3128",
+	"max-request-body": 10485760,
+	"verbose": true,
+	"timeout": "30s",
+	"header": ["Cache-Control: no-store, no-cache", "-Server"]
+}`
+
+func TestLoad(t *testing.T) {
+	tempdir, err := ioutil.TempDir("", "osmosis-config-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempdir)
+
+	filename := filepath.Join(tempdir, "config.json")
+	err = ioutil.WriteFile(filename, []byte(testConfig), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := Load(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var opts testOptions
+	fs := newFlagSet(&opts)
+	err = fs.Parse(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = f.Apply(fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := testOptions{
+		Listen:  []string{"127.0.0.1:8080", "127.0.0.1:8081"},
+		Proxy:   "http://proxy.local:3128",
+		MaxBody: 10485760,
+		Verbose: true,
+		Timeout: 30 * time.Second,
+		Headers: []string{"Cache-Control: no-store, no-cache", "-Server"},
+	}
+
+	if !reflect.DeepEqual(opts, want) {
+		t.Errorf("wrong options, want:\n  %#v\ngot:\n  %#v", want, opts)
+	}
+}
+
+func TestFlagsOverrideFile(t *testing.T) {
+	f, err := Parse([]byte(testConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var opts testOptions
+	fs := newFlagSet(&opts)
+	err = fs.Parse([]string{"--listen", "[::1]:9090", "--max-request-body", "23", "--header", "X-Foo: bar"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = f.Apply(fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := testOptions{
+		Listen:  []string{"[::1]:9090"},
+		Proxy:   "http://proxy.local:3128",
+		MaxBody: 23,
+		Verbose: true,
+		Timeout: 30 * time.Second,
+		Headers: []string{"X-Foo: bar"},
+	}
+
+	if !reflect.DeepEqual(opts, want) {
+		t.Errorf("wrong options, want:\n  %#v\ngot:\n  %#v", want, opts)
+	}
+}
+
+func TestApplyErrors(t *testing.T) {
+	var tests = []string{
+		`{"unknown-option": "foo"}`,
+		`{"max-request-body": "lots"}`,
+		`{"listen": [8080]}`,
+		`{"listen": ["a,b"]}`,
+		`{"verbose": {"nested": true}}`,
+	}
+
+	for _, test := range tests {
+		t.Run("", func(t *testing.T) {
+			f, err := Parse([]byte(test))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var opts testOptions
+			err = f.Apply(newFlagSet(&opts))
+			if err == nil {
+				t.Fatalf("expected error for %v not found", test)
+			}
+		})
+	}
+}
+
+func TestSet(t *testing.T) {
+	tempdir, err := ioutil.TempDir("", "osmosis-config-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempdir)
+
+	filename := filepath.Join(tempdir, "config.json")
+
+	// the file is created on the first call
+	err = Set(filename, "verbose", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Set(filename, "listen", []string{"127.0.0.1:8080", "127.0.0.1:8081"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Set(filename, "upstream-proxy", "http://proxy.local:3128")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Set(filename, "upstream-proxy", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := Load(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var opts testOptions
+	fs := newFlagSet(&opts)
+	err = fs.Parse(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = f.Apply(fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := testOptions{
+		Listen:  []string{"127.0.0.1:8080", "127.0.0.1:8081"},
+		Verbose: true,
+		Timeout: time.Minute,
+	}
+	if !reflect.DeepEqual(opts, want) {
+		t.Errorf("wrong options after reload, want %+v, got %+v", want, opts)
+	}
+}
@@ -0,0 +1,35 @@
+// Package display prepares the contents of transactions for showing them to
+// the user. The functions never modify the raw bytes passed to them.
+package display
+
+import (
+	"bytes"
+	"io/ioutil"
+	"unicode/utf8"
+
+	"golang.org/x/net/html/charset"
+)
+
+// ToUTF8 converts body to UTF-8 for display. The charset is taken from the
+// charset parameter of contentType, and if none is set, detected from a
+// byte order mark or a meta tag in HTML documents. The returned name is the
+// name of the charset body was decoded from. The body itself is not modified.
+func ToUTF8(contentType string, body []byte) (text []byte, name string, err error) {
+	_, name, certain := charset.DetermineEncoding(body, contentType)
+	if !certain && utf8.Valid(body) {
+		// nothing indicates a different charset, use the body as is
+		return body, "utf-8", nil
+	}
+
+	rd, err := charset.NewReaderLabel(name, bytes.NewReader(body))
+	if err != nil {
+		return nil, "", err
+	}
+
+	text, err = ioutil.ReadAll(rd)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return text, name, nil
+}
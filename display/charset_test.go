@@ -0,0 +1,49 @@
+package display
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestToUTF8(t *testing.T) {
+	// "こんにちは" in Shift_JIS
+	sjis := []byte{0x82, 0xb1, 0x82, 0xf1, 0x82, 0xc9, 0x82, 0xbf, 0x82, 0xcd}
+	// "café" in ISO-8859-1
+	latin1 := []byte{'c', 'a', 'f', 0xe9}
+
+	var tests = []struct {
+		contentType string
+		body        []byte
+		text        string
+		name        string
+	}{
+		{"text/plain; charset=Shift_JIS", sjis, "こんにちは", "shift_jis"},
+		{"text/plain; charset=ISO-8859-1", latin1, "café", "windows-1252"},
+		{"text/plain; charset=utf-8", []byte("café"), "café", "utf-8"},
+		{"text/plain", []byte("café"), "café", "utf-8"},
+		{"text/html", []byte(`<meta charset="shift_jis">` + string(sjis)), `<meta charset="shift_jis">こんにちは`, "shift_jis"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.contentType, func(t *testing.T) {
+			orig := append([]byte{}, test.body...)
+
+			text, name, err := ToUTF8(test.contentType, test.body)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if string(text) != test.text {
+				t.Errorf("wrong text, want %q, got %q", test.text, text)
+			}
+
+			if name != test.name {
+				t.Errorf("wrong charset, want %q, got %q", test.name, name)
+			}
+
+			if !bytes.Equal(orig, test.body) {
+				t.Errorf("raw body was modified: %q", test.body)
+			}
+		})
+	}
+}
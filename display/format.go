@@ -0,0 +1,103 @@
+package display
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"mime"
+	"strings"
+)
+
+// isJSON returns true if mediaType describes a JSON document.
+func isJSON(mediaType string) bool {
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+// isXML returns true if mediaType describes an XML document.
+func isXML(mediaType string) bool {
+	return mediaType == "application/xml" || mediaType == "text/xml" || strings.HasSuffix(mediaType, "+xml")
+}
+
+// Format returns an indented copy of a JSON or XML body, the type is taken
+// from contentType. For other content types, ok is false. The body itself is
+// not modified.
+func Format(contentType string, body []byte) (formatted []byte, ok bool, err error) {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+
+	switch {
+	case isJSON(mediaType):
+		var buf bytes.Buffer
+		err = json.Indent(&buf, body, "", "  ")
+		if err != nil {
+			return nil, false, err
+		}
+		return buf.Bytes(), true, nil
+	case isXML(mediaType):
+		formatted, err = formatXML(body)
+		if err != nil {
+			return nil, false, err
+		}
+		return formatted, true, nil
+	}
+
+	return nil, false, nil
+}
+
+// rawName returns the name with the namespace prefix (as returned by
+// RawToken) included in the local part, so that the encoder writes it as is.
+func rawName(name xml.Name) xml.Name {
+	if name.Space == "" {
+		return name
+	}
+	return xml.Name{Local: name.Space + ":" + name.Local}
+}
+
+// formatXML indents an XML document, whitespace between elements is removed.
+func formatXML(body []byte) ([]byte, error) {
+	dec := xml.NewDecoder(bytes.NewReader(body))
+	dec.Strict = false
+
+	var buf bytes.Buffer
+	enc := xml.NewEncoder(&buf)
+	enc.Indent("", "  ")
+
+	for {
+		token, err := dec.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := token.(type) {
+		case xml.CharData:
+			if len(bytes.TrimSpace(t)) == 0 {
+				continue
+			}
+		case xml.StartElement:
+			t = t.Copy()
+			t.Name = rawName(t.Name)
+			for i := range t.Attr {
+				t.Attr[i].Name = rawName(t.Attr[i].Name)
+			}
+			token = t
+		case xml.EndElement:
+			t.Name = rawName(t.Name)
+			token = t
+		}
+
+		err = enc.EncodeToken(xml.CopyToken(token))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err := enc.Flush()
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
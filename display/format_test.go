@@ -0,0 +1,44 @@
+package display
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestFormat(t *testing.T) {
+	var tests = []struct {
+		contentType string
+		body        string
+		want        string
+		ok          bool
+	}{
+		{"application/json", `{"a":[1,2],"b":"c"}`, "{\n  \"a\": [\n    1,\n    2\n  ],\n  \"b\": \"c\"\n}", true},
+		{"application/vnd.api+json; charset=utf-8", `{"a":1}`, "{\n  \"a\": 1\n}", true},
+		{"text/xml", `<a x="1"><b>text</b> <c/></a>`, "<a x=\"1\">\n  <b>text</b>\n  <c></c>\n</a>", true},
+		{"application/soap+xml", `<s:Envelope xmlns:s="urn:x"><s:Body/></s:Envelope>`, "<s:Envelope xmlns:s=\"urn:x\">\n  <s:Body></s:Body>\n</s:Envelope>", true},
+		{"text/plain", `{"a":1}`, "", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.contentType, func(t *testing.T) {
+			body := []byte(test.body)
+
+			formatted, ok, err := Format(test.contentType, body)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if ok != test.ok {
+				t.Fatalf("wrong ok value, want %v, got %v", test.ok, ok)
+			}
+
+			if string(formatted) != test.want {
+				t.Errorf("wrong formatted body, want\n%s\ngot\n%s", test.want, formatted)
+			}
+
+			if !bytes.Equal(body, []byte(test.body)) {
+				t.Errorf("raw body was modified: %q", body)
+			}
+		})
+	}
+}
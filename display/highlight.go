@@ -0,0 +1,199 @@
+package display
+
+import (
+	"mime"
+	"regexp"
+	"strings"
+)
+
+// Colors used for highlighting, as tview color tags.
+const (
+	colorKey       = "[blue]"
+	colorString    = "[green]"
+	colorNumber    = "[yellow]"
+	colorLiteral   = "[purple]"
+	colorTag       = "[blue]"
+	colorAttribute = "[yellow]"
+	colorComment   = "[gray]"
+	colorReset     = "[-]"
+)
+
+// tagPattern matches text tview would interpret as a tag, including the
+// empty tag "[]".
+var tagPattern = regexp.MustCompile(`\[\]|\[[a-zA-Z0-9_,;: \-\."#]+\[*\]`)
+
+// escapeTags escapes s so that tview displays it literally. The current color
+// tag is needed to escape empty brackets, which are split by repeating it.
+func escapeTags(s, color string) string {
+	return tagPattern.ReplaceAllStringFunc(s, func(tag string) string {
+		if tag == "[]" {
+			return "[" + color + "]"
+		}
+		return tag[:len(tag)-1] + "[]"
+	})
+}
+
+// isHTML returns true if mediaType describes an HTML document.
+func isHTML(mediaType string) bool {
+	return mediaType == "text/html" || mediaType == "application/xhtml+xml"
+}
+
+// Highlight returns body with tview color tags for JSON, HTML and XML, the
+// type is taken from contentType. For other content types, ok is false. The
+// result is meant for display only, the body itself is not modified.
+func Highlight(contentType string, body []byte) (highlighted string, ok bool) {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+
+	switch {
+	case isJSON(mediaType):
+		return highlightJSON(string(body)), true
+	case isHTML(mediaType), isXML(mediaType):
+		return highlightMarkup(string(body)), true
+	}
+
+	return "", false
+}
+
+// colored returns s escaped and wrapped in color tags.
+func colored(color, s string) string {
+	if s == "" {
+		return ""
+	}
+	return color + escapeTags(s, color) + colorReset
+}
+
+// jsonString returns the length of the JSON string at the start of s,
+// including the quotes. Unterminated strings extend to the end of s.
+func jsonString(s string) int {
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			return i + 1
+		}
+	}
+	return len(s)
+}
+
+// isKey returns true if the next non-whitespace character in s is a colon.
+func isKey(s string) bool {
+	return strings.HasPrefix(strings.TrimLeft(s, " \t\r\n"), ":")
+}
+
+// highlightJSON colors keys, strings, numbers and literals in a JSON
+// document. Invalid documents are highlighted as far as possible.
+func highlightJSON(s string) string {
+	var out strings.Builder
+	for len(s) > 0 {
+		var n int
+		switch c := s[0]; {
+		case c == '"':
+			n = jsonString(s)
+			color := colorString
+			if isKey(s[n:]) {
+				color = colorKey
+			}
+			out.WriteString(colored(color, s[:n]))
+		case c == '-' || (c >= '0' && c <= '9'):
+			n = strings.IndexFunc(s, func(r rune) bool {
+				return !strings.ContainsRune("0123456789+-.eE", r)
+			})
+			if n < 0 {
+				n = len(s)
+			}
+			out.WriteString(colored(colorNumber, s[:n]))
+		case c >= 'a' && c <= 'z':
+			n = strings.IndexFunc(s, func(r rune) bool { return r < 'a' || r > 'z' })
+			if n < 0 {
+				n = len(s)
+			}
+			out.WriteString(colored(colorLiteral, s[:n]))
+		default:
+			// punctuation and whitespace
+			n = strings.IndexAny(s, "\"-0123456789abcdefghijklmnopqrstuvwxyz")
+			if n < 0 {
+				n = len(s)
+			}
+			out.WriteString(escapeTags(s[:n], colorReset))
+		}
+		s = s[n:]
+	}
+	return out.String()
+}
+
+// markupAttr matches an attribute with an optional value in a tag.
+var markupAttr = regexp.MustCompile(`([^\s=/>?]+)(\s*=\s*("[^"]*"|'[^']*'|[^\s>]+))?`)
+
+// highlightTag colors the tag name and the attributes in a tag (including
+// the angle brackets).
+func highlightTag(tag string) string {
+	// split off "<", "</" or "<?" and the name
+	start := 1
+	if len(tag) > 1 && (tag[1] == '/' || tag[1] == '?' || tag[1] == '!') {
+		start = 2
+	}
+	end := start
+	for end < len(tag) && !strings.ContainsRune(" \t\r\n/>?", rune(tag[end])) {
+		end++
+	}
+
+	var out strings.Builder
+	out.WriteString(colored(colorTag, tag[:end]))
+
+	rest := tag[end:]
+	for len(rest) > 0 {
+		loc := markupAttr.FindStringSubmatchIndex(rest)
+		if loc == nil {
+			out.WriteString(colored(colorTag, rest))
+			break
+		}
+
+		out.WriteString(colored(colorTag, rest[:loc[0]]))
+		out.WriteString(colored(colorAttribute, rest[loc[2]:loc[3]]))
+		if loc[4] >= 0 {
+			out.WriteString(escapeTags(rest[loc[4]:loc[6]], colorReset))
+			out.WriteString(colored(colorString, rest[loc[6]:loc[7]]))
+		}
+		rest = rest[loc[1]:]
+	}
+
+	return out.String()
+}
+
+// highlightMarkup colors tags, attributes and comments in an HTML or XML
+// document.
+func highlightMarkup(s string) string {
+	var out strings.Builder
+	for len(s) > 0 {
+		i := strings.IndexByte(s, '<')
+		if i < 0 {
+			out.WriteString(escapeTags(s, colorReset))
+			break
+		}
+		out.WriteString(escapeTags(s[:i], colorReset))
+		s = s[i:]
+
+		if strings.HasPrefix(s, "<!--") {
+			n := strings.Index(s, "-->")
+			if n < 0 {
+				n = len(s)
+			} else {
+				n += 3
+			}
+			out.WriteString(colored(colorComment, s[:n]))
+			s = s[n:]
+			continue
+		}
+
+		n := strings.IndexByte(s, '>')
+		if n < 0 {
+			n = len(s)
+		} else {
+			n++
+		}
+		out.WriteString(highlightTag(s[:n]))
+		s = s[n:]
+	}
+	return out.String()
+}
@@ -0,0 +1,53 @@
+package display
+
+import "testing"
+
+func TestHighlight(t *testing.T) {
+	var tests = []struct {
+		contentType string
+		body        string
+		want        string
+		ok          bool
+	}{
+		{
+			"application/json",
+			`{"name": "a[b]", "n": -1.5e3, "ok": true, "list": []}`,
+			`{[blue]"name"[-]: [green]"a[b[]"[-], [blue]"n"[-]: [yellow]-1.5e3[-], ` +
+				`[blue]"ok"[-]: [purple]true[-], [blue]"list"[-]: [[-]]}`,
+			true,
+		},
+		{
+			"text/html; charset=utf-8",
+			`<!-- c --><a href="/x" data-y='1' hidden>[red]</a>`,
+			`[gray]<!-- c -->[-][blue]<a[-][blue] [-][yellow]href[-]=[green]"/x"[-][blue] [-][yellow]data-y[-]=[green]'1'[-]` +
+				`[blue] [-][yellow]hidden[-][blue]>[-][red[][blue]</a[-][blue]>[-]`,
+			true,
+		},
+		{
+			"application/xml",
+			`<?xml version="1.0"?><r/>`,
+			`[blue]<?xml[-][blue] [-][yellow]version[-]=[green]"1.0"[-][blue]?>[-][blue]<r[-][blue]/>[-]`,
+			true,
+		},
+		{"text/plain", `{"a": 1}`, "", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.contentType, func(t *testing.T) {
+			body := []byte(test.body)
+
+			highlighted, ok := Highlight(test.contentType, body)
+			if ok != test.ok {
+				t.Fatalf("wrong ok value, want %v, got %v", test.ok, ok)
+			}
+
+			if highlighted != test.want {
+				t.Errorf("wrong output, want\n%s\ngot\n%s", test.want, highlighted)
+			}
+
+			if string(body) != test.body {
+				t.Errorf("raw body was modified: %q", body)
+			}
+		})
+	}
+}
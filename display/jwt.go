@@ -0,0 +1,94 @@
+package display
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// JWT is a JSON Web Token found in a message. It is decoded without
+// verifying the signature.
+type JWT struct {
+	Token   string                 `json:"token"`
+	Header  map[string]interface{} `json:"header"`
+	Payload map[string]interface{} `json:"payload"`
+
+	// Expires is taken from the exp claim, it is nil if there is none.
+	Expires *time.Time `json:"expires,omitempty"`
+}
+
+// Expired returns true if the token has expired at t.
+func (j JWT) Expired(t time.Time) bool {
+	return j.Expires != nil && !t.Before(*j.Expires)
+}
+
+// jwtPattern matches strings shaped like a JWT: the encoded header and
+// payload are JSON objects, so they start with "eyJ" ("{\"").
+var jwtPattern = regexp.MustCompile(`eyJ[A-Za-z0-9_-]*\.eyJ[A-Za-z0-9_-]*\.[A-Za-z0-9_-]*`)
+
+// FindJWTs returns the JWTs in text, e.g. in the Authorization header, a
+// cookie or the body, each token is returned once. Strings which look like a
+// JWT but can't be decoded are skipped.
+func FindJWTs(text []byte) []JWT {
+	var list []JWT
+	seen := make(map[string]struct{})
+	for _, match := range jwtPattern.FindAll(text, -1) {
+		token := string(match)
+		if _, ok := seen[token]; ok {
+			continue
+		}
+		seen[token] = struct{}{}
+
+		header, payload, err := decodeJWT(token)
+		if err != nil {
+			continue
+		}
+
+		jwt := JWT{Token: token, Header: header, Payload: payload}
+		if exp, ok := payload["exp"].(float64); ok {
+			t := time.Unix(int64(exp), 0).UTC()
+			jwt.Expires = &t
+		}
+		list = append(list, jwt)
+	}
+	return list
+}
+
+// decodeJWT returns the decoded header and payload of token.
+func decodeJWT(token string) (header, payload map[string]interface{}, err error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, nil, errors.New("token does not consist of three parts")
+	}
+
+	header, err = decodeJWTPart(parts[0])
+	if err != nil {
+		return nil, nil, fmt.Errorf("header: %v", err)
+	}
+
+	payload, err = decodeJWTPart(parts[1])
+	if err != nil {
+		return nil, nil, fmt.Errorf("payload: %v", err)
+	}
+
+	return header, payload, nil
+}
+
+// decodeJWTPart decodes a base64url encoded JSON object.
+func decodeJWTPart(part string) (map[string]interface{}, error) {
+	buf, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(part, "="))
+	if err != nil {
+		return nil, err
+	}
+
+	var obj map[string]interface{}
+	err = json.Unmarshal(buf, &obj)
+	if err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
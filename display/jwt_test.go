@@ -0,0 +1,69 @@
+package display
+
+import (
+	"encoding/base64"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// sampleJWT is the example token from RFC 7519 and jwt.io.
+const sampleJWT = "eyJhbGciOiJIUzI1NiIsInR5cCI6IkpXVCJ9." +
+	"eyJzdWIiOiIxMjM0NTY3ODkwIiwibmFtZSI6IkpvaG4gRG9lIiwiaWF0IjoxNTE2MjM5MDIyfQ." +
+	"SflKxwRJSMeKKF2QT4fwpMeJf36POk6yJV_adQssw5c"
+
+func TestDecodeJWT(t *testing.T) {
+	header, payload, err := decodeJWT(sampleJWT)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantHeader := map[string]interface{}{"alg": "HS256", "typ": "JWT"}
+	if !reflect.DeepEqual(header, wantHeader) {
+		t.Errorf("wrong header, want %v, got %v", wantHeader, header)
+	}
+
+	wantPayload := map[string]interface{}{"sub": "1234567890", "name": "John Doe", "iat": float64(1516239022)}
+	if !reflect.DeepEqual(payload, wantPayload) {
+		t.Errorf("wrong payload, want %v, got %v", wantPayload, payload)
+	}
+
+	for _, token := range []string{"eyJhbGciOiJIUzI1NiJ9.eyJzdWIiOiIxIn0", "eyJ!.eyJ.x", "eyJhbGciOi.eyJzdWIiOiIxIn0.x"} {
+		_, _, err := decodeJWT(token)
+		if err == nil {
+			t.Errorf("invalid token %q was decoded", token)
+		}
+	}
+}
+
+func TestFindJWTs(t *testing.T) {
+	enc := base64.RawURLEncoding.EncodeToString
+	expiring := enc([]byte(`{"alg":"none"}`)) + "." + enc([]byte(`{"sub":"alice","exp":1700000000}`)) + "."
+
+	text := "GET / HTTP/1.1\r\n" +
+		"Authorization: Bearer " + sampleJWT + "\r\n" +
+		"Cookie: session=" + expiring + "; other=eyJub3QiOi.eyJqd3Q.x\r\n" +
+		"\r\n" +
+		`{"token": "` + sampleJWT + `"}`
+
+	list := FindJWTs([]byte(text))
+	if len(list) != 2 {
+		t.Fatalf("wrong number of tokens found, want 2, got %d: %+v", len(list), list)
+	}
+
+	if list[0].Token != sampleJWT || list[0].Payload["name"] != "John Doe" || list[0].Expires != nil {
+		t.Errorf("wrong first token: %+v", list[0])
+	}
+
+	exp := time.Unix(1700000000, 0).UTC()
+	if list[1].Payload["sub"] != "alice" || list[1].Expires == nil || !list[1].Expires.Equal(exp) {
+		t.Errorf("wrong second token: %+v", list[1])
+	}
+
+	if !list[1].Expired(exp) || list[1].Expired(exp.Add(-time.Second)) {
+		t.Errorf("wrong expiry for %v", list[1].Expires)
+	}
+	if list[0].Expired(time.Now()) {
+		t.Errorf("token without exp claim has expired")
+	}
+}
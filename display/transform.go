@@ -0,0 +1,145 @@
+package display
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"strings"
+)
+
+// BodyTransformer converts a body for displaying, searching, exporting or
+// logging it, e.g. by decompressing or indenting it. The input must not be
+// modified. A transformer which does not apply to the content type returns
+// the input unchanged.
+type BodyTransformer interface {
+	Transform(contentType string, in []byte) (out []byte, newContentType string, err error)
+}
+
+// BodyTransformerFunc is an adapter which allows using a function as a
+// BodyTransformer.
+type BodyTransformerFunc func(contentType string, in []byte) ([]byte, string, error)
+
+// Transform calls f.
+func (f BodyTransformerFunc) Transform(contentType string, in []byte) ([]byte, string, error) {
+	return f(contentType, in)
+}
+
+// Pipeline applies transformers in order, each one receives the output and
+// content type of the previous one. Nil entries are skipped.
+type Pipeline []BodyTransformer
+
+// Transform runs the body through all transformers of the pipeline.
+func (p Pipeline) Transform(contentType string, in []byte) ([]byte, string, error) {
+	out := in
+	for _, t := range p {
+		if t == nil {
+			continue
+		}
+
+		var err error
+		out, contentType, err = t.Transform(contentType, out)
+		if err != nil {
+			return nil, "", fmt.Errorf("%T: %v", t, err)
+		}
+	}
+	return out, contentType, nil
+}
+
+// Decompress removes the content encoding. If Encoding (the value of the
+// Content-Encoding header) is empty, gzip and zlib streams are detected by
+// their header, other bodies (and bodies which only look like compressed
+// data) are returned unchanged.
+type Decompress struct {
+	Encoding string
+}
+
+// Transform decompresses the body, the content type is not changed.
+func (d Decompress) Transform(contentType string, in []byte) ([]byte, string, error) {
+	encoding := strings.ToLower(strings.TrimSpace(d.Encoding))
+	if encoding == "" {
+		out, err := decompress(sniffEncoding(in), in)
+		if err != nil {
+			return in, contentType, nil
+		}
+		return out, contentType, nil
+	}
+
+	out, err := decompress(encoding, in)
+	if err != nil {
+		return nil, "", err
+	}
+	return out, contentType, nil
+}
+
+// decompress returns the decoded body for the content encoding.
+func decompress(encoding string, in []byte) ([]byte, error) {
+
+	var rd io.Reader
+	var err error
+	switch encoding {
+	case "", "identity":
+		return in, nil
+	case "gzip", "x-gzip":
+		rd, err = gzip.NewReader(bytes.NewReader(in))
+	case "deflate":
+		rd, err = zlib.NewReader(bytes.NewReader(in))
+	default:
+		return nil, fmt.Errorf("unsupported content encoding %q", encoding)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return ioutil.ReadAll(rd)
+}
+
+// sniffEncoding returns "gzip" or "deflate" if buf starts with a gzip or zlib
+// header, and an empty string otherwise.
+func sniffEncoding(buf []byte) string {
+	switch {
+	case len(buf) >= 3 && buf[0] == 0x1f && buf[1] == 0x8b && buf[2] == 8:
+		return "gzip"
+	case len(buf) >= 2 && buf[0]&0x0f == 8 && (uint16(buf[0])<<8|uint16(buf[1]))%31 == 0:
+		return "deflate"
+	}
+	return ""
+}
+
+// UTF8 converts the body to UTF-8 like ToUTF8, the charset parameter of the
+// content type is updated.
+type UTF8 struct{}
+
+// Transform converts the body to UTF-8.
+func (UTF8) Transform(contentType string, in []byte) ([]byte, string, error) {
+	out, name, err := ToUTF8(contentType, in)
+	if err != nil {
+		return nil, "", err
+	}
+	if name == "utf-8" {
+		return out, contentType, nil
+	}
+
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return out, contentType, nil
+	}
+	params["charset"] = "utf-8"
+	return out, mime.FormatMediaType(mediaType, params), nil
+}
+
+// Pretty indents JSON and XML documents like Format. Other bodies and
+// documents which cannot be parsed are returned unchanged.
+type Pretty struct{}
+
+// Transform indents the body.
+func (Pretty) Transform(contentType string, in []byte) ([]byte, string, error) {
+	formatted, ok, err := Format(contentType, in)
+	if err != nil || !ok {
+		return in, contentType, nil
+	}
+	return formatted, contentType, nil
+}
@@ -0,0 +1,106 @@
+package display
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"strings"
+	"testing"
+)
+
+func compress(t testing.TB, encoding, s string) []byte {
+	var buf bytes.Buffer
+	var wr interface {
+		Write([]byte) (int, error)
+		Close() error
+	}
+	switch encoding {
+	case "gzip":
+		wr = gzip.NewWriter(&buf)
+	case "deflate":
+		wr = zlib.NewWriter(&buf)
+	}
+
+	_, err := wr.Write([]byte(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = wr.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestPipelineDecompressPretty(t *testing.T) {
+	const doc = `{"user":"foo","roles":["admin"]}`
+	const want = "{\n  \"user\": \"foo\",\n  \"roles\": [\n    \"admin\"\n  ]\n}"
+
+	var tests = []struct {
+		encoding string
+		body     []byte
+	}{
+		{"gzip", compress(t, "gzip", doc)},
+		{"deflate", compress(t, "deflate", doc)},
+		{"", compress(t, "gzip", doc)},
+		{"", compress(t, "deflate", doc)},
+		{"identity", []byte(doc)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.encoding, func(t *testing.T) {
+			orig := append([]byte{}, test.body...)
+
+			p := Pipeline{Decompress{Encoding: test.encoding}, Pretty{}}
+			out, contentType, err := p.Transform("application/json", test.body)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if string(out) != want {
+				t.Errorf("wrong output, want\n%s\ngot\n%s", want, out)
+			}
+			if contentType != "application/json" {
+				t.Errorf("content type changed to %q", contentType)
+			}
+			if !bytes.Equal(test.body, orig) {
+				t.Errorf("input was modified")
+			}
+		})
+	}
+}
+
+func TestDecompressErrors(t *testing.T) {
+	// bodies which only look compressed are passed through when sniffing
+	body := []byte("x^ not compressed")
+	out, _, err := Decompress{}.Transform("text/plain", body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out, body) {
+		t.Errorf("body changed: %q", out)
+	}
+
+	_, _, err = Decompress{Encoding: "gzip"}.Transform("text/plain", body)
+	if err == nil {
+		t.Error("invalid gzip data did not return an error")
+	}
+
+	_, _, err = Pipeline{Decompress{Encoding: "br"}}.Transform("text/plain", body)
+	if err == nil || !strings.Contains(err.Error(), "br") {
+		t.Errorf("unexpected error for unsupported encoding: %v", err)
+	}
+}
+
+func TestUTF8Transform(t *testing.T) {
+	out, contentType, err := UTF8{}.Transform("text/plain; charset=iso-8859-1", []byte("gr\xfc\xdf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != "grüß" {
+		t.Errorf("wrong output %q", out)
+	}
+	if contentType != "text/plain; charset=utf-8" {
+		t.Errorf("wrong content type %q", contentType)
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/fd0/osmosis/config"
+	"github.com/fd0/osmosis/proxy"
+)
+
+// hookState enables and disables the named hooks of a proxy and records the
+// disabled ones in the configuration file, so they stay disabled after a
+// restart.
+type hookState struct {
+	*proxy.Proxy
+
+	// filename is the configuration file, if empty the state is not saved
+	filename string
+	m        sync.Mutex
+}
+
+// SetHookEnabled enables or disables the hook and saves the state.
+func (s *hookState) SetHookEnabled(name string, enabled bool) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	err := s.Proxy.SetHookEnabled(name, enabled)
+	if err != nil {
+		return err
+	}
+
+	if s.filename == "" {
+		return nil
+	}
+
+	var disabled []string
+	for _, hook := range s.Hooks() {
+		if !hook.Enabled {
+			disabled = append(disabled, hook.Name)
+		}
+	}
+
+	var value interface{}
+	if len(disabled) > 0 {
+		value = disabled
+	}
+	return config.Set(s.filename, "disable-hook", value)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/fd0/osmosis/config"
+	"github.com/fd0/osmosis/proxy"
+	"github.com/spf13/pflag"
+)
+
+func TestHookStatePersisted(t *testing.T) {
+	dir, err := ioutil.TempDir("", "osmosis-test-hooks-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "config.json")
+	err = ioutil.WriteFile(filename, []byte(`{"no-clone": true}`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, _, _ := proxy.TestProxy(t, nil)
+	for _, name := range []string{"user-agent", "log-request"} {
+		err = p.RegisterNamed(name, "all requests", func(event *proxy.Event) (*proxy.Response, error) {
+			return event.ForwardRequest()
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	state := &hookState{Proxy: p, filename: filename}
+	err = state.SetHookEnabled("user-agent", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// reload the file like on startup
+	cfg, err := config.Load(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var disabled []string
+	var noClone bool
+	fs := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	fs.StringSliceVar(&disabled, "disable-hook", nil, "")
+	fs.BoolVar(&noClone, "no-clone", false, "")
+	err = cfg.Apply(fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(disabled, []string{"user-agent"}) {
+		t.Errorf("wrong hooks disabled after reload: %v", disabled)
+	}
+	if !noClone {
+		t.Error("other options were not preserved")
+	}
+
+	err = state.SetHookEnabled("user-agent", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err = config.Load(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := cfg["disable-hook"]; ok {
+		t.Errorf("option still present after enabling all hooks: %v", cfg)
+	}
+
+	if err := state.SetHookEnabled("unknown", false); err == nil {
+		t.Error("disabling an unknown hook did not return an error")
+	}
+}
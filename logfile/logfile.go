@@ -0,0 +1,200 @@
+// Package logfile implements an io.Writer which writes to a log file that is
+// rotated when it grows too large. Old log files are removed according to
+// their number and age.
+package logfile
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// backupTimeFormat is appended to the file name of rotated log files.
+const backupTimeFormat = "20060102-150405.000000000"
+
+// Options collects the settings for a rotating log file.
+type Options struct {
+	// Filename is the file the log is written to.
+	Filename string
+
+	// MaxSize is the size in bytes at which the file is rotated. If it is
+	// zero, the file is never rotated.
+	MaxSize int64
+
+	// MaxBackups is the number of rotated files to keep, zero keeps all.
+	MaxBackups int
+
+	// MaxAge is the duration after which rotated files are removed, zero
+	// keeps them forever.
+	MaxAge time.Duration
+}
+
+// Writer writes to a file which is rotated according to the options.
+type Writer struct {
+	opts Options
+
+	m    sync.Mutex
+	f    *os.File
+	size int64
+}
+
+// New opens (or creates) the log file and returns a Writer for it.
+func New(opts Options) (*Writer, error) {
+	if opts.Filename == "" {
+		return nil, fmt.Errorf("no log file name specified")
+	}
+
+	w := &Writer{opts: opts}
+	err := w.open()
+	if err != nil {
+		return nil, err
+	}
+
+	return w, nil
+}
+
+// open opens the log file for appending.
+func (w *Writer) open() error {
+	f, err := os.OpenFile(w.opts.Filename, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	w.f = f
+	w.size = fi.Size()
+	return nil
+}
+
+// Write writes p to the log file, it rotates the file before if writing p
+// would exceed the maximum size.
+func (w *Writer) Write(p []byte) (int, error) {
+	w.m.Lock()
+	defer w.m.Unlock()
+
+	if w.f == nil {
+		return 0, os.ErrClosed
+	}
+
+	if w.opts.MaxSize > 0 && w.size > 0 && w.size+int64(len(p)) > w.opts.MaxSize {
+		err := w.rotate()
+		if err != nil {
+			return 0, fmt.Errorf("rotating log file: %v", err)
+		}
+	}
+
+	n, err := w.f.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+// Rotate closes the current log file, renames it and opens a new one.
+func (w *Writer) Rotate() error {
+	w.m.Lock()
+	defer w.m.Unlock()
+
+	return w.rotate()
+}
+
+func (w *Writer) rotate() error {
+	if w.f != nil {
+		err := w.f.Close()
+		if err != nil {
+			return err
+		}
+		w.f = nil
+	}
+
+	backup := w.opts.Filename + "." + time.Now().Format(backupTimeFormat)
+	err := os.Rename(w.opts.Filename, backup)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	err = w.open()
+	if err != nil {
+		return err
+	}
+
+	return w.removeOld()
+}
+
+// Backups returns the file names of all rotated log files, newest first.
+func (w *Writer) Backups() ([]string, error) {
+	files, err := filepath.Glob(w.opts.Filename + ".*")
+	if err != nil {
+		return nil, err
+	}
+
+	var backups []string
+	for _, file := range files {
+		suffix := strings.TrimPrefix(file, w.opts.Filename+".")
+		if _, err := time.Parse(backupTimeFormat, suffix); err != nil {
+			// not one of our files
+			continue
+		}
+		backups = append(backups, file)
+	}
+
+	// the timestamp format sorts lexically
+	sort.Sort(sort.Reverse(sort.StringSlice(backups)))
+	return backups, nil
+}
+
+// removeOld removes rotated log files exceeding MaxBackups or MaxAge.
+func (w *Writer) removeOld() error {
+	if w.opts.MaxBackups == 0 && w.opts.MaxAge == 0 {
+		return nil
+	}
+
+	backups, err := w.Backups()
+	if err != nil {
+		return err
+	}
+
+	for i, file := range backups {
+		remove := w.opts.MaxBackups > 0 && i >= w.opts.MaxBackups
+
+		if !remove && w.opts.MaxAge > 0 {
+			suffix := strings.TrimPrefix(file, w.opts.Filename+".")
+			t, err := time.ParseInLocation(backupTimeFormat, suffix, time.Local)
+			if err == nil && time.Since(t) > w.opts.MaxAge {
+				remove = true
+			}
+		}
+
+		if !remove {
+			continue
+		}
+
+		err := os.Remove(file)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Close closes the log file.
+func (w *Writer) Close() error {
+	w.m.Lock()
+	defer w.m.Unlock()
+
+	if w.f == nil {
+		return nil
+	}
+
+	err := w.f.Close()
+	w.f = nil
+	return err
+}
@@ -0,0 +1,89 @@
+package logfile
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriterRotate(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "osmosis.testing.logfile.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "proxy.log")
+	wr, err := New(Options{Filename: filename, MaxSize: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wr.Close()
+
+	first := bytes.Repeat([]byte("a"), 80)
+	second := bytes.Repeat([]byte("b"), 80)
+
+	for _, buf := range [][]byte{first, second} {
+		_, err = wr.Write(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	backups, err := wr.Backups()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(backups) != 1 {
+		t.Fatalf("wrong number of rotated files, want 1, got %v", backups)
+	}
+
+	buf, err := ioutil.ReadFile(backups[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf, first) {
+		t.Errorf("rotated file has wrong content, want %q, got %q", first, buf)
+	}
+
+	buf, err = ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf, second) {
+		t.Errorf("log file has wrong content, want %q, got %q", second, buf)
+	}
+}
+
+func TestWriterMaxBackups(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "osmosis.testing.logfile.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	wr, err := New(Options{Filename: filepath.Join(dir, "proxy.log"), MaxSize: 10, MaxBackups: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wr.Close()
+
+	for i := 0; i < 5; i++ {
+		_, err = wr.Write([]byte("0123456789"))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	backups, err := wr.Backups()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(backups) != 2 {
+		t.Fatalf("wrong number of rotated files, want 2, got %v", backups)
+	}
+}
@@ -2,44 +2,205 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
+	"net"
+	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"runtime"
 	"strings"
 	"time"
 
 	"github.com/fd0/osmosis/certauth"
+	"github.com/fd0/osmosis/config"
+	"github.com/fd0/osmosis/logfile"
 	"github.com/fd0/osmosis/proxy"
 	"github.com/fd0/osmosis/proxy/hooks"
+	"github.com/fd0/osmosis/redact"
+	"github.com/fd0/osmosis/replay"
+	"github.com/fd0/osmosis/store"
+	"github.com/fd0/osmosis/webui"
 	"github.com/spf13/pflag"
 )
 
 // Options collects global settings.
 type Options struct {
+	ConfigFile                       string
 	CertificateFilename, KeyFilename string
-	Listen                           string
+	Listen                           []string
+	ListenTLS                        []string
 	Logdir                           string
+	StoreDir                         string
+	StoreEncoding                    string
 	NoGui                            bool
+	WebUI                            bool
+	WebUIToken                       string
+	Redact                           bool
+	RedactHeaders                    []string
+	RedactJSONFields                 []string
+	JSON                             bool
+	ResponseHeaders                  []string
+	RequestHeaders                   []string
+	CertClientAuth                   bool
+	CTPoison                         bool
+	NoVerifyCA                       bool
+	NoClone                          bool
+	LogCertLookups                   bool
+	PassthroughContentTypes          []string
+	BufferContentTypes               []string
+	UpstreamProxy                    string
+	RootCAs                          []string
+	SchemeOverrides                  []string
+	RewriteURLs                      []string
+	WebsocketLogMessages             bool
+	WebsocketLogPayloads             bool
+	MaxRequestBodySize               int64
+	LeakThreshold                    time.Duration
+	StreamLargeRequests              bool
+	MaxHeaderCount                   int
+	MaxWebsockets                    int
+	MaxHeaderSize                    int
+	TrimLargeHeaders                 bool
+	ForwardEarlyHints                bool
+	StaleOnError                     bool
+	AllowedConnectPorts              []int
+	TranscriptDir                    string
+	HSTSPassthrough                  bool
+	UpstreamCiphers                  string
+	DefaultHost                      string
+	Scope                            []string
+	BlockStatus                      int
+	BlockBody                        string
+	BlockHeaders                     []string
+	DisabledHooks                    []string
+	CASubject                        string
+	CertSubject                      string
+	ReplayKeepConditional            bool
+	ReplayStripCache                 bool
+	StoreScopes                      []string
+	StoreRawRequests                 bool
+	StoreRawResponses                bool
+	StoreErrors                      bool
+	StoreAsync                       bool
+	ReplayEnvs                       []string
+	Shadow                           string
+	ShadowScheme                     string
+	ShadowReturn                     bool
+	OnScriptError                    string
+
+	LogFile         string
+	AccessLog       string
+	AccessLogFormat string
+	LogMaxSize      int
+	LogMaxBackups   int
+	LogMaxAge       time.Duration
 }
 
 var opts Options
 
+// args contains the remaining command line arguments (a subcommand)
+var args []string
+
 func init() {
 	fs := pflag.NewFlagSet("osmosis", pflag.ExitOnError)
+	fs.StringVar(&opts.ConfigFile, "config", "", "read options from JSON `file`, command line flags take precedence")
 	fs.StringVar(&opts.CertificateFilename, "cert", "ca.crt", "read certificate from `file`")
 	fs.StringVar(&opts.KeyFilename, "key", "ca.key", "read private key from `file`")
-	fs.StringVar(&opts.Listen, "listen", "[::1]:8080", "listen at `addr`")
+	fs.StringSliceVar(&opts.Listen, "listen", []string{"[::1]:8080"}, "listen at `addr` (can be specified multiple times)")
+	fs.StringSliceVar(&opts.ListenTLS, "listen-tls", nil, "terminate TLS for clients connecting directly (without CONNECT) at `addr`")
 	fs.StringVar(&opts.Logdir, "log-dir", "", "set log `directory` (default: log-YYYMMMDDD-HHMMSS)")
 	fs.BoolVar(&opts.NoGui, "no-gui", false, "Disable graphical user interface")
+	fs.BoolVar(&opts.WebUI, "web-ui", false, "serve a web interface for the store at http://proxy/")
+	fs.StringVar(&opts.WebUIToken, "web-ui-token", "", "require `token` to access the web interface (default: random, logged at startup)")
+	fs.BoolVar(&opts.Redact, "redact", false, "mask credential headers (Authorization, Cookie, ...) in the web interface")
+	fs.StringSliceVar(&opts.RedactHeaders, "redact-header", nil, "also mask header `name` (implies --redact)")
+	fs.StringSliceVar(&opts.RedactJSONFields, "redact-json", nil, "mask JSON body field `name` (implies --redact)")
+	fs.BoolVar(&opts.JSON, "json", false, "print one JSON line per completed transaction to stdout")
+	fs.StringArrayVar(&opts.ResponseHeaders, "response-header", nil, "modify response headers sent to the client: `Name: value` sets, +Name: value adds, -Name removes")
+	fs.StringArrayVar(&opts.RequestHeaders, "request-header", nil, "set header on all requests sent upstream, replacing the client's value: `Name: value`")
+	fs.StringVar(&opts.StoreDir, "store", "store", "record transactions in the store in `dir`, which is also used by the web UI")
+	fs.StringSliceVar(&opts.StoreScopes, "store-scope", nil, "record transactions for hosts matching `pattern=dir` in a separate store (e.g. *.example.com=store-example)")
+	fs.BoolVar(&opts.StoreRawRequests, "store-raw-requests", false, "also store the exact bytes of requests as received from the client (reads request bodies into memory, tunnels use HTTP/1.1)")
+	fs.BoolVar(&opts.StoreRawResponses, "store-raw-responses", false, "also store the exact bytes of responses (sends each request over a new HTTP/1.1 connection, bypassing the upstream proxy)")
+	fs.BoolVar(&opts.StoreErrors, "store-errors", false, "also store why a request could not be forwarded, the transaction is shown as an error")
+	fs.BoolVar(&opts.StoreAsync, "store-async", false, "write transactions to the store in the background instead of delaying the responses, queued writes are stored on shutdown")
+	fs.StringVar(&opts.StoreEncoding, "store-encoding", "raw", "write requests and responses to the store as `raw`, base64 or hex")
+	fs.StringSliceVar(&opts.PassthroughContentTypes, "passthrough", nil, "pass responses matching content type `pattern` (e.g. video/*) through untouched")
+	fs.StringSliceVar(&opts.BufferContentTypes, "buffer", nil, "always pass responses matching content type `pattern` through the hooks even if they match --passthrough")
+	fs.StringVar(&opts.UpstreamProxy, "upstream-proxy", "", "send requests through the HTTP proxy at `url` (default: from environment)")
+	fs.StringSliceVar(&opts.RootCAs, "root-ca", nil, "also trust root certificates from `file` or directory for upstream servers")
+	fs.Int64Var(&opts.MaxRequestBodySize, "max-request-body", 0, "reject request bodies larger than `n` bytes (0 disables the limit)")
+	fs.DurationVar(&opts.LeakThreshold, "leak-threshold", 0, "log requests still running `duration` after their connection was closed (0 disables)")
+	fs.BoolVar(&opts.StreamLargeRequests, "stream-large-requests", false, "forward requests exceeding --max-request-body without running the hooks")
+	fs.IntVar(&opts.MaxWebsockets, "max-websockets", 0, "reject websocket upgrades while `n` websocket connections are open (0 disables the limit)")
+	fs.IntVar(&opts.MaxHeaderCount, "max-header-count", 0, "reject requests with more than `n` header fields (0 disables the limit)")
+	fs.IntVar(&opts.MaxHeaderSize, "max-header-size", 0, "reject requests with more than `n` bytes of header fields (0 disables the limit)")
+	fs.BoolVar(&opts.TrimLargeHeaders, "trim-large-headers", false, "remove header fields exceeding --max-header-count or --max-header-size instead of rejecting the request")
+	fs.BoolVar(&opts.ForwardEarlyHints, "forward-early-hints", false, "pass 103 Early Hints responses from upstream servers on to the client")
+	fs.IntSliceVar(&opts.AllowedConnectPorts, "allow-connect-port", nil, "only allow CONNECT requests to `port` (can be specified multiple times, default: all ports)")
+	fs.StringVar(&opts.TranscriptDir, "transcript-dir", "", "write the decrypted bytes of each CONNECT tunnel to a file in `dir` (contains secrets!)")
+	fs.StringSliceVar(&opts.Scope, "scope", nil, "only allow requests to hosts matching `pattern` (e.g. *.example.com), block all others")
+	fs.IntVar(&opts.BlockStatus, "block-status", 0, "respond to blocked requests with status `code` (default: 403 with the reason)")
+	fs.StringVar(&opts.BlockBody, "block-body", "", "send `text` as the body of responses to blocked requests")
+	fs.StringArrayVar(&opts.BlockHeaders, "block-header", nil, "set header on responses to blocked requests: `Name: value`")
+	fs.StringVar(&opts.DefaultHost, "default-host", "", "send requests without absolute URL and Host header to `host[:port]`")
+	fs.StringVar(&opts.UpstreamCiphers, "upstream-ciphers", "default", "restrict the cipher suites, curves and TLS versions offered to upstream servers to `profile` (modern, compatible, legacy)")
+	fs.BoolVar(&opts.HSTSPassthrough, "hsts-passthrough", false, "don't intercept connections to hosts using HSTS, tunnel them instead")
+	fs.StringSliceVar(&opts.DisabledHooks, "disable-hook", nil, "don't run the hook `name` (toggles in the web interface are saved to --config)")
+	fs.BoolVar(&opts.ReplayKeepConditional, "replay-keep-conditional", false, "keep conditional headers (If-None-Match, ...) when resending requests")
+	fs.BoolVar(&opts.ReplayStripCache, "replay-strip-cache", false, "remove Cache-Control and Pragma when resending requests")
+	fs.StringArrayVar(&opts.ReplayEnvs, "replay-env", nil, "load the environment `name=file` (a JSON object) for resending requests in the web UI")
+	fs.StringVar(&opts.OnScriptError, "on-script-error", "fail", "handle scripts which fail or return an unparsable request or response with `policy` (fail, continue)")
+	fs.StringVar(&opts.Shadow, "shadow", "", "also send each request to `host[:port]` and store the differences of the responses with the transaction")
+	fs.StringVar(&opts.ShadowScheme, "shadow-scheme", "", "connect to the --shadow host with `scheme` (default: the scheme of the request)")
+	fs.BoolVar(&opts.ShadowReturn, "shadow-return", false, "send the response of the --shadow host to the client instead")
+	fs.BoolVar(&opts.StaleOnError, "stale-on-error", false, "serve the last response for an equivalent request when the upstream fails")
+	fs.BoolVar(&opts.WebsocketLogMessages, "ws-log-messages", false, "log the direction, type and length of each websocket message")
+	fs.BoolVar(&opts.WebsocketLogPayloads, "ws-log-payloads", false, "log the payload of each websocket message (implies --ws-log-messages)")
+	fs.StringSliceVar(&opts.SchemeOverrides, "scheme-override", nil, "connect to a host with a fixed scheme, `host=scheme` (e.g. staging.local=http)")
+	fs.StringSliceVar(&opts.RewriteURLs, "rewrite-urls", nil, "rewrite URLs in HTML, CSS and JS responses, `from=to` (e.g. https://example.com=http://localhost:8000)")
+	fs.StringVar(&opts.CASubject, "ca-subject", "", "use `subject` (CN=..,O=..,C=.. or digicert, globalsign, letsencrypt) for a newly generated CA")
+	fs.StringVar(&opts.CertSubject, "cert-subject", "", "set `subject` attributes (O=..,OU=..,C=..,L=..,ST=..) in generated certificates")
+	fs.BoolVar(&opts.CertClientAuth, "cert-client-auth", false, "include the client auth usage in generated certificates")
+	fs.BoolVar(&opts.NoClone, "no-clone", false, "don't fetch and clone upstream certificates, always generate a minimal one")
+	fs.BoolVar(&opts.LogCertLookups, "log-cert-lookups", false, "log each certificate lookup (repetitions are summarized)")
+	fs.BoolVar(&opts.NoVerifyCA, "no-verify-ca", false, "don't check at startup that generated certificates chain to the CA certificate")
+	fs.BoolVar(&opts.CTPoison, "ct-poison", false, "mark generated certificates as CT precertificates (for testing, most clients reject them)")
+	fs.StringVar(&opts.LogFile, "log-file", "", "write proxy log to `file` instead of stderr")
+	fs.StringVar(&opts.AccessLog, "access-log", "", "write a line for each transaction to `file`")
+	fs.StringVar(&opts.AccessLogFormat, "access-log-format", "common", "write the access log in `format` (common, w3c)")
+	fs.IntVar(&opts.LogMaxSize, "log-max-size", 100, "rotate the log file when it reaches `n` MiB (0 disables rotation)")
+	fs.IntVar(&opts.LogMaxBackups, "log-max-backups", 5, "keep at most `n` rotated log files (0 keeps all)")
+	fs.DurationVar(&opts.LogMaxAge, "log-max-age", 0, "remove rotated log files older than `duration` (0 keeps them)")
 
 	err := fs.Parse(os.Args)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "error parsing flags: %v\n", err)
 		os.Exit(1)
 	}
+
+	if opts.ConfigFile != "" {
+		cfg, err := config.Load(opts.ConfigFile)
+		if err == nil {
+			err = cfg.Apply(fs)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error loading config: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	// the first argument is the program name
+	args = fs.Args()[1:]
 }
 
 func warn(msg string, args ...interface{}) {
@@ -50,10 +211,28 @@ func warn(msg string, args ...interface{}) {
 }
 
 func main() {
+	if len(args) > 0 {
+		err := runCommand(args)
+		if err != nil {
+			warn("%v", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	ca, err := certauth.Load(opts.CertificateFilename, opts.KeyFilename)
 	if os.IsNotExist(err) {
-		fmt.Printf("generate new CA certificate\n")
-		ca, err = certauth.NewCA()
+		warn("generate new CA certificate")
+		subject := certauth.DefaultCASubject
+		if opts.CASubject != "" {
+			subject, err = certauth.ParseSubject(opts.CASubject)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "invalid --ca-subject: %v\n", err)
+				os.Exit(1)
+			}
+		}
+
+		ca, err = certauth.NewCAWithSubject(subject)
 		if err != nil {
 			panic(err)
 		}
@@ -62,6 +241,30 @@ func main() {
 		if err != nil {
 			panic(err)
 		}
+	} else if err != nil {
+		warn("loading CA failed: %v", err)
+		os.Exit(1)
+	}
+
+	if opts.CertClientAuth {
+		ca.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth}
+	}
+	ca.CTPoison = opts.CTPoison
+
+	if opts.CertSubject != "" {
+		ca.LeafSubject, err = certauth.ParseSubject(opts.CertSubject)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid --cert-subject: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if !opts.NoVerifyCA {
+		err = ca.Verify()
+		if err != nil {
+			warn("CA check failed: %v", err)
+			os.Exit(1)
+		}
 	}
 
 	if opts.Logdir != "" {
@@ -84,24 +287,277 @@ func main() {
 		}()
 	}
 
-	p := proxy.New(opts.Listen, ca, nil, os.Stdout)
+	var logWriter io.Writer = os.Stderr
+	if opts.LogFile != "" {
+		wr, err := logfile.New(logfile.Options{
+			Filename:   opts.LogFile,
+			MaxSize:    int64(opts.LogMaxSize) * 1024 * 1024,
+			MaxBackups: opts.LogMaxBackups,
+			MaxAge:     opts.LogMaxAge,
+		})
+		if err != nil {
+			panic(err)
+		}
+		defer wr.Close()
+		logWriter = wr
+	}
+	log.SetOutput(logWriter)
 
-	preScriptHook, err := hooks.CompileTengoPreHookFile("pre.tengo")
+	if len(opts.Listen) == 0 {
+		warn("no listen address specified")
+		os.Exit(1)
+	}
+
+	p := proxy.New(opts.Listen[0], ca, nil, logWriter)
+	p.Cache.NoClone = opts.NoClone
+	p.Cache.Debug = opts.LogCertLookups
+	p.MaxRequestBodySize = opts.MaxRequestBodySize
+	p.StreamLargeRequests = opts.StreamLargeRequests
+	p.MaxHeaderCount = opts.MaxHeaderCount
+	p.MaxWebsockets = opts.MaxWebsockets
+	p.MaxHeaderSize = opts.MaxHeaderSize
+	p.TrimLargeHeaders = opts.TrimLargeHeaders
+	p.LeakThreshold = opts.LeakThreshold
+	p.ForwardEarlyHints = opts.ForwardEarlyHints
+	p.StaleOnError = opts.StaleOnError
+	p.TranscriptDir = opts.TranscriptDir
+	p.HSTSPassthrough = opts.HSTSPassthrough
+	p.CaptureRaw = opts.StoreRawRequests
+	p.CaptureRawResponses = opts.StoreRawResponses
+
+	requestHeaders, err := parseHeaderList(opts.RequestHeaders)
+	if err != nil {
+		warn("invalid request header: %v", err)
+		os.Exit(1)
+	}
+
+	blockHeaders, err := parseHeaderList(opts.BlockHeaders)
+	if err != nil {
+		warn("invalid block header: %v", err)
+		os.Exit(1)
+	}
+
+	p.UpdateConfig(func(cfg *proxy.Config) {
+		cfg.PassthroughContentTypes = opts.PassthroughContentTypes
+		cfg.BufferContentTypes = opts.BufferContentTypes
+		cfg.AllowedConnectPorts = opts.AllowedConnectPorts
+		cfg.DefaultHost = opts.DefaultHost
+		cfg.StaticRequestHeaders = requestHeaders
+		cfg.Scope = opts.Scope
+		cfg.Block = proxy.BlockAction{
+			StatusCode: opts.BlockStatus,
+			Header:     blockHeaders,
+			Body:       opts.BlockBody,
+		}
+	})
+
+	if opts.AccessLog != "" {
+		format, err := proxy.ParseAccessLogFormat(opts.AccessLogFormat)
+		if err != nil {
+			warn("invalid --access-log-format: %v", err)
+			os.Exit(1)
+		}
+
+		wr, err := logfile.New(logfile.Options{
+			Filename:   opts.AccessLog,
+			MaxSize:    int64(opts.LogMaxSize) * 1024 * 1024,
+			MaxBackups: opts.LogMaxBackups,
+			MaxAge:     opts.LogMaxAge,
+		})
+		if err != nil {
+			warn("opening access log failed: %v", err)
+			os.Exit(1)
+		}
+		defer wr.Close()
+		p.AccessLog = proxy.NewAccessLog(wr, format)
+	}
+
+	if opts.UpstreamCiphers != "default" {
+		err = p.SetCipherProfile(opts.UpstreamCiphers)
+		if err != nil {
+			warn("%v, available: %v", err, strings.Join(proxy.CipherProfileNames(), ", "))
+			os.Exit(1)
+		}
+	}
+
+	switch {
+	case opts.WebsocketLogPayloads:
+		p.WebsocketLog = proxy.WebsocketLogPayloads
+	case opts.WebsocketLogMessages:
+		p.WebsocketLog = proxy.WebsocketLogMessages
+	}
+
+	if opts.UpstreamProxy != "" {
+		upstream, err := url.Parse(opts.UpstreamProxy)
+		if err != nil {
+			warn("invalid upstream proxy: %v", err)
+			os.Exit(1)
+		}
+		p.SetUpstreamProxy(upstream)
+	}
+
+	for _, override := range opts.SchemeOverrides {
+		parts := strings.SplitN(override, "=", 2)
+		if len(parts) != 2 || (parts[1] != "http" && parts[1] != "https") {
+			warn("invalid scheme override %q, want host=http or host=https", override)
+			os.Exit(1)
+		}
+		p.SetSchemeOverride(parts[0], parts[1])
+	}
+
+	if len(opts.RootCAs) > 0 {
+		pool, err := certauth.LoadCertPool(opts.RootCAs)
+		if err != nil {
+			warn("loading root certificates failed: %v", err)
+			os.Exit(1)
+		}
+		p.SetRootCAs(pool)
+	}
+
+	encoding, err := store.ParseEncoding(opts.StoreEncoding)
+	if err != nil {
+		warn("%v", err)
+		os.Exit(1)
+	}
+	s, err := store.New(opts.StoreDir)
+	if err != nil {
+		warn("opening store failed: %v", err)
+		os.Exit(1)
+	}
+	s.Encoding = encoding
+
+	router := &store.Router{Default: s}
+	err = addStoreScopes(router, opts.StoreScopes, opts.StoreDir, encoding)
+	if err != nil {
+		_ = router.Close()
+		warn("%v", err)
+		os.Exit(1)
+	}
+
+	if opts.WebUI {
+		r := replay.New(s, nil)
+		r.StripConditional = !opts.ReplayKeepConditional
+		r.StripCache = opts.ReplayStripCache
+		ui := webui.New(s, r)
+		ui.Token = opts.WebUIToken
+		if ui.Token == "" {
+			ui.Token, err = randomToken()
+			if err != nil {
+				warn("%v", err)
+				os.Exit(1)
+			}
+		}
+		log.Printf("Web UI: http://proxy/?token=%v\n", ui.Token)
+		ui.Environments, err = loadReplayEnvs(opts.ReplayEnvs)
+		if err != nil {
+			warn("%v", err)
+			os.Exit(1)
+		}
+		if opts.Redact || len(opts.RedactHeaders) > 0 || len(opts.RedactJSONFields) > 0 {
+			ui.Redactor = &redact.Redactor{
+				Headers:    append(redact.DefaultHeaders, opts.RedactHeaders...),
+				JSONFields: opts.RedactJSONFields,
+			}
+		}
+		ui.Hooks = &hookState{Proxy: p, filename: opts.ConfigFile}
+		p.WebsocketFeed = proxy.NewWebsocketFeed()
+		ui.Websockets = p.WebsocketFeed
+		p.AdminHandler = ui
+	}
+
+	register := func(name, scope string, f func(*proxy.Event) (*proxy.Response, error)) {
+		err := p.RegisterNamed(name, scope, f)
+		if err != nil {
+			warn("%v", err)
+			os.Exit(1)
+		}
+	}
+
+	onScriptError, err := hooks.ParseScriptErrorPolicy(opts.OnScriptError)
+	if err != nil {
+		warn("invalid --on-script-error: %v", err)
+		os.Exit(1)
+	}
+	preScriptHook, err := hooks.CompileTengoPreHookFile("pre.tengo", onScriptError)
 	if err != nil {
 		log.Fatal(err)
 	}
-	postScriptHook, err := hooks.CompileTengoPostHookFile("post.tengo")
+	postScriptHook, err := hooks.CompileTengoPostHookFile("post.tengo", onScriptError)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	p.Register(preScriptHook, hooks.RemoveCompression)
+	if opts.JSON {
+		// registered first so that the duration includes all other hooks
+		register("json-log", "all requests", hooks.LogJSON(os.Stdout))
+	}
+	if len(opts.RewriteURLs) > 0 {
+		var rewrites []hooks.URLRewrite
+		for _, s := range opts.RewriteURLs {
+			parts := strings.SplitN(s, "=", 2)
+			if len(parts) != 2 {
+				warn("invalid URL rewrite %q, want from=to", s)
+				os.Exit(1)
+			}
+			rewrites = append(rewrites, hooks.URLRewrite{From: parts[0], To: parts[1]})
+		}
+		hook, err := hooks.RewriteURLs(nil, rewrites...)
+		if err != nil {
+			warn("%v", err)
+			os.Exit(1)
+		}
+		register("rewrite-urls", "HTML, CSS and JS responses", hook)
+	}
+	if len(opts.ResponseHeaders) > 0 {
+		var edits []hooks.HeaderEdit
+		for _, s := range opts.ResponseHeaders {
+			edit, err := hooks.ParseHeaderEdit(s)
+			if err != nil {
+				warn("%v", err)
+				os.Exit(1)
+			}
+			edits = append(edits, edit)
+		}
+		register("response-headers", "all responses", hooks.ResponseHeaders(edits...))
+	}
+	register("post-script", "all requests (post.tengo)", postScriptHook)
+	register("log-request", "all requests", hooks.LogCompleteRequest)
 	// Header rewrite demo
-	p.Register(func(event *proxy.Event) (*proxy.Response, error) {
+	register("user-agent", "all requests", func(event *proxy.Event) (*proxy.Response, error) {
 		event.Req.Header["User-Agent"] = []string{"Osmosis Proxy"}
 		return event.ForwardRequest()
 	})
-	p.Register(hooks.LogCompleteRequest, postScriptHook)
+	register("remove-compression", "all requests", hooks.RemoveCompression)
+	register("pre-script", "all requests (pre.tengo)", preScriptHook)
+
+	// registered last so that the requests and responses are recorded as
+	// they are exchanged with the upstream server
+	var writers *store.Writers
+	if opts.StoreAsync {
+		writers = &store.Writers{Workers: 4, QueueSize: 256}
+	}
+	register("record", "all requests (--store, --store-scope)", hooks.Record(router, opts.StoreErrors, writers))
+
+	if opts.Shadow != "" {
+		if opts.ShadowScheme != "" && opts.ShadowScheme != "http" && opts.ShadowScheme != "https" {
+			warn("invalid --shadow-scheme %q, want http or https", opts.ShadowScheme)
+			os.Exit(1)
+		}
+		p.Shadow = &proxy.Shadow{
+			Host:         opts.Shadow,
+			Scheme:       opts.ShadowScheme,
+			ReturnShadow: opts.ShadowReturn,
+			OnResult:     hooks.RecordShadow(),
+		}
+	}
+
+	for _, name := range opts.DisabledHooks {
+		err = p.SetHookEnabled(name, false)
+		if err != nil {
+			// the hook may only be registered with other options
+			warn("%v", err)
+		}
+	}
 
 	log.SetFlags(log.Ldate | log.Ltime | log.Lmicroseconds)
 	log.Printf("CA loaded: %v\n", ca.Certificate.Subject)
@@ -110,22 +566,165 @@ func main() {
 		ticker := time.NewTicker(2 * time.Second)
 		defer ticker.Stop()
 
-		var last int
+		var last proxy.Stats
+		var lastGoroutines int
 		for range ticker.C {
-			var cur = runtime.NumGoroutine()
-			if cur != last {
-				log.Printf("%d active goroutines", cur)
+			cur := p.Stats()
+			goroutines := runtime.NumGoroutine()
+			if cur != last || goroutines != lastGoroutines {
+				log.Printf("%d requests (%d active, %d errors), %d bytes in, %d bytes out, %d certificates cached, %d websockets (%d rejected), %d goroutines",
+					cur.Requests, cur.ActiveRequests, cur.Errors, cur.BytesIn, cur.BytesOut, cur.CachedCertificates,
+					cur.ActiveWebsockets, cur.RejectedWebsockets, goroutines)
 				last = cur
+				lastGoroutines = goroutines
 			}
 		}
 	}()
 
+	done := make(chan struct{})
 	go func() {
 		sigchan := make(chan os.Signal, 10)
 		signal.Notify(sigchan, os.Interrupt)
 		<-sigchan
-		p.Shutdown(context.Background())
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		err := shutdown(ctx, p, router, writers)
+		if err != nil {
+			log.Printf("shutdown: %v", err)
+		}
+		close(done)
 	}()
 
-	log.Println(p.ListenAndServe())
+	for _, addr := range opts.ListenTLS {
+		listener, err := net.Listen("tcp", addr)
+		if err != nil {
+			warn("%v", err)
+			os.Exit(1)
+		}
+		log.Printf("Listening on %s (TLS)\n", addr)
+
+		go func() {
+			err := p.ServeTLS(listener)
+			if err != nil && err != http.ErrServerClosed {
+				log.Printf("serving TLS: %v", err)
+			}
+		}()
+	}
+
+	err = p.ListenAndServeAll(opts.Listen)
+	if err == http.ErrServerClosed {
+		// wait until the store is closed before the log directory is removed
+		<-done
+		return
+	}
+
+	log.Println(err)
+	_ = router.Close()
+}
+
+// loadReplayEnvs loads the environments "name=file".
+func loadReplayEnvs(specs []string) (map[string]replay.Environment, error) {
+	envs := make(map[string]replay.Environment)
+	for _, spec := range specs {
+		parts := strings.SplitN(spec, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("invalid replay environment %q, want name=file", spec)
+		}
+
+		env, err := replay.LoadEnvironment(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("loading replay environment %v: %v", parts[0], err)
+		}
+		envs[parts[0]] = env
+	}
+	return envs, nil
+}
+
+// randomToken returns a random token for the web UI.
+func randomToken() (string, error) {
+	buf := make([]byte, 16)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return "", fmt.Errorf("generating token: %v", err)
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// addStoreScopes adds the scopes "pattern=dir" to router. Scopes with the
+// same directory share a store, a scope for mainDir uses the default store of
+// the router. New stores write values with enc.
+func addStoreScopes(router *store.Router, scopes []string, mainDir string, enc store.Encoding) error {
+	stores := map[string]*store.TxnStore{
+		filepath.Clean(mainDir): router.Default,
+	}
+	for _, scope := range scopes {
+		parts := strings.SplitN(scope, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return fmt.Errorf("invalid store scope %q, want pattern=dir", scope)
+		}
+
+		dir := filepath.Clean(parts[1])
+		s, ok := stores[dir]
+		if !ok {
+			var err error
+			s, err = store.New(dir)
+			if err != nil {
+				return fmt.Errorf("opening store for %v failed: %v", parts[0], err)
+			}
+			s.Encoding = enc
+			stores[dir] = s
+		}
+		router.Add(parts[0], s)
+	}
+	return nil
+}
+
+// shutdown stops the proxy, waiting for active requests to finish, stores the
+// writes queued in writers (which may be nil) and then closes the stores of
+// router so that all data is flushed to disk.
+func shutdown(ctx context.Context, p *proxy.Proxy, router *store.Router, writers *store.Writers) error {
+	// the proxy may not stop in time when streamed responses are still open,
+	// the queued transactions must be written and the stores closed anyway
+	var errs []string
+	err := p.Shutdown(ctx)
+	if err != nil {
+		errs = append(errs, fmt.Sprintf("stopping proxy: %v", err))
+	}
+
+	if writers != nil {
+		err = writers.Close()
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("writing queued transactions: %v", err))
+		}
+	}
+
+	err = router.Close()
+	if err != nil {
+		errs = append(errs, fmt.Sprintf("closing stores: %v", err))
+	}
+
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// parseHeaderList parses a list of headers in the form "Name: value", the
+// result is nil if the list is empty.
+func parseHeaderList(list []string) (http.Header, error) {
+	if len(list) == 0 {
+		return nil, nil
+	}
+
+	hdr := make(http.Header)
+	for _, s := range list {
+		parts := strings.SplitN(s, ":", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return nil, fmt.Errorf("%q, want Name: value", s)
+		}
+		hdr.Add(strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]))
+	}
+	return hdr, nil
 }
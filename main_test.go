@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fd0/osmosis/proxy"
+	"github.com/fd0/osmosis/store"
+)
+
+func TestShutdownClosesStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "osmosis-test-shutdown-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := store.New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, serve, _ := proxy.TestProxy(t, nil)
+	go serve()
+
+	req, err := http.NewRequest(http.MethodGet, "http://example.com/foo", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the request may still be queued when shutdown is called
+	writers := &store.Writers{Workers: 1, QueueSize: 1}
+	err = writers.Get(s).AddRequest(1, req, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err = shutdown(ctx, p, &store.Router{Default: s}, writers)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the store can be opened again and contains the request
+	s, err = store.New(dir)
+	if err != nil {
+		t.Fatalf("reopening store failed: %v", err)
+	}
+	defer s.Close()
+
+	stored, err := s.GetRequest(1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.URL.String() != req.URL.String() {
+		t.Errorf("wrong request stored, want %v, got %v", req.URL, stored.URL)
+	}
+}
+
+func TestShutdownTimeoutClosesStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "osmosis-test-shutdown-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := store.New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, serve, _ := proxy.TestProxy(t, nil)
+
+	// block a request in the proxy so that the shutdown times out
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	defer close(release)
+	p.Register(func(event *proxy.Event) (*proxy.Response, error) {
+		close(entered)
+		<-release
+		return nil, nil
+	})
+	go serve()
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			Proxy: http.ProxyURL(&url.URL{Scheme: "http", Host: p.Addr}),
+		},
+	}
+	go func() {
+		res, err := client.Get("http://example.com/slow")
+		if err == nil {
+			res.Body.Close()
+		}
+	}()
+	<-entered
+
+	req, err := http.NewRequest(http.MethodGet, "http://example.com/foo", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writers := &store.Writers{Workers: 1, QueueSize: 1}
+	err = writers.Get(s).AddRequest(1, req, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	err = shutdown(ctx, p, &store.Router{Default: s}, writers)
+	if err == nil {
+		t.Fatal("shutdown did not return an error")
+	}
+
+	// the store was closed anyway and contains the queued request
+	s, err = store.New(dir)
+	if err != nil {
+		t.Fatalf("reopening store failed: %v", err)
+	}
+	defer s.Close()
+
+	_, err = s.GetRequest(1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAddStoreScopes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "osmosis-test-scopes-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	mainDir := filepath.Join(dir, "main")
+	s, err := store.New(mainDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Encoding = store.EncodingHex
+	router := &store.Router{Default: s}
+	defer router.Close()
+
+	err = addStoreScopes(router, []string{
+		"a.example.com=" + mainDir + "/",
+		"b.example.com=" + filepath.Join(dir, "other"),
+		"c.example.com=" + filepath.Join(dir, "other"),
+	}, mainDir, store.EncodingHex)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if router.Lookup("a.example.com") != s {
+		t.Errorf("scope for the main store directory did not reuse the main store")
+	}
+	other := router.Lookup("b.example.com")
+	if other == nil || other == s || router.Lookup("c.example.com") != other {
+		t.Errorf("scopes with the same directory do not share a store")
+	}
+	if other.Encoding != store.EncodingHex {
+		t.Errorf("encoding not applied to the scope store")
+	}
+	if router.Lookup("unknown.example.com") != s {
+		t.Errorf("unknown hosts are not recorded in the main store")
+	}
+}
@@ -0,0 +1,135 @@
+package proxy
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AccessLogFormat selects the format of the lines written to an AccessLog.
+type AccessLogFormat int
+
+// These are the supported access log formats.
+const (
+	// AccessLogCommon is the Common Log Format (CLF) used by web servers:
+	// remote host, identity and user (always "-"), timestamp, request line,
+	// status and the number of bytes sent.
+	AccessLogCommon AccessLogFormat = iota
+
+	// AccessLogW3C is the W3C Extended Log File Format, with the fields
+	// listed in the "#Fields" directive at the start of the log.
+	AccessLogW3C
+)
+
+// ParseAccessLogFormat returns the format for the name "common" (or "clf")
+// or "w3c" (or "elf").
+func ParseAccessLogFormat(name string) (AccessLogFormat, error) {
+	switch strings.ToLower(name) {
+	case "common", "clf":
+		return AccessLogCommon, nil
+	case "w3c", "elf":
+		return AccessLogW3C, nil
+	}
+	return 0, fmt.Errorf("unknown access log format %q", name)
+}
+
+// w3cFields are the fields written for AccessLogW3C.
+const w3cFields = "date time c-ip cs-method cs-uri cs-version sc-status sc-bytes time-taken"
+
+// AccessLog writes a line for each completed transaction, separate from the
+// log of the proxy, e.g. for processing with log analysis tools.
+type AccessLog struct {
+	format AccessLogFormat
+
+	m             sync.Mutex
+	w             io.Writer
+	headerWritten bool
+}
+
+// NewAccessLog returns an access log writing lines in format to w.
+func NewAccessLog(w io.Writer, format AccessLogFormat) *AccessLog {
+	return &AccessLog{w: w, format: format}
+}
+
+// accessLogEntry contains the data logged for a transaction. The request
+// line is recorded before the hooks can modify the request.
+type accessLogEntry struct {
+	start                 time.Time
+	remoteAddr            string
+	method, target, proto string
+	status                int
+	bytes                 int64
+}
+
+// newAccessLogEntry records the request of event.
+func newAccessLogEntry(event *Event, start time.Time) *accessLogEntry {
+	u := *event.Req.URL
+	if event.ForceHost != "" && u.Host == "" {
+		u.Scheme = event.ForceScheme
+		u.Host = event.ForceHost
+	}
+
+	return &accessLogEntry{
+		start:      start,
+		remoteAddr: event.Req.RemoteAddr,
+		method:     event.Req.Method,
+		target:     u.String(),
+		proto:      event.Req.Proto,
+	}
+}
+
+// remoteHost returns the IP address of the client, or "-" if it is unknown.
+func (e *accessLogEntry) remoteHost() string {
+	host, _, err := net.SplitHostPort(e.remoteAddr)
+	if err != nil || host == "" {
+		return "-"
+	}
+	return host
+}
+
+// dash returns "-" for zero values as is customary in log files.
+func dash(n int64) string {
+	if n == 0 {
+		return "-"
+	}
+	return strconv.FormatInt(n, 10)
+}
+
+// format returns the log line (including the newline) for the entry.
+func (e *accessLogEntry) format(format AccessLogFormat, end time.Time) string {
+	switch format {
+	case AccessLogW3C:
+		t := e.start.UTC()
+		return fmt.Sprintf("%s %s %s %s %s %s %s %s %.3f\n",
+			t.Format("2006-01-02"), t.Format("15:04:05"), e.remoteHost(),
+			e.method, e.target, e.proto, dash(int64(e.status)), strconv.FormatInt(e.bytes, 10),
+			end.Sub(e.start).Seconds())
+	default:
+		return fmt.Sprintf("%s - - [%s] \"%s %s %s\" %s %s\n",
+			e.remoteHost(), e.start.Format("02/Jan/2006:15:04:05 -0700"),
+			e.method, e.target, e.proto, dash(int64(e.status)), dash(e.bytes))
+	}
+}
+
+// write appends the line for e to the log, the W3C directives are written
+// before the first line.
+func (l *AccessLog) write(e *accessLogEntry, end time.Time) error {
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	if l.format == AccessLogW3C && !l.headerWritten {
+		_, err := fmt.Fprintf(l.w, "#Version: 1.0\n#Date: %s\n#Fields: %s\n",
+			end.UTC().Format("02-Jan-2006 15:04:05"), w3cFields)
+		if err != nil {
+			return err
+		}
+		l.headerWritten = true
+	}
+
+	_, err := io.WriteString(l.w, e.format(l.format, end))
+	return err
+}
@@ -0,0 +1,134 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProxyAccessLog(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusCreated)
+		_, _ = io.WriteString(rw, "hello world")
+	}))
+	defer srv.Close()
+
+	var tests = []struct {
+		format AccessLogFormat
+		want   []*regexp.Regexp
+	}{
+		{
+			AccessLogCommon,
+			[]*regexp.Regexp{
+				regexp.MustCompile(`^127\.0\.0\.1 - - \[\d{2}/[A-Z][a-z]{2}/\d{4}:\d{2}:\d{2}:\d{2} [+-]\d{4}\] "GET ` +
+					regexp.QuoteMeta(srv.URL) + `/foo\?x=1 HTTP/1\.1" 201 11$`),
+			},
+		},
+		{
+			AccessLogW3C,
+			[]*regexp.Regexp{
+				regexp.MustCompile(`^#Version: 1\.0$`),
+				regexp.MustCompile(`^#Date: \d{2}-[A-Z][a-z]{2}-\d{4} \d{2}:\d{2}:\d{2}$`),
+				regexp.MustCompile(`^#Fields: ` + w3cFields + `$`),
+				regexp.MustCompile(`^\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2} 127\.0\.0\.1 GET ` +
+					regexp.QuoteMeta(srv.URL) + `/foo\?x=1 HTTP/1\.1 201 11 \d+\.\d{3}$`),
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run("", func(t *testing.T) {
+			proxy, serve, shutdown := TestProxy(t, nil)
+			buf := &syncBuffer{}
+			proxy.AccessLog = NewAccessLog(buf, test.format)
+			go serve()
+			defer shutdown()
+
+			client := testClient(t, proxy.Addr, proxy.CertificateAuthority)
+			res, err := client.Get(srv.URL + "/foo?x=1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			wantStatus(t, res, http.StatusCreated)
+			wantBody(t, res, "hello world")
+
+			// the line is written after the response has been sent
+			if !waitFor(2*time.Second, func() bool { return strings.HasSuffix(buf.String(), "\n") }) {
+				t.Fatalf("no access log line written")
+			}
+
+			lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+			if len(lines) != len(test.want) {
+				t.Fatalf("want %d lines, got %d:\n%s", len(test.want), len(lines), buf.String())
+			}
+			for i, line := range lines {
+				if !test.want[i].MatchString(line) {
+					t.Errorf("line %d does not match %v:\n  %s", i, test.want[i], line)
+				}
+			}
+		})
+	}
+}
+
+func TestParseAccessLogFormat(t *testing.T) {
+	for name, want := range map[string]AccessLogFormat{
+		"common": AccessLogCommon,
+		"CLF":    AccessLogCommon,
+		"w3c":    AccessLogW3C,
+		"elf":    AccessLogW3C,
+	} {
+		format, err := ParseAccessLogFormat(name)
+		if err != nil {
+			t.Errorf("%v: %v", name, err)
+			continue
+		}
+		if format != want {
+			t.Errorf("%v: want %v, got %v", name, want, format)
+		}
+	}
+
+	_, err := ParseAccessLogFormat("combined")
+	if err == nil {
+		t.Errorf("unknown format was accepted")
+	}
+}
+
+func TestProxyAccessLogPaused(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		_, _ = io.WriteString(rw, req.URL.Path)
+	}))
+	defer srv.Close()
+
+	proxy, serve, shutdown := TestProxy(t, nil)
+	buf := &syncBuffer{}
+	proxy.AccessLog = NewAccessLog(buf, AccessLogCommon)
+	go serve()
+	defer shutdown()
+
+	client := testClient(t, proxy.Addr, proxy.CertificateAuthority)
+	for _, path := range []string{"/paused", "/resumed"} {
+		if path == "/paused" {
+			proxy.PauseCapture()
+		} else {
+			proxy.ResumeCapture()
+		}
+
+		res, err := client.Get(srv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wantStatus(t, res, http.StatusOK)
+		wantBody(t, res, path)
+	}
+
+	if !waitFor(2*time.Second, func() bool { return strings.Contains(buf.String(), "/resumed") }) {
+		t.Fatalf("no access log line written")
+	}
+	if strings.Contains(buf.String(), "/paused") {
+		t.Errorf("request sent while paused was logged:\n%s", buf.String())
+	}
+}
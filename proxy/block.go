@@ -0,0 +1,93 @@
+package proxy
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/textproto"
+	"strings"
+)
+
+// BlockAction is the response sent to clients for requests blocked by the
+// proxy, e.g. because the target is outside of Config.Scope or the port of a
+// CONNECT request is not allowed.
+type BlockAction struct {
+	// StatusCode is the status of the response. If it is zero, the proxy
+	// responds with 403 Forbidden and the reason as the body.
+	StatusCode int
+
+	// Header is set on the response, Body is sent unless the status does
+	// not permit a body (e.g. 204 No Content).
+	Header http.Header
+	Body   string
+}
+
+// inScope returns true if host matches one of the patterns in scope, or if
+// scope is empty. A pattern starting with "*." also matches all subdomains.
+func inScope(scope []string, host string) bool {
+	if len(scope) == 0 {
+		return true
+	}
+
+	host = strings.ToLower(host)
+	for _, pattern := range scope {
+		pattern = strings.ToLower(pattern)
+		if strings.HasPrefix(pattern, "*.") {
+			if strings.HasSuffix(host, pattern[1:]) || host == pattern[2:] {
+				return true
+			}
+			continue
+		}
+		if host == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// targetHostname returns the name of the host the request is sent to,
+// without the port. Requests without a target go to defaultHost.
+func targetHostname(event *Event, defaultHost string) string {
+	host := event.ForceHost
+	if host == "" {
+		host = event.Req.URL.Host
+	}
+	if host == "" {
+		host = event.Req.Host
+	}
+	if host == "" {
+		host = defaultHost
+	}
+
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.Trim(host, "[]")
+}
+
+// bodyAllowed returns true if a response with the status code may contain a
+// body.
+func bodyAllowed(code int) bool {
+	return code >= 200 && code != http.StatusNoContent && code != http.StatusNotModified
+}
+
+// sendBlocked responds to a blocked request according to action, reason is
+// logged (and sent as the body for the default action).
+func (e *Event) sendBlocked(action BlockAction, reason string, args ...interface{}) {
+	if action.StatusCode == 0 {
+		e.SendErrorStatus(http.StatusForbidden, reason, args...)
+		return
+	}
+
+	e.Log("blocked: "+reason, args...)
+	for name, values := range action.Header {
+		e.ResponseWriter.Header()[textproto.CanonicalMIMEHeaderKey(name)] = append([]string(nil), values...)
+	}
+	e.ResponseWriter.WriteHeader(action.StatusCode)
+	e.status = action.StatusCode
+
+	if action.Body != "" && bodyAllowed(action.StatusCode) {
+		n, _ := io.WriteString(e.ResponseWriter, action.Body)
+		e.errorBytes = int64(n)
+	}
+}
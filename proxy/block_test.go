@@ -0,0 +1,128 @@
+package proxy
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+)
+
+func TestProxyBlockOutOfScope(t *testing.T) {
+	var received int32
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&received, 1)
+		_, _ = io.WriteString(rw, "forwarded")
+	}))
+	defer srv.Close()
+
+	srvURL, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	proxy, serve, shutdown := TestProxy(t, nil)
+	go serve()
+	defer shutdown()
+
+	proxy.UpdateConfig(func(cfg *Config) {
+		cfg.Scope = []string{"127.0.0.1"}
+		cfg.Block = BlockAction{
+			StatusCode: http.StatusUnavailableForLegalReasons,
+			Header:     http.Header{"X-Blocked": []string{"scope"}},
+			Body:       "this host is out of scope",
+		}
+	})
+
+	client := testClient(t, proxy.Addr, proxy.CertificateAuthority)
+
+	// in scope
+	res, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantStatus(t, res, http.StatusOK)
+	wantBody(t, res, "forwarded")
+
+	// the same server under a different name is out of scope
+	res, err = client.Get("http://localhost:" + srvURL.Port() + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantStatus(t, res, http.StatusUnavailableForLegalReasons)
+	wantHeader(t, res, map[string]string{"X-Blocked": "scope"})
+	wantBody(t, res, "this host is out of scope")
+
+	if n := atomic.LoadInt32(&received); n != 1 {
+		t.Errorf("want 1 request forwarded, got %d", n)
+	}
+}
+
+func TestProxyBlockConnect(t *testing.T) {
+	proxy, serve, shutdown := TestProxy(t, nil)
+	go serve()
+	defer shutdown()
+
+	proxy.UpdateConfig(func(cfg *Config) {
+		cfg.Scope = []string{"*.example.com"}
+		cfg.AllowedConnectPorts = []int{443}
+		cfg.Block = BlockAction{StatusCode: http.StatusNoContent, Body: "not sent"}
+	})
+
+	var tests = []struct {
+		target string
+		status int
+	}{
+		{"other.example.net:443", http.StatusNoContent},
+		{"www.example.com:8443", http.StatusNoContent},
+	}
+
+	for _, test := range tests {
+		t.Run(test.target, func(t *testing.T) {
+			conn, err := net.Dial("tcp", proxy.Addr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+
+			_, err = fmt.Fprintf(conn, "CONNECT %s HTTP/1.1\r\nHost: %s\r\n\r\n", test.target, test.target)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			res, err := http.ReadResponse(bufio.NewReader(conn), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			wantStatus(t, res, test.status)
+			wantBody(t, res, "")
+		})
+	}
+}
+
+func TestInScope(t *testing.T) {
+	var tests = []struct {
+		scope []string
+		host  string
+		want  bool
+	}{
+		{nil, "example.com", true},
+		{[]string{"example.com"}, "example.com", true},
+		{[]string{"example.com"}, "EXAMPLE.com", true},
+		{[]string{"example.com"}, "www.example.com", false},
+		{[]string{"*.example.com"}, "www.example.com", true},
+		{[]string{"*.example.com"}, "example.com", true},
+		{[]string{"*.example.com"}, "badexample.com", false},
+		{[]string{"foo.com", "bar.com"}, "bar.com", true},
+	}
+
+	for _, test := range tests {
+		if got := inScope(test.scope, test.host); got != test.want {
+			t.Errorf("inScope(%v, %q): want %v, got %v", test.scope, test.host, test.want, got)
+		}
+	}
+}
@@ -0,0 +1,209 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// captureConn records the bytes read from a client connection, so that the
+// exact bytes of each request can be recovered, see Proxy.CaptureRaw.
+type captureConn struct {
+	net.Conn
+
+	m       sync.Mutex
+	buf     []byte
+	stopped bool
+}
+
+func (c *captureConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+
+	c.m.Lock()
+	if !c.stopped {
+		c.buf = append(c.buf, p[:n]...)
+	}
+	c.m.Unlock()
+
+	return n, err
+}
+
+// take removes the bytes of a request from the start of the buffer and
+// returns them, the body must have been read completely. If the end of the
+// request can't be found, nil is returned and recording stops.
+func (c *captureConn) take(req *http.Request) []byte {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	n := requestLength(c.buf, req)
+	if n < 0 {
+		c.buf, c.stopped = nil, true
+		return nil
+	}
+
+	raw := make([]byte, n)
+	copy(raw, c.buf)
+	c.buf = c.buf[n:]
+	return raw
+}
+
+// stop ends the recording, e.g. when the connection is taken over by a
+// CONNECT tunnel or a websocket.
+func (c *captureConn) stop() {
+	c.m.Lock()
+	c.buf, c.stopped = nil, true
+	c.m.Unlock()
+}
+
+// requestLength returns the length of the HTTP/1.x request req at the start
+// of buf, or -1 if buf does not contain the complete request.
+func requestLength(buf []byte, req *http.Request) int {
+	end := bytes.Index(buf, []byte("\r\n\r\n"))
+	if end < 0 {
+		return -1
+	}
+	end += 4
+
+	if len(req.TransferEncoding) > 0 && req.TransferEncoding[0] == "chunked" {
+		n := chunkedLength(buf[end:])
+		if n < 0 {
+			return -1
+		}
+		return end + n
+	}
+
+	if req.ContentLength > 0 {
+		end += int(req.ContentLength)
+	}
+	if end > len(buf) {
+		return -1
+	}
+	return end
+}
+
+// chunkedLength returns the length of the chunked body (including the
+// trailer) at the start of buf, or -1 if it is incomplete.
+func chunkedLength(buf []byte) int {
+	pos := 0
+	for {
+		i := bytes.Index(buf[pos:], []byte("\r\n"))
+		if i < 0 {
+			return -1
+		}
+
+		line := string(buf[pos : pos+i])
+		if semi := strings.IndexByte(line, ';'); semi >= 0 {
+			line = line[:semi]
+		}
+		size, err := strconv.ParseUint(strings.TrimSpace(line), 16, 63)
+		if err != nil {
+			return -1
+		}
+		pos += i + 2
+
+		if size == 0 {
+			break
+		}
+
+		// chunk data is followed by CRLF
+		pos += int(size) + 2
+		if pos > len(buf) {
+			return -1
+		}
+	}
+
+	// trailer lines up to an empty line
+	for {
+		i := bytes.Index(buf[pos:], []byte("\r\n"))
+		if i < 0 {
+			return -1
+		}
+		pos += i + 2
+		if i == 0 {
+			return pos
+		}
+	}
+}
+
+// captureListener wraps all accepted connections in a captureConn.
+type captureListener struct {
+	net.Listener
+}
+
+func (l captureListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &captureConn{Conn: conn}, nil
+}
+
+type captureConnKey struct{}
+
+// captureConnContext adds conn to ctx if it is a captureConn, it is used as
+// the ConnContext of the servers.
+func captureConnContext(ctx context.Context, conn net.Conn) context.Context {
+	if c, ok := conn.(*captureConn); ok {
+		return context.WithValue(ctx, captureConnKey{}, c)
+	}
+	return ctx
+}
+
+// captureRequest returns the exact bytes of req as received from the client
+// if the connection is recorded, otherwise nil. The body is read into memory,
+// up to maxBody bytes (zero means no limit). For larger bodies and gRPC calls,
+// which may stream the body, the recording of the connection stops and nil is
+// returned, the body is left to the proxy. The same happens if paused is set,
+// see Proxy.PauseCapture.
+func captureRequest(req *http.Request, maxBody int64, paused bool) ([]byte, error) {
+	c, ok := req.Context().Value(captureConnKey{}).(*captureConn)
+	if !ok {
+		return nil, nil
+	}
+
+	if paused || isGRPC(req) || (maxBody > 0 && req.ContentLength > maxBody) {
+		c.stop()
+		return nil, nil
+	}
+
+	var rd io.Reader = req.Body
+	if maxBody > 0 {
+		rd = io.LimitReader(req.Body, maxBody+1)
+	}
+	body, err := ioutil.ReadAll(rd)
+	if err != nil {
+		c.stop()
+		return nil, err
+	}
+
+	if maxBody > 0 && int64(len(body)) > maxBody {
+		// put the data back in front of the rest of the body
+		req.Body = bufferedReadCloser{
+			Reader: io.MultiReader(bytes.NewReader(body), req.Body),
+			Closer: req.Body,
+		}
+		c.stop()
+		return nil, nil
+	}
+
+	_ = req.Body.Close()
+	req.Body = http.NoBody
+	if len(body) > 0 {
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+
+	raw := c.take(req)
+
+	// the connection is taken over, don't record the data sent afterwards
+	if req.Method == http.MethodConnect || req.Header.Get("Upgrade") != "" {
+		c.stop()
+	}
+
+	return raw, nil
+}
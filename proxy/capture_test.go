@@ -0,0 +1,280 @@
+package proxy
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRequestLength(t *testing.T) {
+	var tests = []struct {
+		raw  string
+		want int
+	}{
+		{"GET / HTTP/1.1\r\nHost: x\r\n\r\n", 27},
+		{"GET / HTTP/1.1\r\nHost: x\r\n\r\nGET /next", 27},
+		{"POST / HTTP/1.1\r\nHost: x\r\nContent-Length: 3\r\n\r\nabc", 50},
+		{"POST / HTTP/1.1\r\nHost: x\r\nContent-Length: 3\r\n\r\nab", -1},
+		{"POST / HTTP/1.1\r\nHost: x\r\nTransfer-Encoding: chunked\r\n\r\n3;ext=1\r\nabc\r\n0\r\nX-Trailer: 1\r\n\r\n", 89},
+		{"POST / HTTP/1.1\r\nHost: x\r\nTransfer-Encoding: chunked\r\n\r\n3\r\nabc\r\n", -1},
+	}
+
+	for _, test := range tests {
+		t.Run("", func(t *testing.T) {
+			req, err := http.ReadRequest(bufio.NewReader(strings.NewReader(test.raw)))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			n := requestLength([]byte(test.raw), req)
+			if n != test.want {
+				t.Errorf("wrong length, want %v, got %v", test.want, n)
+			}
+		})
+	}
+}
+
+func TestProxyCaptureRaw(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		_, _ = io.WriteString(rw, req.URL.Path+" "+string(body))
+	}))
+	defer srv.Close()
+
+	proxy, serve, shutdown := TestProxy(t, nil)
+	proxy.CaptureRaw = true
+	go serve()
+	defer shutdown()
+
+	var m sync.Mutex
+	captured := make(map[string]string)
+	proxy.Register(func(event *Event) (*Response, error) {
+		m.Lock()
+		captured[event.Req.URL.Path] = string(event.RawClientRequest)
+		m.Unlock()
+		return event.ForwardRequest()
+	})
+
+	// unusual spacing and header order which would be normalized
+	requests := map[string]string{
+		"/chunked": "POST " + srv.URL + "/chunked HTTP/1.1\r\nX-B:   2  \r\nHost: " + srv.Listener.Addr().String() +
+			"\r\nTransfer-Encoding: chunked\r\nx-a: 1\r\n\r\n4\r\nbody\r\n0\r\n\r\n",
+		"/plain": "GET " + srv.URL + "/plain HTTP/1.1\r\nhost:" + srv.Listener.Addr().String() + "\r\nX-Sig: abc\r\n\r\n",
+	}
+
+	conn, err := net.Dial("tcp", proxy.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	rd := bufio.NewReader(conn)
+
+	// both requests use the same connection
+	for _, path := range []string{"/chunked", "/plain"} {
+		_, err = io.WriteString(conn, requests[path])
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		res, err := http.ReadResponse(rd, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wantStatus(t, res, http.StatusOK)
+		_, _ = ioutil.ReadAll(res.Body)
+		_ = res.Body.Close()
+	}
+
+	m.Lock()
+	defer m.Unlock()
+	for path, raw := range requests {
+		if captured[path] != raw {
+			t.Errorf("wrong bytes captured for %v:\nwant %q\n got %q", path, raw, captured[path])
+		}
+	}
+}
+
+func TestProxyCaptureRawConnect(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		_, _ = io.WriteString(rw, "ok")
+	}))
+	defer srv.Close()
+
+	proxy, serve, shutdown := TestProxy(t, srv.Client().Transport.(*http.Transport).TLSClientConfig)
+	proxy.CaptureRaw = true
+	proxy.Cache.NoClone = true
+	go serve()
+	defer shutdown()
+
+	var m sync.Mutex
+	var captured string
+	proxy.Register(func(event *Event) (*Response, error) {
+		m.Lock()
+		captured = string(event.RawClientRequest)
+		m.Unlock()
+		return event.ForwardRequest()
+	})
+
+	client := testClient(t, proxy.Addr, proxy.CertificateAuthority)
+	res, err := client.Get(srv.URL + "/tunnel")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantStatus(t, res, http.StatusOK)
+	wantBody(t, res, "ok")
+
+	m.Lock()
+	defer m.Unlock()
+	if !strings.HasPrefix(captured, "GET /tunnel HTTP/1.1\r\n") || !strings.HasSuffix(captured, "\r\n\r\n") {
+		t.Errorf("wrong bytes captured in tunnel: %q", captured)
+	}
+}
+
+func TestProxyCaptureRawLimit(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		_, _ = io.WriteString(rw, string(body))
+	}))
+	defer srv.Close()
+
+	proxy, serve, shutdown := TestProxy(t, nil)
+	proxy.CaptureRaw = true
+	proxy.MaxRequestBodySize = 8
+	proxy.StreamLargeRequests = true
+	go serve()
+	defer shutdown()
+
+	var m sync.Mutex
+	captured := make(map[string][]byte)
+	proxy.Register(func(event *Event) (*Response, error) {
+		m.Lock()
+		captured[event.Req.URL.Path] = event.RawClientRequest
+		m.Unlock()
+		return event.ForwardRequest()
+	})
+
+	host := srv.Listener.Addr().String()
+	body := strings.Repeat("x", 20)
+	var tests = []struct {
+		name string
+		raw  string
+	}{
+		{"length", "POST " + srv.URL + "/length HTTP/1.1\r\nHost: " + host +
+			"\r\nContent-Length: 20\r\n\r\n" + body},
+		{"chunked", "POST " + srv.URL + "/chunked HTTP/1.1\r\nHost: " + host +
+			"\r\nTransfer-Encoding: chunked\r\n\r\n14\r\n" + body + "\r\n0\r\n\r\n"},
+		{"grpc", "POST " + srv.URL + "/grpc HTTP/1.1\r\nHost: " + host +
+			"\r\nContent-Type: application/grpc\r\nContent-Length: 4\r\n\r\nbody"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			conn, err := net.Dial("tcp", proxy.Addr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			rd := bufio.NewReader(conn)
+
+			// the body is forwarded completely, but not recorded
+			_, err = io.WriteString(conn, test.raw)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res, err := http.ReadResponse(rd, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			wantStatus(t, res, http.StatusOK)
+			if test.name != "grpc" {
+				wantBody(t, res, body)
+			}
+			_, _ = ioutil.ReadAll(res.Body)
+			_ = res.Body.Close()
+
+			// neither is the next request on the connection
+			next := "GET " + srv.URL + "/next-" + test.name + " HTTP/1.1\r\nHost: " + host + "\r\n\r\n"
+			_, err = io.WriteString(conn, next)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res, err = http.ReadResponse(rd, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			wantStatus(t, res, http.StatusOK)
+			_, _ = ioutil.ReadAll(res.Body)
+			_ = res.Body.Close()
+
+			m.Lock()
+			defer m.Unlock()
+			for path, raw := range captured {
+				if raw != nil && strings.HasSuffix(path, test.name) {
+					t.Errorf("request %v was recorded: %q", path, raw)
+				}
+			}
+			if _, ok := captured["/next-"+test.name]; !ok {
+				t.Errorf("next request did not run the hooks")
+			}
+		})
+	}
+}
+
+func TestProxyCaptureRawPaused(t *testing.T) {
+	received := make(chan string, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		buf := make([]byte, 5)
+		_, _ = io.ReadFull(req.Body, buf)
+		received <- string(buf)
+		rest, _ := ioutil.ReadAll(req.Body)
+		_, _ = io.WriteString(rw, string(buf)+string(rest))
+	}))
+	defer srv.Close()
+
+	proxy, serve, shutdown := TestProxy(t, nil)
+	proxy.CaptureRaw = true
+	proxy.PauseCapture()
+	go serve()
+	defer shutdown()
+
+	conn, err := net.Dial("tcp", proxy.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	_, err = io.WriteString(conn, "POST "+srv.URL+"/ HTTP/1.1\r\nHost: "+srv.Listener.Addr().String()+
+		"\r\nContent-Length: 10\r\n\r\nfirst")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the body is not read into memory for capturing, the upstream server
+	// receives the start before the rest was sent
+	select {
+	case start := <-received:
+		if start != "first" {
+			t.Errorf("wrong start of body received: %q", start)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("request body was not forwarded while paused")
+	}
+
+	_, err = io.WriteString(conn, "-last")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantStatus(t, res, http.StatusOK)
+	wantBody(t, res, "first-last")
+}
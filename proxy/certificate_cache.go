@@ -35,12 +35,29 @@ type Cache struct {
 
 	ca           *certauth.CertificateAuthority
 	clientConfig *tls.Config
-	log          *log.Logger
+	log          *coalescingLog
+
+	// hostConfig returns the TLS client configuration for a host name if it
+	// differs from clientConfig, and nil otherwise.
+	hostConfig func(host string) *tls.Config
+
+	// NoClone disables fetching and cloning the upstream server's
+	// certificate, a minimal certificate for the requested name is generated
+	// instead without connecting to the server.
+	NoClone bool
+
+	// Debug enables logging each certificate lookup. Identical messages are
+	// summarized per logWindow, so a burst of lookups produces two lines.
+	Debug bool
 }
 
 const (
 	cleanupInterval = 30 * time.Second
 	cacheDuration   = 10 * time.Minute
+
+	// logWindow is the interval in which repeated log messages are
+	// summarized
+	logWindow = 10 * time.Second
 )
 
 // NewCache returns a new Cache.
@@ -52,10 +69,18 @@ func NewCache(ca *certauth.CertificateAuthority, clientConfig *tls.Config, log *
 
 		ca:           ca,
 		clientConfig: clientConfig,
-		log:          log,
+		log:          newCoalescingLog(log, logWindow),
 	}
 }
 
+// Len returns the number of certificates in the cache.
+func (c *Cache) Len() int {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	return len(c.certs)
+}
+
 // cleanup removes old certificates.
 func (c *Cache) cleanup() {
 	for name, entry := range c.certs {
@@ -153,9 +178,27 @@ func getCertificate(ctx context.Context, target, serverName string, clientConfig
 func (c *Cache) Get(ctx context.Context, addr, serverName string) (*tls.Certificate, error) {
 	name := strings.Split(addr, ":")[0]
 
+	if c.Debug {
+		c.log.Printf("get certificate for %v (%v)", addr, serverName)
+	}
+
 	crt, err := c.getOrCreate(addr, serverName, func() (*x509.Certificate, error) {
+		if c.NoClone {
+			if serverName != "" {
+				name = serverName
+			}
+			return c.ca.NewCertificate(name, []string{name})
+		}
+
 		// try to get the host's cert and clone it
-		cert, err := getCertificate(ctx, addr, serverName, c.clientConfig)
+		cfg := c.clientConfig
+		if c.hostConfig != nil {
+			if hostCfg := c.hostConfig(name); hostCfg != nil {
+				cfg = hostCfg
+			}
+		}
+
+		cert, err := getCertificate(ctx, addr, serverName, cfg)
 		if err == nil {
 			clonedCert, err := c.ca.Clone(cert)
 			if err == nil {
@@ -0,0 +1,92 @@
+package proxy
+
+import (
+	"context"
+	"crypto/x509"
+	"io/ioutil"
+	"log"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fd0/osmosis/certauth"
+)
+
+func TestCacheNoClone(t *testing.T) {
+	listener := newLocalListener(t)
+	defer listener.Close()
+
+	var dials int32
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			atomic.AddInt32(&dials, 1)
+			_ = conn.Close()
+		}
+	}()
+
+	cache := NewCache(certauth.TestCA(t), nil, log.New(ioutil.Discard, "", 0))
+	cache.NoClone = true
+
+	crt, err := cache.Get(context.Background(), listener.Addr().String(), "www.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	leaf, err := x509.ParseCertificate(crt.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(leaf.DNSNames) != 1 || leaf.DNSNames[0] != "www.example.com" {
+		t.Errorf("wrong names in certificate: %v %v", leaf.DNSNames, leaf.IPAddresses)
+	}
+
+	if n := atomic.LoadInt32(&dials); n != 0 {
+		t.Errorf("upstream server was contacted %d times", n)
+	}
+}
+
+func TestCacheLogCoalescing(t *testing.T) {
+	var buf syncBuffer
+	cache := NewCache(certauth.TestCA(t), nil, log.New(&buf, "", 0))
+	cache.NoClone = true
+	cache.Debug = true
+	cache.log.window = 100 * time.Millisecond
+
+	for i := 0; i < 42; i++ {
+		_, err := cache.Get(context.Background(), "example.com:443", "example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err := cache.Get(context.Background(), "other.com:443", "other.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "get certificate for example.com:443 (example.com)\n" +
+		"get certificate for other.com:443 (other.com)\n"
+	if buf.String() != want {
+		t.Errorf("wrong log during the burst, want:\n%s\ngot:\n%s", want, buf.String())
+	}
+
+	want += "get certificate for example.com:443 (example.com) (repeated 41 times)\n"
+	ok := waitFor(5*time.Second, func() bool { return buf.String() == want })
+	if !ok {
+		t.Errorf("repetitions not summarized, want:\n%s\ngot:\n%s", want, buf.String())
+	}
+
+	// the window has ended, the next message is logged again
+	_, err = cache.Get(context.Background(), "example.com:443", "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(buf.String(), want+"get certificate for example.com:443 (example.com)\n") {
+		t.Errorf("message after the window was not logged:\n%s", buf.String())
+	}
+}
@@ -0,0 +1,146 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"fmt"
+	"sort"
+)
+
+// CipherProfile restricts the parameters offered in the TLS ClientHello of
+// connections to upstream servers: the set of cipher suites, curves and
+// protocol versions. The standard library ignores the order of CipherSuites
+// and always offers all TLS 1.3 cipher suites, so only TLS 1.2 and earlier
+// suites can be selected. The extensions are not changed.
+type CipherProfile struct {
+	CipherSuites     []uint16
+	CurvePreferences []tls.CurveID
+	MinVersion       uint16
+	MaxVersion       uint16
+}
+
+// CipherProfiles contains the profiles available for SetCipherProfile. The
+// "default" profile leaves the configuration of the standard library
+// untouched.
+var CipherProfiles = map[string]CipherProfile{
+	"default": {},
+	// modern offers only forward secret AEAD suites
+	"modern": {
+		CipherSuites: []uint16{
+			tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+		},
+		CurvePreferences: []tls.CurveID{tls.X25519, tls.CurveP256, tls.CurveP384},
+		MinVersion:       tls.VersionTLS12,
+		MaxVersion:       tls.VersionTLS13,
+	},
+	// compatible adds CBC suites and RSA key exchange
+	"compatible": {
+		CipherSuites: []uint16{
+			tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA,
+			tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA,
+			tls.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA,
+			tls.TLS_RSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_RSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_RSA_WITH_AES_128_CBC_SHA,
+			tls.TLS_RSA_WITH_AES_256_CBC_SHA,
+		},
+		CurvePreferences: []tls.CurveID{tls.X25519, tls.CurveP256, tls.CurveP384, tls.CurveP521},
+		MinVersion:       tls.VersionTLS12,
+		MaxVersion:       tls.VersionTLS13,
+	},
+	// legacy also allows TLS 1.0 and 1.1 and 3DES for old servers
+	"legacy": {
+		CipherSuites: []uint16{
+			tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA,
+			tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA,
+			tls.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA,
+			tls.TLS_RSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_RSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_RSA_WITH_AES_128_CBC_SHA,
+			tls.TLS_RSA_WITH_AES_256_CBC_SHA,
+			tls.TLS_ECDHE_RSA_WITH_3DES_EDE_CBC_SHA,
+			tls.TLS_RSA_WITH_3DES_EDE_CBC_SHA,
+		},
+		CurvePreferences: []tls.CurveID{tls.X25519, tls.CurveP256, tls.CurveP384, tls.CurveP521},
+		MinVersion:       tls.VersionTLS10,
+		MaxVersion:       tls.VersionTLS13,
+	},
+}
+
+// CipherProfileNames returns the names of the available profiles in sorted
+// order.
+func CipherProfileNames() []string {
+	names := make([]string, 0, len(CipherProfiles))
+	for name := range CipherProfiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// apply sets the parameters of the profile in cfg.
+func (c CipherProfile) apply(cfg *tls.Config) {
+	if c.CipherSuites != nil {
+		cfg.CipherSuites = c.CipherSuites
+	}
+	if c.CurvePreferences != nil {
+		cfg.CurvePreferences = c.CurvePreferences
+	}
+	if c.MinVersion != 0 {
+		cfg.MinVersion = c.MinVersion
+	}
+	if c.MaxVersion != 0 {
+		cfg.MaxVersion = c.MaxVersion
+	}
+}
+
+// SetCipherProfile selects the profile from CipherProfiles used for TLS
+// connections to upstream servers of requests. Connections through an
+// upstream proxy are not affected. It may be called while the proxy is
+// running, idle connections are closed so that new connections use the
+// profile.
+func (p *Proxy) SetCipherProfile(name string) error {
+	c, ok := CipherProfiles[name]
+	if !ok {
+		return fmt.Errorf("unknown cipher profile %q", name)
+	}
+
+	p.hostConfigMu.Lock()
+	p.cipherProfile = name
+	p.cipherProfileConfig = c
+	p.hostConfigMu.Unlock()
+
+	p.transport.CloseIdleConnections()
+
+	return nil
+}
+
+// CipherProfile returns the name of the profile used for upstream
+// connections.
+func (p *Proxy) CipherProfile() string {
+	p.hostConfigMu.Lock()
+	defer p.hostConfigMu.Unlock()
+
+	if p.cipherProfile == "" {
+		return "default"
+	}
+	return p.cipherProfile
+}
@@ -0,0 +1,130 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestProxyCipherProfile(t *testing.T) {
+	var (
+		m      sync.Mutex
+		hellos []string
+	)
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		_, _ = rw.Write([]byte("ok"))
+	}))
+	srv.TLS = &tls.Config{
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			m.Lock()
+			hellos = append(hellos, fmt.Sprintf("%v %v %v", hello.CipherSuites, hello.SupportedCurves, hello.SupportedVersions))
+			m.Unlock()
+			return nil, nil
+		},
+	}
+	srv.StartTLS()
+	defer srv.Close()
+
+	proxy, serve, shutdown := TestProxy(t, &tls.Config{InsecureSkipVerify: true})
+	// only record the handshakes for requests
+	proxy.Cache.NoClone = true
+
+	var profiles []string
+	proxy.Register(func(event *Event) (*Response, error) {
+		profiles = append(profiles, event.UpstreamCipherProfile)
+		return event.ForwardRequest()
+	})
+	go serve()
+	defer shutdown()
+
+	err := proxy.SetCipherProfile("paranoid")
+	if err == nil {
+		t.Errorf("no error for unknown profile")
+	}
+
+	// the profile can be changed while the proxy is running
+	want := []string{"default", "modern", "compatible", "legacy"}
+	for _, name := range want {
+		err = proxy.SetCipherProfile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		client := testClient(t, proxy.Addr, proxy.CertificateAuthority)
+		res, err := client.Get(srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wantStatus(t, res, http.StatusOK)
+		wantBody(t, res, "ok")
+
+		client.Transport.(*http.Transport).CloseIdleConnections()
+	}
+
+	if fmt.Sprint(profiles) != fmt.Sprint(want) {
+		t.Errorf("wrong profiles recorded for transactions, want %v, got %v", want, profiles)
+	}
+
+	m.Lock()
+	defer m.Unlock()
+
+	if len(hellos) != len(want) {
+		t.Fatalf("wrong number of handshakes, want %d, got %d", len(want), len(hellos))
+	}
+
+	seen := make(map[string]string)
+	for i, hello := range hellos {
+		if other, ok := seen[hello]; ok {
+			t.Errorf("ClientHello for %v is the same as for %v: %v", want[i], other, hello)
+		}
+		seen[hello] = want[i]
+	}
+}
+
+func TestProxyTLSConfigConcurrent(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		_, _ = rw.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	proxy, serve, shutdown := TestProxy(t, &tls.Config{InsecureSkipVerify: true})
+	proxy.Cache.NoClone = true
+	go serve()
+	defer shutdown()
+
+	// change the settings while requests are being sent (run with -race)
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	defer func() {
+		close(done)
+		<-stopped
+	}()
+	go func() {
+		defer close(stopped)
+		for i := 0; ; i++ {
+			select {
+			case <-done:
+				return
+			default:
+			}
+
+			_ = proxy.SetCipherProfile(CipherProfileNames()[i%len(CipherProfiles)])
+			proxy.SetHostClientConfig("localhost", &tls.Config{InsecureSkipVerify: true})
+			proxy.SetHostClientConfig("localhost", nil)
+		}
+	}()
+
+	client := testClient(t, proxy.Addr, proxy.CertificateAuthority)
+	for i := 0; i < 10; i++ {
+		res, err := client.Get(srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wantStatus(t, res, http.StatusOK)
+		wantBody(t, res, "ok")
+	}
+}
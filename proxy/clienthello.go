@@ -0,0 +1,190 @@
+package proxy
+
+import (
+	"bufio"
+	"crypto/md5"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ClientHello contains the fields of the TLS ClientHello message received
+// from a client which are used for fingerprinting. GREASE values are removed.
+type ClientHello struct {
+	Version      uint16
+	CipherSuites []uint16
+	Extensions   []uint16
+	Curves       []uint16
+	PointFormats []uint8
+	ServerName   string
+}
+
+// JA3 returns the JA3 string of the ClientHello.
+func (c *ClientHello) JA3() string {
+	join := func(values []uint16) string {
+		s := make([]string, 0, len(values))
+		for _, v := range values {
+			s = append(s, fmt.Sprint(v))
+		}
+		return strings.Join(s, "-")
+	}
+
+	points := make([]uint16, 0, len(c.PointFormats))
+	for _, p := range c.PointFormats {
+		points = append(points, uint16(p))
+	}
+
+	return fmt.Sprintf("%d,%s,%s,%s,%s", c.Version, join(c.CipherSuites),
+		join(c.Extensions), join(c.Curves), join(points))
+}
+
+// JA3Hash returns the JA3 fingerprint (the MD5 hash of the JA3 string).
+func (c *ClientHello) JA3Hash() string {
+	sum := md5.Sum([]byte(c.JA3()))
+	return hex.EncodeToString(sum[:])
+}
+
+// isGREASE returns true for the reserved values defined in RFC 8701.
+func isGREASE(v uint16) bool {
+	return v&0x0f0f == 0x0a0a && v>>8 == v&0xff
+}
+
+var errShortClientHello = errors.New("ClientHello is truncated")
+
+// helloReader reads the fields of a ClientHello message.
+type helloReader struct {
+	buf []byte
+	err error
+}
+
+func (r *helloReader) next(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if len(r.buf) < n {
+		r.err = errShortClientHello
+		return nil
+	}
+	res := r.buf[:n]
+	r.buf = r.buf[n:]
+	return res
+}
+
+func (r *helloReader) uint8() int {
+	b := r.next(1)
+	if b == nil {
+		return 0
+	}
+	return int(b[0])
+}
+
+func (r *helloReader) uint16() int {
+	b := r.next(2)
+	if b == nil {
+		return 0
+	}
+	return int(binary.BigEndian.Uint16(b))
+}
+
+// uint16List returns the two byte values contained in data,
+// GREASE values are skipped.
+func uint16List(data []byte) []uint16 {
+	var res []uint16
+	for i := 0; i+1 < len(data); i += 2 {
+		v := binary.BigEndian.Uint16(data[i:])
+		if !isGREASE(v) {
+			res = append(res, v)
+		}
+	}
+	return res
+}
+
+// parseClientHello parses a TLS record containing a ClientHello message.
+func parseClientHello(record []byte) (*ClientHello, error) {
+	r := &helloReader{buf: record}
+
+	// record header
+	if r.uint8() != 0x16 {
+		return nil, errors.New("not a handshake record")
+	}
+	r.next(2)
+	r.buf = r.next(r.uint16())
+
+	// handshake header
+	if r.uint8() != 1 {
+		return nil, errors.New("not a ClientHello message")
+	}
+	r.next(3)
+
+	hello := &ClientHello{}
+	hello.Version = uint16(r.uint16())
+	r.next(32)        // random
+	r.next(r.uint8()) // session ID
+	hello.CipherSuites = uint16List(r.next(r.uint16()))
+	r.next(r.uint8()) // compression methods
+
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	if len(r.buf) == 0 {
+		// no extensions
+		return hello, nil
+	}
+
+	ext := &helloReader{buf: r.next(r.uint16())}
+	for ext.err == nil && len(ext.buf) > 0 {
+		typ := uint16(ext.uint16())
+		data := ext.next(ext.uint16())
+
+		if isGREASE(typ) {
+			continue
+		}
+		hello.Extensions = append(hello.Extensions, typ)
+
+		switch typ {
+		case 0: // server_name
+			d := &helloReader{buf: data}
+			d.next(2) // list length
+			if d.uint8() == 0 {
+				hello.ServerName = string(d.next(d.uint16()))
+			}
+		case 10: // supported_groups
+			if len(data) >= 2 {
+				hello.Curves = uint16List(data[2:])
+			}
+		case 11: // ec_point_formats
+			if len(data) >= 1 {
+				hello.PointFormats = append([]uint8{}, data[1:]...)
+			}
+		}
+	}
+
+	if r.err != nil {
+		return nil, r.err
+	}
+	if ext.err != nil {
+		return nil, ext.err
+	}
+
+	return hello, nil
+}
+
+// peekClientHello returns the ClientHello at the start of rd without
+// consuming it.
+func peekClientHello(rd *bufio.Reader) (*ClientHello, error) {
+	header, err := rd.Peek(5)
+	if err != nil {
+		return nil, err
+	}
+
+	length := int(binary.BigEndian.Uint16(header[3:]))
+	record, err := rd.Peek(5 + length)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseClientHello(record)
+}
@@ -0,0 +1,79 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestProxyClientHelloFingerprint(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {}))
+	defer srv.Close()
+
+	proxy, serve, shutdown := TestProxy(t, &tls.Config{InsecureSkipVerify: true})
+	go serve()
+	defer shutdown()
+
+	hellos := make(chan *ClientHello, 1)
+	proxy.Register(func(event *Event) (*Response, error) {
+		hellos <- event.ClientHello
+		return event.ForwardRequest()
+	})
+
+	proxyURL, err := url.Parse("http://" + proxy.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certPool := x509.NewCertPool()
+	certPool.AddCert(proxy.CertificateAuthority.Certificate)
+
+	fingerprint := func(suites []uint16) *ClientHello {
+		client := &http.Client{
+			Transport: &http.Transport{
+				Proxy: http.ProxyURL(proxyURL),
+				TLSClientConfig: &tls.Config{
+					RootCAs:      certPool,
+					MaxVersion:   tls.VersionTLS12,
+					CipherSuites: suites,
+				},
+			},
+		}
+
+		res, err := client.Get(srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = res.Body.Close()
+
+		hello := <-hellos
+		if hello == nil {
+			t.Fatal("ClientHello was not recorded")
+		}
+		return hello
+	}
+
+	hello1 := fingerprint([]uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256})
+	hello2 := fingerprint([]uint16{tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384, tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256})
+
+	if hello1.Version != tls.VersionTLS12 {
+		t.Errorf("wrong version %x", hello1.Version)
+	}
+
+	if hello1.ServerName != "" {
+		t.Errorf("unexpected server name %q for IP address", hello1.ServerName)
+	}
+
+	ja3 := strings.Split(hello1.JA3(), ",")
+	if len(ja3) != 5 || ja3[1] != "49199" {
+		t.Errorf("unexpected JA3 string %q", hello1.JA3())
+	}
+
+	if hello1.JA3Hash() == hello2.JA3Hash() {
+		t.Errorf("different clients have the same fingerprint %v", hello1.JA3Hash())
+	}
+}
@@ -0,0 +1,67 @@
+package proxy
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+)
+
+// bufferBody reads the body completely and replaces it with a reader over
+// the same bytes. A nil body or http.NoBody is kept as is and returns nil.
+func bufferBody(body *io.ReadCloser) ([]byte, error) {
+	if *body == nil || *body == http.NoBody {
+		return nil, nil
+	}
+	return readWithoutClose(body)
+}
+
+// newBody returns a body reading buf, or http.NoBody if buf is nil.
+func newBody(buf []byte) io.ReadCloser {
+	if buf == nil {
+		return http.NoBody
+	}
+	return ioutil.NopCloser(bytes.NewReader(buf))
+}
+
+// CloneRequest returns a deep copy of req (with the same context). The body
+// is buffered in memory, afterwards both the original and the clone have a
+// body which can be read independently.
+func CloneRequest(req *http.Request) (*http.Request, error) {
+	body, err := bufferBody(&req.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	clone := req.Clone(req.Context())
+	if req.Body == nil {
+		return clone, nil
+	}
+
+	clone.Body = newBody(body)
+	clone.GetBody = func() (io.ReadCloser, error) {
+		return newBody(body), nil
+	}
+	return clone, nil
+}
+
+// CloneResponse returns a deep copy of res. The body is buffered in memory,
+// afterwards both the original and the clone have a body which can be read
+// independently. The request the response belongs to is shared.
+func CloneResponse(res *Response) (*Response, error) {
+	body, err := bufferBody(&res.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	clone := *res.Response
+	clone.Header = res.Header.Clone()
+	clone.Trailer = res.Trailer.Clone()
+	if res.TransferEncoding != nil {
+		clone.TransferEncoding = append([]string(nil), res.TransferEncoding...)
+	}
+	if res.Body != nil {
+		clone.Body = newBody(body)
+	}
+	return &Response{&clone}, nil
+}
@@ -0,0 +1,103 @@
+package proxy
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func readAll(t testing.TB, body io.Reader) string {
+	buf, err := ioutil.ReadAll(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(buf)
+}
+
+func TestCloneRequest(t *testing.T) {
+	req, err := http.NewRequest(http.MethodPost, "http://example.com/foo?x=1", strings.NewReader("original body"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("X-Test", "original")
+
+	clone, err := CloneRequest(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clone.Header.Set("X-Test", "clone")
+	clone.URL.Path = "/bar"
+
+	if req.Header.Get("X-Test") != "original" || req.URL.Path != "/foo" {
+		t.Errorf("modifying the clone changed the original: %v %v", req.URL, req.Header)
+	}
+
+	// both bodies can be read completely, in any order
+	if body := readAll(t, clone.Body); body != "original body" {
+		t.Errorf("wrong clone body %q", body)
+	}
+	if body := readAll(t, req.Body); body != "original body" {
+		t.Errorf("wrong original body %q", body)
+	}
+
+	// GetBody returns a fresh copy of the body
+	body, err := clone.GetBody()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := readAll(t, body); s != "original body" {
+		t.Errorf("wrong body from GetBody %q", s)
+	}
+
+	// requests without a body stay that way
+	req, err = http.NewRequest(http.MethodGet, "http://example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clone, err = CloneRequest(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if clone.Body != nil {
+		t.Errorf("clone of request without body has body %v", clone.Body)
+	}
+}
+
+func TestCloneResponse(t *testing.T) {
+	res := &Response{&http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{"text/plain"}},
+		Trailer:    http.Header{"X-Checksum": []string{"1234"}},
+		Body:       ioutil.NopCloser(strings.NewReader("response body")),
+	}}
+
+	clone, err := CloneResponse(res)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clone.StatusCode = http.StatusTeapot
+	clone.Header.Set("Content-Type", "application/json")
+	clone.Trailer.Set("X-Checksum", "5678")
+	clone.SetBody([]byte("modified"))
+
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("status of original was changed to %v", res.StatusCode)
+	}
+	if v := res.Header.Get("Content-Type"); v != "text/plain" {
+		t.Errorf("header of original was changed to %q", v)
+	}
+	if v := res.Trailer.Get("X-Checksum"); v != "1234" {
+		t.Errorf("trailer of original was changed to %q", v)
+	}
+
+	if body := readAll(t, res.Body); body != "response body" {
+		t.Errorf("wrong original body %q", body)
+	}
+	if body := readAll(t, clone.Body); body != "modified" {
+		t.Errorf("wrong clone body %q", body)
+	}
+}
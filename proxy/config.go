@@ -0,0 +1,100 @@
+package proxy
+
+import (
+	"net/http"
+)
+
+// Config contains the settings which are read for every request and can be
+// changed with UpdateConfig while the proxy is running.
+type Config struct {
+	// PassthroughContentTypes is a list of media type patterns (e.g.
+	// "video/*"), matching responses are sent to the client untouched
+	// without running the hooks on the body.
+	PassthroughContentTypes []string
+
+	// BufferContentTypes is a list of media type patterns for responses which
+	// are always buffered and passed through the hooks, even if they match
+	// PassthroughContentTypes or have an unknown length while
+	// Proxy.StreamUnknownLength is set. Server-sent events and gRPC responses
+	// are streamed regardless.
+	BufferContentTypes []string
+
+	// StaticRequestHeaders are set on all requests forwarded to upstream
+	// servers. They replace headers of the same name sent by the client, but
+	// hooks run afterwards and can still change them.
+	StaticRequestHeaders http.Header
+
+	// StaticResponseHeaders are set on all responses forwarded to the client
+	// after the hooks have run, replacing headers of the same name sent by the
+	// upstream server or set by a hook. Errors generated by the proxy itself
+	// don't include them.
+	StaticResponseHeaders http.Header
+
+	// DefaultHost is used as the target (via plain HTTP) for requests which
+	// contain neither an absolute URL nor a Host header, e.g. raw requests
+	// sent for testing. If it is empty, these requests are rejected.
+	DefaultHost string
+
+	// AllowedConnectPorts, if set, restricts CONNECT requests to targets on
+	// the listed ports, others are blocked. By default, all ports are
+	// allowed.
+	AllowedConnectPorts []int
+
+	// Scope, if set, lists the host patterns requests may be sent to, a
+	// pattern starting with "*." also matches all subdomains. Requests and
+	// CONNECT tunnels to other hosts are blocked.
+	Scope []string
+
+	// Block is the response sent for blocked requests.
+	Block BlockAction
+}
+
+// clone returns a deep copy of c.
+func (c *Config) clone() *Config {
+	res := *c
+	res.PassthroughContentTypes = append([]string(nil), c.PassthroughContentTypes...)
+	res.BufferContentTypes = append([]string(nil), c.BufferContentTypes...)
+	res.StaticRequestHeaders = cloneHeader(c.StaticRequestHeaders)
+	res.StaticResponseHeaders = cloneHeader(c.StaticResponseHeaders)
+	res.AllowedConnectPorts = append([]int(nil), c.AllowedConnectPorts...)
+	res.Scope = append([]string(nil), c.Scope...)
+	res.Block.Header = cloneHeader(c.Block.Header)
+	return &res
+}
+
+func cloneHeader(h http.Header) http.Header {
+	if h == nil {
+		return nil
+	}
+	res := make(http.Header, len(h))
+	for name, values := range h {
+		res[name] = append([]string(nil), values...)
+	}
+	return res
+}
+
+// Config returns a copy of the current configuration.
+func (p *Proxy) Config() Config {
+	return *p.config().clone()
+}
+
+// config returns the current configuration. It is shared by all requests and
+// must not be modified.
+func (p *Proxy) config() *Config {
+	p.configMu.RLock()
+	defer p.configMu.RUnlock()
+	return p.cfg
+}
+
+// UpdateConfig calls f with a copy of the current configuration and makes the
+// modified copy the new configuration. Requests which started before keep
+// using the previous configuration, new requests see all changes made by f at
+// once. Concurrent calls are serialized.
+func (p *Proxy) UpdateConfig(f func(*Config)) {
+	p.configMu.Lock()
+	defer p.configMu.Unlock()
+
+	cfg := p.cfg.clone()
+	f(cfg)
+	p.cfg = cfg
+}
@@ -0,0 +1,95 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestProxyUpdateConfig(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		_, _ = io.WriteString(rw, req.Header.Get("X-Generation"))
+	}))
+	defer srv.Close()
+
+	proxy, serve, shutdown := TestProxy(t, nil)
+	go serve()
+	defer shutdown()
+
+	client := testClient(t, proxy.Addr, proxy.CertificateAuthority)
+
+	// update the config while requests are forwarded
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-done:
+				return
+			default:
+			}
+
+			proxy.UpdateConfig(func(cfg *Config) {
+				if cfg.StaticRequestHeaders == nil {
+					cfg.StaticRequestHeaders = make(http.Header)
+				}
+				cfg.StaticRequestHeaders.Set("X-Generation", strconv.Itoa(i))
+				cfg.StaticResponseHeaders = http.Header{"X-Generation": []string{strconv.Itoa(i)}}
+				cfg.PassthroughContentTypes = append(cfg.PassthroughContentTypes[:0], "video/*")
+			})
+		}
+	}()
+
+	for i := 0; i < 20; i++ {
+		res, err := client.Get(srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wantStatus(t, res, http.StatusOK)
+		_ = res.Body.Close()
+	}
+
+	close(done)
+	wg.Wait()
+
+	// changes take effect for the next request
+	proxy.UpdateConfig(func(cfg *Config) {
+		cfg.StaticRequestHeaders.Set("X-Generation", "final")
+		cfg.StaticResponseHeaders.Set("X-Generation", "final")
+	})
+
+	res, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantStatus(t, res, http.StatusOK)
+	wantHeader(t, res, map[string]string{"X-Generation": "final"})
+	wantBody(t, res, "final")
+}
+
+func TestProxyConfigCopy(t *testing.T) {
+	proxy, _, shutdown := TestProxy(t, nil)
+	defer shutdown()
+
+	proxy.UpdateConfig(func(cfg *Config) {
+		cfg.StaticRequestHeaders = http.Header{"X-Foo": []string{"bar"}}
+		cfg.AllowedConnectPorts = []int{443}
+	})
+
+	cfg := proxy.Config()
+	cfg.StaticRequestHeaders.Set("X-Foo", "changed")
+	cfg.AllowedConnectPorts[0] = 8443
+
+	cfg = proxy.Config()
+	if v := cfg.StaticRequestHeaders.Get("X-Foo"); v != "bar" {
+		t.Errorf("modifying the copy changed the config: X-Foo is %q", v)
+	}
+	if cfg.AllowedConnectPorts[0] != 443 {
+		t.Errorf("modifying the copy changed the config: ports are %v", cfg.AllowedConnectPorts)
+	}
+}
@@ -2,6 +2,7 @@ package proxy
 
 import (
 	"bufio"
+	"context"
 	"crypto/tls"
 	"errors"
 	"fmt"
@@ -9,6 +10,10 @@ import (
 	"log"
 	"net"
 	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/errgroup"
 )
 
 type buffConn struct {
@@ -22,6 +27,10 @@ func (b buffConn) Read(p []byte) (int, error) {
 
 var errFakeListenerEOF = errors.New("listener has no more connections")
 
+// tlsHandshakeTimeout limits the time a client within a CONNECT tunnel may
+// take for the TLS handshake.
+var tlsHandshakeTimeout = 30 * time.Second
+
 type fakeListener struct {
 	ch   chan net.Conn
 	addr net.Addr
@@ -43,6 +52,61 @@ func (l *fakeListener) Addr() net.Addr {
 	return l.addr
 }
 
+// tunnelServers keeps track of the servers handling the requests received
+// through CONNECT tunnels. The connections are hijacked from the proxy's
+// server, so its Shutdown doesn't wait for them.
+type tunnelServers struct {
+	mu      sync.Mutex
+	servers map[*http.Server]struct{}
+	closed  bool
+}
+
+// add registers srv, it returns false if the servers are shut down already.
+func (t *tunnelServers) add(srv *http.Server) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.closed {
+		return false
+	}
+
+	if t.servers == nil {
+		t.servers = make(map[*http.Server]struct{})
+	}
+	t.servers[srv] = struct{}{}
+	return true
+}
+
+// remove forgets srv.
+func (t *tunnelServers) remove(srv *http.Server) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.servers, srv)
+}
+
+// Shutdown shuts down all registered servers and waits for the requests
+// being processed, until ctx is cancelled. Servers added afterwards are
+// rejected.
+func (t *tunnelServers) Shutdown(ctx context.Context) error {
+	t.mu.Lock()
+	t.closed = true
+	servers := make([]*http.Server, 0, len(t.servers))
+	for srv := range t.servers {
+		servers = append(servers, srv)
+	}
+	t.mu.Unlock()
+
+	var g errgroup.Group
+	for _, srv := range servers {
+		srv := srv
+		g.Go(func() error {
+			return srv.Shutdown(ctx)
+		})
+	}
+	return g.Wait()
+}
+
 func writeConnectSuccess(wr io.Writer) error {
 	res := http.Response{
 		Proto:         "HTTP/1.0",
@@ -74,6 +138,39 @@ func writeConnectError(wr io.WriteCloser, err error) {
 // ServeConnect makes a connection to a target host and forwards all packets.
 // If an error is returned, hijacking the connection hasn't worked.
 func ServeConnect(event *Event, tlsConfig *tls.Config, certCache *Cache, errorLogger *log.Logger, nextRequestID func() uint64, serveProxyRequest func(*Event)) {
+	serveConnect(event, tlsConfig, certCache, errorLogger, nextRequestID, serveProxyRequest, connectOptions{})
+}
+
+// connectOptions configures serveConnect.
+type connectOptions struct {
+	// tracker, if set, registers the connection and the requests received on
+	// it.
+	tracker *connTracker
+
+	// transcriptDir, if set, receives a transcript of the (decrypted) data
+	// exchanged with the client.
+	transcriptDir string
+
+	// captureRaw records the exact bytes of the requests received in the
+	// tunnel, see Proxy.CaptureRaw. Request bodies larger than maxBody
+	// bytes are not recorded, and neither are requests while paused returns
+	// true, see captureRequest.
+	captureRaw bool
+	maxBody    int64
+	paused     func() bool
+
+	// hsts, if set, is used to warn about intercepting HSTS hosts. With
+	// hstsPassthrough, connections to these hosts are not intercepted.
+	hsts            *HSTSList
+	hstsPassthrough bool
+
+	// servers, if set, registers the server handling the requests in the
+	// tunnel, so that it can be shut down with the proxy.
+	servers *tunnelServers
+}
+
+// serveConnect works like ServeConnect, with additional options.
+func serveConnect(event *Event, tlsConfig *tls.Config, certCache *Cache, errorLogger *log.Logger, nextRequestID func() uint64, serveProxyRequest func(*Event), opts connectOptions) {
 	hj, ok := event.ResponseWriter.(http.Hijacker)
 	if !ok {
 		event.SendError("unable to reuse connection for CONNECT")
@@ -102,12 +199,34 @@ func ServeConnect(event *Event, tlsConfig *tls.Config, certCache *Cache, errorLo
 		return
 	}
 
-	// try to find out if the client tries to setup TLS
 	bconn := buffConn{
-		Reader: bufio.NewReader(conn),
+		// make sure a complete TLS record fits into the buffer
+		Reader: bufio.NewReaderSize(conn, 5+16*1024),
 		Conn:   conn,
 	}
 
+	var forceHost = event.Req.URL.Host
+	if event.ForceHost != "" {
+		forceHost = event.ForceHost
+	}
+
+	if opts.hsts != nil {
+		host, port, err := net.SplitHostPort(forceHost)
+		if err != nil {
+			host, port = forceHost, "443"
+		}
+
+		if opts.hsts.Contains(host) {
+			if opts.hstsPassthrough {
+				event.Log("%v uses HSTS, passing the connection through without interception", host)
+				tunnel(event, bconn, net.JoinHostPort(host, port))
+				return
+			}
+			event.Log("warning: %v uses HSTS, browsers will refuse the generated certificate unless the CA is trusted", host)
+		}
+	}
+
+	// try to find out if the client tries to setup TLS
 	buf, err := bconn.Peek(1)
 	if err != nil {
 		event.Log("peek(1) failed: %v", err)
@@ -120,15 +239,22 @@ func ServeConnect(event *Event, tlsConfig *tls.Config, certCache *Cache, errorLo
 		addr: conn.RemoteAddr(),
 	}
 
-	var forceHost = event.Req.URL.Host
-	if event.ForceHost != "" {
-		forceHost = event.ForceHost
-	}
 	var forceScheme string
 	var parentID = event.ID
+	var clientHello *ClientHello
+
+	// tlsState is set if the server can't determine the TLS state itself
+	var tlsState *tls.ConnectionState
 
 	// TLS client hello starts with 0x16
 	if buf[0] == 0x16 {
+		// don't wait forever for clients which stall during the handshake
+		_ = conn.SetDeadline(time.Now().Add(tlsHandshakeTimeout))
+
+		clientHello, err = peekClientHello(bconn.Reader)
+		if err != nil {
+			event.Log("parsing ClientHello failed: %v", err)
+		}
 
 		// create new TLS config for this server, copying all values from tlsConfig
 		var cfg = tlsConfig.Clone()
@@ -138,17 +264,30 @@ func ServeConnect(event *Event, tlsConfig *tls.Config, certCache *Cache, errorLo
 			return certCache.Get(event.Req.Context(), forceHost, ch.ServerName)
 		}
 
+		if opts.transcriptDir != "" || opts.captureRaw {
+			// the transcript or the capture wraps the TLS connection, so the
+			// server can't negotiate HTTP2 on it
+			cfg.NextProtos = []string{"http/1.1"}
+		}
+
 		tlsConn := tls.Server(bconn, cfg)
 
 		err = tlsConn.Handshake()
 		if err != nil {
 			event.Log("TLS handshake for %v failed: %v", event.Req.URL.Host, err)
+			_ = conn.Close()
 			return
 		}
+		_ = conn.SetDeadline(time.Time{})
 
 		// req.Log("TLS handshake for %v succeeded, next protocol: %v", req.URL.Host, tlsConn.ConnectionState().NegotiatedProtocol)
 
-		listener.ch <- tlsConn
+		if opts.transcriptDir != "" || opts.captureRaw {
+			state := tlsConn.ConnectionState()
+			tlsState = &state
+		}
+
+		listener.ch <- capture(opts.captureRaw, transcribe(event, opts.transcriptDir, tlsConn, forceHost))
 		close(listener.ch)
 
 		// use new request IDs for HTTP2
@@ -160,7 +299,7 @@ func ServeConnect(event *Event, tlsConfig *tls.Config, certCache *Cache, errorLo
 		forceScheme = "https"
 
 	} else {
-		listener.ch <- bconn
+		listener.ch <- capture(opts.captureRaw, transcribe(event, opts.transcriptDir, bconn, forceHost))
 		close(listener.ch)
 
 		// handle the next requests as HTTP
@@ -169,29 +308,101 @@ func ServeConnect(event *Event, tlsConfig *tls.Config, certCache *Cache, errorLo
 
 	logger := event.Logger
 
+	var tracked *trackedConn
+	if opts.tracker != nil {
+		tracked = opts.tracker.open(event.ID, forceHost)
+	}
+
 	srv := &http.Server{
-		ErrorLog: errorLogger,
+		ErrorLog:    errorLogger,
+		ConnContext: captureConnContext,
 		Handler: http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			if tracked != nil {
+				defer tracked.begin(req.Context())()
+			}
+
 			nextID := parentID
 			if nextID == 0 {
 				nextID = nextRequestID()
 			}
+			if req.TLS == nil && tlsState != nil {
+				req.TLS = tlsState
+			}
+
+			raw, err := captureRequest(req, opts.maxBody, opts.paused != nil && opts.paused())
+
 			event := newEvent(res, req, logger, nextID)
+			if err != nil {
+				event.SendErrorStatus(http.StatusBadRequest, "reading request body failed: %v", err)
+				return
+			}
+			event.RawClientRequest = raw
 			// send all requests to the host we were told to connect to
 			event.ForceHost = forceHost
 			event.ForceScheme = forceScheme
+			event.ViaCONNECT = true
+			event.ClientHello = clientHello
 
 			serveProxyRequest(event)
 		}),
 	}
 
-	// handle all incoming requests
+	srv.ConnState = func(c net.Conn, state http.ConnState) {
+		if tracked != nil {
+			tracked.connState(c, state)
+		}
+		if opts.servers != nil && (state == http.StateClosed || state == http.StateHijacked) {
+			opts.servers.remove(srv)
+		}
+	}
+
+	if opts.servers != nil && !opts.servers.add(srv) {
+		// the proxy is shutting down
+		_ = conn.Close()
+		if tracked != nil {
+			tracked.closed()
+		}
+		return
+	}
+
+	// handle all incoming requests, Serve returns when the connection has
+	// been accepted and the server keeps running until it is closed
 	err = srv.Serve(listener)
 	if err == errFakeListenerEOF {
 		err = nil
 	}
+	if err == http.ErrServerClosed {
+		// shut down before the connection was accepted
+		_ = conn.Close()
+		if tracked != nil {
+			tracked.closed()
+		}
+		err = nil
+	}
 
 	if err != nil {
 		event.Log("error serving connection: %v", err)
 	}
 }
+
+// capture returns conn wrapped in a captureConn if enabled is set.
+func capture(enabled bool, conn net.Conn) net.Conn {
+	if !enabled {
+		return conn
+	}
+	return &captureConn{Conn: conn}
+}
+
+// transcribe returns conn wrapped in a transcriptConn if dir is not empty.
+func transcribe(event *Event, dir string, conn net.Conn, host string) net.Conn {
+	if dir == "" {
+		return conn
+	}
+
+	tconn, err := newTranscriptConn(conn, dir, event.ID, host)
+	if err != nil {
+		event.Log("creating transcript failed: %v", err)
+		return conn
+	}
+	return tconn
+}
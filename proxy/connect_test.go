@@ -0,0 +1,61 @@
+package proxy
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"testing"
+)
+
+// sendConnect sends a CONNECT request for target to the proxy and returns the
+// status code of the response.
+func sendConnect(t testing.TB, proxyAddr, target string) int {
+	conn, err := net.Dial("tcp", proxyAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	_, err = fmt.Fprintf(conn, "CONNECT %v HTTP/1.1\r\nHost: %v\r\n\r\n", target, target)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = res.Body.Close()
+
+	return res.StatusCode
+}
+
+func TestProxyAllowedConnectPorts(t *testing.T) {
+	proxy, serve, shutdown := TestProxy(t, nil)
+	proxy.UpdateConfig(func(cfg *Config) {
+		cfg.AllowedConnectPorts = []int{443, 8443}
+	})
+	go serve()
+	defer shutdown()
+
+	var tests = []struct {
+		target string
+		status int
+	}{
+		{"example.com:443", http.StatusOK},
+		{"example.com:8443", http.StatusOK},
+		{"example.com", http.StatusOK},
+		{"example.com:22", http.StatusForbidden},
+		{"localhost:6379", http.StatusForbidden},
+	}
+
+	for _, test := range tests {
+		t.Run(test.target, func(t *testing.T) {
+			status := sendConnect(t, proxy.Addr, test.target)
+			if status != test.status {
+				t.Errorf("wrong status for CONNECT to %v, want %v, got %v", test.target, test.status, status)
+			}
+		})
+	}
+}
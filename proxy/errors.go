@@ -0,0 +1,107 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Errors returned by ForwardRequest when the request to the upstream server
+// fails, wrapped in an UpstreamError. Use errors.Is to check for them.
+var (
+	ErrUpstreamDial     = errors.New("connecting to upstream failed")
+	ErrUpstreamTimeout  = errors.New("upstream timed out")
+	ErrUpstreamTLS      = errors.New("TLS handshake with upstream failed")
+	ErrUpstreamProtocol = errors.New("invalid upstream response")
+)
+
+// ErrNoTarget is returned for requests which neither contain an absolute URL
+// nor a Host header, when no Config.DefaultHost is configured.
+var ErrNoTarget = errors.New("request has no target: neither an absolute URL nor a Host header was sent")
+
+// UpstreamError is returned by ForwardRequest when the request to the
+// upstream server fails. Kind is one of the ErrUpstream* errors and Err the
+// underlying cause.
+type UpstreamError struct {
+	Kind error
+	Err  error
+}
+
+func (e *UpstreamError) Error() string {
+	return e.Kind.Error() + ": " + e.Err.Error()
+}
+
+// Unwrap returns the underlying cause.
+func (e *UpstreamError) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether target is the kind of the error.
+func (e *UpstreamError) Is(target error) bool {
+	return e.Kind == target
+}
+
+// Status returns the HTTP status code sent to the client for the error.
+func (e *UpstreamError) Status() int {
+	if e.Kind == ErrUpstreamTimeout {
+		return http.StatusGatewayTimeout
+	}
+	return http.StatusBadGateway
+}
+
+// upstreamError classifies an error returned when sending a request to the
+// upstream server. Errors caused by the client going away are returned
+// unchanged.
+func upstreamError(err error) error {
+	var upstreamErr *UpstreamError
+	if errors.As(err, &upstreamErr) || errors.Is(err, context.Canceled) {
+		return err
+	}
+
+	return &UpstreamError{Kind: upstreamErrorKind(err), Err: err}
+}
+
+func upstreamErrorKind(err error) error {
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return ErrUpstreamTimeout
+	}
+
+	var (
+		recordErr    tls.RecordHeaderError
+		alertErr     tls.AlertError
+		verifyErr    *tls.CertificateVerificationError
+		authorityErr x509.UnknownAuthorityError
+		hostnameErr  x509.HostnameError
+		invalidErr   x509.CertificateInvalidError
+	)
+	if errors.As(err, &recordErr) || errors.As(err, &alertErr) || errors.As(err, &verifyErr) ||
+		errors.As(err, &authorityErr) || errors.As(err, &hostnameErr) || errors.As(err, &invalidErr) ||
+		strings.Contains(err.Error(), "tls: ") ||
+		strings.Contains(err.Error(), "server gave HTTP response to HTTPS client") {
+		return ErrUpstreamTLS
+	}
+
+	var (
+		opErr  *net.OpError
+		dnsErr *net.DNSError
+	)
+	if errors.As(err, &dnsErr) || (errors.As(err, &opErr) && opErr.Op == "dial") {
+		return ErrUpstreamDial
+	}
+
+	return ErrUpstreamProtocol
+}
+
+// errorStatus returns the status code sent to the client for err.
+func errorStatus(err error) int {
+	var upstreamErr *UpstreamError
+	if errors.As(err, &upstreamErr) {
+		return upstreamErr.Status()
+	}
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,101 @@
+package proxy
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestProxyUpstreamErrors(t *testing.T) {
+	// a plain HTTP server fails the TLS handshake
+	plainSrv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {}))
+	defer plainSrv.Close()
+
+	// the certificate of this server is not trusted by the proxy
+	untrustedSrv := httptest.NewTLSServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {}))
+	untrustedSrv.Config.ErrorLog = log.New(ioutil.Discard, "", 0)
+	defer untrustedSrv.Close()
+
+	// nothing listens on the address of a closed listener
+	listener := newLocalListener(t)
+	refusedAddr := listener.Addr().String()
+	_ = listener.Close()
+
+	var tests = []struct {
+		url    string
+		kind   error
+		status int
+	}{
+		{"http://" + refusedAddr, ErrUpstreamDial, http.StatusBadGateway},
+		{strings.Replace(plainSrv.URL, "http://", "https://", 1), ErrUpstreamTLS, http.StatusBadGateway},
+		{untrustedSrv.URL, ErrUpstreamTLS, http.StatusBadGateway},
+	}
+
+	for _, test := range tests {
+		t.Run(test.url, func(t *testing.T) {
+			proxy, serve, shutdown := TestProxy(t, nil)
+			go serve()
+			defer shutdown()
+
+			var forwardErr error
+			proxy.Register(func(event *Event) (*Response, error) {
+				res, err := event.ForwardRequest()
+				forwardErr = err
+				return res, err
+			})
+
+			client := testClient(t, proxy.Addr, proxy.CertificateAuthority)
+			res, err := client.Get(test.url)
+			if err != nil {
+				t.Fatal(err)
+			}
+			wantStatus(t, res, test.status)
+			_ = res.Body.Close()
+
+			if !errors.Is(forwardErr, test.kind) {
+				t.Fatalf("wrong error returned, want %v, got %v", test.kind, forwardErr)
+			}
+
+			var upstreamErr *UpstreamError
+			if !errors.As(forwardErr, &upstreamErr) || upstreamErr.Err == nil {
+				t.Errorf("cause of error %v not available", forwardErr)
+			}
+		})
+	}
+}
+
+func TestProxyErrorAfterPassthrough(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		// flush the header, so the body is sent without a Content-Length
+		rw.Header().Set("Content-Type", "text/event-stream")
+		rw.(http.Flusher).Flush()
+		_, _ = io.WriteString(rw, "data: event\n\n")
+	}))
+	defer srv.Close()
+
+	proxy, serve, shutdown := TestProxy(t, nil)
+	go serve()
+	defer shutdown()
+
+	// the hook fails after the response was passed through to the client
+	proxy.Register(func(event *Event) (*Response, error) {
+		_, err := event.ForwardRequest()
+		if err != nil {
+			return nil, err
+		}
+		return nil, errors.New("hook failed")
+	})
+
+	client := testClient(t, proxy.Addr, proxy.CertificateAuthority)
+	res, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantStatus(t, res, http.StatusOK)
+	wantBody(t, res, "data: event\n\n")
+}
@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"io"
@@ -11,8 +12,10 @@ import (
 	"log"
 	"net/http"
 	"net/http/httputil"
+	"net/textproto"
 	"net/url"
 	"strings"
+	"sync"
 )
 
 // ErrNoForwardAction is thrown by the default value of the
@@ -34,10 +37,65 @@ type Event struct {
 
 	ForceHost, ForceScheme string
 
+	// ClientProto is the protocol the client used for the request (e.g.
+	// "HTTP/1.1" or "HTTP/2.0"), ClientTLS is the state of the TLS
+	// connection to the client or nil if the request was sent in plaintext.
+	// ViaCONNECT is set if the request was received through a CONNECT tunnel.
+	ClientProto string
+	ClientTLS   *tls.ConnectionState
+	ViaCONNECT  bool
+
+	// ClientHello contains the details of the TLS handshake with the client
+	// for requests received through a CONNECT tunnel, e.g. for computing a
+	// JA3 fingerprint. It is nil for plaintext requests.
+	ClientHello *ClientHello
+
+	// UpstreamCipherProfile is the name of the cipher profile used for
+	// connections to the upstream server, see SetCipherProfile.
+	UpstreamCipherProfile string
+
+	// RawUpstream, if set by a hook before forwarding the request, is written
+	// to a new connection to the upstream server verbatim instead of the
+	// request, e.g. for sending ambiguous framing like duplicate
+	// Content-Length and Transfer-Encoding headers. Req is still used to
+	// select the target.
+	RawUpstream []byte
+
+	// RawClientRequest contains the exact bytes of the request as received
+	// from the client if Proxy.CaptureRaw is enabled. Setting RawUpstream to
+	// it forwards the request verbatim.
+	RawClientRequest []byte
+
+	// RawUpstreamResponse contains the exact bytes of the response as
+	// received from the upstream server if Proxy.CaptureRawResponses is
+	// enabled, including the header casing and order and the transfer
+	// encoding of the body. It is set when the request has been forwarded,
+	// responses passed through to the client are not recorded.
+	RawUpstreamResponse []byte
+
 	ForwardRequest func() (*Response, error)
 	Abort          context.CancelFunc
 
 	*log.Logger
+
+	responseSent bool
+	bytesSent    int64
+
+	// withoutHooks is set for requests forwarded without running the hooks,
+	// e.g. because the body is large or streamed
+	withoutHooks bool
+
+	// paused is set if capturing was paused when the request was received,
+	// see Proxy.PauseCapture
+	paused bool
+
+	// status is the status code sent to the client by the proxy,
+	// errorBytes the size of the body of an error generated by the proxy
+	status     int
+	errorBytes int64
+
+	valuesMu sync.Mutex
+	values   map[string]interface{}
 }
 
 func newEvent(rw http.ResponseWriter, req *http.Request, logger *log.Logger, id uint64) *Event {
@@ -45,6 +103,8 @@ func newEvent(rw http.ResponseWriter, req *http.Request, logger *log.Logger, id
 		ID:             id,
 		Req:            req,
 		ResponseWriter: rw,
+		ClientProto:    req.Proto,
+		ClientTLS:      req.TLS,
 		ForwardRequest: func() (*Response, error) {
 			return nil, ErrNoForwardAction
 		},
@@ -101,18 +161,24 @@ func (e *Event) SetRequestBody(body []byte) {
 // parsed from the provided byte slice
 func (e *Event) SetRequest(rawRequest []byte) error {
 	req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(rawRequest)))
+	if err != nil {
+		return fmt.Errorf("ReadRequest: %v", err)
+	}
 
 	// RequestURI can't be set for client requests
 	req.RequestURI = ""
-	// recover the protocl from the original request, but update Host and URL
-	req.URL, err = url.Parse(fmt.Sprintf("%s://%s%s", e.Req.URL.Scheme, req.Host, req.URL))
+	req.RemoteAddr = e.Req.RemoteAddr
+
+	// recover the protocol from the original request, but update Host and URL
+	var scheme = "http"
+	if e.Req.URL != nil && e.Req.URL.Scheme != "" {
+		scheme = e.Req.URL.Scheme
+	}
+	req.URL, err = url.Parse(fmt.Sprintf("%s://%s%s", scheme, req.Host, req.URL))
 	if err != nil {
 		return fmt.Errorf("parsing reconstructed URL: %v", err)
 	}
 
-	if err != nil {
-		return fmt.Errorf("ReadRequest: %v", err)
-	}
 	e.Req = req
 	return nil
 }
@@ -131,7 +197,7 @@ func (r *Response) RawBody() ([]byte, error) {
 }
 
 // Raw returns an approximation of the full response as byte
-// slice.
+// slice, see Event.RawUpstreamResponse for the exact bytes.
 func (r *Response) Raw() ([]byte, error) {
 	// make sure that the body is a NopCloser
 	_, err := readWithoutClose(&r.Body)
@@ -163,7 +229,14 @@ func (r *Response) Set(rawResponse []byte) error {
 	return nil
 }
 
-func (e *Event) prepareRequest() error {
+// prepareRequest builds the request for the upstream server from the request
+// received from the client. The request-target form used on the wire is
+// selected by the transport: origin-form (GET /path) when connecting to the
+// target host directly, absolute-form (GET http://host/path) only for an
+// upstream proxy. The escaping of the path is kept as sent by the client.
+// The static headers replace the headers of the same name sent by the
+// client, the filter for hop-by-hop headers applies to them as well.
+func (e *Event) prepareRequest(static http.Header) error {
 	url := e.Req.URL
 	if e.ForceHost != "" {
 		url.Scheme = e.ForceScheme
@@ -192,10 +265,20 @@ func (e *Event) prepareRequest() error {
 		return err
 	}
 
-	// use Host header from received request
+	// use Host header from received request, keep the client address for
+	// logging and hooks
 	req.Host = e.Req.Host
+	req.RemoteAddr = e.Req.RemoteAddr
 
-	for name, values := range e.Req.Header {
+	header := e.Req.Header
+	if len(static) > 0 {
+		header = header.Clone()
+		for name, values := range static {
+			header[textproto.CanonicalMIMEHeaderKey(name)] = append([]string(nil), values...)
+		}
+	}
+
+	for name, values := range header {
 		if _, ok := filterHeaders[strings.ToLower(name)]; ok {
 			// header is filtered, do not send it to the upstream server
 			continue
@@ -214,6 +297,41 @@ func (e *Event) prepareRequest() error {
 	return nil
 }
 
+// ResponseSent returns true if the response has already been sent to the
+// client, e.g. because it was streamed. Hooks cannot modify the response in
+// this case.
+func (e *Event) ResponseSent() bool {
+	return e.responseSent
+}
+
+// BytesSent returns the number of response body bytes sent to the client. If
+// sending the response failed, this is the number of bytes delivered before
+// the error.
+func (e *Event) BytesSent() int64 {
+	return e.bytesSent
+}
+
+// Set stores the value v under key for this transaction, so that hooks later in
+// the pipeline can retrieve it with Get. Values are not shared between events.
+func (e *Event) Set(key string, v interface{}) {
+	e.valuesMu.Lock()
+	defer e.valuesMu.Unlock()
+
+	if e.values == nil {
+		e.values = make(map[string]interface{})
+	}
+	e.values[key] = v
+}
+
+// Get returns the value stored with Set under key for this transaction.
+func (e *Event) Get(key string) (v interface{}, ok bool) {
+	e.valuesMu.Lock()
+	defer e.valuesMu.Unlock()
+
+	v, ok = e.values[key]
+	return v, ok
+}
+
 // Log logs a message through the embedded logger, prefixed with information
 // about the request that spawned the Event
 func (e *Event) Log(msg string, args ...interface{}) {
@@ -223,8 +341,16 @@ func (e *Event) Log(msg string, args ...interface{}) {
 
 // SendError responds with an error (which is also logged).
 func (e *Event) SendError(msg string, args ...interface{}) {
+	e.SendErrorStatus(http.StatusInternalServerError, msg, args...)
+}
+
+// SendErrorStatus responds with an error and the given status code (the error
+// is also logged).
+func (e *Event) SendErrorStatus(code int, msg string, args ...interface{}) {
 	e.Log(msg, args...)
 	e.ResponseWriter.Header().Set("Content-Type", "text/plain")
-	e.ResponseWriter.WriteHeader(http.StatusInternalServerError)
-	fmt.Fprintf(e.ResponseWriter, msg, args...)
+	e.ResponseWriter.WriteHeader(code)
+	e.status = code
+	n, _ := fmt.Fprintf(e.ResponseWriter, msg, args...)
+	e.errorBytes = int64(n)
 }
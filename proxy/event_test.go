@@ -87,6 +87,40 @@ func TestSetRequest(t *testing.T) {
 			t.Errorf("client request cannot have RequestURI set")
 		}
 	})
+
+	t.Run("custom method", func(t *testing.T) {
+		e := dummyEvent()
+
+		for _, method := range []string{"PURGE", "PROPFIND", "x-custom"} {
+			err := e.SetRequest(bytes.Replace(postRequest, []byte("POST"), []byte(method), 1))
+			if err != nil {
+				t.Fatalf("SetRequest with %v request failed: %v", method, err)
+			}
+			if e.Req.Method != method {
+				t.Errorf("Method mismatch (got `%s`, want `%s`)", e.Req.Method, method)
+			}
+			body, err := e.RawRequestBody()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(body, postRequestBody) {
+				t.Errorf("body mismatch (got `%s`, want `%s`)", body, postRequestBody)
+			}
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		e := dummyEvent()
+		req := e.Req
+
+		err := e.SetRequest([]byte("not a request\r\n\r\n"))
+		if err == nil {
+			t.Fatal("SetRequest with invalid request did not return an error")
+		}
+		if e.Req != req {
+			t.Errorf("request was replaced despite the error")
+		}
+	})
 }
 
 func TestResponseSet(t *testing.T) {
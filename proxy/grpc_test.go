@@ -0,0 +1,114 @@
+package proxy
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+// grpcFrame returns msg as a length-prefixed gRPC message.
+func grpcFrame(msg []byte) []byte {
+	buf := make([]byte, 5, 5+len(msg))
+	binary.BigEndian.PutUint32(buf[1:], uint32(len(msg)))
+	return append(buf, msg...)
+}
+
+func TestProxyGRPC(t *testing.T) {
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.ProtoMajor != 2 {
+			t.Errorf("upstream received %v instead of HTTP/2", req.Proto)
+		}
+
+		if req.Header.Get("Te") != "trailers" {
+			t.Errorf("TE header was not forwarded: %v", req.Header)
+		}
+
+		body, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			t.Error(err)
+		}
+
+		if !bytes.Equal(body, grpcFrame([]byte("ping"))) {
+			t.Errorf("wrong request message received: %q", body)
+		}
+
+		rw.Header().Set("Content-Type", "application/grpc")
+		rw.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+		rw.WriteHeader(http.StatusOK)
+		_, _ = rw.Write(grpcFrame([]byte("pong")))
+
+		rw.Header().Set("Grpc-Status", "0")
+		rw.Header().Set("Grpc-Message", "ok")
+	}))
+	srv.EnableHTTP2 = true
+	srv.StartTLS()
+	defer srv.Close()
+
+	proxy, serve, shutdown := TestProxy(t, &tls.Config{InsecureSkipVerify: true})
+	go serve()
+	defer shutdown()
+
+	var hookCalled bool
+	proxy.Register(func(event *Event) (*Response, error) {
+		hookCalled = true
+		return event.ForwardRequest()
+	})
+
+	proxyURL, err := url.Parse("http://" + proxy.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certPool := x509.NewCertPool()
+	certPool.AddCert(proxy.CertificateAuthority.Certificate)
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			Proxy:             http.ProxyURL(proxyURL),
+			TLSClientConfig:   &tls.Config{RootCAs: certPool},
+			ForceAttemptHTTP2: true,
+		},
+	}
+
+	req, err := http.NewRequest(http.MethodPost, srv.URL+"/test.Service/Ping", bytes.NewReader(grpcFrame([]byte("ping"))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("TE", "trailers")
+
+	res, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res.ProtoMajor != 2 {
+		t.Errorf("client received %v instead of HTTP/2", res.Proto)
+	}
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = res.Body.Close()
+
+	wantStatus(t, res, http.StatusOK)
+
+	if !bytes.Equal(body, grpcFrame([]byte("pong"))) {
+		t.Errorf("wrong response message received: %q", body)
+	}
+
+	if res.Trailer.Get("Grpc-Status") != "0" || res.Trailer.Get("Grpc-Message") != "ok" {
+		t.Errorf("wrong trailers received: %v", res.Trailer)
+	}
+
+	if hookCalled {
+		t.Errorf("hook was run for gRPC call")
+	}
+}
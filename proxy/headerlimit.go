@@ -0,0 +1,85 @@
+package proxy
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// headerFieldSize returns the size of a header field on the wire, including
+// the separator and the line ending.
+func headerFieldSize(name, value string) int {
+	return len(name) + len(": ") + len(value) + len("\r\n")
+}
+
+// headerStats returns the number of header fields (each value counts as a
+// field) and their total size.
+func headerStats(header http.Header) (count, size int) {
+	for name, values := range header {
+		for _, value := range values {
+			count++
+			size += headerFieldSize(name, value)
+		}
+	}
+	return count, size
+}
+
+// trimHeader removes the header fields exceeding maxCount or maxSize (zero
+// disables a limit), names are processed in sorted order. It returns the
+// names of the removed fields.
+func trimHeader(header http.Header, maxCount, maxSize int) (removed []string) {
+	names := make([]string, 0, len(header))
+	for name := range header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var count, size int
+	for _, name := range names {
+		var keep []string
+		for _, value := range header[name] {
+			fieldSize := headerFieldSize(name, value)
+			if (maxCount > 0 && count+1 > maxCount) || (maxSize > 0 && size+fieldSize > maxSize) {
+				if len(removed) == 0 || removed[len(removed)-1] != name {
+					removed = append(removed, name)
+				}
+				continue
+			}
+			count++
+			size += fieldSize
+			keep = append(keep, value)
+		}
+
+		if len(keep) == 0 {
+			delete(header, name)
+		} else {
+			header[name] = keep
+		}
+	}
+
+	return removed
+}
+
+// checkHeaderLimits enforces MaxHeaderCount and MaxHeaderSize for the request
+// of event. It returns false if the request was rejected.
+func (p *Proxy) checkHeaderLimits(event *Event) bool {
+	if p.MaxHeaderCount <= 0 && p.MaxHeaderSize <= 0 {
+		return true
+	}
+
+	count, size := headerStats(event.Req.Header)
+	if (p.MaxHeaderCount <= 0 || count <= p.MaxHeaderCount) && (p.MaxHeaderSize <= 0 || size <= p.MaxHeaderSize) {
+		return true
+	}
+
+	if !p.TrimLargeHeaders {
+		event.SendErrorStatus(http.StatusRequestHeaderFieldsTooLarge,
+			"request header exceeds the limits (%d fields, %d bytes)", count, size)
+		return false
+	}
+
+	removed := trimHeader(event.Req.Header, p.MaxHeaderCount, p.MaxHeaderSize)
+	event.Log("request header exceeds the limits (%d fields, %d bytes), removed %v",
+		count, size, strings.Join(removed, ", "))
+	return true
+}
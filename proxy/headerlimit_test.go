@@ -0,0 +1,47 @@
+package proxy
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestTrimHeader(t *testing.T) {
+	header := http.Header{
+		"A": []string{"1", "2"},
+		"B": []string{"3"},
+		"C": []string{"4", "5"},
+	}
+
+	removed := trimHeader(header, 3, 0)
+
+	want := http.Header{
+		"A": []string{"1", "2"},
+		"B": []string{"3"},
+	}
+	if !reflect.DeepEqual(header, want) {
+		t.Errorf("wrong header after trimming, want %v, got %v", want, header)
+	}
+	if !reflect.DeepEqual(removed, []string{"C"}) {
+		t.Errorf("wrong names removed: %v", removed)
+	}
+
+	// each field is the name, ": ", the value and "\r\n", later fields are
+	// kept if they still fit
+	header = http.Header{
+		"A": []string{"1", "4444"},
+		"B": []string{"2"},
+	}
+	removed = trimHeader(header, 0, 12)
+
+	want = http.Header{
+		"A": []string{"1"},
+		"B": []string{"2"},
+	}
+	if !reflect.DeepEqual(header, want) {
+		t.Errorf("wrong header after trimming, want %v, got %v", want, header)
+	}
+	if !reflect.DeepEqual(removed, []string{"A"}) {
+		t.Errorf("wrong names removed: %v", removed)
+	}
+}
@@ -0,0 +1,134 @@
+package hooks
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/fd0/osmosis/proxy"
+)
+
+// HeaderOp is the operation of a HeaderEdit.
+type HeaderOp int
+
+// These are the operations for editing headers.
+const (
+	// HeaderSet replaces all values of the header.
+	HeaderSet HeaderOp = iota
+
+	// HeaderAdd adds a value to the header.
+	HeaderAdd
+
+	// HeaderDel removes the header.
+	HeaderDel
+)
+
+// HeaderEdit describes a modification of a header. The string "{id}" in the
+// value is replaced by the ID of the transaction.
+type HeaderEdit struct {
+	Op          HeaderOp
+	Name, Value string
+}
+
+// ParseHeaderEdit parses a header edit in the form "Name: value" (set),
+// "+Name: value" (add) or "-Name" (delete).
+func ParseHeaderEdit(s string) (HeaderEdit, error) {
+	if strings.HasPrefix(s, "-") {
+		name := strings.TrimSpace(s[1:])
+		if name == "" {
+			return HeaderEdit{}, fmt.Errorf("header name missing in %q", s)
+		}
+		return HeaderEdit{Op: HeaderDel, Name: name}, nil
+	}
+
+	op := HeaderSet
+	if strings.HasPrefix(s, "+") {
+		op = HeaderAdd
+		s = s[1:]
+	}
+
+	parts := strings.SplitN(s, ":", 2)
+	if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+		return HeaderEdit{}, fmt.Errorf("invalid header %q, want Name: value", s)
+	}
+
+	return HeaderEdit{
+		Op:    op,
+		Name:  strings.TrimSpace(parts[0]),
+		Value: strings.TrimSpace(parts[1]),
+	}, nil
+}
+
+// RequestMatcher reports whether a hook applies to a request.
+type RequestMatcher func(req *http.Request) bool
+
+// MatchHost returns a matcher for requests to one of the hosts. A pattern
+// starting with "*." also matches all subdomains.
+func MatchHost(patterns ...string) RequestMatcher {
+	return func(req *http.Request) bool {
+		host := strings.ToLower(req.URL.Hostname())
+		for _, pattern := range patterns {
+			pattern = strings.ToLower(pattern)
+			if strings.HasPrefix(pattern, "*.") {
+				if strings.HasSuffix(host, pattern[1:]) || host == pattern[2:] {
+					return true
+				}
+				continue
+			}
+			if host == pattern {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// ResponseHeaders returns a hook which applies the edits to the headers of
+// all responses before they are sent to the client.
+func ResponseHeaders(edits ...HeaderEdit) func(*proxy.Event) (*proxy.Response, error) {
+	return ResponseHeadersMatching(nil, edits...)
+}
+
+// ResponseHeadersMatching works like ResponseHeaders, but only modifies the
+// responses for requests matched by match. If match is nil, all responses are
+// modified.
+func ResponseHeadersMatching(match RequestMatcher, edits ...HeaderEdit) func(*proxy.Event) (*proxy.Response, error) {
+	return func(event *proxy.Event) (*proxy.Response, error) {
+		// evaluate before the request is modified by other hooks
+		matched := match == nil || match(event.Req)
+
+		res, err := event.ForwardRequest()
+		if err != nil {
+			return nil, err
+		}
+
+		if !matched || event.ResponseSent() {
+			return res, nil
+		}
+
+		id := strconv.FormatUint(event.ID, 10)
+		for _, edit := range edits {
+			name := http.CanonicalHeaderKey(edit.Name)
+
+			// the Trailer header is generated from the announced trailers, and
+			// fields announced as trailers are only sent after the body
+			if _, ok := res.Trailer[name]; ok || name == "Trailer" {
+				event.Log("not modifying trailer field %v", name)
+				continue
+			}
+
+			value := strings.Replace(edit.Value, "{id}", id, -1)
+			switch edit.Op {
+			case HeaderSet:
+				res.Header.Set(name, value)
+			case HeaderAdd:
+				res.Header.Add(name, value)
+			case HeaderDel:
+				res.Header.Del(name)
+			}
+		}
+
+		return res, nil
+	}
+}
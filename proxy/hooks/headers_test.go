@@ -0,0 +1,132 @@
+package hooks
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/fd0/osmosis/proxy"
+)
+
+func TestParseHeaderEdit(t *testing.T) {
+	var tests = []struct {
+		s    string
+		want HeaderEdit
+		err  bool
+	}{
+		{s: "Cache-Control: no-store", want: HeaderEdit{Op: HeaderSet, Name: "Cache-Control", Value: "no-store"}},
+		{s: "+X-Debug:txn {id}", want: HeaderEdit{Op: HeaderAdd, Name: "X-Debug", Value: "txn {id}"}},
+		{s: "-Server", want: HeaderEdit{Op: HeaderDel, Name: "Server"}},
+		{s: "X-Empty:", want: HeaderEdit{Op: HeaderSet, Name: "X-Empty"}},
+		{s: "no value", err: true},
+		{s: ": value", err: true},
+		{s: "-", err: true},
+	}
+
+	for _, test := range tests {
+		t.Run("", func(t *testing.T) {
+			edit, err := ParseHeaderEdit(test.s)
+			if test.err {
+				if err == nil {
+					t.Fatalf("expected error for %q not found", test.s)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if edit != test.want {
+				t.Errorf("wrong edit for %q, want %+v, got %+v", test.s, test.want, edit)
+			}
+		})
+	}
+}
+
+func TestResponseHeaders(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Trailer", "X-Checksum")
+		rw.Header().Set("Cache-Control", "max-age=3600")
+		rw.Header().Set("Server", "upstream")
+		_, _ = io.WriteString(rw, "body")
+		rw.Header().Set("X-Checksum", "1234")
+	}))
+	defer srv.Close()
+
+	p, serve, shutdown := proxy.TestProxy(t, nil)
+	go serve()
+	defer shutdown()
+
+	p.Register(ResponseHeaders(
+		HeaderEdit{Op: HeaderSet, Name: "Cache-Control", Value: "no-store"},
+		HeaderEdit{Op: HeaderAdd, Name: "X-Osmosis-ID", Value: "txn-{id}"},
+		HeaderEdit{Op: HeaderDel, Name: "Server"},
+		HeaderEdit{Op: HeaderSet, Name: "X-Checksum", Value: "overwritten"},
+	))
+	p.Register(ResponseHeadersMatching(MatchHost("example.com"),
+		HeaderEdit{Op: HeaderSet, Name: "X-Scoped", Value: "yes"},
+	))
+
+	res, err := testClient(t, p).Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = res.Body.Close()
+
+	if string(body) != "body" {
+		t.Errorf("wrong body %q", body)
+	}
+
+	if v := res.Header.Get("Cache-Control"); v != "no-store" {
+		t.Errorf("Cache-Control was not replaced: %q", v)
+	}
+	if v := res.Header.Get("X-Osmosis-ID"); !strings.HasPrefix(v, "txn-") || v == "txn-{id}" {
+		t.Errorf("wrong X-Osmosis-ID header: %q", v)
+	}
+	if v, ok := res.Header["Server"]; ok {
+		t.Errorf("Server header was not removed: %q", v)
+	}
+	if v, ok := res.Header["X-Scoped"]; ok {
+		t.Errorf("header for other host was added: %q", v)
+	}
+
+	// the trailer is still announced and sent after the body
+	if v := res.Trailer.Get("X-Checksum"); v != "1234" {
+		t.Errorf("wrong trailer value %q", v)
+	}
+	if v, ok := res.Header["X-Checksum"]; ok {
+		t.Errorf("trailer field was sent as header: %q", v)
+	}
+}
+
+func TestMatchHost(t *testing.T) {
+	match := MatchHost("example.com", "*.test.local")
+
+	var tests = []struct {
+		url   string
+		match bool
+	}{
+		{"http://example.com/foo", true},
+		{"https://EXAMPLE.com:8443/", true},
+		{"http://www.example.com/", false},
+		{"http://test.local/", true},
+		{"http://api.test.local/", true},
+		{"http://xtest.local/", false},
+	}
+
+	for _, test := range tests {
+		req, err := http.NewRequest(http.MethodGet, test.url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if match(req) != test.match {
+			t.Errorf("wrong result for %v, want %v", test.url, test.match)
+		}
+	}
+}
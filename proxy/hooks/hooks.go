@@ -4,6 +4,7 @@ import (
 	"fmt"
 
 	"github.com/fd0/osmosis/proxy"
+	"github.com/fd0/osmosis/redact"
 )
 
 // RemoveCompression sets Accept-Encoding to identity such that the
@@ -26,13 +27,19 @@ func LogCompleteRequest(event *proxy.Event) (*proxy.Response, error) {
 
 // DumpToLog returns a hook that dumps the request and/or the response to the event's logger.
 func DumpToLog(dumpRequest, dumpResponse bool) func(*proxy.Event) (*proxy.Response, error) {
+	return DumpToLogRedacted(nil, dumpRequest, dumpResponse)
+}
+
+// DumpToLogRedacted works like DumpToLog, but masks secrets in the dumps with
+// r first. If r is nil, nothing is masked.
+func DumpToLogRedacted(r *redact.Redactor, dumpRequest, dumpResponse bool) func(*proxy.Event) (*proxy.Response, error) {
 	return func(event *proxy.Event) (*proxy.Response, error) {
 		if dumpRequest {
 			dump, err := event.RawRequest()
 			if err != nil {
 				return nil, fmt.Errorf("dumping request: %v", err)
 			}
-			event.Log("Request dump:\n%s", dump)
+			event.Log("Request dump:\n%s", r.Raw(dump))
 		}
 
 		res, err := event.ForwardRequest()
@@ -40,12 +47,12 @@ func DumpToLog(dumpRequest, dumpResponse bool) func(*proxy.Event) (*proxy.Respon
 			return nil, err
 		}
 
-		if dumpResponse {
+		if dumpResponse && !event.ResponseSent() {
 			dump, err := res.Raw()
 			if err != nil {
 				return nil, fmt.Errorf("dumping response: %v", err)
 			}
-			event.Log("Response dump:\n%s", dump)
+			event.Log("Response dump:\n%s", r.Raw(dump))
 		}
 		return res, nil
 	}
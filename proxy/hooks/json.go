@@ -0,0 +1,65 @@
+package hooks
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/fd0/osmosis/proxy"
+)
+
+// TransactionRecord is the summary of a transaction written by LogJSON.
+type TransactionRecord struct {
+	ID       uint64        `json:"id"`
+	Method   string        `json:"method"`
+	URL      string        `json:"url"`
+	Status   int           `json:"status"`
+	Bytes    int64         `json:"bytes"`
+	Duration time.Duration `json:"duration"` // in nanoseconds
+}
+
+// LogJSON returns a hook that writes one JSON object per line to wr for each
+// completed transaction. The duration is measured from the time the hook is
+// called, so it should be registered first to include all other hooks. If
+// the response length is unknown and the body was already streamed to the
+// client, bytes is -1.
+func LogJSON(wr io.Writer) func(*proxy.Event) (*proxy.Response, error) {
+	var mu sync.Mutex
+	enc := json.NewEncoder(wr)
+
+	return func(event *proxy.Event) (*proxy.Response, error) {
+		start := time.Now()
+
+		res, err := event.ForwardRequest()
+		if err != nil {
+			return nil, err
+		}
+
+		rec := TransactionRecord{
+			ID:     event.ID,
+			Method: event.Req.Method,
+			URL:    event.Req.URL.String(),
+			Status: res.StatusCode,
+			Bytes:  res.ContentLength,
+		}
+
+		if !event.ResponseSent() {
+			body, err := res.RawBody()
+			if err != nil {
+				return nil, err
+			}
+			rec.Bytes = int64(len(body))
+		}
+		rec.Duration = time.Since(start)
+
+		mu.Lock()
+		err = enc.Encode(rec)
+		mu.Unlock()
+		if err != nil {
+			event.Log("writing JSON record failed: %v", err)
+		}
+
+		return res, nil
+	}
+}
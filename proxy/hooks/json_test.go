@@ -0,0 +1,85 @@
+package hooks
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/fd0/osmosis/proxy"
+)
+
+// syncBuffer is a bytes.Buffer safe for concurrent use.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) Bytes() []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]byte{}, b.buf.Bytes()...)
+}
+
+func TestLogJSON(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusTeapot)
+		_, _ = rw.Write([]byte("hello world"))
+	}))
+	defer srv.Close()
+
+	p, serve, shutdown := proxy.TestProxy(t, nil)
+	go serve()
+	defer shutdown()
+
+	var buf syncBuffer
+	p.Register(LogJSON(&buf))
+
+	res, err := testClient(t, p).Get(srv.URL + "/foo?x=y")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = res.Body.Close()
+
+	lines := bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n"))
+	if len(lines) != 1 {
+		t.Fatalf("want one JSON line, got %d: %q", len(lines), buf.Bytes())
+	}
+
+	var rec map[string]interface{}
+	err = json.Unmarshal(lines[0], &rec)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]interface{}{
+		"method": "GET",
+		"url":    srv.URL + "/foo?x=y",
+		"status": float64(http.StatusTeapot),
+		"bytes":  float64(len("hello world")),
+	}
+	for field, value := range want {
+		if rec[field] != value {
+			t.Errorf("wrong value for %q, want %v, got %v", field, value, rec[field])
+		}
+	}
+
+	for _, field := range []string{"id", "duration"} {
+		if _, ok := rec[field]; !ok {
+			t.Errorf("field %q is missing", field)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package hooks
+
+import (
+	"fmt"
+	"mime"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/fd0/osmosis/proxy"
+)
+
+// rewriteContentTypes contains the media types of bodies in which URLs are
+// rewritten.
+var rewriteContentTypes = map[string]struct{}{
+	"text/html":                struct{}{},
+	"application/xhtml+xml":    struct{}{},
+	"text/css":                 struct{}{},
+	"text/javascript":          struct{}{},
+	"application/javascript":   struct{}{},
+	"application/x-javascript": struct{}{},
+}
+
+// URLRewrite maps an origin (scheme and host, e.g. "https://example.com") to
+// another origin.
+type URLRewrite struct {
+	From, To string
+}
+
+// urlRewriter applies a URLRewrite to a body.
+type urlRewriter struct {
+	pattern   *regexp.Regexp
+	to        string
+	toEscaped string
+	toNoProto string
+}
+
+// urlBoundary matches the characters which may follow an origin in a URL, so
+// that e.g. https://example.com.other.org is not rewritten.
+const urlBoundary = `([/"'?#)\s>\\]|$)`
+
+func newURLRewriter(rw URLRewrite) (urlRewriter, error) {
+	from, err := url.Parse(rw.From)
+	if err != nil || from.Scheme == "" || from.Host == "" {
+		return urlRewriter{}, fmt.Errorf("invalid origin %q", rw.From)
+	}
+	to, err := url.Parse(rw.To)
+	if err != nil || to.Scheme == "" || to.Host == "" {
+		return urlRewriter{}, fmt.Errorf("invalid origin %q", rw.To)
+	}
+
+	host := regexp.QuoteMeta(from.Host)
+	scheme := regexp.QuoteMeta(from.Scheme)
+
+	// match the origin, the origin with escaped slashes (as in JSON or JS
+	// strings) and protocol-relative URLs
+	pattern := `(?i)(` + scheme + `://` + host + `|` + scheme + `:\\/\\/` + host + `|(?:^|[="'(\s])//` + host + `)` + urlBoundary
+
+	return urlRewriter{
+		pattern:   regexp.MustCompile(pattern),
+		to:        to.Scheme + "://" + to.Host,
+		toEscaped: to.Scheme + `:\/\/` + to.Host,
+		toNoProto: "//" + to.Host,
+	}, nil
+}
+
+// rewrite returns body with all occurrences of the origin replaced.
+func (r urlRewriter) rewrite(body string) string {
+	return r.pattern.ReplaceAllStringFunc(body, func(match string) string {
+		sub := r.pattern.FindStringSubmatch(match)
+		origin, boundary := sub[1], sub[2]
+
+		switch {
+		case strings.Contains(origin, `:\/\/`):
+			return r.toEscaped + boundary
+		case strings.Contains(origin, "://"):
+			return r.to + boundary
+		default:
+			// keep the character before the protocol-relative URL
+			prefix := origin[:strings.Index(origin, "//")]
+			return prefix + r.toNoProto + boundary
+		}
+	})
+}
+
+// RewriteURLs returns a hook which rewrites absolute URLs pointing to the
+// origins in HTML, CSS and JavaScript response bodies, e.g. so that a site
+// served under a different host keeps routing links through the proxy. The
+// rewriting is textual, so URLs in inline scripts are covered on a best-effort
+// basis. If match is non-nil, only responses for matching requests are
+// modified. Compressed bodies are not modified, see RemoveCompression.
+func RewriteURLs(match RequestMatcher, rewrites ...URLRewrite) (func(*proxy.Event) (*proxy.Response, error), error) {
+	var rewriters []urlRewriter
+	for _, rw := range rewrites {
+		r, err := newURLRewriter(rw)
+		if err != nil {
+			return nil, err
+		}
+		rewriters = append(rewriters, r)
+	}
+
+	return func(event *proxy.Event) (*proxy.Response, error) {
+		matched := match == nil || match(event.Req)
+
+		res, err := event.ForwardRequest()
+		if err != nil {
+			return nil, err
+		}
+
+		if !matched || event.ResponseSent() {
+			return res, nil
+		}
+
+		mediaType, _, _ := mime.ParseMediaType(res.Header.Get("Content-Type"))
+		if _, ok := rewriteContentTypes[mediaType]; !ok {
+			return res, nil
+		}
+
+		if enc := res.Header.Get("Content-Encoding"); enc != "" && enc != "identity" {
+			return res, nil
+		}
+
+		buf, err := res.RawBody()
+		if err != nil {
+			return nil, fmt.Errorf("reading body: %v", err)
+		}
+
+		body := string(buf)
+		for _, r := range rewriters {
+			body = r.rewrite(body)
+		}
+
+		res.SetBody([]byte(body))
+		res.ContentLength = int64(len(body))
+		if res.Header.Get("Content-Length") != "" {
+			res.Header.Set("Content-Length", strconv.Itoa(len(body)))
+		}
+
+		return res, nil
+	}, nil
+}
@@ -0,0 +1,121 @@
+package hooks
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/fd0/osmosis/proxy"
+)
+
+func TestURLRewriter(t *testing.T) {
+	r, err := newURLRewriter(URLRewrite{From: "https://example.com", To: "http://localhost:8080"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		body, want string
+	}{
+		{
+			`<a href="https://example.com/foo">x</a><img src='https://EXAMPLE.com'>`,
+			`<a href="http://localhost:8080/foo">x</a><img src='http://localhost:8080'>`,
+		},
+		{
+			`body { background: url(https://example.com/bg.png) }`,
+			`body { background: url(http://localhost:8080/bg.png) }`,
+		},
+		{
+			`var api = "https:\/\/example.com\/api"; fetch("//example.com/x")`,
+			`var api = "http:\/\/localhost:8080\/api"; fetch("//localhost:8080/x")`,
+		},
+		{
+			`<a href="https://example.com.evil.org/">`,
+			`<a href="https://example.com.evil.org/">`,
+		},
+		{
+			`<a href="http://example.com/">`,
+			`<a href="http://example.com/">`,
+		},
+	}
+
+	for _, test := range tests {
+		got := r.rewrite(test.body)
+		if got != test.want {
+			t.Errorf("wrong rewrite for %s\nwant: %s\n got: %s", test.body, test.want, got)
+		}
+	}
+}
+
+func TestRewriteURLs(t *testing.T) {
+	page := `<html><a href="https://target.example/login">login</a>` +
+		`<script src="https://target.example/app.js"></script>` +
+		`<a href="https://other.example/">other</a></html>`
+
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/data" {
+			rw.Header().Set("Content-Type", "application/json")
+			_, _ = io.WriteString(rw, `{"url": "https://target.example/"}`)
+			return
+		}
+		rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+		rw.Header().Set("Content-Length", strconv.Itoa(len(page)))
+		_, _ = io.WriteString(rw, page)
+	}))
+	defer srv.Close()
+
+	p, serve, shutdown := proxy.TestProxy(t, nil)
+	go serve()
+	defer shutdown()
+
+	hook, err := RewriteURLs(nil, URLRewrite{From: "https://target.example", To: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Register(hook)
+
+	client := testClient(t, p)
+
+	res, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = res.Body.Close()
+
+	want := `<html><a href="` + srv.URL + `/login">login</a>` +
+		`<script src="` + srv.URL + `/app.js"></script>` +
+		`<a href="https://other.example/">other</a></html>`
+	if string(body) != want {
+		t.Errorf("wrong body, want\n%s\ngot\n%s", want, body)
+	}
+	if res.ContentLength != int64(len(want)) {
+		t.Errorf("wrong Content-Length, want %d, got %d", len(want), res.ContentLength)
+	}
+
+	// other content types are not modified
+	res, err = client.Get(srv.URL + "/data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err = ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = res.Body.Close()
+
+	if string(body) != `{"url": "https://target.example/"}` {
+		t.Errorf("JSON body was modified: %s", body)
+	}
+
+	_, err = RewriteURLs(nil, URLRewrite{From: "example.com", To: srv.URL})
+	if err == nil {
+		t.Errorf("invalid origin was accepted")
+	}
+}
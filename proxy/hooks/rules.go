@@ -0,0 +1,143 @@
+package hooks
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+
+	"github.com/fd0/osmosis/proxy"
+)
+
+// ResponseMatcher reports whether a rule applies to a response, body is the
+// complete response body.
+type ResponseMatcher func(res *proxy.Response, body []byte) bool
+
+// MatchStatus returns a matcher for responses with the status code.
+func MatchStatus(code int) ResponseMatcher {
+	return func(res *proxy.Response, body []byte) bool {
+		return res.StatusCode == code
+	}
+}
+
+// MatchBody returns a matcher for responses with a body containing s.
+func MatchBody(s string) ResponseMatcher {
+	return func(res *proxy.Response, body []byte) bool {
+		return bytes.Contains(body, []byte(s))
+	}
+}
+
+// MatchAll returns a matcher for responses matched by all matchers.
+func MatchAll(matchers ...ResponseMatcher) ResponseMatcher {
+	return func(res *proxy.Response, body []byte) bool {
+		for _, match := range matchers {
+			if !match(res, body) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// RuleResult tells the rules hook how to continue after an action has run.
+type RuleResult int
+
+// These are the possible results of an action.
+const (
+	// Continue returns the response to the client, subsequent rules are
+	// evaluated.
+	Continue RuleResult = iota
+
+	// Retry sends the (possibly modified) request in the event again.
+	Retry
+)
+
+// Action is run for a response matched by a rule. It may modify the request
+// in event (e.g. to refresh credentials) and request a retry, or e.g. tag the
+// transaction or alert the user. The request body has been restored, so it can
+// be read again.
+type Action func(event *proxy.Event, res *proxy.Response) (RuleResult, error)
+
+// Rule combines a matcher with the action to run for matching responses.
+type Rule struct {
+	Name   string
+	Match  ResponseMatcher
+	Action Action
+}
+
+// ApplyRules returns a hook which evaluates the rules in order for each
+// response. If an action requests a retry, the request is sent again and the
+// rules are evaluated for the new response, at most maxRetries times. The
+// last response is returned to the client. Streamed responses are not
+// inspected.
+func ApplyRules(maxRetries int, rules ...Rule) func(*proxy.Event) (*proxy.Response, error) {
+	return func(event *proxy.Event) (*proxy.Response, error) {
+		// ForwardRequest is replaced by the hooks further down the pipeline,
+		// so keep the function for retries
+		forward := event.ForwardRequest
+
+		// keep the request body so that it can be sent again
+		var reqBody []byte
+		if event.Req.Body != nil && event.Req.Body != http.NoBody {
+			var err error
+			reqBody, err = event.RawRequestBody()
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		for attempt := 0; ; attempt++ {
+			res, err := forward()
+			if err != nil {
+				return nil, err
+			}
+
+			if event.ResponseSent() || attempt >= maxRetries {
+				return res, nil
+			}
+
+			body, err := res.RawBody()
+			if err != nil {
+				return nil, err
+			}
+
+			retry, err := applyRules(event, res, body, reqBody, rules)
+			if err != nil {
+				return nil, err
+			}
+
+			if !retry {
+				return res, nil
+			}
+
+			event.Log("retrying request (attempt %d of %d)", attempt+1, maxRetries)
+			_ = res.Body.Close()
+		}
+	}
+}
+
+// applyRules runs the actions of all rules matching the response and returns
+// true if one of them requested a retry.
+func applyRules(event *proxy.Event, res *proxy.Response, body, reqBody []byte, rules []Rule) (bool, error) {
+	for _, rule := range rules {
+		if !rule.Match(res, body) {
+			continue
+		}
+
+		event.Log("rule %q matched response %v", rule.Name, res.Status)
+
+		// restore the request body for the action and a retry
+		if reqBody != nil {
+			event.SetRequestBody(reqBody)
+		}
+
+		result, err := rule.Action(event, res)
+		if err != nil {
+			return false, fmt.Errorf("rule %q: %v", rule.Name, err)
+		}
+
+		if result == Retry {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,135 @@
+package hooks
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/fd0/osmosis/proxy"
+)
+
+func TestApplyRulesRetry(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		token    = "old"
+		requests int
+	)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if req.URL.Path == "/login" {
+			token = "new"
+			_, _ = rw.Write([]byte(token))
+			return
+		}
+
+		requests++
+
+		body, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			t.Error(err)
+		}
+		if string(body) != "payload" {
+			t.Errorf("wrong request body received: %q", body)
+		}
+
+		if req.Header.Get("Authorization") != "Bearer "+token || token != "new" {
+			rw.WriteHeader(http.StatusUnauthorized)
+			_, _ = rw.Write([]byte(`{"error":"expired"}`))
+			return
+		}
+		_, _ = rw.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	p, serve, shutdown := proxy.TestProxy(t, nil)
+	go serve()
+	defer shutdown()
+
+	reauth := Rule{
+		Name:  "reauth",
+		Match: MatchAll(MatchStatus(http.StatusUnauthorized), MatchBody(`{"error":"expired"}`)),
+		Action: func(event *proxy.Event, _ *proxy.Response) (RuleResult, error) {
+			res, err := http.Get(srv.URL + "/login")
+			if err != nil {
+				return Continue, err
+			}
+			defer res.Body.Close()
+
+			token, err := ioutil.ReadAll(res.Body)
+			if err != nil {
+				return Continue, err
+			}
+
+			event.Req.Header.Set("Authorization", "Bearer "+string(token))
+			return Retry, nil
+		},
+	}
+
+	p.Register(ApplyRules(3, reauth))
+
+	req, err := http.NewRequest(http.MethodPost, srv.URL+"/api", strings.NewReader("payload"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer old")
+
+	res, err := testClient(t, p).Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = res.Body.Close()
+
+	if res.StatusCode != http.StatusOK || string(body) != "ok" {
+		t.Errorf("unexpected response %v: %q", res.Status, body)
+	}
+
+	if requests != 2 {
+		t.Errorf("wrong number of requests, want 2, got %d", requests)
+	}
+}
+
+func TestApplyRulesMaxRetries(t *testing.T) {
+	var requests int
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		requests++
+		rw.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer srv.Close()
+
+	p, serve, shutdown := proxy.TestProxy(t, nil)
+	go serve()
+	defer shutdown()
+
+	p.Register(ApplyRules(2, Rule{
+		Name:  "always",
+		Match: MatchStatus(http.StatusUnauthorized),
+		Action: func(*proxy.Event, *proxy.Response) (RuleResult, error) {
+			return Retry, nil
+		},
+	}))
+
+	res, err := testClient(t, p).Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = res.Body.Close()
+
+	if res.StatusCode != http.StatusUnauthorized {
+		t.Errorf("unexpected status %v", res.Status)
+	}
+
+	if requests != 3 {
+		t.Errorf("wrong number of requests, want 3, got %d", requests)
+	}
+}
@@ -0,0 +1,87 @@
+package hooks
+
+import (
+	"github.com/fd0/osmosis/proxy"
+)
+
+// SecurityHeaderOptions selects which security related response headers the
+// SecurityHeaders hook removes or adds. The zero value changes nothing.
+type SecurityHeaderOptions struct {
+	StripHSTS          bool // Strict-Transport-Security
+	StripCSP           bool // Content-Security-Policy and the report-only variant
+	StripFrameOptions  bool // X-Frame-Options
+	StripCORS          bool // all Access-Control-* headers
+	StripContentSniff  bool // X-Content-Type-Options
+	StripXSSProtection bool // X-XSS-Protection
+
+	// Strip contains additional header names to remove.
+	Strip []string
+
+	// AllowAllOrigins sets permissive CORS headers on all responses.
+	AllowAllOrigins bool
+}
+
+// corsHeaders contains the CORS response headers.
+var corsHeaders = []string{
+	"Access-Control-Allow-Origin",
+	"Access-Control-Allow-Credentials",
+	"Access-Control-Allow-Methods",
+	"Access-Control-Allow-Headers",
+	"Access-Control-Expose-Headers",
+	"Access-Control-Max-Age",
+}
+
+// headers returns the names of the headers to remove.
+func (opts SecurityHeaderOptions) headers() []string {
+	var names []string
+	if opts.StripHSTS {
+		names = append(names, "Strict-Transport-Security")
+	}
+	if opts.StripCSP {
+		names = append(names, "Content-Security-Policy", "Content-Security-Policy-Report-Only")
+	}
+	if opts.StripFrameOptions {
+		names = append(names, "X-Frame-Options")
+	}
+	if opts.StripCORS {
+		names = append(names, corsHeaders...)
+	}
+	if opts.StripContentSniff {
+		names = append(names, "X-Content-Type-Options")
+	}
+	if opts.StripXSSProtection {
+		names = append(names, "X-XSS-Protection")
+	}
+	return append(names, opts.Strip...)
+}
+
+// SecurityHeaders returns a hook which removes security related headers from
+// the response and optionally injects permissive CORS headers, e.g. for
+// testing client behavior.
+func SecurityHeaders(opts SecurityHeaderOptions) func(*proxy.Event) (*proxy.Response, error) {
+	strip := opts.headers()
+
+	return func(event *proxy.Event) (*proxy.Response, error) {
+		res, err := event.ForwardRequest()
+		if err != nil {
+			return nil, err
+		}
+
+		if event.ResponseSent() {
+			event.Log("response already sent, security headers are not modified")
+			return res, nil
+		}
+
+		for _, name := range strip {
+			res.Header.Del(name)
+		}
+
+		if opts.AllowAllOrigins {
+			res.Header.Set("Access-Control-Allow-Origin", "*")
+			res.Header.Set("Access-Control-Allow-Methods", "*")
+			res.Header.Set("Access-Control-Allow-Headers", "*")
+		}
+
+		return res, nil
+	}
+}
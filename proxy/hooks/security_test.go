@@ -0,0 +1,70 @@
+package hooks
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/fd0/osmosis/proxy"
+)
+
+func TestSecurityHeaders(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Strict-Transport-Security", "max-age=31536000")
+		rw.Header().Set("X-Frame-Options", "DENY")
+		rw.Header().Set("X-Custom", "foo")
+		rw.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	var tests = []struct {
+		opts SecurityHeaderOptions
+		want map[string]string
+	}{
+		{
+			SecurityHeaderOptions{},
+			map[string]string{
+				"Strict-Transport-Security": "max-age=31536000",
+				"X-Frame-Options":           "DENY",
+			},
+		},
+		{
+			SecurityHeaderOptions{StripHSTS: true},
+			map[string]string{
+				"Strict-Transport-Security": "",
+				"X-Frame-Options":           "DENY",
+			},
+		},
+		{
+			SecurityHeaderOptions{StripFrameOptions: true, Strip: []string{"X-Custom"}, AllowAllOrigins: true},
+			map[string]string{
+				"Strict-Transport-Security":   "max-age=31536000",
+				"X-Frame-Options":             "",
+				"X-Custom":                    "",
+				"Access-Control-Allow-Origin": "*",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run("", func(t *testing.T) {
+			p, serve, shutdown := proxy.TestProxy(t, nil)
+			go serve()
+			defer shutdown()
+
+			p.Register(SecurityHeaders(test.opts))
+
+			res, err := testClient(t, p).Get(srv.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res.Body.Close()
+
+			for name, value := range test.want {
+				if res.Header.Get(name) != value {
+					t.Errorf("wrong value for header %v: want %q, got %q", name, value, res.Header.Get(name))
+				}
+			}
+		})
+	}
+}
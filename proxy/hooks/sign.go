@@ -0,0 +1,236 @@
+package hooks
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fd0/osmosis/proxy"
+)
+
+// Signer computes a signature over a request and adds it to the request,
+// e.g. as a header. The body is passed separately, the request's Body must
+// not be consumed.
+type Signer interface {
+	Sign(req *http.Request, body []byte) error
+}
+
+// SignRequest returns a hook which signs the request with signer before it is
+// forwarded. Since hooks run in the order they were registered, register it
+// after all hooks which modify the request so that the signature covers all
+// edits.
+func SignRequest(signer Signer) func(*proxy.Event) (*proxy.Response, error) {
+	return func(event *proxy.Event) (*proxy.Response, error) {
+		body, err := event.RawRequestBody()
+		if err != nil {
+			return nil, fmt.Errorf("reading body for signature: %v", err)
+		}
+
+		err = signer.Sign(event.Req, body)
+		if err != nil {
+			return nil, fmt.Errorf("signing request: %v", err)
+		}
+
+		return event.ForwardRequest()
+	}
+}
+
+// requestHost returns the host the request is sent to.
+func requestHost(req *http.Request) string {
+	if req.Host != "" {
+		return req.Host
+	}
+	return req.URL.Host
+}
+
+// HMACSigner signs requests with an HMAC and sets the hex-encoded signature
+// as the value of a header. The signed message is the canonical request:
+//
+//	METHOD "\n" REQUEST-URI "\n" HOST "\n" BODY
+type HMACSigner struct {
+	Key []byte
+
+	// Hash is used for the HMAC, defaults to SHA-256.
+	Hash func() hash.Hash
+
+	// Header receives the signature, prefixed with Prefix.
+	Header string
+	Prefix string
+}
+
+// CanonicalRequest returns the message which is signed for req.
+func (s HMACSigner) CanonicalRequest(req *http.Request, body []byte) []byte {
+	msg := fmt.Sprintf("%s\n%s\n%s\n", req.Method, req.URL.RequestURI(), requestHost(req))
+	return append([]byte(msg), body...)
+}
+
+// Signature returns the hex-encoded HMAC over the canonical request.
+func (s HMACSigner) Signature(req *http.Request, body []byte) string {
+	hashFunc := s.Hash
+	if hashFunc == nil {
+		hashFunc = sha256.New
+	}
+
+	mac := hmac.New(hashFunc, s.Key)
+	mac.Write(s.CanonicalRequest(req, body))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Sign sets the signature header for req.
+func (s HMACSigner) Sign(req *http.Request, body []byte) error {
+	if s.Header == "" {
+		return fmt.Errorf("no header name for the signature specified")
+	}
+
+	req.Header.Set(s.Header, s.Prefix+s.Signature(req, body))
+	return nil
+}
+
+// AWSSigner signs requests with AWS Signature Version 4 and sets the
+// Authorization header.
+type AWSSigner struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+
+	Region  string
+	Service string
+
+	// Now returns the signing time, defaults to time.Now.
+	Now func() time.Time
+}
+
+const (
+	awsAlgorithm  = "AWS4-HMAC-SHA256"
+	awsTimeFormat = "20060102T150405Z"
+	awsDateFormat = "20060102"
+)
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// awsEscape escapes s as described for the canonical query string.
+func awsEscape(s string) string {
+	return strings.Replace(url.QueryEscape(s), "+", "%20", -1)
+}
+
+// canonicalQuery returns the sorted and escaped query string.
+func canonicalQuery(u *url.URL) string {
+	query := u.Query()
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var parts []string
+	for _, key := range keys {
+		values := append([]string{}, query[key]...)
+		sort.Strings(values)
+		for _, value := range values {
+			parts = append(parts, awsEscape(key)+"="+awsEscape(value))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// canonicalHeaders returns the canonical header block and the list of signed
+// header names. The host, content-type and all x-amz-* headers are signed.
+func canonicalHeaders(req *http.Request) (headers, signed string) {
+	values := map[string]string{
+		"host": requestHost(req),
+	}
+	for name, vals := range req.Header {
+		name = strings.ToLower(name)
+		if name != "content-type" && !strings.HasPrefix(name, "x-amz-") {
+			continue
+		}
+
+		trimmed := make([]string, 0, len(vals))
+		for _, val := range vals {
+			trimmed = append(trimmed, strings.Join(strings.Fields(val), " "))
+		}
+		values[name] = strings.Join(trimmed, ",")
+	}
+
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var buf strings.Builder
+	for _, name := range names {
+		buf.WriteString(name + ":" + values[name] + "\n")
+	}
+
+	return buf.String(), strings.Join(names, ";")
+}
+
+// Sign sets the X-Amz-Date and Authorization headers for req.
+func (s AWSSigner) Sign(req *http.Request, body []byte) error {
+	now := time.Now
+	if s.Now != nil {
+		now = s.Now
+	}
+	t := now().UTC()
+
+	req.Header.Set("X-Amz-Date", t.Format(awsTimeFormat))
+	if s.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.SessionToken)
+	}
+
+	payloadHash := sha256Hex(body)
+	if req.Header.Get("X-Amz-Content-Sha256") != "" {
+		// update the hash if the client sent it (e.g. for S3)
+		req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	}
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+
+	headers, signedHeaders := canonicalHeaders(req)
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		path,
+		canonicalQuery(req.URL),
+		headers,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := strings.Join([]string{t.Format(awsDateFormat), s.Region, s.Service, "aws4_request"}, "/")
+	stringToSign := strings.Join([]string{
+		awsAlgorithm,
+		t.Format(awsTimeFormat),
+		scope,
+		sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.SecretAccessKey), t.Format(awsDateFormat))
+	key = hmacSHA256(key, s.Region)
+	key = hmacSHA256(key, s.Service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		awsAlgorithm, s.AccessKeyID, scope, signedHeaders, signature))
+	return nil
+}
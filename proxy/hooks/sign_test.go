@@ -0,0 +1,131 @@
+package hooks
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fd0/osmosis/proxy"
+)
+
+func testClient(t testing.TB, p *proxy.Proxy) *http.Client {
+	proxyURL, err := url.Parse("http://" + p.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certPool := x509.NewCertPool()
+	certPool.AddCert(p.CertificateAuthority.Certificate)
+
+	return &http.Client{
+		Transport: &http.Transport{
+			Proxy: func(*http.Request) (*url.URL, error) {
+				return proxyURL, nil
+			},
+			TLSClientConfig: &tls.Config{
+				RootCAs: certPool,
+			},
+		},
+	}
+}
+
+func TestSignRequestHMAC(t *testing.T) {
+	p, serve, shutdown := proxy.TestProxy(t, nil)
+	go serve()
+	defer shutdown()
+
+	signer := HMACSigner{
+		Key:    []byte("secret"),
+		Header: "X-Signature",
+		Prefix: "sha256=",
+	}
+
+	// the signer is registered last so that it runs after the body is edited
+	p.Register(func(event *proxy.Event) (*proxy.Response, error) {
+		event.SetRequestBody([]byte("edited"))
+		event.Req.ContentLength = int64(len("edited"))
+		return event.ForwardRequest()
+	}, SignRequest(signer))
+
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		body, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(body) != "edited" {
+			t.Errorf("body was not edited: %q", body)
+		}
+
+		want := "sha256=" + signer.Signature(req, body)
+		if req.Header.Get("X-Signature") != want {
+			http.Error(rw, "invalid signature", http.StatusForbidden)
+			return
+		}
+		rw.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	req, err := http.NewRequest(http.MethodPost, srv.URL+"/api?x=1", strings.NewReader("original"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("X-Signature", "sha256=invalid")
+
+	res, err := testClient(t, p).Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("server rejected the signature: %v", res.Status)
+	}
+}
+
+func TestAWSSigner(t *testing.T) {
+	// test vector "get-vanilla" from the AWS Signature Version 4 test suite
+	req, err := http.NewRequest(http.MethodGet, "https://example.amazonaws.com/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signer := AWSSigner{
+		AccessKeyID:     "AKIDEXAMPLE",
+		SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+		Region:          "us-east-1",
+		Service:         "service",
+		Now: func() time.Time {
+			return time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+		},
+	}
+
+	err = signer.Sign(req, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, " +
+		"SignedHeaders=host;x-amz-date, " +
+		"Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"
+
+	if got := req.Header.Get("Authorization"); got != want {
+		t.Errorf("wrong Authorization header:\nwant %v\ngot  %v", want, got)
+	}
+
+	// signing a different body must result in a different signature
+	err = signer.Sign(req, []byte("body"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if req.Header.Get("Authorization") == want {
+		t.Errorf("signature did not change for a different body")
+	}
+}
@@ -0,0 +1,170 @@
+package hooks
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/fd0/osmosis/proxy"
+	"github.com/fd0/osmosis/store"
+)
+
+// Record returns a hook which stores each transaction in the store selected
+// by router for the target host under a new ID allocated by the store, see
+// store.TxnStore.NextID. Transactions for hosts without a store are not
+// recorded. Responses which were streamed to the client are stored without
+// the body. The exact bytes of the request and the response are stored as
+// well if they were captured, see proxy.Proxy.CaptureRaw and
+// proxy.Proxy.CaptureRawResponses, and so is the client connection, see
+// store.ConnInfo. If recordErrors is set and the request cannot be forwarded,
+// the error is stored for the transaction, see store.TxnStore.SetError.
+//
+// Comparisons with a shadow upstream are stored with the transaction if
+// RecordShadow is used for proxy.Shadow.OnResult.
+//
+// If writers is not nil, requests and responses are queued in the writer for
+// the store instead of waiting for the database, the caller must flush the
+// writers before reading the transactions.
+func Record(router *store.Router, recordErrors bool, writers *store.Writers) func(*proxy.Event) (*proxy.Response, error) {
+	return func(event *proxy.Event) (*proxy.Response, error) {
+		s := router.Lookup(event.Req.URL.Hostname())
+		if s == nil {
+			return event.ForwardRequest()
+		}
+
+		var w txnWriter = s
+		if writers != nil {
+			if sw := writers.Get(s); sw != nil {
+				w = sw
+			}
+		}
+
+		id, err := s.NextID()
+		if err != nil {
+			return nil, err
+		}
+		event.Set(recordedKey, recorded{store: s, id: id})
+
+		body, err := event.RawRequestBody()
+		if err != nil {
+			return nil, err
+		}
+
+		// store a copy, writing the request consumes the body
+		req := event.Req.Clone(event.Req.Context())
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+		err = w.AddRequest(id, req, false)
+		if err != nil {
+			event.Log("recording request failed: %v", err)
+		}
+
+		err = s.AddConnInfo(id, connInfo(event))
+		if err != nil {
+			event.Log("recording connection info failed: %v", err)
+		}
+
+		if event.RawClientRequest != nil {
+			err = s.SetRawRequest(id, event.RawClientRequest)
+			if err != nil {
+				event.Log("recording raw request failed: %v", err)
+			}
+		}
+
+		res, err := event.ForwardRequest()
+		if err != nil {
+			if recordErrors {
+				serr := s.SetError(id, err.Error())
+				if serr != nil {
+					event.Log("recording error failed: %v", serr)
+				}
+			}
+			return nil, err
+		}
+
+		var resBody []byte
+		if !event.ResponseSent() {
+			resBody, err = res.RawBody()
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		err = w.AddResponse(id, res.Response, resBody, false)
+		if err != nil {
+			event.Log("recording response failed: %v", err)
+		}
+
+		if event.RawUpstreamResponse != nil {
+			err = s.SetRawResponse(id, event.RawUpstreamResponse)
+			if err != nil {
+				event.Log("recording raw response failed: %v", err)
+			}
+		}
+
+		return res, nil
+	}
+}
+
+// recordedKey is the key of the event value set by Record.
+const recordedKey = "hooks.recorded"
+
+// recorded describes where Record stored a transaction.
+type recorded struct {
+	store *store.TxnStore
+	id    uint64
+}
+
+// RecordShadow returns a function for proxy.Shadow.OnResult, which stores the
+// comparison with the shadow upstream with the transaction recorded by Record,
+// see store.TxnStore.SetShadowResult. Results for transactions which were not
+// recorded are ignored.
+func RecordShadow() func(*proxy.Event, *proxy.ShadowResult) {
+	return func(event *proxy.Event, result *proxy.ShadowResult) {
+		v, ok := event.Get(recordedKey)
+		if !ok {
+			return
+		}
+		rec := v.(recorded)
+
+		stored := store.ShadowResult{
+			Primary: result.Primary,
+			Shadow:  result.Shadow,
+			Diff:    result.Diff,
+		}
+		if result.Err != nil {
+			stored.Err = result.Err.Error()
+		}
+
+		err := rec.store.SetShadowResult(rec.id, stored)
+		if err != nil {
+			event.Log("recording shadow result failed: %v", err)
+		}
+	}
+}
+
+// txnWriter stores requests and responses, it is implemented by
+// store.TxnStore and store.Writer.
+type txnWriter interface {
+	AddRequest(id uint64, req *http.Request, edited bool) error
+	AddResponse(id uint64, res *http.Response, body []byte, edited bool) error
+}
+
+// connInfo describes the client connection of the event.
+func connInfo(event *proxy.Event) store.ConnInfo {
+	info := store.ConnInfo{
+		Proto:      event.ClientProto,
+		ViaCONNECT: event.ViaCONNECT,
+		ClientIP:   store.ClientIP(event.Req.RemoteAddr),
+	}
+	if state := event.ClientTLS; state != nil {
+		info.TLS = true
+		info.TLSVersion = state.Version
+		info.CipherSuite = state.CipherSuite
+		info.ServerName = state.ServerName
+		info.NegotiatedProtocol = state.NegotiatedProtocol
+	}
+	if event.ClientHello != nil {
+		info.JA3 = event.ClientHello.JA3Hash()
+	}
+	return info
+}
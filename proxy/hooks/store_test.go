@@ -0,0 +1,604 @@
+package hooks
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fd0/osmosis/proxy"
+	"github.com/fd0/osmosis/store"
+)
+
+func testStore(t testing.TB) (s *store.TxnStore, cleanup func()) {
+	dir, err := ioutil.TempDir(os.TempDir(), "osmosis.testing.hooks.")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = store.New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return s, func() {
+		_ = s.Close()
+		_ = os.RemoveAll(dir)
+	}
+}
+
+// newServerAt runs a test server listening on addr.
+func newServerAt(t testing.TB, addr string, handler http.Handler) *httptest.Server {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Skipf("unable to listen on %v: %v", addr, err)
+	}
+
+	srv := httptest.NewUnstartedServer(handler)
+	_ = srv.Listener.Close()
+	srv.Listener = listener
+	srv.Start()
+	return srv
+}
+
+func TestRecord(t *testing.T) {
+	t.Run("sync", func(t *testing.T) {
+		testRecord(t, nil)
+	})
+	t.Run("writers", func(t *testing.T) {
+		testRecord(t, &store.Writers{Workers: 2, QueueSize: 4})
+	})
+}
+
+func testRecord(t *testing.T, writers *store.Writers) {
+	handler := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		_, _ = io.WriteString(rw, "response from "+req.Host)
+	})
+	srvA := newServerAt(t, "127.0.0.1:0", handler)
+	defer srvA.Close()
+	srvB := newServerAt(t, "127.0.0.2:0", handler)
+	defer srvB.Close()
+
+	storeA, cleanupA := testStore(t)
+	defer cleanupA()
+	storeB, cleanupB := testStore(t)
+	defer cleanupB()
+
+	var router store.Router
+	router.Add("127.0.0.1", storeA)
+	router.Add("127.0.0.2", storeB)
+
+	p, serve, shutdown := proxy.TestProxy(t, nil)
+	go serve()
+	defer shutdown()
+
+	p.Register(Record(&router, false, writers))
+
+	client := testClient(t, p)
+	for _, url := range []string{srvA.URL + "/a", srvB.URL + "/b1", srvB.URL + "/b2"} {
+		res, err := client.Post(url, "text/plain", strings.NewReader("request body"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = ioutil.ReadAll(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = res.Body.Close()
+	}
+
+	if writers != nil {
+		err := writers.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var tests = []struct {
+		name  string
+		store *store.TxnStore
+		paths []string
+	}{
+		{"A", storeA, []string{"/a"}},
+		{"B", storeB, []string{"/b1", "/b2"}},
+	}
+
+	for _, test := range tests {
+		summaries, err := test.store.TxnSummaries()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var paths []string
+		for _, summary := range summaries {
+			paths = append(paths, summary.URL.Path)
+			if !summary.HasResponse || summary.StatusCode != http.StatusOK {
+				t.Errorf("store %v: response missing for %v", test.name, summary.URL)
+			}
+
+			req, err := test.store.GetRequest(summary.ID, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, err := ioutil.ReadAll(req.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(body) != "request body" {
+				t.Errorf("store %v: wrong request body %q", test.name, body)
+			}
+		}
+
+		if strings.Join(paths, " ") != strings.Join(test.paths, " ") {
+			t.Errorf("store %v: wrong transactions, want %v, got %v", test.name, test.paths, paths)
+		}
+	}
+}
+
+func TestRecordTunnel(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		_, _ = io.WriteString(rw, "response for "+req.URL.Path)
+	}))
+	defer srv.Close()
+
+	s, cleanup := testStore(t)
+	defer cleanup()
+
+	// a transaction from an earlier run must not be overwritten
+	old, err := http.NewRequest(http.MethodGet, "http://example.com/old", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.AddRequest(1, old, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, serve, shutdown := proxy.TestProxy(t, &tls.Config{InsecureSkipVerify: true})
+	go serve()
+	defer shutdown()
+
+	p.Register(Record(&store.Router{Default: s}, false, nil))
+
+	// both requests are sent over the same CONNECT tunnel
+	client := testClient(t, p)
+	for _, path := range []string{"/one", "/two"} {
+		res, err := client.Get(srv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = ioutil.ReadAll(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = res.Body.Close()
+	}
+
+	summaries, err := s.TxnSummaries()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var paths []string
+	for _, summary := range summaries {
+		paths = append(paths, summary.URL.Path)
+	}
+	if strings.Join(paths, " ") != "/old /one /two" {
+		t.Errorf("wrong transactions stored: %v", paths)
+	}
+
+	for _, summary := range summaries[1:] {
+		conn := summary.Conn
+		if conn == nil {
+			t.Fatalf("connection info for %v was not stored", summary.URL.Path)
+		}
+		if !conn.ViaCONNECT || !conn.TLS || conn.TLSVersion < tls.VersionTLS12 || conn.CipherSuite == 0 {
+			t.Errorf("wrong connection info stored for %v: %+v", summary.URL.Path, conn)
+		}
+	}
+}
+
+func TestRecordTunnelShutdown(t *testing.T) {
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		close(entered)
+		<-release
+		_, _ = io.WriteString(rw, "slow response")
+	}))
+	defer srv.Close()
+
+	var releaseOnce sync.Once
+	unblock := func() { releaseOnce.Do(func() { close(release) }) }
+	defer unblock()
+
+	s, cleanup := testStore(t)
+	defer cleanup()
+
+	p, serve, _ := proxy.TestProxy(t, &tls.Config{InsecureSkipVerify: true})
+	go serve()
+
+	p.Register(Record(&store.Router{Default: s}, false, nil))
+
+	client := testClient(t, p)
+	go func() {
+		res, err := client.Get(srv.URL + "/slow")
+		if err == nil {
+			_, _ = ioutil.ReadAll(res.Body)
+			_ = res.Body.Close()
+		}
+	}()
+	<-entered
+
+	// the request in the tunnel is still being processed
+	done := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		done <- p.Shutdown(ctx)
+	}()
+
+	select {
+	case err := <-done:
+		t.Fatalf("Shutdown returned before the request in the tunnel was done: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	unblock()
+	err := <-done
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the transaction was recorded before Shutdown returned
+	summaries, err := s.TxnSummaries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(summaries) != 1 || !summaries[0].HasResponse {
+		t.Fatalf("transaction was not recorded completely: %+v", summaries)
+	}
+	if summaries[0].URL.Path != "/slow" || summaries[0].StatusCode != http.StatusOK {
+		t.Errorf("wrong transaction recorded: %+v", summaries[0])
+	}
+}
+
+func TestRecordShadow(t *testing.T) {
+	primary := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		_, _ = io.WriteString(rw, "primary")
+	}))
+	defer primary.Close()
+
+	shadow := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusNotFound)
+		_, _ = io.WriteString(rw, "shadow")
+	}))
+	defer shadow.Close()
+
+	s, cleanup := testStore(t)
+	defer cleanup()
+
+	// the store ID differs from the event ID
+	old, err := http.NewRequest(http.MethodGet, "http://example.com/old", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.AddRequest(1, old, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, serve, shutdown := proxy.TestProxy(t, nil)
+	go serve()
+	defer shutdown()
+
+	p.Shadow = &proxy.Shadow{
+		Host:     strings.TrimPrefix(shadow.URL, "http://"),
+		OnResult: RecordShadow(),
+	}
+	p.Register(Record(&store.Router{Default: s}, false, nil))
+
+	res, err := testClient(t, p).Get(primary.URL + "/foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = ioutil.ReadAll(res.Body)
+	_ = res.Body.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err = p.Shadow.Wait(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := s.GetShadowResult(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Err != "" || len(result.Diff) == 0 {
+		t.Errorf("wrong result stored: %+v", result)
+	}
+	if !strings.HasSuffix(string(result.Primary), "primary") || !strings.HasSuffix(string(result.Shadow), "shadow") {
+		t.Errorf("wrong responses stored: %q, %q", result.Primary, result.Shadow)
+	}
+}
+
+func TestRecordRawResponse(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	raw := "HTTP/1.1 200 OK\r\n" +
+		"content-length: 4\r\n" +
+		"X-Custom:  spaced \r\n" +
+		"Date: Mon, 01 Jan 2024 00:00:00 GMT\r\n" +
+		"\r\n" +
+		"body"
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		_, err = http.ReadRequest(bufio.NewReader(conn))
+		if err != nil {
+			return
+		}
+		_, _ = io.WriteString(conn, raw)
+	}()
+
+	s, cleanup := testStore(t)
+	defer cleanup()
+
+	router := &store.Router{Default: s}
+
+	p, serve, shutdown := proxy.TestProxy(t, nil)
+	go serve()
+	defer shutdown()
+
+	p.CaptureRawResponses = true
+	p.Register(Record(router, false, nil))
+
+	client := testClient(t, p)
+	res, err := client.Get("http://" + listener.Addr().String() + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = res.Body.Close()
+
+	summaries, err := s.TxnSummaries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(summaries) != 1 {
+		t.Fatalf("wrong number of transactions stored: %v", len(summaries))
+	}
+
+	buf, err := s.GetRawResponse(summaries[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != raw {
+		t.Errorf("wrong raw response stored, want:\n%q\ngot:\n%q", raw, buf)
+	}
+}
+
+func TestRecordRawRequest(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		_, _ = io.WriteString(rw, "ok")
+	}))
+	defer srv.Close()
+
+	s, cleanup := testStore(t)
+	defer cleanup()
+
+	p, serve, shutdown := proxy.TestProxy(t, nil)
+	p.CaptureRaw = true
+	go serve()
+	defer shutdown()
+
+	p.Register(Record(&store.Router{Default: s}, false, nil))
+
+	conn, err := net.Dial("tcp", p.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	raw := "POST " + srv.URL + "/path HTTP/1.1\r\n" +
+		"Host: " + srv.Listener.Addr().String() + "\r\n" +
+		"x-custom:  spaced \r\n" +
+		"Content-Length: 4\r\n" +
+		"\r\n" +
+		"body"
+	_, err = io.WriteString(conn, raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = ioutil.ReadAll(res.Body)
+	_ = res.Body.Close()
+
+	summaries, err := s.TxnSummaries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(summaries) != 1 {
+		t.Fatalf("wrong number of transactions stored: %v", len(summaries))
+	}
+
+	buf, err := s.GetRawRequest(summaries[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != raw {
+		t.Errorf("wrong raw request stored, want:\n%q\ngot:\n%q", raw, buf)
+	}
+}
+
+func TestRecordErrors(t *testing.T) {
+	// nothing listens on the address after the listener is closed
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	target := "http://" + listener.Addr().String() + "/unreachable"
+	_ = listener.Close()
+
+	for _, recordErrors := range []bool{false, true} {
+		s, cleanup := testStore(t)
+		defer cleanup()
+
+		p, serve, shutdown := proxy.TestProxy(t, nil)
+		go serve()
+		defer shutdown()
+
+		p.Register(Record(&store.Router{Default: s}, recordErrors, nil))
+
+		res, err := testClient(t, p).Get(target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = res.Body.Close()
+		if res.StatusCode != http.StatusBadGateway {
+			t.Errorf("unexpected status %v", res.Status)
+		}
+
+		summaries, err := s.TxnSummaries()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(summaries) != 1 {
+			t.Fatalf("wrong number of transactions stored: %v", len(summaries))
+		}
+
+		summary := summaries[0]
+		if summary.HasResponse {
+			t.Errorf("response stored for failed request")
+		}
+		if summary.URL == nil || summary.URL.String() != target {
+			t.Errorf("wrong request stored: %v", summary.URL)
+		}
+		if recordErrors && !strings.Contains(summary.Error, "connection refused") {
+			t.Errorf("error not recorded, got %q", summary.Error)
+		}
+		if !recordErrors && summary.Error != "" {
+			t.Errorf("error recorded although disabled: %q", summary.Error)
+		}
+	}
+}
+
+func TestRecordClientIP(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		_, _ = io.WriteString(rw, "ok")
+	}))
+	defer srv.Close()
+
+	s, cleanup := testStore(t)
+	defer cleanup()
+
+	p, serve, shutdown := proxy.TestProxy(t, nil)
+	go serve()
+	defer shutdown()
+
+	p.Register(Record(&store.Router{Default: s}, false, nil))
+
+	res, err := testClient(t, p).Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = res.Body.Close()
+
+	summaries, err := s.TxnSummaries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(summaries) != 1 {
+		t.Fatalf("wrong number of transactions stored: %v", len(summaries))
+	}
+
+	conn := summaries[0].Conn
+	if conn == nil {
+		t.Fatal("connection info was not stored")
+	}
+	if conn.ClientIP != "127.0.0.1" || conn.Proto != "HTTP/1.1" || conn.ViaCONNECT || conn.TLS {
+		t.Errorf("wrong connection info stored: %+v", conn)
+	}
+
+	if n := len(store.FilterClientIP(summaries, "127.0.0.1")); n != 1 {
+		t.Errorf("filtering by the client IP returned %d transactions", n)
+	}
+	if n := len(store.FilterClientIP(summaries, "10.0.0.1")); n != 0 {
+		t.Errorf("filtering by another client IP returned %d transactions", n)
+	}
+}
+
+func TestRecordJA3(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		_, _ = io.WriteString(rw, "ok")
+	}))
+	defer srv.Close()
+
+	s, cleanup := testStore(t)
+	defer cleanup()
+
+	p, serve, shutdown := proxy.TestProxy(t, &tls.Config{InsecureSkipVerify: true})
+	go serve()
+	defer shutdown()
+
+	p.Register(Record(&store.Router{Default: s}, false, nil))
+
+	// clients offering different cipher suites have different fingerprints
+	for _, suite := range []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384} {
+		client := testClient(t, p)
+		cfg := client.Transport.(*http.Transport).TLSClientConfig
+		cfg.MaxVersion = tls.VersionTLS12
+		cfg.CipherSuites = []uint16{suite}
+
+		res, err := client.Get(srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = res.Body.Close()
+	}
+
+	summaries, err := s.TxnSummaries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(summaries) != 2 {
+		t.Fatalf("wrong number of transactions stored: %v", len(summaries))
+	}
+
+	var fingerprints []string
+	for _, summary := range summaries {
+		if summary.Conn == nil || len(summary.Conn.JA3) != 32 {
+			t.Fatalf("no JA3 fingerprint stored: %+v", summary.Conn)
+		}
+		fingerprints = append(fingerprints, summary.Conn.JA3)
+	}
+	if fingerprints[0] == fingerprints[1] {
+		t.Errorf("different clients have the same fingerprint %v", fingerprints[0])
+	}
+}
@@ -4,20 +4,47 @@ import (
 	"bytes"
 	"fmt"
 	"io/ioutil"
+	"strings"
 
 	"github.com/d5/tengo/script"
 	"github.com/d5/tengo/stdlib"
 	"github.com/fd0/osmosis/proxy"
 )
 
+// ScriptErrorPolicy selects what a script hook does when the script fails at
+// runtime or returns a request or response which cannot be parsed.
+type ScriptErrorPolicy int
+
+// These are the supported policies for script errors.
+const (
+	// ScriptErrorFail fails the transaction, the client receives an error.
+	ScriptErrorFail ScriptErrorPolicy = iota
+
+	// ScriptErrorContinue logs the error and continues with the original
+	// request or response, as if the script had not changed it.
+	ScriptErrorContinue
+)
+
+// ParseScriptErrorPolicy returns the policy for the name "fail" or
+// "continue".
+func ParseScriptErrorPolicy(name string) (ScriptErrorPolicy, error) {
+	switch strings.ToLower(name) {
+	case "fail":
+		return ScriptErrorFail, nil
+	case "continue":
+		return ScriptErrorContinue, nil
+	}
+	return 0, fmt.Errorf("unknown script error policy %q", name)
+}
+
 // CompileTengoPreHookFile is a CompileTengoPreHook wrapper that sets the script name and
 // code based on the given file name and file content.
-func CompileTengoPreHookFile(fileName string) (func(*proxy.Event) (*proxy.Response, error), error) {
+func CompileTengoPreHookFile(fileName string, onError ScriptErrorPolicy) (func(*proxy.Event) (*proxy.Response, error), error) {
 	rawScript, err := ioutil.ReadFile(fileName)
 	if err != nil {
 		return nil, fmt.Errorf("reading script `%s`: %v", fileName, err)
 	}
-	return CompileTengoPreHook(fileName, rawScript)
+	return CompileTengoPreHook(fileName, rawScript, onError)
 }
 
 // func HotReloadingTengoPreHook(fileName string) func(*proxy.Event) (*proxy.Response, error) {
@@ -50,13 +77,14 @@ func CompileTengoPreHookFile(fileName string) (func(*proxy.Event) (*proxy.Respon
 // CompileTengoPreHook compiles a Tengo script into a proxy hook that runs before a request is
 // forwarded. In the script, the raw request is available through the Bytes variable `request`.
 // If the script declares the Bytes variable `newRequest`, the original is replaced by the
-// parsed value of this variable.
-func CompileTengoPreHook(name string, rawScript []byte) (func(*proxy.Event) (*proxy.Response, error), error) {
+// parsed value of this variable. Runtime errors and requests which cannot be parsed are
+// handled according to onError.
+func CompileTengoPreHook(name string, rawScript []byte, onError ScriptErrorPolicy) (func(*proxy.Event) (*proxy.Response, error), error) {
 	compiledScript, err := prepareTengoPreScript(rawScript)
 	if err != nil {
 		return nil, fmt.Errorf("setting up pre-script `%s`: %v", name, err)
 	}
-	return tengoPreHook(name, compiledScript), nil
+	return tengoPreHook(name, compiledScript, onError), nil
 }
 
 func prepareTengoPreScript(code []byte) (*script.Compiled, error) {
@@ -75,8 +103,16 @@ func prepareTengoPreScript(code []byte) (*script.Compiled, error) {
 	return compiledScript, nil
 }
 
-func tengoPreHook(name string, scriptTemplate *script.Compiled) func(*proxy.Event) (*proxy.Response, error) {
+func tengoPreHook(name string, scriptTemplate *script.Compiled, onError ScriptErrorPolicy) func(*proxy.Event) (*proxy.Response, error) {
 	return func(event *proxy.Event) (*proxy.Response, error) {
+		scriptFailed := func(err error) (*proxy.Response, error) {
+			if onError == ScriptErrorContinue {
+				event.Log("%v, forwarding the original request", err)
+				return event.ForwardRequest()
+			}
+			return nil, err
+		}
+
 		if scriptTemplate == nil {
 			event.Log("pre-hook `%s` is no-op", name)
 			return event.ForwardRequest()
@@ -95,20 +131,20 @@ func tengoPreHook(name string, scriptTemplate *script.Compiled) func(*proxy.Even
 
 		err = scriptInstance.Run()
 		if err != nil {
-			return nil, fmt.Errorf("runtime error in pre-script `%s`: %v", name, err)
+			return scriptFailed(fmt.Errorf("runtime error in pre-script `%s`: %v", name, err))
 		}
 
 		if scriptInstance.IsDefined("request") {
 			newRawRequest := scriptInstance.Get("request").Bytes()
 			if newRawRequest == nil {
-				return nil, fmt.Errorf("pre-script `%s`: newRequest is not of type Bytes", name)
+				return scriptFailed(fmt.Errorf("pre-script `%s`: newRequest is not of type Bytes", name))
 			}
 
 			if !bytes.Equal(rawRequest, newRawRequest) {
 				err = event.SetRequest(newRawRequest)
 				if err != nil {
 					event.Log(string(newRawRequest))
-					return nil, fmt.Errorf("updating request after pre-script `%s`: %v", name, err)
+					return scriptFailed(fmt.Errorf("updating request after pre-script `%s`: %v", name, err))
 				}
 			}
 		} else {
@@ -121,24 +157,25 @@ func tengoPreHook(name string, scriptTemplate *script.Compiled) func(*proxy.Even
 
 // CompileTengoPostHookFile is a CompileTengoPostHook wrapper that sets the script name and
 // code based on the given file name and file content.
-func CompileTengoPostHookFile(fileName string) (func(*proxy.Event) (*proxy.Response, error), error) {
+func CompileTengoPostHookFile(fileName string, onError ScriptErrorPolicy) (func(*proxy.Event) (*proxy.Response, error), error) {
 	rawScript, err := ioutil.ReadFile(fileName)
 	if err != nil {
 		return nil, fmt.Errorf("reading script %s: %v", fileName, err)
 	}
-	return CompileTengoPostHook(fileName, rawScript)
+	return CompileTengoPostHook(fileName, rawScript, onError)
 }
 
 // CompileTengoPostHook compiles a Tengo script into a proxy hook that runs after the response
 // is received. In the script, the raw response as well as the request are available through
 // the Bytes variables `response` and `request`. If the script declares the Bytes variable
-// `newResponse`, the original is replaced by the parsed value of this variable.
-func CompileTengoPostHook(name string, rawScript []byte) (func(*proxy.Event) (*proxy.Response, error), error) {
+// `newResponse`, the original is replaced by the parsed value of this variable. Runtime
+// errors and responses which cannot be parsed are handled according to onError.
+func CompileTengoPostHook(name string, rawScript []byte, onError ScriptErrorPolicy) (func(*proxy.Event) (*proxy.Response, error), error) {
 	compiledScript, err := prepareTengoPostScript(rawScript)
 	if err != nil {
 		return nil, fmt.Errorf("setting up post-script `%s`: %v", name, err)
 	}
-	return tengoPostHook(name, compiledScript), nil
+	return tengoPostHook(name, compiledScript, onError), nil
 }
 
 func prepareTengoPostScript(code []byte) (*script.Compiled, error) {
@@ -161,18 +198,31 @@ func prepareTengoPostScript(code []byte) (*script.Compiled, error) {
 	return compiledScript, nil
 }
 
-func tengoPostHook(name string, scriptTemplate *script.Compiled) func(*proxy.Event) (*proxy.Response, error) {
+func tengoPostHook(name string, scriptTemplate *script.Compiled, onError ScriptErrorPolicy) func(*proxy.Event) (*proxy.Response, error) {
 	return func(event *proxy.Event) (*proxy.Response, error) {
 		response, err := event.ForwardRequest()
 		if err != nil {
 			return nil, err
 		}
 
+		scriptFailed := func(err error) (*proxy.Response, error) {
+			if onError == ScriptErrorContinue {
+				event.Log("%v, returning the original response", err)
+				return response, nil
+			}
+			return nil, err
+		}
+
 		if scriptTemplate == nil {
 			event.Log("post-hook `%s` is no-op", name)
 			return response, nil
 		}
 
+		if event.ResponseSent() {
+			// the response was streamed to the client, there's nothing to modify
+			return response, nil
+		}
+
 		scriptInstance := scriptTemplate.Clone()
 
 		rawRequest, err := event.RawRequest()
@@ -196,23 +246,23 @@ func tengoPostHook(name string, scriptTemplate *script.Compiled) func(*proxy.Eve
 
 		err = scriptInstance.Run()
 		if err != nil {
-			return nil, fmt.Errorf("runtime error in post-script `%s`: %v", name, err)
+			return scriptFailed(fmt.Errorf("runtime error in post-script `%s`: %v", name, err))
 		}
 
 		if !scriptInstance.IsDefined("response") {
-			return nil, fmt.Errorf("post-script `%s` response variable is not defined", name)
+			return scriptFailed(fmt.Errorf("post-script `%s` response variable is not defined", name))
 		}
 
 		newRawResponse := scriptInstance.Get("response").Bytes()
 		if newRawResponse == nil {
-			return nil, fmt.Errorf("post-script `%s`: newResponse is not of type Bytes", name)
+			return scriptFailed(fmt.Errorf("post-script `%s`: newResponse is not of type Bytes", name))
 		}
 
 		if !bytes.Equal(rawResponse, newRawResponse) {
 			err = response.Set(newRawResponse)
 			if err != nil {
 				event.Log(string(newRawResponse))
-				return nil, fmt.Errorf("updating response after post-script `%s`: %v", name, err)
+				return scriptFailed(fmt.Errorf("updating response after post-script `%s`: %v", name, err))
 			}
 		}
 
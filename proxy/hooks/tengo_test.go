@@ -0,0 +1,104 @@
+package hooks
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/fd0/osmosis/proxy"
+)
+
+func TestParseScriptErrorPolicy(t *testing.T) {
+	var tests = []struct {
+		name string
+		want ScriptErrorPolicy
+		err  bool
+	}{
+		{name: "fail", want: ScriptErrorFail},
+		{name: "Continue", want: ScriptErrorContinue},
+		{name: "ignore", err: true},
+	}
+
+	for _, test := range tests {
+		policy, err := ParseScriptErrorPolicy(test.name)
+		if test.err {
+			if err == nil {
+				t.Errorf("expected error for %q not found", test.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if policy != test.want {
+			t.Errorf("wrong policy for %q, want %v, got %v", test.name, test.want, policy)
+		}
+	}
+}
+
+func TestTengoHookScriptError(t *testing.T) {
+	var tests = []struct {
+		name    string
+		compile func(name string, rawScript []byte, onError ScriptErrorPolicy) (func(*proxy.Event) (*proxy.Response, error), error)
+		script  string
+	}{
+		{"pre-garbage", CompileTengoPreHook, `request = bytes("garbage")`},
+		{"pre-runtime", CompileTengoPreHook, `request = request + 1`},
+		{"post-garbage", CompileTengoPostHook, `response = bytes("garbage")`},
+		{"post-runtime", CompileTengoPostHook, `response = response + 1`},
+	}
+
+	for _, test := range tests {
+		for policyName, policy := range map[string]ScriptErrorPolicy{"fail": ScriptErrorFail, "continue": ScriptErrorContinue} {
+			t.Run(test.name+"-"+policyName, func(t *testing.T) {
+				received := make(chan string, 1)
+				srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+					received <- req.URL.RequestURI()
+					_, _ = io.WriteString(rw, "original")
+				}))
+				defer srv.Close()
+
+				p, serve, shutdown := proxy.TestProxy(t, nil)
+				go serve()
+				defer shutdown()
+
+				hook, err := test.compile(test.name, []byte(test.script), policy)
+				if err != nil {
+					t.Fatal(err)
+				}
+				p.Register(hook)
+
+				res, err := testClient(t, p).Get(srv.URL + "/path?x=1")
+				if err != nil {
+					t.Fatal(err)
+				}
+				body, err := ioutil.ReadAll(res.Body)
+				if err != nil {
+					t.Fatal(err)
+				}
+				_ = res.Body.Close()
+
+				if policy == ScriptErrorFail {
+					if res.StatusCode != http.StatusInternalServerError {
+						t.Errorf("want status 500, got %v", res.Status)
+					}
+					return
+				}
+
+				if res.StatusCode != http.StatusOK || string(body) != "original" {
+					t.Errorf("original response not returned, got %v %q", res.Status, body)
+				}
+				select {
+				case uri := <-received:
+					if uri != "/path?x=1" {
+						t.Errorf("wrong request forwarded: %v", uri)
+					}
+				default:
+					t.Errorf("original request was not forwarded")
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package proxy
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// hstsPreload is a small subset of the HSTS preload list shipped with
+// browsers, all entries include subdomains. It contains TLDs which are
+// preloaded as a whole and a few popular domains.
+var hstsPreload = []string{
+	// TLDs
+	"app", "bank", "boo", "channel", "dad", "day", "dev", "esq", "foo",
+	"gle", "how", "insurance", "ing", "meme", "mov", "new", "nexus", "page",
+	"phd", "prof", "rsvp", "soy", "zip",
+
+	// domains
+	"dropbox.com", "facebook.com", "github.com", "gmail.com", "google.com",
+	"paypal.com", "torproject.org", "twitter.com", "wikipedia.org",
+}
+
+// HSTSList keeps track of hosts which use HTTP Strict Transport Security
+// (HSTS). Browsers don't allow users to bypass certificate errors for these
+// hosts, so intercepting them only works if the CA is trusted.
+type HSTSList struct {
+	m sync.Mutex
+
+	// hosts maps host names to the includeSubDomains flag
+	hosts map[string]bool
+}
+
+// NewHSTSList returns a list containing the built-in preloaded hosts.
+func NewHSTSList() *HSTSList {
+	l := &HSTSList{hosts: make(map[string]bool)}
+	for _, host := range hstsPreload {
+		l.Add(host, true)
+	}
+	return l
+}
+
+// Add adds host to the list, includeSubdomains extends the entry to all
+// subdomains.
+func (l *HSTSList) Add(host string, includeSubdomains bool) {
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	if l.hosts == nil {
+		l.hosts = make(map[string]bool)
+	}
+
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	l.hosts[host] = l.hosts[host] || includeSubdomains
+}
+
+// Contains returns true if host (without port) uses HSTS.
+func (l *HSTSList) Contains(host string) bool {
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if _, ok := l.hosts[host]; ok {
+		return true
+	}
+
+	// check parent domains with includeSubDomains
+	for {
+		i := strings.IndexByte(host, '.')
+		if i < 0 {
+			return false
+		}
+		host = host[i+1:]
+
+		if l.hosts[host] {
+			return true
+		}
+	}
+}
+
+// Observe adds host to the list if the response res, received over HTTPS,
+// has a Strict-Transport-Security header with a non-zero max-age.
+func (l *HSTSList) Observe(host string, res *http.Response) {
+	header := res.Header.Get("Strict-Transport-Security")
+	if header == "" {
+		return
+	}
+
+	var maxAge, includeSubdomains bool
+	for _, directive := range strings.Split(header, ";") {
+		directive = strings.ToLower(strings.TrimSpace(directive))
+		switch {
+		case directive == "includesubdomains":
+			includeSubdomains = true
+		case strings.HasPrefix(directive, "max-age="):
+			value := strings.Trim(strings.TrimPrefix(directive, "max-age="), `"`)
+			maxAge = value != "0" && value != ""
+		}
+	}
+
+	if maxAge {
+		l.Add(host, includeSubdomains)
+	}
+}
+
+// tunnel connects the client to target and copies data in both directions
+// without intercepting it.
+func tunnel(event *Event, client net.Conn, target string) {
+	defer client.Close()
+
+	upstream, err := net.Dial("tcp", target)
+	if err != nil {
+		event.Log("connecting to %v failed: %v", target, err)
+		return
+	}
+	defer upstream.Close()
+
+	done := make(chan struct{})
+	go func() {
+		_, _ = io.Copy(upstream, client)
+		if c, ok := upstream.(*net.TCPConn); ok {
+			_ = c.CloseWrite()
+		}
+		close(done)
+	}()
+
+	_, _ = io.Copy(client, upstream)
+	_ = client.Close()
+	<-done
+}
@@ -0,0 +1,148 @@
+package proxy
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHSTSList(t *testing.T) {
+	l := NewHSTSList()
+
+	var tests = []struct {
+		host string
+		hsts bool
+	}{
+		{"google.com", true},
+		{"accounts.google.com", true},
+		{"WWW.GitHub.com.", true},
+		{"example.dev", true},
+		{"example.com", false},
+		{"notgoogle.com", false},
+		{"localhost", false},
+	}
+
+	for _, test := range tests {
+		if l.Contains(test.host) != test.hsts {
+			t.Errorf("wrong result for %v, want %v", test.host, test.hsts)
+		}
+	}
+
+	observe := func(host, header string) {
+		l.Observe(host, &http.Response{Header: http.Header{"Strict-Transport-Security": []string{header}}})
+	}
+
+	observe("example.com", "max-age=31536000")
+	observe("example.org", "max-age=31536000; includeSubDomains")
+	observe("example.net", "max-age=0; includeSubDomains")
+
+	tests = []struct {
+		host string
+		hsts bool
+	}{
+		{"example.com", true},
+		{"www.example.com", false},
+		{"example.org", true},
+		{"www.example.org", true},
+		{"example.net", false},
+	}
+
+	for _, test := range tests {
+		if l.Contains(test.host) != test.hsts {
+			t.Errorf("wrong result for %v after observing responses, want %v", test.host, test.hsts)
+		}
+	}
+}
+
+func TestProxyHSTSWarning(t *testing.T) {
+	proxy, serve, shutdown := TestProxy(t, nil)
+
+	var buf syncBuffer
+	proxy.logger = log.New(&buf, "", 0)
+
+	go serve()
+	defer shutdown()
+
+	for _, target := range []string{"example.com:443", "www.github.com:443"} {
+		status := sendConnect(t, proxy.Addr, target)
+		if status != http.StatusOK {
+			t.Fatalf("wrong status for CONNECT to %v: %v", target, status)
+		}
+	}
+
+	if !waitFor(2*time.Second, func() bool { return strings.Contains(buf.String(), "www.github.com uses HSTS") }) {
+		t.Fatalf("no warning logged for HSTS host:\n%s", buf.String())
+	}
+
+	if strings.Contains(buf.String(), "example.com uses HSTS") {
+		t.Errorf("warning logged for host without HSTS:\n%s", buf.String())
+	}
+}
+
+func TestProxyHSTSPassthrough(t *testing.T) {
+	// the upstream echoes all data back
+	listener := newLocalListener(t)
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				_, _ = io.Copy(conn, conn)
+				_ = conn.Close()
+			}()
+		}
+	}()
+
+	proxy, serve, shutdown := TestProxy(t, nil)
+	proxy.HSTS.Add("localhost", false)
+	proxy.HSTSPassthrough = true
+	go serve()
+	defer shutdown()
+
+	_, port, err := net.SplitHostPort(listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	target := net.JoinHostPort("localhost", port)
+
+	conn, err := net.Dial("tcp", proxy.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	_, err = fmt.Fprintf(conn, "CONNECT %v HTTP/1.1\r\nHost: %v\r\n\r\n", target, target)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rd := bufio.NewReader(conn)
+	res, err := http.ReadResponse(rd, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantStatus(t, res, http.StatusOK)
+
+	// the data is not intercepted, so any protocol works
+	_, err = io.WriteString(conn, "not a TLS handshake\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	line, err := rd.ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if line != "not a TLS handshake\n" {
+		t.Errorf("wrong data received through the tunnel: %q", line)
+	}
+}
@@ -0,0 +1,190 @@
+package proxy
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// LiveConn describes a client connection received through a CONNECT tunnel.
+type LiveConn struct {
+	// ID is the ID of the CONNECT request, Target the host it was sent for.
+	ID     uint64
+	Target string
+
+	Opened time.Time
+
+	// Closed is the time the client connection was closed, it is zero while
+	// the connection is open.
+	Closed time.Time
+
+	// ActiveRequests is the number of requests received on the connection
+	// which are still being processed.
+	ActiveRequests int
+}
+
+// Leaked returns true if requests are still being processed for more than
+// threshold after the connection was closed.
+func (c LiveConn) Leaked(threshold time.Duration) bool {
+	return !c.Closed.IsZero() && c.ActiveRequests > 0 && time.Since(c.Closed) > threshold
+}
+
+// trackedConn is a connection registered with a connTracker.
+type trackedConn struct {
+	t *connTracker
+
+	info     LiveConn
+	hijacked bool
+}
+
+// connTracker keeps track of the connections received through CONNECT
+// tunnels and the requests processed for them, in order to detect goroutines
+// which outlive their connection.
+type connTracker struct {
+	mu    sync.Mutex
+	conns map[*trackedConn]struct{}
+}
+
+// open registers a new connection.
+func (t *connTracker) open(id uint64, target string) *trackedConn {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.conns == nil {
+		t.conns = make(map[*trackedConn]struct{})
+	}
+
+	c := &trackedConn{
+		t:    t,
+		info: LiveConn{ID: id, Target: target, Opened: time.Now()},
+	}
+	t.conns[c] = struct{}{}
+	return c
+}
+
+// removeIfDone forgets c once it is closed and all requests have finished.
+// The caller must hold t.mu.
+func (t *connTracker) removeIfDone(c *trackedConn) {
+	if !c.info.Closed.IsZero() && c.info.ActiveRequests == 0 {
+		delete(t.conns, c)
+	}
+}
+
+// closed records that the client connection has been closed.
+func (c *trackedConn) closed() {
+	c.t.mu.Lock()
+	defer c.t.mu.Unlock()
+
+	if c.info.Closed.IsZero() {
+		c.info.Closed = time.Now()
+	}
+	c.t.removeIfDone(c)
+}
+
+// begin records the start of a request on the connection, the returned
+// function must be called when the request is done. The connection is
+// considered closed when ctx is cancelled before, which happens when the
+// client goes away. If the connection was hijacked (e.g. for a websocket)
+// during the request, it is considered closed afterwards.
+func (c *trackedConn) begin(ctx context.Context) (done func()) {
+	c.t.mu.Lock()
+	c.info.ActiveRequests++
+	c.t.mu.Unlock()
+
+	finished := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			// the context is also cancelled after the handler returned
+			select {
+			case <-finished:
+				return
+			default:
+			}
+			c.closed()
+		case <-finished:
+		}
+	}()
+
+	return func() {
+		close(finished)
+
+		c.t.mu.Lock()
+		defer c.t.mu.Unlock()
+
+		c.info.ActiveRequests--
+		if c.hijacked && c.info.Closed.IsZero() {
+			c.info.Closed = time.Now()
+		}
+		c.t.removeIfDone(c)
+	}
+}
+
+// connState is used as the http.Server's ConnState callback.
+func (c *trackedConn) connState(_ net.Conn, state http.ConnState) {
+	switch state {
+	case http.StateHijacked:
+		c.t.mu.Lock()
+		c.hijacked = true
+		c.t.mu.Unlock()
+	case http.StateClosed:
+		c.closed()
+	}
+}
+
+// list returns the tracked connections ordered by ID.
+func (t *connTracker) list() []LiveConn {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	list := make([]LiveConn, 0, len(t.conns))
+	for c := range t.conns {
+		list = append(list, c.info)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list
+}
+
+// LiveConns returns the client connections received through CONNECT tunnels
+// which are still open, or which have been closed while requests are still
+// being processed.
+func (p *Proxy) LiveConns() []LiveConn {
+	return p.conns.list()
+}
+
+// watchLeaks logs connections for which requests are still processed more
+// than threshold after the connection was closed. Each connection is only
+// reported once. It runs until done is closed.
+func (p *Proxy) watchLeaks(threshold time.Duration, done <-chan struct{}) {
+	ticker := time.NewTicker(threshold / 2)
+	defer ticker.Stop()
+
+	reported := make(map[uint64]bool)
+	for {
+		select {
+		case <-ticker.C:
+		case <-done:
+			return
+		}
+
+		seen := make(map[uint64]bool)
+		for _, c := range p.LiveConns() {
+			seen[c.ID] = true
+			if c.Leaked(threshold) && !reported[c.ID] {
+				p.logger.Printf("[%4d] %d request(s) for %v still running %v after the connection was closed",
+					c.ID, c.ActiveRequests, c.Target, time.Since(c.Closed).Round(time.Millisecond))
+				reported[c.ID] = true
+			}
+		}
+
+		// forget connections which are gone
+		for id := range reported {
+			if !seen[id] {
+				delete(reported, id)
+			}
+		}
+	}
+}
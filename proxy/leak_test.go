@@ -0,0 +1,274 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// waitFor polls cond until it returns true or the timeout expires.
+func waitFor(timeout time.Duration, cond func() bool) bool {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return cond()
+}
+
+func TestProxyConnectNoGoroutineLeak(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		_, _ = rw.Write([]byte("secure"))
+	}))
+	defer srv.Close()
+
+	proxy, serve, shutdown := TestProxy(t, &tls.Config{InsecureSkipVerify: true})
+	go serve()
+	defer shutdown()
+
+	// let the proxy settle before taking the baseline
+	client := testClient(t, proxy.Addr, proxy.CertificateAuthority)
+	before := runtime.NumGoroutine()
+
+	for i := 0; i < 10; i++ {
+		// use a new connection for each request
+		client.Transport.(*http.Transport).DisableKeepAlives = true
+
+		res, err := client.Get(srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wantStatus(t, res, http.StatusOK)
+		wantBody(t, res, "secure")
+	}
+
+	client.CloseIdleConnections()
+	proxy.transport.CloseIdleConnections()
+
+	if !waitFor(5*time.Second, func() bool { return len(proxy.LiveConns()) == 0 }) {
+		t.Errorf("connections are still tracked: %+v", proxy.LiveConns())
+	}
+
+	// allow for a few goroutines started lazily by the runtime or the
+	// HTTP client, but not one per connection
+	var after int
+	ok := waitFor(5*time.Second, func() bool {
+		proxy.transport.CloseIdleConnections()
+		after = runtime.NumGoroutine()
+		return after <= before+2
+	})
+	if !ok {
+		buf := make([]byte, 1<<20)
+		n := runtime.Stack(buf, true)
+		t.Errorf("goroutines leaked: %d before, %d after\n%s", before, after, buf[:n])
+	}
+}
+
+func TestProxyLiveConns(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {}))
+	defer srv.Close()
+
+	proxy, serve, shutdown := TestProxy(t, &tls.Config{InsecureSkipVerify: true})
+	go serve()
+	defer shutdown()
+
+	// the hook is stuck until it is released, even after the client is gone
+	started := make(chan struct{})
+	release := make(chan struct{})
+	proxy.Register(func(event *Event) (*Response, error) {
+		close(started)
+		<-release
+		return event.ForwardRequest()
+	})
+
+	client := testClient(t, proxy.Addr, proxy.CertificateAuthority)
+	client.Timeout = 200 * time.Millisecond
+
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := client.Get(srv.URL)
+		errCh <- err
+	}()
+
+	<-started
+	if err := <-errCh; err == nil {
+		t.Fatal("request did not time out")
+	}
+
+	// the client has given up and closed the connection
+	var conns []LiveConn
+	ok := waitFor(5*time.Second, func() bool {
+		conns = proxy.LiveConns()
+		return len(conns) == 1 && !conns[0].Closed.IsZero()
+	})
+	if !ok {
+		close(release)
+		t.Fatalf("closed connection not reported: %+v", conns)
+	}
+
+	if conns[0].ActiveRequests != 1 || !conns[0].Leaked(0) || conns[0].Leaked(time.Hour) {
+		t.Errorf("wrong state for stuck request: %+v", conns[0])
+	}
+
+	close(release)
+
+	if !waitFor(5*time.Second, func() bool { return len(proxy.LiveConns()) == 0 }) {
+		t.Errorf("connection is still tracked after the request finished: %+v", proxy.LiveConns())
+	}
+}
+
+func TestProxyConnectAbortNoGoroutineLeak(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		_, _ = rw.Write([]byte("secure"))
+	}))
+	defer srv.Close()
+
+	proxy, serve, shutdown := TestProxy(t, &tls.Config{InsecureSkipVerify: true})
+	proxy.Cache.NoClone = true
+	go serve()
+	defer shutdown()
+
+	// connect returns a connection to the proxy on which the CONNECT request
+	// has succeeded
+	connect := func() net.Conn {
+		conn, err := net.Dial("tcp", proxy.Addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = fmt.Fprintf(conn, "CONNECT %v HTTP/1.1\r\nHost: %v\r\n\r\n", srv.Listener.Addr(), srv.Listener.Addr())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// read the response byte by byte, the TLS data must not be buffered
+		var buf []byte
+		b := make([]byte, 1)
+		for !bytes.HasSuffix(buf, []byte("\r\n\r\n")) {
+			_, err = conn.Read(b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			buf = append(buf, b[0])
+		}
+		if !bytes.HasPrefix(buf, []byte("HTTP/1.0 200")) {
+			t.Fatalf("CONNECT failed: %q", buf)
+		}
+		return conn
+	}
+
+	// handshake runs the TLS handshake within the tunnel
+	handshake := func(conn net.Conn) *tls.Conn {
+		tlsConn := tls.Client(conn, &tls.Config{InsecureSkipVerify: true})
+		err := tlsConn.Handshake()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tlsConn
+	}
+
+	var tests = []struct {
+		name  string
+		abort func(net.Conn)
+	}{
+		{"before TLS", func(conn net.Conn) {}},
+		{"partial ClientHello", func(conn net.Conn) {
+			_, _ = conn.Write([]byte{0x16, 0x03, 0x01, 0x02, 0x00, 0x01})
+		}},
+		{"after handshake", func(conn net.Conn) {
+			handshake(conn)
+		}},
+		{"partial request", func(conn net.Conn) {
+			_, _ = io.WriteString(handshake(conn), "GET / HTTP/1.1\r\nHost: ")
+		}},
+		{"after request", func(conn net.Conn) {
+			tlsConn := handshake(conn)
+			_, _ = io.WriteString(tlsConn, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+			_, _ = http.ReadResponse(bufio.NewReader(tlsConn), nil)
+		}},
+	}
+
+	// run all cases once so that the pools of the proxy are set up
+	for _, test := range tests {
+		conn := connect()
+		test.abort(conn)
+		_ = conn.Close()
+	}
+
+	waitFor(5*time.Second, func() bool { return len(proxy.LiveConns()) == 0 })
+	proxy.transport.CloseIdleConnections()
+	time.Sleep(50 * time.Millisecond)
+	before := runtime.NumGoroutine()
+
+	for _, test := range tests {
+		for i := 0; i < 5; i++ {
+			conn := connect()
+			test.abort(conn)
+			_ = conn.Close()
+		}
+	}
+
+	if !waitFor(5*time.Second, func() bool { return len(proxy.LiveConns()) == 0 }) {
+		t.Errorf("connections are still tracked: %+v", proxy.LiveConns())
+	}
+
+	var after int
+	ok := waitFor(5*time.Second, func() bool {
+		proxy.transport.CloseIdleConnections()
+		after = runtime.NumGoroutine()
+		return after <= before+2
+	})
+	if !ok {
+		buf := make([]byte, 1<<20)
+		n := runtime.Stack(buf, true)
+		t.Errorf("goroutines leaked: %d before, %d after\n%s", before, after, buf[:n])
+	}
+}
+
+func TestProxyConnectHandshakeTimeout(t *testing.T) {
+	defer func(d time.Duration) { tlsHandshakeTimeout = d }(tlsHandshakeTimeout)
+	tlsHandshakeTimeout = 100 * time.Millisecond
+
+	proxy, serve, shutdown := TestProxy(t, nil)
+	go serve()
+	defer shutdown()
+
+	conn, err := net.Dial("tcp", proxy.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	rd := bufio.NewReader(conn)
+	_, err = io.WriteString(conn, "CONNECT example.com:443 HTTP/1.1\r\nHost: example.com:443\r\n\r\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := http.ReadResponse(rd, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantStatus(t, res, http.StatusOK)
+
+	// start a TLS record, then stall
+	_, err = conn.Write([]byte{0x16, 0x03, 0x01, 0x02, 0x00})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = rd.ReadByte()
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		t.Fatal("proxy did not close the connection of the stalled client")
+	}
+}
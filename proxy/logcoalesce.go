@@ -0,0 +1,59 @@
+package proxy
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// coalescingLog writes messages to a logger and summarizes repetitions: the
+// first occurrence of a message is logged right away, identical messages
+// within the window afterwards are only counted and logged as a single line
+// when the window ends.
+type coalescingLog struct {
+	log    *log.Logger
+	window time.Duration
+
+	m        sync.Mutex
+	repeated map[string]int
+}
+
+// newCoalescingLog returns a log which summarizes messages repeated within
+// window.
+func newCoalescingLog(logger *log.Logger, window time.Duration) *coalescingLog {
+	return &coalescingLog{
+		log:      logger,
+		window:   window,
+		repeated: make(map[string]int),
+	}
+}
+
+// Printf logs the message unless it was logged within the window.
+func (l *coalescingLog) Printf(format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	if n, ok := l.repeated[msg]; ok {
+		l.repeated[msg] = n + 1
+		return
+	}
+
+	l.repeated[msg] = 0
+	l.log.Print(msg)
+	time.AfterFunc(l.window, func() { l.flush(msg) })
+}
+
+// flush ends the window for msg and logs the number of repetitions.
+func (l *coalescingLog) flush(msg string) {
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	n := l.repeated[msg]
+	delete(l.repeated, msg)
+	if n > 0 {
+		l.log.Printf("%s (repeated %d times)", msg, n)
+	}
+}
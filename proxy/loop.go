@@ -0,0 +1,130 @@
+package proxy
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// newViaPseudonym returns a random name for this proxy instance, which is
+// added to the Via header of forwarded requests.
+func newViaPseudonym() string {
+	buf := make([]byte, 4)
+	_, err := rand.Read(buf)
+	if err != nil {
+		panic(err)
+	}
+	return "osmosis-" + hex.EncodeToString(buf)
+}
+
+// addVia adds this proxy to the Via header of req, proto is the protocol
+// version the request was received with.
+func (p *Proxy) addVia(req *http.Request, major, minor int) {
+	req.Header.Add("Via", fmt.Sprintf("%d.%d %s", major, minor, p.via))
+}
+
+// seenBefore returns true if the Via header of req lists this proxy.
+func (p *Proxy) seenBefore(req *http.Request) bool {
+	for _, value := range req.Header["Via"] {
+		for _, entry := range strings.Split(value, ",") {
+			fields := strings.Fields(entry)
+			if len(fields) >= 2 && fields[1] == p.via {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// addListenAddr records the address of a listener the proxy serves on.
+func (p *Proxy) addListenAddr(addr net.Addr) {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return
+	}
+
+	p.listenMu.Lock()
+	p.listenAddrs = append(p.listenAddrs, tcpAddr)
+	p.listenMu.Unlock()
+}
+
+// isLocalIP returns true if ip is assigned to one of the local interfaces.
+func isLocalIP(ip net.IP) bool {
+	if ip.IsLoopback() {
+		return true
+	}
+
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return false
+	}
+
+	for _, addr := range addrs {
+		if ipnet, ok := addr.(*net.IPNet); ok && ipnet.IP.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// targetsSelf returns true if u points to one of the addresses the proxy
+// listens on. Host names are only resolved if the port matches.
+func (p *Proxy) targetsSelf(ctx context.Context, u *url.URL) bool {
+	port := u.Port()
+	if port == "" {
+		port = "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+	}
+
+	portnum, err := strconv.Atoi(port)
+	if err != nil {
+		return false
+	}
+
+	p.listenMu.Lock()
+	var candidates []*net.TCPAddr
+	for _, addr := range p.listenAddrs {
+		if addr.Port == portnum {
+			candidates = append(candidates, addr)
+		}
+	}
+	p.listenMu.Unlock()
+
+	if len(candidates) == 0 {
+		return false
+	}
+
+	var ips []net.IP
+	if ip := net.ParseIP(u.Hostname()); ip != nil {
+		ips = append(ips, ip)
+	} else {
+		addrs, err := net.DefaultResolver.LookupIPAddr(ctx, u.Hostname())
+		if err != nil {
+			return false
+		}
+		for _, addr := range addrs {
+			ips = append(ips, addr.IP)
+		}
+	}
+
+	for _, addr := range candidates {
+		for _, ip := range ips {
+			if addr.IP.IsUnspecified() && isLocalIP(ip) {
+				return true
+			}
+			if addr.IP.Equal(ip) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
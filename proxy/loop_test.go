@@ -0,0 +1,84 @@
+package proxy
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestProxyLoopSelfTarget(t *testing.T) {
+	proxy, serve, shutdown := TestProxy(t, nil)
+	go serve()
+	defer shutdown()
+
+	var requests int
+	proxy.Register(func(event *Event) (*Response, error) {
+		requests++
+		return event.ForwardRequest()
+	})
+
+	client := testClient(t, proxy.Addr, proxy.CertificateAuthority)
+	res, err := client.Get("http://" + proxy.Addr + "/foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantStatus(t, res, http.StatusLoopDetected)
+
+	buf, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = res.Body.Close()
+
+	if !strings.Contains(string(buf), "is the proxy itself") {
+		t.Errorf("error message is not descriptive: %q", buf)
+	}
+
+	if requests != 0 {
+		t.Errorf("request to the proxy itself was forwarded %d times", requests)
+	}
+}
+
+func TestProxyLoopVia(t *testing.T) {
+	proxy, serve, shutdown := TestProxy(t, nil)
+	go serve()
+	defer shutdown()
+
+	var via []string
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		via = req.Header["Via"]
+	}))
+	defer srv.Close()
+
+	client := testClient(t, proxy.Addr, proxy.CertificateAuthority)
+
+	req, err := http.NewRequest(http.MethodGet, srv.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Via", "1.0 other-proxy")
+
+	res, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantStatus(t, res, http.StatusOK)
+	_ = res.Body.Close()
+
+	want := []string{"1.0 other-proxy", "1.1 " + proxy.via}
+	if strings.Join(via, "|") != strings.Join(want, "|") {
+		t.Fatalf("wrong Via header received, want %q, got %q", want, via)
+	}
+
+	// send the request again as if it looped back to the proxy
+	req.Header.Set("Via", strings.Join(via, ", "))
+	res, err = client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantStatus(t, res, http.StatusLoopDetected)
+	_ = res.Body.Close()
+}
@@ -1,20 +1,31 @@
 package proxy
 
 import (
+	"bytes"
 	"context"
 	"crypto/tls"
+	"crypto/x509"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
+	"mime"
 	"net"
 	"net/http"
+	"net/http/httptrace"
+	"net/textproto"
+	"net/url"
+	"path"
+	"strconv"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/fd0/osmosis/certauth"
 	"golang.org/x/net/context/ctxhttp"
 	"golang.org/x/net/http2"
+	"golang.org/x/sync/errgroup"
 )
 
 // Proxy allows intercepting and modifying requests.
@@ -27,6 +38,9 @@ type Proxy struct {
 	client       *http.Client
 	clientConfig *tls.Config
 
+	// transport is the default transport of client
+	transport *http.Transport
+
 	logger *log.Logger
 
 	*certauth.CertificateAuthority
@@ -34,6 +48,144 @@ type Proxy struct {
 	Addr string
 
 	roundTripPipeline EventHook
+
+	// hooks lists the functions registered with Register in order
+	hooks []EventHook
+
+	// namedHooks lists the hooks registered with RegisterNamed
+	namedHooks []*namedHook
+	hooksMu    sync.Mutex
+
+	// via is the pseudonym of this proxy used in the Via header, listenAddrs
+	// are the addresses the proxy serves on, both are used to detect loops.
+	via         string
+	listenMu    sync.Mutex
+	listenAddrs []*net.TCPAddr
+
+	// hostConfigs contains TLS client configurations for individual hosts
+	hostConfigs  map[string]*tls.Config
+	hostConfigMu sync.Mutex
+
+	// cipherProfile is the name of the profile set with SetCipherProfile,
+	// also protected by hostConfigMu
+	cipherProfile       string
+	cipherProfileConfig CipherProfile
+
+	// cfg contains the settings read for every request, see UpdateConfig
+	cfg      *Config
+	configMu sync.RWMutex
+
+	// schemeOverrides contains the scheme to use for individual hosts
+	schemeOverrides map[string]string
+	schemeMu        sync.Mutex
+
+	// StreamUnknownLength enables streaming all responses without a known
+	// content length directly to the client, like server-sent events.
+	StreamUnknownLength bool
+
+	// Shadow, if set, sends all requests to a second upstream as well and
+	// records the differences of the responses.
+	Shadow *Shadow
+
+	// MaxRequestBodySize limits the size of request bodies passed through the
+	// hooks, which may buffer the body in memory. Larger requests are
+	// rejected, or if StreamLargeRequests is set, forwarded to the upstream
+	// server without running the hooks. Zero means no limit.
+	MaxRequestBodySize  int64
+	StreamLargeRequests bool
+
+	// MaxHeaderCount and MaxHeaderSize limit the number of header fields
+	// (each value counts as a field) and their total size in bytes for
+	// requests received from clients, zero disables a limit. Requests
+	// exceeding a limit are rejected with 431 (Request Header Fields Too
+	// Large), or if TrimLargeHeaders is set, the fields exceeding the limits
+	// are removed and the request is forwarded.
+	MaxHeaderCount   int
+	MaxHeaderSize    int
+	TrimLargeHeaders bool
+
+	// AdminHandler, if set, serves all requests for the host "proxy" except
+	// for the CA certificate, e.g. a web interface.
+	AdminHandler http.Handler
+
+	// WebsocketReconnect configures reconnecting dropped upstream websocket
+	// connections, it is disabled by default.
+	WebsocketReconnect WebsocketReconnect
+
+	// WebsocketLog selects how much websocket activity is logged.
+	WebsocketLog WebsocketLogLevel
+
+	// WebsocketFeed, if set, receives the messages of all websocket
+	// connections, e.g. for a live view.
+	WebsocketFeed *WebsocketFeed
+
+	// MaxWebsockets limits the number of concurrent websocket connections,
+	// further upgrade requests are rejected with 503 Service Unavailable.
+	// Zero means no limit.
+	MaxWebsockets int
+
+	// AccessLog, if set, receives a line for each transaction when the
+	// response has been sent to the client.
+	AccessLog *AccessLog
+
+	// TranscriptDir, if set, receives a file for each CONNECT tunnel with
+	// the exact (decrypted) bytes exchanged with the client in both
+	// directions. The transcripts contain secrets like cookies, so they are
+	// only readable by the user. Tunnels use HTTP/1.1 if this is enabled.
+	TranscriptDir string
+
+	// CaptureRaw records the exact bytes of each HTTP/1.x request received
+	// from a client in Event.RawClientRequest, before the request is parsed
+	// and normalized. Request bodies are read into memory. Requests with
+	// bodies larger than MaxRequestBodySize, gRPC calls and requests received
+	// while capturing is paused are not recorded, and neither is anything
+	// after them on the same connection. Tunnels use HTTP/1.1 if this is
+	// enabled. It must be set before Serve is called.
+	CaptureRaw bool
+
+	// CaptureRawResponses records the exact bytes of each response received
+	// from the upstream server in Event.RawUpstreamResponse. Requests are
+	// sent over a new HTTP/1.1 connection each, bypassing the HTTP client
+	// and the upstream proxy, and response bodies are read into memory.
+	CaptureRawResponses bool
+
+	// HSTS contains the hosts using HTTP Strict Transport Security, it is
+	// populated with a built-in list and updated from responses. A warning
+	// is logged for CONNECT requests to these hosts, with HSTSPassthrough
+	// they are tunneled without interception instead. Set HSTS to nil to
+	// disable this.
+	HSTS            *HSTSList
+	HSTSPassthrough bool
+
+	// StaleOnError keeps the last response for each request signature (see
+	// RequestSignature) and serves it to the client, marked with a Warning
+	// header, when forwarding an equivalent request fails. Requests forwarded
+	// without running the hooks and requests with a body of unknown length
+	// or larger than 1 MiB are not covered, their body is not buffered.
+	StaleOnError bool
+	stale        staleCache
+
+	// ForwardEarlyHints sends 103 (Early Hints) responses received from the
+	// upstream server on to the client before the final response. Other
+	// informational responses are only logged.
+	ForwardEarlyHints bool
+
+	// paused is set (to 1) while capturing is paused
+	paused int32
+
+	// conns tracks the connections received through CONNECT tunnels
+	conns    connTracker
+	leakOnce sync.Once
+
+	// tunnels contains the servers for the requests in CONNECT tunnels
+	tunnels tunnelServers
+
+	// LeakThreshold enables logging requests which are still processed
+	// longer than the threshold after their client connection was closed,
+	// e.g. goroutines stuck in a hook. See also LiveConns.
+	LeakThreshold time.Duration
+
+	counters *counters
 }
 
 // EventHook is a wrapper around ForwardRequest that is derived
@@ -81,6 +233,10 @@ func New(address string, ca *certauth.CertificateAuthority, clientConfig *tls.Co
 		CertificateAuthority: ca,
 		Cache:                NewCache(ca, clientConfig, logger),
 		Addr:                 address,
+		via:                  newViaPseudonym(),
+		counters:             &counters{},
+		HSTS:                 NewHSTSList(),
+		cfg:                  &Config{},
 	}
 
 	// TLS server configuration
@@ -95,15 +251,74 @@ func New(address string, ca *certauth.CertificateAuthority, clientConfig *tls.Co
 		Addr:     address,
 		ErrorLog: proxy.logger,
 		Handler:  proxy,
+
+		ConnContext: connContext,
 	}
 
 	// initialize HTTP client to use
 	proxy.client = newHTTPClient(true, clientConfig)
+	proxy.transport = proxy.client.Transport.(*http.Transport)
+	// TLS connections are established by dialTLS, so that host
+	// configurations and the cipher profile can be changed at runtime
+	proxy.transport.DialTLSContext = proxy.dialTLS
 	proxy.clientConfig = clientConfig
+	proxy.Cache.hostConfig = proxy.hostClientConfig
 
 	return proxy
 }
 
+// SetUpstreamProxy configures the proxy to send requests through the HTTP
+// proxy at u. If u is nil, the proxy is taken from the environment, which is
+// the default.
+func (p *Proxy) SetUpstreamProxy(u *url.URL) {
+	tr := p.transport
+	if u == nil {
+		tr.Proxy = http.ProxyFromEnvironment
+		return
+	}
+	tr.Proxy = http.ProxyURL(u)
+}
+
+// SetUpstreamTransport replaces the RoundTripper used to send requests to the
+// upstream servers, e.g. for experimenting with HTTP/3. Redirects are still
+// passed on to the client. The settings of SetUpstreamProxy, SetRootCAs,
+// SetHostClientConfig and SetUpstreamDialer only apply to the default
+// transport, which is restored if rt is nil.
+func (p *Proxy) SetUpstreamTransport(rt http.RoundTripper) {
+	if rt == nil {
+		rt = p.transport
+	}
+	p.client.Transport = rt
+}
+
+// SetUpstreamDialer sets the function the default transport uses to connect
+// to upstream servers (and an upstream proxy), e.g. for a custom network
+// stack. TLS is established on top of the returned connection.
+func (p *Proxy) SetUpstreamDialer(dial func(ctx context.Context, network, addr string) (net.Conn, error)) {
+	p.transport.DialContext = dial
+}
+
+// SetRootCAs configures the proxy to verify upstream servers (for requests,
+// websockets and when cloning certificates) with the certificates in pool.
+func (p *Proxy) SetRootCAs(pool *x509.CertPool) {
+	cfg := &tls.Config{}
+	if p.clientConfig != nil {
+		cfg = p.clientConfig.Clone()
+	}
+	cfg.RootCAs = pool
+	p.clientConfig = cfg
+	p.Cache.clientConfig = cfg
+
+	// the transport's config has been extended for HTTP2, so modify a copy of it
+	tr := p.transport
+	trConfig := &tls.Config{}
+	if tr.TLSClientConfig != nil {
+		trConfig = tr.TLSClientConfig.Clone()
+	}
+	trConfig.RootCAs = pool
+	tr.TLSClientConfig = trConfig
+}
+
 // Log exposes the proxy's logger to the user
 func (p *Proxy) Log(msg string, args ...interface{}) {
 	p.logger.Printf(msg, args...)
@@ -143,26 +358,260 @@ func copyHeader(dst, src, trailer http.Header) {
 	}
 }
 
+// setStaticResponseHeaders sets Config.StaticResponseHeaders on res.
+func (p *Proxy) setStaticResponseHeaders(res *http.Response) {
+	headers := p.config().StaticResponseHeaders
+	if len(headers) == 0 {
+		return
+	}
+
+	if res.Header == nil {
+		res.Header = make(http.Header)
+	}
+	for name, values := range headers {
+		res.Header[textproto.CanonicalMIMEHeaderKey(name)] = append([]string(nil), values...)
+	}
+}
+
+// PauseCapture stops running the hooks (and thereby recording) and logging
+// requests, traffic is still forwarded transparently until ResumeCapture is
+// called. While paused, requests are also left out of the access log, the
+// websocket feed, transcripts, raw captures (see CaptureRaw and
+// CaptureRawResponses) and shadow requests. CONNECT tunnels established
+// while paused are not transcribed or captured at all.
+func (p *Proxy) PauseCapture() {
+	atomic.StoreInt32(&p.paused, 1)
+}
+
+// ResumeCapture resumes running the hooks and logging for new requests.
+func (p *Proxy) ResumeCapture() {
+	atomic.StoreInt32(&p.paused, 0)
+}
+
+// CapturePaused returns true if capturing is paused.
+func (p *Proxy) CapturePaused() bool {
+	return atomic.LoadInt32(&p.paused) == 1
+}
+
 // ServeProxyRequest is called for each request the proxy receives.
 func (p *Proxy) ServeProxyRequest(event *Event) {
+	paused := p.CapturePaused()
+	event.paused = paused
+	if paused {
+		event.Logger = log.New(ioutil.Discard, "", 0)
+	}
+
+	if p.AccessLog != nil && !paused {
+		entry := newAccessLogEntry(event, time.Now())
+		defer func() {
+			entry.status = event.status
+			entry.bytes = event.BytesSent() + event.errorBytes
+			err := p.AccessLog.write(entry, time.Now())
+			if err != nil {
+				p.logger.Printf("writing access log failed: %v", err)
+			}
+		}()
+	}
+
+	atomic.AddUint64(&p.counters.requests, 1)
+	atomic.AddInt64(&p.counters.active, 1)
+	defer func() {
+		atomic.AddUint64(&p.counters.bytesOut, uint64(event.BytesSent()))
+		atomic.AddInt64(&p.counters.active, -1)
+	}()
+
+	event.UpstreamCipherProfile = p.CipherProfile()
+
+	if !p.checkHeaderLimits(event) {
+		return
+	}
+
+	cfg := p.config()
+	if host := targetHostname(event, cfg.DefaultHost); !inScope(cfg.Scope, host) {
+		event.sendBlocked(cfg.Block, "request to %v is out of scope", host)
+		return
+	}
+
 	// handle websockets
 	if isWebsocketHandshake(event.Req) {
-		HandleUpgradeRequest(event, p.clientConfig)
+		if !p.acquireWebsocket() {
+			event.SendErrorStatus(http.StatusServiceUnavailable, "rejecting websocket upgrade, limit of %d concurrent connections reached", p.MaxWebsockets)
+			return
+		}
+		defer p.releaseWebsocket()
+
+		host := event.Req.URL.Hostname()
+		if event.ForceHost != "" {
+			host = strings.Split(event.ForceHost, ":")[0]
+		}
+		feed := p.WebsocketFeed
+		if paused {
+			feed = nil
+		}
+		HandleUpgradeRequest(event, p.clientConfigFor(host), p.WebsocketReconnect, p.WebsocketLog, feed)
+		return
+	}
+
+	if p.seenBefore(event.Req) {
+		event.SendErrorStatus(http.StatusLoopDetected, "request loop detected: request was already forwarded by this proxy (%v)", p.via)
+		return
+	}
+
+	major, minor := event.Req.ProtoMajor, event.Req.ProtoMinor
+
+	err := p.resolveTarget(event)
+	if err != nil {
+		atomic.AddUint64(&p.counters.errors, 1)
+		event.SendErrorStatus(http.StatusBadRequest, "%v", err)
 		return
 	}
 
-	err := event.prepareRequest()
+	err = event.prepareRequest(cfg.StaticRequestHeaders)
 	if err != nil {
+		atomic.AddUint64(&p.counters.errors, 1)
 		event.SendError("error preparing requests: %v", err)
 		return
 	}
+	p.countRequestBody(event.Req)
+
+	fixResponseURLs := p.applySchemeOverride(event)
+
+	if p.targetsSelf(event.Req.Context(), event.Req.URL) {
+		event.SendErrorStatus(http.StatusLoopDetected, "request loop detected: target %v is the proxy itself", event.Req.URL.Host)
+		return
+	}
+
+	p.addVia(event.Req, major, minor)
+
+	// gRPC calls may stream the request body, so don't try to read it
+	var oversized bool
+	if !isGRPC(event.Req) {
+		oversized, err = p.requestTooLarge(event.Req)
+		if err != nil {
+			event.SendError("error reading request body: %v", err)
+			return
+		}
+	}
 
-	response, err := p.ForwardThroughPipeline(event)
+	var response *http.Response
+	switch {
+	case oversized && !p.StreamLargeRequests:
+		event.SendErrorStatus(http.StatusRequestEntityTooLarge, "request body exceeds the limit of %d bytes", p.MaxRequestBodySize)
+		return
+	case oversized:
+		event.Log("request body exceeds %d bytes, forwarding it without running the hooks", p.MaxRequestBodySize)
+		response, err = p.forwardWithoutHooks(event)
+	case isGRPC(event.Req):
+		event.Log("passing gRPC call through without running the hooks")
+		response, err = p.forwardWithoutHooks(event)
+	case paused:
+		response, err = p.forwardWithoutHooks(event)
+	default:
+		response, err = p.ForwardThroughPipeline(event)
+	}
 	if err != nil {
-		event.SendError("error executing request: %v", err)
+		atomic.AddUint64(&p.counters.errors, 1)
+		if event.ResponseSent() {
+			// the response has been streamed to the client already, it's too
+			// late for an error response
+			event.Log("error executing request after the response was sent: %v", err)
+			return
+		}
+		event.SendErrorStatus(errorStatus(err), "error executing request: %v", err)
+		return
+	}
+
+	if event.ResponseSent() {
+		// the response has been streamed to the client already
 		return
 	}
 
+	if fixResponseURLs != nil {
+		fixResponseURLs(response)
+	}
+
+	if p.HSTS != nil && event.Req.URL.Scheme == "https" {
+		p.HSTS.Observe(event.Req.URL.Hostname(), response)
+	}
+
+	p.setStaticResponseHeaders(response)
+	err = writeResponse(event, response)
+	if err != nil {
+		atomic.AddUint64(&p.counters.errors, 1)
+		event.Log("%v", err)
+	}
+}
+
+// resolveTarget makes sure the request has a target host. Requests in
+// origin-form (e.g. "GET /path") are sent to the host from the Host header or
+// to Config.DefaultHost, ErrNoTarget is returned if neither is available.
+func (p *Proxy) resolveTarget(event *Event) error {
+	if event.ForceHost != "" || event.Req.URL.Host != "" {
+		return nil
+	}
+
+	host := event.Req.Host
+	if host == "" {
+		host = p.config().DefaultHost
+	}
+	if host == "" {
+		return ErrNoTarget
+	}
+
+	event.Log("request has no absolute URL, sending it to %v", host)
+	event.Req.URL.Scheme = "http"
+	event.Req.URL.Host = host
+	if event.Req.Host == "" {
+		event.Req.Host = host
+	}
+	return nil
+}
+
+// requestTooLarge returns true if the body of req exceeds MaxRequestBodySize.
+// For bodies of unknown length, up to MaxRequestBodySize+1 bytes are read and
+// put back in front of the body.
+func (p *Proxy) requestTooLarge(req *http.Request) (bool, error) {
+	if p.MaxRequestBodySize <= 0 || req.Body == nil || req.Body == http.NoBody {
+		return false, nil
+	}
+
+	if req.ContentLength >= 0 {
+		return req.ContentLength > p.MaxRequestBodySize, nil
+	}
+
+	buf, err := ioutil.ReadAll(io.LimitReader(req.Body, p.MaxRequestBodySize+1))
+	if err != nil {
+		return false, err
+	}
+
+	req.Body = bufferedReadCloser{
+		Reader: io.MultiReader(bytes.NewReader(buf), req.Body),
+		Closer: req.Body,
+	}
+
+	return int64(len(buf)) > p.MaxRequestBodySize, nil
+}
+
+// flushWriter calls Flush on the underlying ResponseWriter after each write.
+type flushWriter struct {
+	w http.ResponseWriter
+	f http.Flusher
+}
+
+func (fw flushWriter) Write(p []byte) (int, error) {
+	n, err := fw.w.Write(p)
+	fw.f.Flush()
+	return n, err
+}
+
+// writeResponse sends the response including the body and trailers to the
+// client. If supported by the ResponseWriter, the body is flushed to the
+// client after each chunk so that slowly produced bodies arrive promptly. The
+// body is always closed, the number of body bytes sent to the client is
+// recorded in the event, also if copying fails (e.g. the client is gone).
+func writeResponse(event *Event, response *http.Response) error {
+	defer response.Body.Close()
+
 	copyHeader(event.ResponseWriter.Header(), response.Header, response.Trailer)
 	if len(response.Trailer) > 0 {
 		event.Log("trailer detected, announcing: %v", response.Trailer)
@@ -176,17 +625,24 @@ func (p *Proxy) ServeProxyRequest(event *Event) {
 	}
 
 	event.ResponseWriter.WriteHeader(response.StatusCode)
+	event.status = response.StatusCode
+
+	var wr io.Writer = event.ResponseWriter
+	if flusher, ok := event.ResponseWriter.(http.Flusher); ok {
+		// send the header right away
+		flusher.Flush()
+		wr = flushWriter{w: event.ResponseWriter, f: flusher}
+	}
 
-	_, err = io.Copy(event.ResponseWriter, response.Body)
+	n, err := io.Copy(wr, response.Body)
+	event.bytesSent = n
 	if err != nil {
-		event.Log("error copying body: %v", err)
-		return
+		return fmt.Errorf("error copying body after %d bytes: %v", n, err)
 	}
 
 	err = response.Body.Close()
 	if err != nil {
-		event.Log("error closing body: %v", err)
-		return
+		return fmt.Errorf("error closing body: %v", err)
 	}
 
 	// send the trailer values
@@ -195,18 +651,206 @@ func (p *Proxy) ServeProxyRequest(event *Event) {
 			event.ResponseWriter.Header().Add(name, value)
 		}
 	}
+
+	return nil
+}
+
+// streamContentTypes contains the media types of responses which are
+// potentially never finished, they are streamed to the client directly.
+var streamContentTypes = map[string]struct{}{
+	"text/event-stream": struct{}{},
+}
+
+// isGRPCMediaType returns true if mediaType is used for gRPC messages, e.g.
+// "application/grpc" or "application/grpc+proto".
+func isGRPCMediaType(mediaType string) bool {
+	return mediaType == "application/grpc" || strings.HasPrefix(mediaType, "application/grpc+")
+}
+
+// isGRPC returns true if req is a gRPC call.
+func isGRPC(req *http.Request) bool {
+	mediaType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	return isGRPCMediaType(mediaType)
+}
+
+// isPassthrough returns true if the response should be streamed to the client
+// without passing the body through the hooks.
+func (p *Proxy) isPassthrough(res *http.Response) bool {
+	mediaType, _, _ := mime.ParseMediaType(res.Header.Get("Content-Type"))
+	if _, ok := streamContentTypes[mediaType]; ok {
+		return true
+	}
+
+	// gRPC responses are streamed and end with trailers
+	if isGRPCMediaType(mediaType) {
+		return true
+	}
+
+	cfg := p.config()
+	if matchMediaType(cfg.BufferContentTypes, mediaType) {
+		return false
+	}
+
+	if matchMediaType(cfg.PassthroughContentTypes, mediaType) {
+		return true
+	}
+
+	return p.StreamUnknownLength && res.ContentLength < 0
+}
+
+// matchMediaType returns true if mediaType matches one of the patterns.
+func matchMediaType(patterns []string, mediaType string) bool {
+	for _, pattern := range patterns {
+		if match, _ := path.Match(pattern, mediaType); match {
+			return true
+		}
+	}
+	return false
 }
 
 // ForwardRequest performs the given request using the proxy's http client.
-// This function is also the core of the roundtrip pipeline.
+// This function is also the core of the roundtrip pipeline. Streaming
+// responses (e.g. server-sent events) and responses matching
+// Config.PassthroughContentTypes are sent to the client right away, the
+// returned Response has an empty body in this case.
 func (p *Proxy) ForwardRequest(event *Event) (*Response, error) {
-	httpResponse, err := ctxhttp.Do(event.Req.Context(), p.client, event.Req)
+	var shadow <-chan shadowResponse
+	var err error
+	if p.Shadow != nil && !event.paused {
+		shadow, err = p.Shadow.start(event.Req.Context(), p.client, event)
+		if err != nil {
+			return nil, err
+		}
+
+		// don't leak the shadow response if the request fails
+		defer func() {
+			if shadow != nil {
+				p.Shadow.discard(shadow)
+			}
+		}()
+	}
+
+	var signature string
+	stale := p.StaleOnError && !event.withoutHooks &&
+		event.Req.ContentLength >= 0 && event.Req.ContentLength <= staleMaxBodySize
+	if stale {
+		body, err := readWithoutClose(&event.Req.Body)
+		if err != nil {
+			return nil, err
+		}
+		signature = RequestSignature(event.Req, body)
+	}
+
+	var httpResponse *http.Response
+	var rawResponse *bytes.Buffer
+	if p.CaptureRawResponses && !event.paused {
+		rawResponse = &bytes.Buffer{}
+	}
+	if event.RawUpstream != nil || rawResponse != nil {
+		if event.RawUpstream != nil {
+			event.Log("sending %d raw bytes to %v", len(event.RawUpstream), event.Req.URL.Host)
+		}
+		httpResponse, err = p.forwardRaw(event, rawResponse)
+	} else {
+		ctx := httptrace.WithClientTrace(event.Req.Context(), &httptrace.ClientTrace{
+			Got1xxResponse: func(code int, header textproto.MIMEHeader) error {
+				p.informationalResponse(event, code, http.Header(header))
+				return nil
+			},
+		})
+		httpResponse, err = ctxhttp.Do(ctx, p.client, event.Req.WithContext(ctx))
+	}
 	if err != nil {
+		err = upstreamError(err)
+		if stale {
+			if res := p.stale.get(signature, event.Req); res != nil {
+				event.Log("forwarding failed (%v), serving stale response", err)
+				return &Response{res}, nil
+			}
+		}
 		return nil, err
 	}
+
+	if rawResponse != nil && !p.isPassthrough(httpResponse) {
+		// the bytes are complete when the body has been read
+		_, err = readWithoutClose(&httpResponse.Body)
+		if err != nil {
+			return nil, err
+		}
+		event.RawUpstreamResponse = rawResponse.Bytes()
+	}
+
+	if shadow != nil {
+		ch := shadow
+		shadow = nil
+		httpResponse, err = p.Shadow.finish(event, httpResponse, ch, p.isPassthrough)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if p.isPassthrough(httpResponse) {
+		event.Log("passing response (%v) through to the client", httpResponse.Header.Get("Content-Type"))
+		event.responseSent = true
+
+		p.setStaticResponseHeaders(httpResponse)
+		err = writeResponse(event, httpResponse)
+		if err != nil {
+			atomic.AddUint64(&p.counters.errors, 1)
+			event.Log("streaming response: %v", err)
+		}
+
+		httpResponse.Body = http.NoBody
+	} else if stale {
+		err = p.stale.put(signature, httpResponse)
+		if err != nil {
+			return nil, fmt.Errorf("recording response for StaleOnError: %v", err)
+		}
+	}
+
 	return &Response{httpResponse}, nil
 }
 
+// informationalResponse is called for each 1xx response received from the
+// upstream server before the final response.
+func (p *Proxy) informationalResponse(event *Event, code int, header http.Header) {
+	event.Log("received informational response %d %v: %v", code, http.StatusText(code), header)
+
+	if code != http.StatusEarlyHints || !p.ForwardEarlyHints || event.ResponseSent() {
+		return
+	}
+
+	// the header map is reused for the final response, so remove the hints
+	// again afterwards
+	rwHeader := event.ResponseWriter.Header()
+	var added []string
+	for name, values := range header {
+		if _, ok := rwHeader[name]; !ok {
+			added = append(added, name)
+		}
+		for _, value := range values {
+			rwHeader.Add(name, value)
+		}
+	}
+
+	event.ResponseWriter.WriteHeader(code)
+
+	for _, name := range added {
+		rwHeader.Del(name)
+	}
+}
+
+// forwardWithoutHooks sends the request to the upstream server, bypassing the
+// roundtrip pipeline.
+func (p *Proxy) forwardWithoutHooks(event *Event) (*http.Response, error) {
+	event.withoutHooks = true
+	res, err := p.ForwardRequest(event)
+	if err != nil {
+		return nil, err
+	}
+	return res.Response, nil
+}
+
 // ForwardThroughPipeline executes the round trip pipeline and handles the case where
 // no pipeline function has been registred using the bare ForwardRequest function as
 // a default.
@@ -221,38 +865,48 @@ func (p *Proxy) ForwardThroughPipeline(event *Event) (*http.Response, error) {
 	return response.Response, nil
 }
 
-// Register registers the given function in the proxy roundtrip pipeline
+// Register appends the functions to the proxy roundtrip pipeline. Hooks run
+// in the order they were registered: the first hook registered receives the
+// request first and the response last. Each hook passes the request on to the
+// next one by calling event.ForwardRequest, for the last hook registered this
+// sends the request to the upstream server.
 func (p *Proxy) Register(funcs ...func(*Event) (*Response, error)) {
-	// the core of the pipeline (i.e. the innermost function) is ForwardRequest
-	// all registered functions are wrapping layers around this initial value of
-	// the roundTripPipeline
-	if p.roundTripPipeline == nil {
-		p.roundTripPipeline = p.ForwardRequest
+	for _, f := range funcs {
+		p.hooks = append(p.hooks, f)
 	}
 
-	for _, f := range funcs {
-		// the anonymous function scope is used to create copies of the state
-		// of f and p.roundTripPipeline in this loop iteration
-		func(pipelineCopy func(*Event) (*Response, error),
-			funcCopy func(*Event) (*Response, error)) {
-			// now the function f will be wrapped around the current pipeline
-			p.roundTripPipeline = func(e *Event) (*Response, error) {
-				e.ForwardRequest = func() (*Response, error) {
-					return pipelineCopy(e)
-				}
-				response, err := funcCopy(e)
-				if err != nil {
-					return nil, err
-				}
-				return response, nil
-			}
-		}(p.roundTripPipeline, f)
+	// the core of the pipeline (i.e. the innermost function) is
+	// ForwardRequest, the hooks are wrapped around it starting with the one
+	// registered last
+	pipeline := EventHook(p.ForwardRequest)
+	for i := len(p.hooks) - 1; i >= 0; i-- {
+		pipeline = wrapHook(p.hooks[i], pipeline)
+	}
+	p.roundTripPipeline = pipeline
+}
 
+// wrapHook returns a pipeline which calls f with next as the event's
+// ForwardRequest function.
+func wrapHook(f, next EventHook) EventHook {
+	return func(e *Event) (*Response, error) {
+		e.ForwardRequest = func() (*Response, error) {
+			return next(e)
+		}
+		response, err := f(e)
+		if err != nil {
+			return nil, err
+		}
+		return response, nil
 	}
 }
 
 // ResetPipeline removes all previously registered functions from the pipeline
 func (p *Proxy) ResetPipeline() {
+	p.hooksMu.Lock()
+	p.namedHooks = nil
+	p.hooksMu.Unlock()
+
+	p.hooks = nil
 	p.roundTripPipeline = p.ForwardRequest
 }
 
@@ -267,16 +921,69 @@ func isWebsocketHandshake(req *http.Request) bool {
 }
 
 func (p *Proxy) ServeHTTP(responseWriter http.ResponseWriter, httpRequest *http.Request) {
+	raw, err := captureRequest(httpRequest, p.MaxRequestBodySize, p.CapturePaused())
+
 	event := newEvent(responseWriter, httpRequest, p.logger, p.nextRequestID())
+	if err != nil {
+		event.SendErrorStatus(http.StatusBadRequest, "reading request body failed: %v", err)
+		return
+	}
+	event.RawClientRequest = raw
+
+	// handle requests received on a connection terminated by ServeTLS
+	if tlsConn, ok := event.Req.Context().Value(directTLSKey{}).(*tls.Conn); ok {
+		state := tlsConn.ConnectionState()
+		if event.Req.TLS == nil {
+			event.Req.TLS = &state
+			event.ClientTLS = &state
+		}
+		event.ForceHost = directTLSTarget(event.Req.Host, state.ServerName)
+		event.ForceScheme = "https"
+		p.ServeProxyRequest(event)
+		return
+	}
 
 	// handle CONNECT requests for HTTPS
 	if event.Req.Method == http.MethodConnect {
-		ServeConnect(event, p.serverConfig, p.Cache, p.logger, p.nextRequestID, p.ServeProxyRequest)
+		cfg := p.config()
+		if !p.connectPortAllowed(event.Req.Host) {
+			event.sendBlocked(cfg.Block, "CONNECT to %v is not allowed", event.Req.Host)
+			return
+		}
+		if host := targetHostname(event, ""); !inScope(cfg.Scope, host) {
+			event.sendBlocked(cfg.Block, "CONNECT to %v is out of scope", host)
+			return
+		}
+		opts := connectOptions{
+			tracker:         &p.conns,
+			transcriptDir:   p.TranscriptDir,
+			captureRaw:      p.CaptureRaw,
+			maxBody:         p.MaxRequestBodySize,
+			paused:          p.CapturePaused,
+			hsts:            p.HSTS,
+			hstsPassthrough: p.HSTSPassthrough,
+			servers:         &p.tunnels,
+		}
+		if p.CapturePaused() {
+			// don't record the tunnel
+			opts.transcriptDir, opts.captureRaw = "", false
+		}
+		serveConnect(event, p.serverConfig, p.Cache, p.logger, p.nextRequestID, p.ServeProxyRequest, opts)
 		return
 	}
 
 	// serve certificate for easier importing
 	if event.Req.URL.Hostname() == "proxy" {
+		if p.AdminHandler != nil && event.Req.URL.Path != "/ca" && event.Req.URL.Path != CAPath {
+			p.AdminHandler.ServeHTTP(event.ResponseWriter, event.Req)
+			return
+		}
+		ServeStatic(event.ResponseWriter, event.Req, p.CertificateAuthority.CertificateAsPEM())
+		return
+	}
+
+	// direct (non-proxied) requests to the listener can fetch the certificate
+	if event.Req.URL.Host == "" && event.Req.URL.Path == CAPath {
 		ServeStatic(event.ResponseWriter, event.Req, p.CertificateAuthority.CertificateAsPEM())
 		return
 	}
@@ -285,6 +992,33 @@ func (p *Proxy) ServeHTTP(responseWriter http.ResponseWriter, httpRequest *http.
 	p.ServeProxyRequest(event)
 }
 
+// connectPortAllowed returns true if Config.AllowedConnectPorts permits a
+// CONNECT request to host.
+func (p *Proxy) connectPortAllowed(host string) bool {
+	ports := p.config().AllowedConnectPorts
+	if len(ports) == 0 {
+		return true
+	}
+
+	_, rawPort, err := net.SplitHostPort(host)
+	if err != nil {
+		// CONNECT without a port defaults to HTTPS
+		rawPort = "443"
+	}
+
+	port, err := strconv.Atoi(rawPort)
+	if err != nil {
+		return false
+	}
+
+	for _, allowed := range ports {
+		if port == allowed {
+			return true
+		}
+	}
+	return false
+}
+
 // ListenAndServe starts the listener and runs the proxy.
 func (p *Proxy) ListenAndServe() error {
 	p.logger.Printf("Listening on %s\n", p.server.Addr)
@@ -296,12 +1030,72 @@ func (p *Proxy) ListenAndServe() error {
 	return p.Serve(listener)
 }
 
-// Serve runs the proxy and answers requests.
+// ListenAndServeAll starts listeners on all addresses and runs the proxy on
+// each of them. It returns when all listeners have been closed, e.g. by
+// Shutdown, with the first error that occurred.
+func (p *Proxy) ListenAndServeAll(addrs []string) error {
+	listeners := make([]net.Listener, 0, len(addrs))
+	for _, addr := range addrs {
+		p.logger.Printf("Listening on %s\n", addr)
+		listener, err := net.Listen("tcp", addr)
+		if err != nil {
+			for _, l := range listeners {
+				_ = l.Close()
+			}
+			return err
+		}
+		listeners = append(listeners, listener)
+	}
+
+	var g errgroup.Group
+	for _, listener := range listeners {
+		listener := listener
+		g.Go(func() error {
+			return p.Serve(listener)
+		})
+	}
+
+	return g.Wait()
+}
+
+// Serve runs the proxy and answers requests. It may be called for several
+// listeners concurrently.
 func (p *Proxy) Serve(listener net.Listener) error {
+	p.addListenAddr(listener.Addr())
+
+	if p.LeakThreshold > 0 {
+		p.leakOnce.Do(func() {
+			done := make(chan struct{})
+			p.server.RegisterOnShutdown(func() { close(done) })
+			go p.watchLeaks(p.LeakThreshold, done)
+		})
+	}
+
+	if p.CaptureRaw {
+		listener = captureListener{listener}
+	}
+
 	return p.server.Serve(listener)
 }
 
-// Shutdown closes the proxy gracefully.
+// Shutdown closes the proxy gracefully. It waits for the requests being
+// processed, including those received through CONNECT tunnels, and the
+// comparisons of shadow responses until ctx is cancelled.
 func (p *Proxy) Shutdown(ctx context.Context) error {
-	return p.server.Shutdown(ctx)
+	err := p.server.Shutdown(ctx)
+
+	// the tunnels are served on hijacked connections, which the server
+	// doesn't wait for
+	terr := p.tunnels.Shutdown(ctx)
+	if err == nil {
+		err = terr
+	}
+
+	if p.Shadow != nil {
+		serr := p.Shadow.Wait(ctx)
+		if err == nil {
+			err = serr
+		}
+	}
+	return err
 }
@@ -1,16 +1,30 @@
 package proxy
 
 import (
+	"bufio"
+	"bytes"
+	"context"
 	"crypto/tls"
 	"crypto/x509"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"log"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/http/httptrace"
+	"net/textproto"
 	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/fd0/osmosis/certauth"
 )
@@ -156,3 +170,1246 @@ func TestProxyPOST(t *testing.T) {
 	wantStatus(t, res, http.StatusOK)
 	wantBody(t, res, "foobar")
 }
+
+func wantLine(t testing.TB, rd *bufio.Reader, line string) {
+	buf, err := rd.ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if buf != line {
+		t.Errorf("unexpected line received: want %q, got %q", line, buf)
+	}
+}
+
+func TestProxyServerSentEvents(t *testing.T) {
+	proxy, serve, shutdown := TestProxy(t, nil)
+	go serve()
+	defer shutdown()
+
+	// register a hook which reads the full body, this would block forever
+	// if the response was not streamed
+	proxy.Register(func(event *Event) (*Response, error) {
+		res, err := event.ForwardRequest()
+		if err != nil {
+			return nil, err
+		}
+		_, err = res.RawBody()
+		return res, err
+	})
+
+	next := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Content-Type", "text/event-stream")
+		rw.WriteHeader(http.StatusOK)
+
+		io.WriteString(rw, "data: first\n\n")
+		rw.(http.Flusher).Flush()
+
+		// wait until the client has received the first event
+		<-next
+
+		io.WriteString(rw, "data: second\n\n")
+		rw.(http.Flusher).Flush()
+	}))
+	defer srv.Close()
+
+	client := testClient(t, proxy.Addr, proxy.CertificateAuthority)
+	client.Timeout = 10 * time.Second
+
+	res, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	wantStatus(t, res, http.StatusOK)
+	wantHeader(t, res, map[string]string{"Content-Type": "text/event-stream"})
+
+	rd := bufio.NewReader(res.Body)
+	wantLine(t, rd, "data: first\n")
+	wantLine(t, rd, "\n")
+
+	close(next)
+
+	wantLine(t, rd, "data: second\n")
+	wantLine(t, rd, "\n")
+}
+
+func TestProxyFlush(t *testing.T) {
+	proxy, serve, shutdown := TestProxy(t, nil)
+	go serve()
+	defer shutdown()
+
+	next := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Content-Type", "text/plain")
+		rw.Header().Set("Trailer", "Content-Hash")
+		rw.WriteHeader(http.StatusOK)
+
+		io.WriteString(rw, "first\n")
+		rw.(http.Flusher).Flush()
+
+		// wait until the client has received the first line
+		<-next
+
+		io.WriteString(rw, "second\n")
+		rw.Header().Set("Content-Hash", "1234")
+	}))
+	defer srv.Close()
+
+	client := testClient(t, proxy.Addr, proxy.CertificateAuthority)
+	client.Timeout = 10 * time.Second
+
+	res, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantStatus(t, res, http.StatusOK)
+
+	rd := bufio.NewReader(res.Body)
+	wantLine(t, rd, "first\n")
+
+	close(next)
+
+	wantLine(t, rd, "second\n")
+
+	_, err = ioutil.ReadAll(rd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	wantTrailer(t, res, map[string]string{"Content-Hash": "1234"})
+}
+
+func TestProxyPassthroughContentTypes(t *testing.T) {
+	proxy, serve, shutdown := TestProxy(t, nil)
+	go serve()
+	defer shutdown()
+
+	proxy.UpdateConfig(func(cfg *Config) {
+		cfg.PassthroughContentTypes = []string{"video/*", "application/octet-stream"}
+		cfg.BufferContentTypes = []string{"video/webm"}
+	})
+
+	// register a hook which replaces the body
+	proxy.Register(func(event *Event) (*Response, error) {
+		res, err := event.ForwardRequest()
+		if err != nil {
+			return nil, err
+		}
+		res.SetBody([]byte("modified"))
+		res.ContentLength = -1
+		res.Header.Del("Content-Length")
+		return res, nil
+	})
+
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Content-Type", req.URL.Query().Get("type"))
+		rw.WriteHeader(http.StatusOK)
+		io.WriteString(rw, "original")
+	}))
+	defer srv.Close()
+
+	client := testClient(t, proxy.Addr, proxy.CertificateAuthority)
+
+	var tests = []struct {
+		contentType string
+		body        string
+	}{
+		{"video/mp4", "original"},
+		{"video/webm", "modified"},
+		{"application/octet-stream", "original"},
+		{"text/html", "modified"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.contentType, func(t *testing.T) {
+			res, err := client.Get(srv.URL + "?type=" + url.QueryEscape(test.contentType))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			wantStatus(t, res, http.StatusOK)
+			wantHeader(t, res, map[string]string{"Content-Type": test.contentType})
+			wantBody(t, res, test.body)
+		})
+	}
+}
+
+func TestProxyListenAndServeAll(t *testing.T) {
+	// find two free ports
+	var addrs []string
+	for i := 0; i < 2; i++ {
+		listener := newLocalListener(t)
+		addrs = append(addrs, listener.Addr().String())
+		listener.Close()
+	}
+
+	proxy := New(addrs[0], certauth.TestCA(t), nil, nil)
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- proxy.ListenAndServeAll(addrs)
+	}()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		io.WriteString(rw, "ok")
+	}))
+	defer srv.Close()
+
+	for _, addr := range addrs {
+		client := testClient(t, addr, proxy.CertificateAuthority)
+
+		var res *http.Response
+		var err error
+		// wait for the listener to become ready
+		for i := 0; i < 50; i++ {
+			res, err = client.Get(srv.URL)
+			if err == nil {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		if err != nil {
+			t.Fatalf("request via %v failed: %v", addr, err)
+		}
+
+		wantStatus(t, res, http.StatusOK)
+		wantBody(t, res, "ok")
+	}
+
+	err := proxy.Shutdown(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = <-errCh
+	if err != http.ErrServerClosed {
+		t.Fatalf("unexpected error returned: %v", err)
+	}
+}
+
+func TestProxyClientConnectionInfo(t *testing.T) {
+	proxy, serve, shutdown := TestProxy(t, &tls.Config{
+		InsecureSkipVerify: true,
+	})
+	go serve()
+	defer shutdown()
+
+	var (
+		clientProto string
+		clientTLS   *tls.ConnectionState
+		viaConnect  bool
+	)
+	proxy.Register(func(event *Event) (*Response, error) {
+		clientProto = event.ClientProto
+		clientTLS = event.ClientTLS
+		viaConnect = event.ViaCONNECT
+		return event.ForwardRequest()
+	})
+
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		io.WriteString(rw, "ok")
+	}))
+	defer srv.Close()
+
+	client := testClient(t, proxy.Addr, proxy.CertificateAuthority)
+
+	res, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantStatus(t, res, http.StatusOK)
+	wantBody(t, res, "ok")
+
+	if !viaConnect {
+		t.Errorf("request was not marked as received via CONNECT")
+	}
+
+	if clientProto != "HTTP/1.1" {
+		t.Errorf("wrong client protocol, want %q, got %q", "HTTP/1.1", clientProto)
+	}
+
+	if clientTLS == nil {
+		t.Fatalf("client TLS connection state is not set")
+	}
+
+	if clientTLS.Version < tls.VersionTLS12 {
+		t.Errorf("unexpected client TLS version %x", clientTLS.Version)
+	}
+
+	if !clientTLS.HandshakeComplete {
+		t.Errorf("client TLS handshake is not complete")
+	}
+}
+
+func TestProxyRootCAs(t *testing.T) {
+	// the upstream server uses a certificate signed by a custom root
+	root := certauth.TestNewCA(t)
+	cert, err := root.NewCertificate("127.0.0.1", []string{"127.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		_, _ = rw.Write([]byte("verified"))
+	}))
+	srv.TLS = &tls.Config{Certificates: []tls.Certificate{*root.TLSCert(cert)}}
+	srv.StartTLS()
+	defer srv.Close()
+
+	tempdir, err := ioutil.TempDir("", "osmosis-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempdir)
+
+	err = certauth.WriteCertificate(filepath.Join(tempdir, "root.crt"), root.Certificate)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// without the root the upstream cannot be verified
+	proxy, serve, shutdown := TestProxy(t, nil)
+	go serve()
+	defer shutdown()
+
+	client := testClient(t, proxy.Addr, proxy.CertificateAuthority)
+	res, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantStatus(t, res, http.StatusBadGateway)
+	_ = res.Body.Close()
+
+	pool, err := certauth.LoadCertPool([]string{tempdir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy.SetRootCAs(pool)
+
+	res, err = client.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantStatus(t, res, http.StatusOK)
+	wantBody(t, res, "verified")
+}
+
+func TestProxyMaxRequestBodySize(t *testing.T) {
+	var received string
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		buf, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			t.Error(err)
+		}
+		received = string(buf)
+	}))
+	defer srv.Close()
+
+	var tests = []struct {
+		body       string
+		chunked    bool
+		stream     bool
+		status     int
+		hookCalled bool
+	}{
+		{"small", false, false, http.StatusOK, true},
+		{"small", true, false, http.StatusOK, true},
+		{"this body is too large", false, false, http.StatusRequestEntityTooLarge, false},
+		{"this body is too large", true, false, http.StatusRequestEntityTooLarge, false},
+		{"this body is too large", false, true, http.StatusOK, false},
+		{"this body is too large", true, true, http.StatusOK, false},
+	}
+
+	for _, test := range tests {
+		t.Run("", func(t *testing.T) {
+			proxy, serve, shutdown := TestProxy(t, nil)
+			go serve()
+			defer shutdown()
+
+			proxy.MaxRequestBodySize = 10
+			proxy.StreamLargeRequests = test.stream
+
+			var hookCalled bool
+			proxy.Register(func(event *Event) (*Response, error) {
+				hookCalled = true
+				return event.ForwardRequest()
+			})
+
+			received = ""
+
+			var body io.Reader = strings.NewReader(test.body)
+			if test.chunked {
+				// hide the length from the client
+				body = io.MultiReader(body)
+			}
+
+			client := testClient(t, proxy.Addr, proxy.CertificateAuthority)
+			res, err := client.Post(srv.URL, "text/plain", body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_ = res.Body.Close()
+
+			wantStatus(t, res, test.status)
+
+			if hookCalled != test.hookCalled {
+				t.Errorf("wrong hook state, want called %v, got %v", test.hookCalled, hookCalled)
+			}
+
+			if test.status == http.StatusOK && received != test.body {
+				t.Errorf("upstream received wrong body, want %q, got %q", test.body, received)
+			}
+		})
+	}
+}
+
+func TestProxyHostClientConfig(t *testing.T) {
+	// the first server uses a self-signed certificate
+	insecureSrv := httptest.NewTLSServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		_, _ = rw.Write([]byte("insecure"))
+	}))
+	defer insecureSrv.Close()
+
+	// the second server uses a certificate signed by a custom root
+	root := certauth.TestNewCA(t)
+	cert, err := root.NewCertificate("127.0.0.1", []string{"127.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		_, _ = rw.Write([]byte("verified"))
+	}))
+	srv.TLS = &tls.Config{Certificates: []tls.Certificate{*root.TLSCert(cert)}}
+	srv.StartTLS()
+	defer srv.Close()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(root.Certificate)
+
+	proxy, serve, shutdown := TestProxy(t, nil)
+	go serve()
+	defer shutdown()
+
+	// access the first server by name, so a different config is used for it
+	proxy.SetHostClientConfig("localhost", &tls.Config{InsecureSkipVerify: true})
+	proxy.SetHostClientConfig("127.0.0.1", &tls.Config{RootCAs: pool})
+
+	insecureURL := strings.Replace(insecureSrv.URL, "127.0.0.1", "localhost", 1)
+
+	var tests = []struct {
+		url    string
+		status int
+		body   string
+	}{
+		{insecureURL, http.StatusOK, "insecure"},
+		{srv.URL, http.StatusOK, "verified"},
+		// the self-signed certificate is rejected for 127.0.0.1
+		{insecureSrv.URL, http.StatusBadGateway, ""},
+	}
+
+	client := testClient(t, proxy.Addr, proxy.CertificateAuthority)
+	for _, test := range tests {
+		t.Run("", func(t *testing.T) {
+			res, err := client.Get(test.url)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			wantStatus(t, res, test.status)
+			if test.body != "" {
+				wantBody(t, res, test.body)
+			}
+			_ = res.Body.Close()
+		})
+	}
+}
+
+// recordingTransport records the URLs of all requests it sends.
+type recordingTransport struct {
+	urls []string
+	rt   http.RoundTripper
+}
+
+func (t *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.urls = append(t.urls, req.URL.String())
+	return t.rt.RoundTrip(req)
+}
+
+func TestProxyUpstreamTransport(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/redirect" {
+			http.Redirect(rw, req, "/target", http.StatusFound)
+			return
+		}
+		_, _ = rw.Write([]byte("target"))
+	}))
+	defer srv.Close()
+
+	proxy, serve, shutdown := TestProxy(t, nil)
+	go serve()
+	defer shutdown()
+
+	rt := &recordingTransport{rt: &http.Transport{}}
+	proxy.SetUpstreamTransport(rt)
+
+	client := testClient(t, proxy.Addr, proxy.CertificateAuthority)
+	client.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+
+	res, err := client.Get(srv.URL + "/redirect")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = res.Body.Close()
+
+	// the redirect is passed on to the client
+	wantStatus(t, res, http.StatusFound)
+
+	want := []string{srv.URL + "/redirect"}
+	if strings.Join(rt.urls, " ") != strings.Join(want, " ") {
+		t.Errorf("custom transport was not used, want %v, got %v", want, rt.urls)
+	}
+
+	// restore the default transport
+	proxy.SetUpstreamTransport(nil)
+
+	var dialed []string
+	proxy.SetUpstreamDialer(func(ctx context.Context, network, addr string) (net.Conn, error) {
+		dialed = append(dialed, addr)
+		return (&net.Dialer{}).DialContext(ctx, network, addr)
+	})
+
+	res, err = client.Get(srv.URL + "/target")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantBody(t, res, "target")
+
+	if len(rt.urls) != 1 {
+		t.Errorf("custom transport was used after it has been removed: %v", rt.urls)
+	}
+
+	if len(dialed) != 1 || dialed[0] != srv.Listener.Addr().String() {
+		t.Errorf("custom dialer was not used: %v", dialed)
+	}
+}
+
+func TestProxySchemeOverride(t *testing.T) {
+	var received []string
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		received = append(received, req.Host+req.URL.Path)
+		if req.URL.Path == "/redirect" {
+			http.Redirect(rw, req, "http://"+req.Host+"/target", http.StatusFound)
+			return
+		}
+		_, _ = rw.Write([]byte("plain"))
+	}))
+	defer srv.Close()
+
+	proxy, serve, shutdown := TestProxy(t, nil)
+	go serve()
+	defer shutdown()
+
+	proxy.SetSchemeOverride("127.0.0.1", "http")
+
+	client := testClient(t, proxy.Addr, proxy.CertificateAuthority)
+	client.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+
+	// the client uses HTTPS to the proxy, the upstream server only speaks HTTP
+	httpsURL := strings.Replace(srv.URL, "http://", "https://", 1)
+
+	res, err := client.Get(httpsURL + "/index")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantStatus(t, res, http.StatusOK)
+	wantBody(t, res, "plain")
+
+	if res.TLS == nil {
+		t.Errorf("response did not reach the client over HTTPS")
+	}
+
+	res, err = client.Get(httpsURL + "/redirect")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = res.Body.Close()
+	wantStatus(t, res, http.StatusFound)
+
+	if loc := res.Header.Get("Location"); loc != httpsURL+"/target" {
+		t.Errorf("wrong Location header, want %v, got %v", httpsURL+"/target", loc)
+	}
+
+	host := srv.Listener.Addr().String()
+	want := []string{host + "/index", host + "/redirect"}
+	if strings.Join(received, " ") != strings.Join(want, " ") {
+		t.Errorf("wrong requests received, want %v, got %v", want, received)
+	}
+}
+
+func TestStripDefaultPort(t *testing.T) {
+	var tests = []struct {
+		hostport, scheme, want string
+	}{
+		{"example.com:443", "https", "example.com"},
+		{"example.com:443", "http", "example.com:443"},
+		{"example.com:80", "http", "example.com"},
+		{"example.com:8443", "https", "example.com:8443"},
+		{"example.com", "https", "example.com"},
+		{"[::1]:443", "https", "[::1]"},
+	}
+
+	for _, test := range tests {
+		got := stripDefaultPort(test.hostport, test.scheme)
+		if got != test.want {
+			t.Errorf("stripDefaultPort(%q, %q): want %q, got %q", test.hostport, test.scheme, test.want, got)
+		}
+	}
+}
+
+// closeRecorder records whether the body has been closed.
+type closeRecorder struct {
+	io.ReadCloser
+	closed chan struct{}
+}
+
+func (c *closeRecorder) Close() error {
+	close(c.closed)
+	return c.ReadCloser.Close()
+}
+
+// closeRecordingTransport wraps the response bodies with a closeRecorder.
+type closeRecordingTransport struct {
+	rt     http.RoundTripper
+	closed chan struct{}
+}
+
+func (t *closeRecordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	res, err := t.rt.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	res.Body = &closeRecorder{ReadCloser: res.Body, closed: t.closed}
+	return res, nil
+}
+
+func TestProxyClientDisconnect(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		// send a chunk every few milliseconds until the proxy goes away
+		for i := 0; i < 1000; i++ {
+			_, err := rw.Write([]byte(strings.Repeat("x", 1024)))
+			if err != nil {
+				return
+			}
+			rw.(http.Flusher).Flush()
+
+			select {
+			case <-req.Context().Done():
+				return
+			case <-time.After(5 * time.Millisecond):
+			}
+		}
+	}))
+	defer srv.Close()
+
+	proxy, serve, shutdown := TestProxy(t, nil)
+	go serve()
+	defer shutdown()
+
+	var buf syncBuffer
+	proxy.logger = log.New(&buf, "", 0)
+
+	closed := make(chan struct{})
+	proxy.SetUpstreamTransport(&closeRecordingTransport{rt: &http.Transport{}, closed: closed})
+
+	client := testClient(t, proxy.Addr, proxy.CertificateAuthority)
+	client.Transport.(*http.Transport).DisableKeepAlives = true
+
+	res, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// read a bit of the body, then disconnect
+	_, err = io.ReadFull(res.Body, make([]byte, 2048))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = res.Body.Close()
+
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("upstream body was not closed")
+	}
+
+	pattern := regexp.MustCompile(`error copying body after (\d+) bytes`)
+	var match []string
+	deadline := time.Now().Add(5 * time.Second)
+	for match == nil && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		match = pattern.FindStringSubmatch(buf.String())
+	}
+
+	if match == nil {
+		t.Fatalf("partial byte count was not logged:\n%s", buf.String())
+	}
+
+	n, err := strconv.Atoi(match[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n < 2048 || n >= 1000*1024 {
+		t.Errorf("unexpected number of bytes sent: %d", n)
+	}
+}
+
+func TestProxyPauseCapture(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		_, _ = io.WriteString(rw, req.URL.Path)
+	}))
+	defer srv.Close()
+
+	proxy, serve, shutdown := TestProxy(t, nil)
+	go serve()
+	defer shutdown()
+
+	// the hook stands in for recording the transactions in the store
+	var recorded []string
+	proxy.Register(func(event *Event) (*Response, error) {
+		recorded = append(recorded, event.Req.URL.Path)
+		return event.ForwardRequest()
+	})
+
+	client := testClient(t, proxy.Addr, proxy.CertificateAuthority)
+	for _, path := range []string{"/before", "/paused", "/after"} {
+		if path == "/paused" {
+			proxy.PauseCapture()
+		} else {
+			proxy.ResumeCapture()
+		}
+
+		if proxy.CapturePaused() != (path == "/paused") {
+			t.Fatalf("wrong pause state for %v", path)
+		}
+
+		res, err := client.Get(srv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wantStatus(t, res, http.StatusOK)
+		wantBody(t, res, path)
+	}
+
+	want := []string{"/before", "/after"}
+	if strings.Join(recorded, " ") != strings.Join(want, " ") {
+		t.Errorf("wrong requests recorded, want %v, got %v", want, recorded)
+	}
+}
+
+func TestProxyStats(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/fail" {
+			// close the connection without sending a response
+			hj := rw.(http.Hijacker)
+			conn, _, err := hj.Hijack()
+			if err == nil {
+				_ = conn.Close()
+			}
+			return
+		}
+
+		_, _ = io.Copy(ioutil.Discard, req.Body)
+		_, _ = io.WriteString(rw, "0123456789")
+	}))
+	defer srv.Close()
+
+	proxy, serve, shutdown := TestProxy(t, nil)
+	go serve()
+	defer shutdown()
+
+	client := testClient(t, proxy.Addr, proxy.CertificateAuthority)
+	for i := 0; i < 3; i++ {
+		res, err := client.Post(srv.URL, "text/plain", strings.NewReader("hello"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		wantBody(t, res, "0123456789")
+	}
+
+	res, err := client.Get(srv.URL + "/fail")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = res.Body.Close()
+	wantStatus(t, res, http.StatusBadGateway)
+
+	// the counters are updated after the response has been sent
+	var stats Stats
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		stats = proxy.Stats()
+		if stats.ActiveRequests == 0 && stats.Requests == 4 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	want := Stats{
+		Requests: 4,
+		BytesIn:  3 * 5,
+		BytesOut: 3 * 10,
+		Errors:   1,
+	}
+	if stats != want {
+		t.Errorf("wrong stats, want %+v, got %+v", want, stats)
+	}
+}
+
+func TestProxyEarlyHints(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Link", "</style.css>; rel=preload; as=style")
+		rw.WriteHeader(http.StatusEarlyHints)
+
+		rw.Header().Del("Link")
+		_, _ = io.WriteString(rw, "final")
+	}))
+	defer srv.Close()
+
+	for _, forward := range []bool{false, true} {
+		t.Run(fmt.Sprintf("forward-%v", forward), func(t *testing.T) {
+			proxy, serve, shutdown := TestProxy(t, nil)
+			proxy.ForwardEarlyHints = forward
+			go serve()
+			defer shutdown()
+
+			var logged bool
+			proxy.Register(func(event *Event) (*Response, error) {
+				buf := &bytes.Buffer{}
+				event.Logger = log.New(buf, "", 0)
+				res, err := event.ForwardRequest()
+				logged = strings.Contains(buf.String(), "informational response 103")
+				return res, err
+			})
+
+			var hints []string
+			trace := &httptrace.ClientTrace{
+				Got1xxResponse: func(code int, header textproto.MIMEHeader) error {
+					if code == http.StatusEarlyHints {
+						hints = append(hints, header.Get("Link"))
+					}
+					return nil
+				},
+			}
+
+			req, err := http.NewRequest(http.MethodGet, srv.URL, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+
+			client := testClient(t, proxy.Addr, proxy.CertificateAuthority)
+			res, err := client.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			wantStatus(t, res, http.StatusOK)
+			wantBody(t, res, "final")
+
+			if res.Header.Get("Link") != "" {
+				t.Errorf("hints leaked into the final response: %v", res.Header)
+			}
+
+			if !logged {
+				t.Errorf("early hints were not logged")
+			}
+
+			if !forward && len(hints) != 0 {
+				t.Errorf("early hints forwarded although disabled: %v", hints)
+			}
+
+			if forward && (len(hints) != 1 || hints[0] != "</style.css>; rel=preload; as=style") {
+				t.Errorf("wrong early hints received, got %v", hints)
+			}
+		})
+	}
+}
+
+func TestProxyEventValues(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		_, _ = io.WriteString(rw, "ok")
+	}))
+	defer srv.Close()
+
+	proxy, serve, shutdown := TestProxy(t, nil)
+	go serve()
+	defer shutdown()
+
+	proxy.Register(func(event *Event) (*Response, error) {
+		if event.Req.URL.Path == "/set" {
+			event.Set("token", "secret")
+		}
+		return event.ForwardRequest()
+	})
+
+	// hooks run in the order they were registered, so this one sees the
+	// value set above
+	seen := make(map[string]interface{})
+	proxy.Register(func(event *Event) (*Response, error) {
+		v, ok := event.Get("token")
+		if ok {
+			seen[event.Req.URL.Path] = v
+		}
+		return event.ForwardRequest()
+	})
+
+	client := testClient(t, proxy.Addr, proxy.CertificateAuthority)
+	for _, path := range []string{"/set", "/other"} {
+		res, err := client.Get(srv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wantStatus(t, res, http.StatusOK)
+		wantBody(t, res, "ok")
+	}
+
+	if seen["/set"] != "secret" {
+		t.Errorf("value not visible to later hook, got %v", seen)
+	}
+
+	if v, ok := seen["/other"]; ok {
+		t.Errorf("value leaked into a different transaction: %v", v)
+	}
+}
+
+func TestProxyHookOrder(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		_, _ = io.WriteString(rw, "ok")
+	}))
+	defer srv.Close()
+
+	proxy, serve, shutdown := TestProxy(t, nil)
+	go serve()
+	defer shutdown()
+
+	var m sync.Mutex
+	var log []string
+	add := func(s string) {
+		m.Lock()
+		log = append(log, s)
+		m.Unlock()
+	}
+
+	hook := func(name string) func(*Event) (*Response, error) {
+		return func(event *Event) (*Response, error) {
+			add(name + "-before")
+			res, err := event.ForwardRequest()
+			add(name + "-after")
+			return res, err
+		}
+	}
+
+	proxy.Register(hook("a"))
+	err := proxy.RegisterNamed("b", "all requests", hook("b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy.Register(hook("c"))
+
+	client := testClient(t, proxy.Addr, proxy.CertificateAuthority)
+	res, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantStatus(t, res, http.StatusOK)
+	wantBody(t, res, "ok")
+
+	want := []string{"a-before", "b-before", "c-before", "c-after", "b-after", "a-after"}
+	m.Lock()
+	defer m.Unlock()
+	if !reflect.DeepEqual(log, want) {
+		t.Errorf("wrong hook order, want %v, got %v", want, log)
+	}
+}
+
+func TestProxyStaticHeaders(t *testing.T) {
+	var m sync.Mutex
+	var seen []http.Header
+	handler := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		m.Lock()
+		seen = append(seen, req.Header.Clone())
+		m.Unlock()
+
+		rw.Header().Set("X-Frame-Options", "ALLOW")
+		_, _ = io.WriteString(rw, "ok")
+	})
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+	tlsSrv := httptest.NewTLSServer(handler)
+	defer tlsSrv.Close()
+
+	proxy, serve, shutdown := TestProxy(t, tlsSrv.Client().Transport.(*http.Transport).TLSClientConfig)
+	proxy.Cache.NoClone = true
+	proxy.UpdateConfig(func(cfg *Config) {
+		cfg.StaticRequestHeaders = http.Header{
+			"X-Trace-Id": []string{"trace-123"},
+			"user-agent": []string{"osmosis"},
+			"Connection": []string{"close"},
+		}
+		cfg.StaticResponseHeaders = http.Header{
+			"X-Frame-Options": []string{"DENY"},
+		}
+	})
+	go serve()
+	defer shutdown()
+
+	client := testClient(t, proxy.Addr, proxy.CertificateAuthority)
+	for _, url := range []string{srv.URL + "/a", srv.URL + "/b", tlsSrv.URL + "/c"} {
+		req, err := http.NewRequest(http.MethodGet, url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("User-Agent", "client")
+		req.Header.Set("X-Trace-Id", "from-client")
+
+		res, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wantStatus(t, res, http.StatusOK)
+		wantHeader(t, res, map[string]string{"X-Frame-Options": "DENY"})
+		wantBody(t, res, "ok")
+	}
+
+	m.Lock()
+	defer m.Unlock()
+	if len(seen) != 3 {
+		t.Fatalf("wrong number of requests received: %v", len(seen))
+	}
+	for _, header := range seen {
+		if v := header["X-Trace-Id"]; len(v) != 1 || v[0] != "trace-123" {
+			t.Errorf("wrong X-Trace-Id received: %v", v)
+		}
+		if v := header.Get("User-Agent"); v != "osmosis" {
+			t.Errorf("wrong User-Agent received: %v", v)
+		}
+		if v := header.Get("Connection"); v != "" {
+			t.Errorf("hop-by-hop header was forwarded: %v", v)
+		}
+	}
+}
+
+func TestProxyCustomMethods(t *testing.T) {
+	type received struct {
+		method, path, body string
+	}
+
+	var m sync.Mutex
+	var seen []received
+	handler := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		body, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			t.Error(err)
+		}
+		m.Lock()
+		seen = append(seen, received{req.Method, req.URL.Path, string(body)})
+		m.Unlock()
+		_, _ = io.WriteString(rw, "ok")
+	})
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+	tlsSrv := httptest.NewTLSServer(handler)
+	defer tlsSrv.Close()
+
+	proxy, serve, shutdown := TestProxy(t, tlsSrv.Client().Transport.(*http.Transport).TLSClientConfig)
+	proxy.Cache.NoClone = true
+	go serve()
+	defer shutdown()
+
+	// requests to /edit are replaced like an edited request would be
+	proxy.Register(func(event *Event) (*Response, error) {
+		if event.Req.URL.Path == "/edit" {
+			raw := "PURGE /edited HTTP/1.1\r\nHost: " + event.Req.Host + "\r\nContent-Length: 4\r\n\r\ndata"
+			err := event.SetRequest([]byte(raw))
+			if err != nil {
+				return nil, err
+			}
+		}
+		return event.ForwardRequest()
+	})
+
+	client := testClient(t, proxy.Addr, proxy.CertificateAuthority)
+
+	var tests = []struct {
+		method, url, body string
+		want              received
+	}{
+		{http.MethodPatch, srv.URL + "/patch", "patched", received{"PATCH", "/patch", "patched"}},
+		{http.MethodDelete, srv.URL + "/delete", "", received{"DELETE", "/delete", ""}},
+		{"PURGE", srv.URL + "/purge", "", received{"PURGE", "/purge", ""}},
+		{"PROPFIND", tlsSrv.URL + "/dav", "<propfind/>", received{"PROPFIND", "/dav", "<propfind/>"}},
+		{"x-custom", srv.URL + "/lower", "", received{"x-custom", "/lower", ""}},
+		{http.MethodGet, srv.URL + "/edit", "", received{"PURGE", "/edited", "data"}},
+		{http.MethodGet, tlsSrv.URL + "/edit", "", received{"PURGE", "/edited", "data"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.method+" "+test.url, func(t *testing.T) {
+			m.Lock()
+			seen = nil
+			m.Unlock()
+
+			req, err := http.NewRequest(test.method, test.url, strings.NewReader(test.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			res, err := client.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			wantStatus(t, res, http.StatusOK)
+			wantBody(t, res, "ok")
+
+			m.Lock()
+			defer m.Unlock()
+			if len(seen) != 1 || seen[0] != test.want {
+				t.Errorf("wrong request received upstream, want %+v, got %+v", test.want, seen)
+			}
+		})
+	}
+}
+
+func TestProxyHeaderLimits(t *testing.T) {
+	var m sync.Mutex
+	var seen http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		m.Lock()
+		seen = req.Header.Clone()
+		m.Unlock()
+		_, _ = io.WriteString(rw, "ok")
+	}))
+	defer srv.Close()
+
+	manyHeaders := make(map[string]string)
+	for i := 0; i < 20; i++ {
+		manyHeaders[fmt.Sprintf("X-Test-%02d", i)] = "value"
+	}
+
+	var tests = []struct {
+		name       string
+		maxCount   int
+		maxSize    int
+		trim       bool
+		header     map[string]string
+		wantStatus int
+		want       []string
+		notWant    []string
+	}{
+		{"within limits", 10, 4096, false, map[string]string{"X-Test-00": "value"}, http.StatusOK, []string{"X-Test-00"}, nil},
+		{"too many", 10, 0, false, manyHeaders, http.StatusRequestHeaderFieldsTooLarge, nil, nil},
+		{"too large", 0, 4096, false, map[string]string{"X-Large": strings.Repeat("x", 5000)}, http.StatusRequestHeaderFieldsTooLarge, nil, nil},
+		{"trim many", 10, 0, true, manyHeaders, http.StatusOK, []string{"X-Test-00", "X-Test-05"}, []string{"X-Test-19"}},
+		{"trim large", 0, 4096, true, map[string]string{"X-Large": strings.Repeat("x", 5000), "X-Small": "value"},
+			http.StatusOK, []string{"X-Small"}, []string{"X-Large"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			proxy, serve, shutdown := TestProxy(t, nil)
+			proxy.MaxHeaderCount = test.maxCount
+			proxy.MaxHeaderSize = test.maxSize
+			proxy.TrimLargeHeaders = test.trim
+			go serve()
+			defer shutdown()
+
+			m.Lock()
+			seen = nil
+			m.Unlock()
+
+			req, err := http.NewRequest(http.MethodGet, srv.URL, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for name, value := range test.header {
+				req.Header.Set(name, value)
+			}
+
+			client := testClient(t, proxy.Addr, proxy.CertificateAuthority)
+			res, err := client.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			wantStatus(t, res, test.wantStatus)
+			_ = res.Body.Close()
+
+			m.Lock()
+			defer m.Unlock()
+			if test.wantStatus != http.StatusOK {
+				if seen != nil {
+					t.Errorf("rejected request was forwarded: %v", seen)
+				}
+				return
+			}
+
+			for _, name := range test.want {
+				if seen.Get(name) == "" {
+					t.Errorf("header %v was not forwarded", name)
+				}
+			}
+			for _, name := range test.notWant {
+				if seen.Get(name) != "" {
+					t.Errorf("header %v was forwarded", name)
+				}
+			}
+		})
+	}
+}
+
+func TestProxyServeCADirect(t *testing.T) {
+	proxy, serve, shutdown := TestProxy(t, nil)
+	proxy.AdminHandler = http.NotFoundHandler()
+	go serve()
+	defer shutdown()
+
+	// a client without proxy, the request is sent to the listener directly
+	client := &http.Client{Transport: &http.Transport{}}
+
+	res, err := client.Get("http://" + proxy.Addr + CAPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantStatus(t, res, http.StatusOK)
+	wantHeader(t, res, map[string]string{"Content-Type": "application/x-x509-ca-cert"})
+	wantBody(t, res, string(proxy.CertificateAuthority.CertificateAsPEM()))
+
+	// the path is available on the magic host as well
+	proxyClient := testClient(t, proxy.Addr, proxy.CertificateAuthority)
+	res, err = proxyClient.Get("http://proxy" + CAPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantStatus(t, res, http.StatusOK)
+	wantBody(t, res, string(proxy.CertificateAuthority.CertificateAsPEM()))
+
+	// other direct requests are not answered with the certificate
+	res, err = client.Get("http://" + proxy.Addr + "/ca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = res.Body.Close()
+	if res.StatusCode == http.StatusOK {
+		t.Errorf("direct request for /ca was answered with %v", res.Status)
+	}
+}
@@ -0,0 +1,93 @@
+package proxy
+
+import (
+	"net/url"
+	"strings"
+)
+
+// The query helpers edit the raw query of a URL pair by pair, so the order
+// and the encoding of the parameters which are not changed are kept, unlike
+// with url.Values.Encode, which sorts the parameters by name.
+
+// queryPairs splits a raw query into its "name=value" pairs.
+func queryPairs(rawQuery string) []string {
+	if rawQuery == "" {
+		return nil
+	}
+	return strings.Split(rawQuery, "&")
+}
+
+// pairName returns the unescaped name of a "name=value" pair.
+func pairName(pair string) string {
+	name := pair
+	if i := strings.IndexByte(pair, '='); i >= 0 {
+		name = pair[:i]
+	}
+	if unescaped, err := url.QueryUnescape(name); err == nil {
+		return unescaped
+	}
+	return name
+}
+
+// queryPair returns the escaped pair for name and value.
+func queryPair(name, value string) string {
+	return url.QueryEscape(name) + "=" + url.QueryEscape(value)
+}
+
+// SetQueryParam sets the query parameter name in u to value. The first
+// occurrence of the parameter is replaced and all others are removed, if
+// there is none the parameter is appended.
+func SetQueryParam(u *url.URL, name, value string) {
+	var res []string
+	var found bool
+	for _, pair := range queryPairs(u.RawQuery) {
+		if pairName(pair) != name {
+			res = append(res, pair)
+			continue
+		}
+		if !found {
+			res = append(res, queryPair(name, value))
+			found = true
+		}
+	}
+	if !found {
+		res = append(res, queryPair(name, value))
+	}
+	u.RawQuery = strings.Join(res, "&")
+	u.ForceQuery = false
+}
+
+// AddQueryParam appends the query parameter name with value to u, existing
+// values are kept.
+func AddQueryParam(u *url.URL, name, value string) {
+	res := append(queryPairs(u.RawQuery), queryPair(name, value))
+	u.RawQuery = strings.Join(res, "&")
+	u.ForceQuery = false
+}
+
+// DelQueryParam removes all occurrences of the query parameter name from u.
+func DelQueryParam(u *url.URL, name string) {
+	var res []string
+	for _, pair := range queryPairs(u.RawQuery) {
+		if pairName(pair) != name {
+			res = append(res, pair)
+		}
+	}
+	u.RawQuery = strings.Join(res, "&")
+}
+
+// SetQueryParam sets the query parameter name of the request to value, see
+// the function SetQueryParam.
+func (e *Event) SetQueryParam(name, value string) {
+	SetQueryParam(e.Req.URL, name, value)
+}
+
+// AddQueryParam appends the query parameter name with value to the request.
+func (e *Event) AddQueryParam(name, value string) {
+	AddQueryParam(e.Req.URL, name, value)
+}
+
+// DelQueryParam removes the query parameter name from the request.
+func (e *Event) DelQueryParam(name string) {
+	DelQueryParam(e.Req.URL, name)
+}
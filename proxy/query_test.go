@@ -0,0 +1,68 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestQueryParams(t *testing.T) {
+	var tests = []struct {
+		query string
+		edit  func(*url.URL)
+		want  string
+	}{
+		{"", func(u *url.URL) { SetQueryParam(u, "a", "1") }, "a=1"},
+		{"b=2&a=1&c=3", func(u *url.URL) { SetQueryParam(u, "a", "x y") }, "b=2&a=x+y&c=3"},
+		{"a=1&b=2&a=3", func(u *url.URL) { SetQueryParam(u, "a", "4") }, "a=4&b=2"},
+		{"b=%2f&a", func(u *url.URL) { SetQueryParam(u, "a", "1") }, "b=%2f&a=1"},
+		{"a%20b=1", func(u *url.URL) { SetQueryParam(u, "a b", "2") }, "a+b=2"},
+		{"a=1", func(u *url.URL) { AddQueryParam(u, "a", "2") }, "a=1&a=2"},
+		{"", func(u *url.URL) { AddQueryParam(u, "q", "a&b=c") }, "q=a%26b%3Dc"},
+		{"a=1&b=2&a=3", func(u *url.URL) { DelQueryParam(u, "a") }, "b=2"},
+		{"a=1", func(u *url.URL) { DelQueryParam(u, "a") }, ""},
+		{"a=1", func(u *url.URL) { DelQueryParam(u, "missing") }, "a=1"},
+	}
+
+	for _, test := range tests {
+		u := &url.URL{Scheme: "http", Host: "example.com", Path: "/", RawQuery: test.query}
+		test.edit(u)
+		if u.RawQuery != test.want {
+			t.Errorf("query %q: want %q, got %q", test.query, test.want, u.RawQuery)
+		}
+	}
+}
+
+func TestProxyEditQueryParams(t *testing.T) {
+	received := make(chan string, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		received <- req.URL.RequestURI()
+		_, _ = io.WriteString(rw, "ok")
+	}))
+	defer srv.Close()
+
+	proxy, serve, shutdown := TestProxy(t, nil)
+	proxy.Register(func(event *Event) (*Response, error) {
+		event.SetQueryParam("page", "2")
+		event.DelQueryParam("debug")
+		event.AddQueryParam("tag", "b c")
+		return event.ForwardRequest()
+	})
+	go serve()
+	defer shutdown()
+
+	client := testClient(t, proxy.Addr, proxy.CertificateAuthority)
+	res, err := client.Get(srv.URL + "/list?sort=z%2Da&page=1&debug=1&tag=a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantStatus(t, res, http.StatusOK)
+	wantBody(t, res, "ok")
+
+	want := "/list?sort=z%2Da&page=2&tag=a&tag=b+c"
+	if got := <-received; got != want {
+		t.Errorf("wrong URL sent upstream, want %q, got %q", want, got)
+	}
+}
@@ -0,0 +1,125 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// connCloser closes the upstream connection when the response body is
+// closed.
+type connCloser struct {
+	io.Reader
+	conn net.Conn
+	done chan struct{}
+	once sync.Once
+}
+
+func (c *connCloser) Close() error {
+	c.once.Do(func() { close(c.done) })
+	return c.conn.Close()
+}
+
+// forwardRaw writes event.RawUpstream (or the request if it is nil) to a new
+// connection to the target of the request and reads the response. The raw
+// bytes are sent verbatim, without any normalization of the request line or
+// the headers. Requests are always sent directly to the upstream server with
+// HTTP/1.1, the upstream proxy is not used. If rec is not nil, all bytes read
+// from the connection are appended to it.
+func (p *Proxy) forwardRaw(event *Event, rec *bytes.Buffer) (*http.Response, error) {
+	target := event.Req.URL
+
+	port := target.Port()
+	if port == "" {
+		port = defaultPorts[target.Scheme]
+	}
+	addr := net.JoinHostPort(target.Hostname(), port)
+
+	dial := (&net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}).DialContext
+
+	// use the dialer set with SetUpstreamDialer
+	if p.transport.DialContext != nil {
+		dial = p.transport.DialContext
+	}
+
+	ctx := event.Req.Context()
+	conn, err := dial(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	if target.Scheme == "https" {
+		cfg := &tls.Config{}
+		if c := p.clientConfigFor(target.Hostname()); c != nil {
+			cfg = c.Clone()
+		}
+		if cfg.ServerName == "" {
+			cfg.ServerName = target.Hostname()
+		}
+		// the raw bytes are HTTP/1.x, so don't negotiate HTTP2
+		cfg.NextProtos = []string{"http/1.1"}
+
+		tlsConn := tls.Client(conn, cfg)
+		err = tlsConn.Handshake()
+		if err != nil {
+			_ = conn.Close()
+			return nil, &UpstreamError{Kind: ErrUpstreamTLS, Err: err}
+		}
+		conn = tlsConn
+	}
+
+	closer := &connCloser{conn: conn, done: make(chan struct{})}
+
+	// abort when the request is cancelled
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = conn.Close()
+		case <-closer.done:
+		}
+	}()
+
+	if event.RawUpstream != nil {
+		_, err = conn.Write(event.RawUpstream)
+		if err != nil {
+			_ = closer.Close()
+			return nil, fmt.Errorf("writing raw request: %v", err)
+		}
+	} else {
+		err = event.Req.Write(conn)
+		if err != nil {
+			_ = closer.Close()
+			return nil, fmt.Errorf("writing request: %v", err)
+		}
+	}
+
+	var rd io.Reader = conn
+	if rec != nil {
+		rd = io.TeeReader(conn, rec)
+	}
+	br := bufio.NewReader(rd)
+
+	res, err := http.ReadResponse(br, event.Req)
+	// skip informational responses like 100 (Continue)
+	for err == nil && res.StatusCode >= 100 && res.StatusCode < 200 && res.StatusCode != http.StatusSwitchingProtocols {
+		p.informationalResponse(event, res.StatusCode, res.Header)
+		res, err = http.ReadResponse(br, event.Req)
+	}
+	if err != nil {
+		_ = closer.Close()
+		return nil, fmt.Errorf("reading response: %v", err)
+	}
+
+	closer.Reader = res.Body
+	res.Body = closer
+	return res, nil
+}
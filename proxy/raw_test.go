@@ -0,0 +1,124 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"testing"
+)
+
+func TestProxyRawUpstream(t *testing.T) {
+	upstream := newLocalListener(t)
+	defer upstream.Close()
+
+	// a request with conflicting framing, the server must see it verbatim
+	raw := []byte(fmt.Sprintf("POST /smuggle HTTP/1.1\r\n"+
+		"Host: %v\r\n"+
+		"Content-Length: 4\r\n"+
+		"Transfer-Encoding: chunked\r\n"+
+		"Transfer-Encoding: identity\r\n"+
+		"\r\n"+
+		"0\r\n\r\nGET /admin HTTP/1.1\r\n\r\n", upstream.Addr()))
+
+	received := make(chan []byte, 1)
+	go func() {
+		conn, err := upstream.Accept()
+		if err != nil {
+			received <- nil
+			return
+		}
+		defer conn.Close()
+
+		buf := make([]byte, len(raw))
+		_, err = io.ReadFull(conn, buf)
+		received <- buf
+		if err != nil {
+			return
+		}
+
+		_, _ = io.WriteString(conn, "HTTP/1.1 200 OK\r\nContent-Length: 3\r\nConnection: close\r\n\r\nraw")
+	}()
+
+	proxy, serve, shutdown := TestProxy(t, nil)
+	go serve()
+	defer shutdown()
+
+	proxy.Register(func(event *Event) (*Response, error) {
+		event.RawUpstream = raw
+		return event.ForwardRequest()
+	})
+
+	client := testClient(t, proxy.Addr, proxy.CertificateAuthority)
+	res, err := client.Post("http://"+upstream.Addr().String()+"/smuggle", "text/plain", bytes.NewReader([]byte("body")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantStatus(t, res, http.StatusOK)
+	wantBody(t, res, "raw")
+
+	buf := <-received
+	if !bytes.Equal(buf, raw) {
+		t.Errorf("upstream received wrong bytes, want:\n%q\ngot:\n%q", raw, buf)
+	}
+}
+
+func TestProxyCaptureRawResponses(t *testing.T) {
+	upstream := newLocalListener(t)
+	defer upstream.Close()
+
+	// unusual header casing and order, an informational response and a
+	// chunked body, all must be recorded verbatim
+	raw := "HTTP/1.1 100 Continue\r\n\r\n" +
+		"HTTP/1.1 200 Okay\r\n" +
+		"x-lower-case: a\r\n" +
+		"Content-Type:text/plain\r\n" +
+		"X-UPPER-CASE: b\r\n" +
+		"x-lower-case: c\r\n" +
+		"Transfer-Encoding: chunked\r\n" +
+		"\r\n" +
+		"3;ext=1\r\nfoo\r\n3\r\nbar\r\n0\r\n\r\n"
+
+	go func() {
+		conn, err := upstream.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		_, err = http.ReadRequest(bufio.NewReader(conn))
+		if err != nil {
+			return
+		}
+
+		_, _ = io.WriteString(conn, raw)
+	}()
+
+	proxy, serve, shutdown := TestProxy(t, nil)
+	go serve()
+	defer shutdown()
+
+	proxy.CaptureRawResponses = true
+
+	captured := make(chan []byte, 1)
+	proxy.Register(func(event *Event) (*Response, error) {
+		res, err := event.ForwardRequest()
+		captured <- event.RawUpstreamResponse
+		return res, err
+	})
+
+	client := testClient(t, proxy.Addr, proxy.CertificateAuthority)
+	res, err := client.Get("http://" + upstream.Addr().String() + "/raw")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantStatus(t, res, http.StatusOK)
+	wantHeader(t, res, map[string]string{"X-Lower-Case": "a"})
+	wantBody(t, res, "foobar")
+
+	buf := <-captured
+	if string(buf) != raw {
+		t.Errorf("wrong raw response captured, want:\n%q\ngot:\n%q", raw, buf)
+	}
+}
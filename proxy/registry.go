@@ -0,0 +1,111 @@
+package proxy
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+// HookInfo describes a hook registered with RegisterNamed.
+type HookInfo struct {
+	Name string `json:"name"`
+
+	// Scope describes which requests the hook affects.
+	Scope string `json:"scope"`
+
+	Enabled bool `json:"enabled"`
+}
+
+type namedHook struct {
+	name, scope string
+	enabled     int32 // accessed atomically
+	removed     int32 // accessed atomically
+}
+
+func (h *namedHook) active() bool {
+	return atomic.LoadInt32(&h.enabled) == 1 && atomic.LoadInt32(&h.removed) == 0
+}
+
+// RegisterNamed registers f in the pipeline like Register, under a name so
+// that it can be disabled later on. Disabled hooks pass the event on to the
+// next one unchanged.
+func (p *Proxy) RegisterNamed(name, scope string, f func(*Event) (*Response, error)) error {
+	p.hooksMu.Lock()
+	defer p.hooksMu.Unlock()
+
+	for _, h := range p.namedHooks {
+		if h.name == name {
+			return fmt.Errorf("hook %q already registered", name)
+		}
+	}
+
+	h := &namedHook{name: name, scope: scope, enabled: 1}
+	p.namedHooks = append(p.namedHooks, h)
+
+	p.Register(func(event *Event) (*Response, error) {
+		if !h.active() {
+			return event.ForwardRequest()
+		}
+		return f(event)
+	})
+
+	return nil
+}
+
+func (p *Proxy) findHook(name string) (int, error) {
+	for i, h := range p.namedHooks {
+		if h.name == name {
+			return i, nil
+		}
+	}
+	return -1, fmt.Errorf("unknown hook %q", name)
+}
+
+// Unregister removes the hook registered as name, new requests are not passed
+// to it anymore.
+func (p *Proxy) Unregister(name string) error {
+	p.hooksMu.Lock()
+	defer p.hooksMu.Unlock()
+
+	i, err := p.findHook(name)
+	if err != nil {
+		return err
+	}
+
+	atomic.StoreInt32(&p.namedHooks[i].removed, 1)
+	p.namedHooks = append(p.namedHooks[:i], p.namedHooks[i+1:]...)
+	return nil
+}
+
+// SetHookEnabled enables or disables the hook registered as name.
+func (p *Proxy) SetHookEnabled(name string, enabled bool) error {
+	p.hooksMu.Lock()
+	defer p.hooksMu.Unlock()
+
+	i, err := p.findHook(name)
+	if err != nil {
+		return err
+	}
+
+	var v int32
+	if enabled {
+		v = 1
+	}
+	atomic.StoreInt32(&p.namedHooks[i].enabled, v)
+	return nil
+}
+
+// Hooks returns the named hooks in the order they were registered.
+func (p *Proxy) Hooks() []HookInfo {
+	p.hooksMu.Lock()
+	defer p.hooksMu.Unlock()
+
+	list := make([]HookInfo, 0, len(p.namedHooks))
+	for _, h := range p.namedHooks {
+		list = append(list, HookInfo{
+			Name:    h.name,
+			Scope:   h.scope,
+			Enabled: atomic.LoadInt32(&h.enabled) == 1,
+		})
+	}
+	return list
+}
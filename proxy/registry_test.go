@@ -0,0 +1,87 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestProxyNamedHooks(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		_, _ = io.WriteString(rw, "ok")
+	}))
+	defer srv.Close()
+
+	proxy, serve, shutdown := TestProxy(t, nil)
+	go serve()
+	defer shutdown()
+
+	header := func(name string) func(*Event) (*Response, error) {
+		return func(event *Event) (*Response, error) {
+			res, err := event.ForwardRequest()
+			if err != nil {
+				return nil, err
+			}
+			res.Header.Set(name, "1")
+			return res, nil
+		}
+	}
+
+	for _, name := range []string{"first", "second"} {
+		err := proxy.RegisterNamed(name, "all requests", header("X-"+name))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := proxy.RegisterNamed("first", "", header("X-Other")); err == nil {
+		t.Error("registering a duplicate name did not return an error")
+	}
+
+	client := testClient(t, proxy.Addr, proxy.CertificateAuthority)
+	get := func(first, second string) {
+		t.Helper()
+		res, err := client.Get(srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wantStatus(t, res, http.StatusOK)
+		wantHeader(t, res, map[string]string{"X-first": first, "X-second": second})
+		wantBody(t, res, "ok")
+	}
+
+	get("1", "1")
+
+	err := proxy.SetHookEnabled("first", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []HookInfo{
+		{Name: "first", Scope: "all requests", Enabled: false},
+		{Name: "second", Scope: "all requests", Enabled: true},
+	}
+	if hooks := proxy.Hooks(); !reflect.DeepEqual(hooks, want) {
+		t.Errorf("wrong hooks listed, want %v, got %v", want, hooks)
+	}
+
+	get("", "1")
+
+	err = proxy.SetHookEnabled("first", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	get("1", "1")
+
+	err = proxy.Unregister("second")
+	if err != nil {
+		t.Fatal(err)
+	}
+	get("1", "")
+
+	if err := proxy.SetHookEnabled("second", true); err == nil {
+		t.Error("enabling an unregistered hook did not return an error")
+	}
+}
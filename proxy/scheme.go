@@ -0,0 +1,94 @@
+package proxy
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// defaultPorts contains the default port for each scheme.
+var defaultPorts = map[string]string{
+	"http":  "80",
+	"https": "443",
+}
+
+// SetSchemeOverride configures the proxy to connect to host (without port)
+// using scheme ("http" or "https") regardless of the scheme the client used,
+// e.g. to terminate TLS from the client but talk plain HTTP to the upstream
+// server. Default ports are translated, other ports are kept. An empty scheme
+// removes the override.
+func (p *Proxy) SetSchemeOverride(host, scheme string) {
+	host = strings.ToLower(host)
+
+	p.schemeMu.Lock()
+	defer p.schemeMu.Unlock()
+
+	if scheme == "" {
+		delete(p.schemeOverrides, host)
+		return
+	}
+
+	if p.schemeOverrides == nil {
+		p.schemeOverrides = make(map[string]string)
+	}
+	p.schemeOverrides[host] = scheme
+}
+
+// schemeOverride returns the scheme configured for host, if any.
+func (p *Proxy) schemeOverride(host string) (string, bool) {
+	p.schemeMu.Lock()
+	defer p.schemeMu.Unlock()
+
+	scheme, ok := p.schemeOverrides[strings.ToLower(host)]
+	return scheme, ok
+}
+
+// stripDefaultPort returns hostport with the port removed if it is the
+// default port for scheme.
+func stripDefaultPort(hostport, scheme string) string {
+	host, port, err := net.SplitHostPort(hostport)
+	if err != nil || port != defaultPorts[scheme] {
+		return hostport
+	}
+
+	if strings.Contains(host, ":") {
+		// IPv6 address
+		return "[" + host + "]"
+	}
+	return host
+}
+
+// applySchemeOverride changes the scheme of the prepared request according to
+// the overrides. It returns a function which translates absolute URLs in the
+// response back to the scheme the client used, or nil if nothing was changed.
+func (p *Proxy) applySchemeOverride(event *Event) func(*http.Response) {
+	u := event.Req.URL
+	scheme, ok := p.schemeOverride(u.Hostname())
+	if !ok || scheme == u.Scheme {
+		return nil
+	}
+
+	origScheme := u.Scheme
+	event.Log("changing scheme for %v from %v to %v", u.Host, origScheme, scheme)
+
+	u.Host = stripDefaultPort(u.Host, origScheme)
+	u.Scheme = scheme
+	event.Req.Host = stripDefaultPort(event.Req.Host, origScheme)
+
+	return func(res *http.Response) {
+		location := res.Header.Get("Location")
+		if location == "" {
+			return
+		}
+
+		loc, err := url.Parse(location)
+		if err != nil || loc.Scheme != scheme || !strings.EqualFold(loc.Hostname(), u.Hostname()) {
+			return
+		}
+
+		loc.Host = stripDefaultPort(loc.Host, scheme)
+		loc.Scheme = origScheme
+		res.Header.Set("Location", loc.String())
+	}
+}
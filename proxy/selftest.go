@@ -0,0 +1,148 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/fd0/osmosis/certauth"
+)
+
+// selfTestTimeout limits the time for each stage of SelfTest.
+const selfTestTimeout = 30 * time.Second
+
+// SelfTest starts a proxy using ca on a local port and requests the HTTPS
+// target through it like a client trusting ca would. Each stage (CONNECT,
+// TLS handshake with the generated certificate, upstream request, response)
+// is reported to out as passed or failed. The returned error is the one of
+// the first failed stage. The clientConfig is used for the upstream
+// connections, as in New.
+func SelfTest(target string, ca *certauth.CertificateAuthority, clientConfig *tls.Config, out io.Writer) error {
+	report := func(stage string, err error, msg string, args ...interface{}) error {
+		if err != nil {
+			fmt.Fprintf(out, "FAIL %-12s %v\n", stage, err)
+			return fmt.Errorf("%v: %v", stage, err)
+		}
+		fmt.Fprintf(out, "PASS %-12s "+msg+"\n", append([]interface{}{stage}, args...)...)
+		return nil
+	}
+
+	u, err := url.Parse(target)
+	if err == nil && u.Scheme != "https" {
+		err = errors.New("only HTTPS URLs can be tested")
+	}
+	if err != nil {
+		return report("url", err, "")
+	}
+
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), "443")
+	}
+
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		return report("listen", err, "")
+	}
+
+	p := New(listener.Addr().String(), ca, clientConfig, nil)
+
+	upstreamErr := make(chan error, 1)
+	p.Register(func(event *Event) (*Response, error) {
+		res, err := event.ForwardRequest()
+		upstreamErr <- err
+		return res, err
+	})
+
+	go func() {
+		_ = p.Serve(listener)
+	}()
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = p.Shutdown(ctx)
+	}()
+
+	// stage 1: establish a tunnel
+	conn, err := net.DialTimeout("tcp", listener.Addr().String(), selfTestTimeout)
+	if err != nil {
+		return report("connect", err, "")
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(selfTestTimeout))
+
+	rd := bufio.NewReader(conn)
+	_, err = fmt.Fprintf(conn, "CONNECT %s HTTP/1.1\r\nHost: %s\r\n\r\n", addr, addr)
+	if err == nil {
+		var res *http.Response
+		res, err = http.ReadResponse(rd, nil)
+		if err == nil && res.StatusCode != http.StatusOK {
+			err = fmt.Errorf("proxy returned %v", res.Status)
+		}
+	}
+	if err = report("connect", err, "tunnel to %v established", addr); err != nil {
+		return err
+	}
+
+	// stage 2: TLS handshake with the certificate generated by the proxy
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.Certificate)
+
+	tlsConn := tls.Client(buffConn{Reader: rd, Conn: conn}, &tls.Config{
+		RootCAs:    pool,
+		ServerName: u.Hostname(),
+		NextProtos: []string{"http/1.1"},
+	})
+	err = tlsConn.Handshake()
+	var subject string
+	if err == nil {
+		subject = tlsConn.ConnectionState().PeerCertificates[0].Subject.String()
+	}
+	if err = report("handshake", err, "certificate %q verified with the CA", subject); err != nil {
+		return err
+	}
+
+	// stage 3: the proxy requests the target from the upstream server
+	req, err := http.NewRequest(http.MethodGet, target, nil)
+	if err != nil {
+		return report("request", err, "")
+	}
+	req.Header.Set("Connection", "close")
+
+	err = req.Write(tlsConn)
+	if err != nil {
+		return report("request", err, "")
+	}
+
+	tlsReader := bufio.NewReader(tlsConn)
+	res, resErr := http.ReadResponse(tlsReader, nil)
+
+	select {
+	case err = <-upstreamErr:
+	case <-time.After(selfTestTimeout):
+		err = errors.New("request was not forwarded")
+	}
+	if err = report("upstream", err, "%v fetched", target); err != nil {
+		return err
+	}
+
+	// stage 4: the client receives the response
+	if resErr == nil {
+		_, resErr = io.Copy(ioutil.Discard, res.Body)
+		_ = res.Body.Close()
+	}
+	var status string
+	if res != nil {
+		status = res.Status
+	}
+	return report("response", resErr, "received %v", status)
+}
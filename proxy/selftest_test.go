@@ -0,0 +1,47 @@
+package proxy
+
+import (
+	"bytes"
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/fd0/osmosis/certauth"
+)
+
+func TestSelfTest(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		_, _ = rw.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	var buf bytes.Buffer
+	err := SelfTest(srv.URL, certauth.TestCA(t), &tls.Config{InsecureSkipVerify: true}, &buf)
+	if err != nil {
+		t.Fatalf("self test failed: %v\n%s", err, buf.String())
+	}
+
+	for _, stage := range []string{"connect", "handshake", "upstream", "response"} {
+		if !strings.Contains(buf.String(), "PASS "+stage) {
+			t.Errorf("stage %v did not pass:\n%s", stage, buf.String())
+		}
+	}
+}
+
+func TestSelfTestUpstreamFailure(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {}))
+	defer srv.Close()
+
+	// the certificate of the test server is not trusted by default
+	var buf bytes.Buffer
+	err := SelfTest(srv.URL, certauth.TestCA(t), nil, &buf)
+	if err == nil {
+		t.Fatalf("self test succeeded unexpectedly:\n%s", buf.String())
+	}
+
+	if !strings.Contains(buf.String(), "PASS handshake") || !strings.Contains(buf.String(), "FAIL upstream") {
+		t.Errorf("unexpected output:\n%s", buf.String())
+	}
+}
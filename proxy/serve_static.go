@@ -2,10 +2,14 @@ package proxy
 
 import "net/http"
 
+// CAPath is the path at which the CA certificate is also available for
+// direct requests to the listener, without using the proxy.
+const CAPath = "/osmosis-ca.pem"
+
 // ServeStatic returns the PEM encoded CA certificate.
 func ServeStatic(rw http.ResponseWriter, req *http.Request, cert []byte) {
 	switch req.URL.Path {
-	case "/ca":
+	case "/ca", CAPath:
 		rw.Header().Set("Content-Type", "application/x-x509-ca-cert")
 		rw.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
 		rw.Header().Set("Pragma", "no-cache")
@@ -0,0 +1,72 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+)
+
+// ListenAndServeTLS starts the listener and runs the proxy like
+// ListenAndServe, but terminates TLS on the listener itself, see ServeTLS.
+func (p *Proxy) ListenAndServeTLS() error {
+	p.logger.Printf("Listening on %s (TLS)\n", p.server.Addr)
+	listener, err := net.Listen("tcp", p.server.Addr)
+	if err != nil {
+		return err
+	}
+
+	return p.ServeTLS(listener)
+}
+
+// ServeTLS runs the proxy for clients which connect directly with TLS instead
+// of sending CONNECT requests, e.g. in transparent deployments. The
+// certificate for each connection is taken from the cache for the server
+// name the client sent (SNI), connections without a server name are
+// rejected. The decrypted requests are sent to the host named in the request
+// via HTTPS, on port 443 unless the Host header contains a port.
+func (p *Proxy) ServeTLS(listener net.Listener) error {
+	cfg := p.serverConfig.Clone()
+	cfg.GetCertificate = func(ch *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		if ch.ServerName == "" {
+			return nil, errors.New("client did not send a server name (SNI)")
+		}
+		return p.Cache.Get(context.Background(), net.JoinHostPort(ch.ServerName, "443"), ch.ServerName)
+	}
+
+	if p.CaptureRaw {
+		// the capture wraps the TLS connection, so the server can't
+		// negotiate HTTP2 on it
+		cfg.NextProtos = []string{"http/1.1"}
+	}
+
+	return p.Serve(tls.NewListener(listener, cfg))
+}
+
+type directTLSKey struct{}
+
+// connContext is the ConnContext of the server. It marks connections on
+// which TLS was terminated by ServeTLS.
+func connContext(ctx context.Context, conn net.Conn) context.Context {
+	ctx = captureConnContext(ctx, conn)
+
+	if c, ok := conn.(*captureConn); ok {
+		conn = c.Conn
+	}
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		ctx = context.WithValue(ctx, directTLSKey{}, tlsConn)
+	}
+	return ctx
+}
+
+// directTLSTarget returns the host and port to send a request received by
+// ServeTLS to.
+func directTLSTarget(host, serverName string) string {
+	if host == "" {
+		host = serverName
+	}
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		host = net.JoinHostPort(host, "443")
+	}
+	return host
+}
@@ -0,0 +1,92 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fd0/osmosis/certauth"
+)
+
+func TestProxyServeTLS(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		_, _ = io.WriteString(rw, "hello from "+req.Host+req.URL.Path)
+	}))
+	defer srv.Close()
+
+	listener := newLocalListener(t)
+	proxy := New(listener.Addr().String(), certauth.TestCA(t), srv.Client().Transport.(*http.Transport).TLSClientConfig, nil)
+	proxy.Cache.NoClone = true
+
+	// the test server's certificate is valid for example.com
+	dialed := make(chan string, 1)
+	proxy.SetUpstreamDialer(func(ctx context.Context, network, addr string) (net.Conn, error) {
+		select {
+		case dialed <- addr:
+		default:
+		}
+		var d net.Dialer
+		return d.DialContext(ctx, network, srv.Listener.Addr().String())
+	})
+
+	go func() {
+		err := proxy.ServeTLS(listener)
+		if err != http.ErrServerClosed {
+			t.Error(err)
+		}
+	}()
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		err := proxy.Shutdown(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	certPool := x509.NewCertPool()
+	certPool.AddCert(proxy.CertificateAuthority.Certificate)
+
+	// connect directly to the proxy without a CONNECT request
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, network, proxy.Addr)
+			},
+			TLSClientConfig: &tls.Config{RootCAs: certPool},
+		},
+	}
+
+	res, err := client.Get("https://example.com/direct")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantStatus(t, res, http.StatusOK)
+	wantBody(t, res, "hello from example.com/direct")
+
+	if res.TLS == nil || len(res.TLS.PeerCertificates) == 0 {
+		t.Fatal("response was not received via TLS")
+	}
+	crt := res.TLS.PeerCertificates[0]
+	if err := crt.VerifyHostname("example.com"); err != nil {
+		t.Errorf("certificate does not match the server name: %v", err)
+	}
+
+	if upstreamAddr := <-dialed; upstreamAddr != "example.com:443" {
+		t.Errorf("wrong upstream address, want example.com:443, got %v", upstreamAddr)
+	}
+
+	// connections without SNI are rejected
+	conn, err := tls.Dial("tcp", proxy.Addr, &tls.Config{InsecureSkipVerify: true})
+	if err == nil {
+		_ = conn.Close()
+		t.Error("handshake without server name succeeded")
+	}
+}
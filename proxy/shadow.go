@@ -0,0 +1,421 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httputil"
+	"sort"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context/ctxhttp"
+)
+
+// Shadow configures the proxy to send each request to a second (shadow)
+// upstream as well and record the differences between both responses. Both
+// responses are read completely, except for responses which are passed
+// through to the client (e.g. streams), these are not compared. The primary
+// response is sent to the client right away, the shadow response is compared
+// in the background. The results for the last 1000 requests are kept in
+// memory, OnResult receives all of them.
+type Shadow struct {
+	// Host and Scheme of the shadow upstream.
+	Host, Scheme string
+
+	// ReturnShadow selects the shadow response to be returned to the client
+	// instead of the response from the original upstream. The client waits
+	// for both responses in this case.
+	ReturnShadow bool
+
+	// OnResult, if set, is called for each result, e.g. to store it with the
+	// transaction. It may be called from another goroutine after the response
+	// has been sent to the client.
+	OnResult func(event *Event, result *ShadowResult)
+
+	m       sync.Mutex
+	results map[uint64]*ShadowResult
+	order   []uint64
+
+	// pending is the number of comparisons running in the background, idle
+	// is closed when it drops to zero
+	pending int
+	idle    chan struct{}
+}
+
+const (
+	// shadowMaxResults is the number of results kept, the oldest result is
+	// removed first.
+	shadowMaxResults = 1000
+
+	// shadowDrainSize is the number of bytes read from the body of a
+	// discarded shadow response, so that the connection can be reused.
+	shadowDrainSize = 256 << 10
+)
+
+// shadowTimeout limits the time for a request to the shadow upstream,
+// including reading the response body.
+var shadowTimeout = time.Minute
+
+// errShadowPassthrough is recorded if the responses were not compared
+// because one of them is passed through to the client.
+var errShadowPassthrough = errors.New("response is passed through, not compared")
+
+// ShadowResult contains both responses for a request and their differences.
+type ShadowResult struct {
+	ID uint64
+
+	// Primary and Shadow contain the raw responses.
+	Primary, Shadow []byte
+
+	// Err is set if the request to the shadow upstream failed.
+	Err error
+
+	// Diff lists the differences between both responses, it is empty if the
+	// responses are the same.
+	Diff []string
+}
+
+// shadowIgnoreHeaders contains header names which are expected to differ
+// between two responses or are covered by comparing the body.
+var shadowIgnoreHeaders = map[string]struct{}{
+	"Date":           struct{}{},
+	"Content-Length": struct{}{},
+}
+
+// Result returns the result recorded for the request with the given ID.
+func (s *Shadow) Result(id uint64) (*ShadowResult, bool) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	res, ok := s.results[id]
+	return res, ok
+}
+
+// Results returns all recorded results ordered by ID.
+func (s *Shadow) Results() []*ShadowResult {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	list := make([]*ShadowResult, 0, len(s.results))
+	for _, res := range s.results {
+		list = append(list, res)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list
+}
+
+// record keeps the result for the event and passes it to OnResult.
+func (s *Shadow) record(event *Event, res *ShadowResult) {
+	if s.OnResult != nil {
+		s.OnResult(event, res)
+	}
+
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if s.results == nil {
+		s.results = make(map[uint64]*ShadowResult)
+	}
+	if _, ok := s.results[res.ID]; !ok {
+		s.order = append(s.order, res.ID)
+	}
+	s.results[res.ID] = res
+
+	for len(s.order) > shadowMaxResults {
+		delete(s.results, s.order[0])
+		s.order = s.order[1:]
+	}
+}
+
+// Wait waits until the comparisons running in the background are done or ctx
+// is cancelled.
+func (s *Shadow) Wait(ctx context.Context) error {
+	s.m.Lock()
+	if s.pending == 0 {
+		s.m.Unlock()
+		return nil
+	}
+	idle := s.idle
+	s.m.Unlock()
+
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// begin registers a comparison running in the background, the returned
+// function must be called when it is done.
+func (s *Shadow) begin() (done func()) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if s.pending == 0 {
+		s.idle = make(chan struct{})
+	}
+	s.pending++
+
+	return func() {
+		s.m.Lock()
+		defer s.m.Unlock()
+
+		s.pending--
+		if s.pending == 0 {
+			close(s.idle)
+		}
+	}
+}
+
+type shadowResponse struct {
+	res *http.Response
+	err error
+}
+
+// cancelBody cancels the context of the shadow request when the body is
+// closed.
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// start sends a copy of the event's request to the shadow upstream in the
+// background. The request body is replaced so that it can be read again. The
+// request is cancelled with ctx only if ReturnShadow is set, otherwise it may
+// outlive the client's request.
+func (s *Shadow) start(ctx context.Context, client *http.Client, event *Event) (<-chan shadowResponse, error) {
+	req, err := CloneRequest(event.Req)
+	if err != nil {
+		return nil, fmt.Errorf("reading body for shadow request: %v", err)
+	}
+
+	if !s.ReturnShadow {
+		ctx = context.Background()
+	}
+	ctx, cancel := context.WithTimeout(ctx, shadowTimeout)
+
+	req = req.WithContext(ctx)
+	req.URL.Host = s.Host
+	if s.Scheme != "" {
+		req.URL.Scheme = s.Scheme
+	}
+	req.Host = s.Host
+
+	ch := make(chan shadowResponse, 1)
+	go func() {
+		res, err := ctxhttp.Do(ctx, client, req)
+		if err != nil {
+			cancel()
+		} else {
+			res.Body = cancelBody{ReadCloser: res.Body, cancel: cancel}
+		}
+		ch <- shadowResponse{res: res, err: err}
+	}()
+
+	return ch, nil
+}
+
+// discard drains and closes the shadow response in the background, e.g.
+// when the request to the primary upstream failed.
+func (s *Shadow) discard(ch <-chan shadowResponse) {
+	go func() {
+		shadow := <-ch
+		if shadow.res != nil {
+			discardResponse(shadow.res)
+		}
+	}()
+}
+
+// discardResponse reads up to shadowDrainSize bytes of the body and closes it.
+func discardResponse(res *http.Response) {
+	_, _ = io.Copy(ioutil.Discard, io.LimitReader(res.Body, shadowDrainSize))
+	_ = res.Body.Close()
+}
+
+// finish records the differences between the primary and the shadow response
+// and returns the response to be sent to the client. Unless ReturnShadow is
+// set, the primary response is returned without waiting for the shadow
+// response, which is compared in the background. Responses for which
+// passthrough returns true are not read, the primary response is returned in
+// this case.
+func (s *Shadow) finish(event *Event, primary *http.Response, ch <-chan shadowResponse, passthrough func(*http.Response) bool) (*http.Response, error) {
+	result := &ShadowResult{ID: event.ID}
+
+	if passthrough(primary) {
+		result.Err = errShadowPassthrough
+		s.discard(ch)
+		s.record(event, result)
+		return primary, nil
+	}
+
+	var err error
+	result.Primary, err = dumpResponseBody(primary)
+	if err != nil {
+		s.discard(ch)
+		s.record(event, result)
+		return nil, err
+	}
+
+	if !s.ReturnShadow {
+		// compare a copy, the primary response is sent to the client
+		// meanwhile
+		clone, err := CloneResponse(&Response{primary})
+		if err != nil {
+			s.discard(ch)
+			return nil, err
+		}
+
+		done := s.begin()
+		go func() {
+			defer done()
+			shadow := s.compare(result, clone.Response, ch, passthrough)
+			if shadow != nil {
+				_ = shadow.Body.Close()
+			}
+			s.record(event, result)
+		}()
+		return primary, nil
+	}
+
+	shadow := s.compare(result, primary, ch, passthrough)
+	s.record(event, result)
+	if shadow != nil {
+		return shadow, nil
+	}
+	return primary, nil
+}
+
+// compare waits for the shadow response and records the differences to the
+// primary response in result. It returns the shadow response with a readable
+// body, or nil if it could not be compared.
+func (s *Shadow) compare(result *ShadowResult, primary *http.Response, ch <-chan shadowResponse, passthrough func(*http.Response) bool) *http.Response {
+	shadow := <-ch
+	if shadow.err != nil {
+		result.Err = shadow.err
+		return nil
+	}
+
+	if passthrough(shadow.res) {
+		// streams may never end, don't read them
+		result.Err = errShadowPassthrough
+		_ = shadow.res.Body.Close()
+		return nil
+	}
+
+	var err error
+	result.Shadow, err = dumpResponseBody(shadow.res)
+	if err != nil {
+		result.Err = err
+		_ = shadow.res.Body.Close()
+		return nil
+	}
+
+	result.Diff = DiffResponses(primary, shadow.res)
+	return shadow.res
+}
+
+// dumpResponseBody returns the raw response and leaves the body readable.
+func dumpResponseBody(res *http.Response) ([]byte, error) {
+	_, err := readWithoutClose(&res.Body)
+	if err != nil {
+		return nil, err
+	}
+	return httputil.DumpResponse(res, true)
+}
+
+// DiffResponses compares status, headers and body of two responses and
+// returns a description of each difference. Headers which are expected to
+// differ (e.g. Date) are ignored. Both bodies are read and remain readable.
+func DiffResponses(a, b *http.Response) []string {
+	var diff []string
+
+	if a.StatusCode != b.StatusCode {
+		diff = append(diff, fmt.Sprintf("status: %d != %d", a.StatusCode, b.StatusCode))
+	}
+
+	diff = append(diff, diffHeaders(a.Header, b.Header)...)
+	diff = append(diff, diffBodies(&a.Body, &b.Body)...)
+
+	return diff
+}
+
+// DiffRequests compares method, URL, headers and body of two requests like
+// DiffResponses. Both bodies are read and remain readable.
+func DiffRequests(a, b *http.Request) []string {
+	var diff []string
+
+	if a.Method != b.Method {
+		diff = append(diff, fmt.Sprintf("method: %v != %v", a.Method, b.Method))
+	}
+
+	if a.URL.String() != b.URL.String() {
+		diff = append(diff, fmt.Sprintf("URL: %v != %v", a.URL, b.URL))
+	}
+
+	diff = append(diff, diffHeaders(a.Header, b.Header)...)
+	diff = append(diff, diffBodies(&a.Body, &b.Body)...)
+
+	return diff
+}
+
+// diffHeaders compares two headers, ignoring shadowIgnoreHeaders.
+func diffHeaders(a, b http.Header) []string {
+	var diff []string
+
+	names := make(map[string]struct{})
+	for name := range a {
+		names[name] = struct{}{}
+	}
+	for name := range b {
+		names[name] = struct{}{}
+	}
+
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		if _, ok := shadowIgnoreHeaders[name]; ok {
+			continue
+		}
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+
+	for _, name := range sorted {
+		va := fmt.Sprintf("%q", a[name])
+		vb := fmt.Sprintf("%q", b[name])
+		if va != vb {
+			diff = append(diff, fmt.Sprintf("header %v: %v != %v", name, va, vb))
+		}
+	}
+
+	return diff
+}
+
+// diffBodies compares two bodies, which remain readable. A nil body is
+// treated as empty.
+func diffBodies(a, b *io.ReadCloser) []string {
+	read := func(body *io.ReadCloser) []byte {
+		if *body == nil {
+			return nil
+		}
+		buf, _ := readWithoutClose(body)
+		return buf
+	}
+
+	bodyA, bodyB := read(a), read(b)
+	if !bytes.Equal(bodyA, bodyB) {
+		return []string{fmt.Sprintf("body: %d bytes != %d bytes", len(bodyA), len(bodyB))}
+	}
+	return nil
+}
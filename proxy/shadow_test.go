@@ -0,0 +1,395 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProxyShadow(t *testing.T) {
+	proxy, serve, shutdown := TestProxy(t, nil)
+	go serve()
+	defer shutdown()
+
+	primary := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		rw.Header().Set("X-Server", "primary")
+		rw.WriteHeader(http.StatusOK)
+		io.WriteString(rw, "primary "+string(body))
+	}))
+	defer primary.Close()
+
+	var shadowBody string
+	shadow := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		shadowBody = string(body)
+		rw.Header().Set("X-Server", "shadow")
+		rw.WriteHeader(http.StatusNotFound)
+		io.WriteString(rw, "shadow "+string(body))
+	}))
+	defer shadow.Close()
+
+	shadowURL, err := url.Parse(shadow.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var passed []*ShadowResult
+	proxy.Shadow = &Shadow{
+		Host:   shadowURL.Host,
+		Scheme: shadowURL.Scheme,
+		OnResult: func(event *Event, result *ShadowResult) {
+			if event.ID != result.ID {
+				t.Errorf("result for %d passed with event %d", result.ID, event.ID)
+			}
+			passed = append(passed, result)
+		},
+	}
+
+	client := testClient(t, proxy.Addr, proxy.CertificateAuthority)
+
+	res, err := client.Post(primary.URL, "text/plain", strings.NewReader("foo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the client receives the primary response
+	wantStatus(t, res, http.StatusOK)
+	wantBody(t, res, "primary foo")
+
+	// the shadow response is compared in the background
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err = proxy.Shadow.Wait(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if shadowBody != "foo" {
+		t.Errorf("shadow upstream received wrong body: %q", shadowBody)
+	}
+
+	results := proxy.Shadow.Results()
+	if len(results) != 1 {
+		t.Fatalf("wrong number of results recorded, want 1, got %d", len(results))
+	}
+
+	result := results[0]
+	if result.Err != nil {
+		t.Fatalf("shadow request failed: %v", result.Err)
+	}
+
+	want := []string{
+		"status: 200 != 404",
+		`header X-Server: ["primary"] != ["shadow"]`,
+		"body: 11 bytes != 10 bytes",
+	}
+
+	if strings.Join(result.Diff, "\n") != strings.Join(want, "\n") {
+		t.Errorf("wrong diff recorded:\nwant %q\ngot  %q", want, result.Diff)
+	}
+
+	if _, ok := proxy.Shadow.Result(result.ID); !ok {
+		t.Errorf("result for ID %d not found", result.ID)
+	}
+
+	if len(passed) != 1 || passed[0] != result {
+		t.Errorf("wrong results passed to OnResult: %v", passed)
+	}
+}
+
+func TestProxyShadowSlow(t *testing.T) {
+	proxy, serve, shutdown := TestProxy(t, nil)
+	go serve()
+	defer shutdown()
+
+	primary := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		io.WriteString(rw, "primary")
+	}))
+	defer primary.Close()
+
+	release := make(chan struct{})
+	shadow := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		<-release
+		io.WriteString(rw, "shadow")
+	}))
+	defer shadow.Close()
+
+	shadowURL, err := url.Parse(shadow.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy.Shadow = &Shadow{Host: shadowURL.Host, Scheme: shadowURL.Scheme}
+
+	client := testClient(t, proxy.Addr, proxy.CertificateAuthority)
+	client.Timeout = 5 * time.Second
+
+	// the primary response is not held back until the shadow answers
+	res, err := client.Get(primary.URL)
+	if err != nil {
+		close(release)
+		t.Fatal(err)
+	}
+	wantStatus(t, res, http.StatusOK)
+	wantBody(t, res, "primary")
+
+	if results := proxy.Shadow.Results(); len(results) != 0 {
+		t.Errorf("result recorded before the shadow responded: %v", results)
+	}
+
+	close(release)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err = proxy.Shadow.Wait(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	results := proxy.Shadow.Results()
+	if len(results) != 1 || results[0].Err != nil || len(results[0].Diff) == 0 {
+		t.Errorf("wrong results recorded: %v", results)
+	}
+}
+
+func TestProxyReturnShadowStream(t *testing.T) {
+	proxy, serve, shutdown := TestProxy(t, nil)
+	go serve()
+	defer shutdown()
+
+	primary := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		io.WriteString(rw, "primary")
+	}))
+	defer primary.Close()
+
+	// the shadow sends an endless stream, which must not be read
+	done := make(chan struct{})
+	shadow := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Content-Type", "text/event-stream")
+		rw.WriteHeader(http.StatusOK)
+		rw.(http.Flusher).Flush()
+		for {
+			_, err := io.WriteString(rw, "data: more\n\n")
+			if err != nil {
+				return
+			}
+			rw.(http.Flusher).Flush()
+
+			select {
+			case <-req.Context().Done():
+				return
+			case <-done:
+				return
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+	}))
+	defer shadow.Close()
+	defer close(done)
+
+	shadowURL, err := url.Parse(shadow.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy.Shadow = &Shadow{Host: shadowURL.Host, Scheme: shadowURL.Scheme, ReturnShadow: true}
+
+	client := testClient(t, proxy.Addr, proxy.CertificateAuthority)
+	client.Timeout = 5 * time.Second
+
+	res, err := client.Get(primary.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantStatus(t, res, http.StatusOK)
+	wantBody(t, res, "primary")
+
+	results := proxy.Shadow.Results()
+	if len(results) != 1 || results[0].Err != errShadowPassthrough {
+		t.Errorf("wrong results recorded: %v", results)
+	}
+}
+
+func TestProxyShadowPassthrough(t *testing.T) {
+	proxy, serve, shutdown := TestProxy(t, nil)
+	go serve()
+	defer shutdown()
+
+	next := make(chan struct{})
+	primary := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Content-Type", "text/event-stream")
+		rw.WriteHeader(http.StatusOK)
+
+		io.WriteString(rw, "data: first\n\n")
+		rw.(http.Flusher).Flush()
+
+		// wait until the client has received the first event
+		<-next
+
+		io.WriteString(rw, "data: second\n\n")
+	}))
+	defer primary.Close()
+
+	shadow := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		io.WriteString(rw, "shadow")
+	}))
+	defer shadow.Close()
+
+	shadowURL, err := url.Parse(shadow.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy.Shadow = &Shadow{Host: shadowURL.Host, Scheme: shadowURL.Scheme}
+
+	client := testClient(t, proxy.Addr, proxy.CertificateAuthority)
+	client.Timeout = 10 * time.Second
+
+	res, err := client.Get(primary.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	// the stream is not buffered for the comparison
+	rd := bufio.NewReader(res.Body)
+	wantLine(t, rd, "data: first\n")
+	wantLine(t, rd, "\n")
+
+	close(next)
+
+	wantLine(t, rd, "data: second\n")
+	wantLine(t, rd, "\n")
+
+	results := proxy.Shadow.Results()
+	if len(results) != 1 || results[0].Err != errShadowPassthrough {
+		t.Errorf("wrong results recorded: %v", results)
+	}
+}
+
+// trackedBody is a response body which records that it was closed.
+type trackedBody struct {
+	io.Reader
+	closed chan struct{}
+}
+
+func (b trackedBody) Close() error {
+	close(b.closed)
+	return nil
+}
+
+func newTrackedResponse(contentType string) (*http.Response, chan struct{}) {
+	closed := make(chan struct{})
+	res := &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{contentType}},
+		Body:       trackedBody{Reader: strings.NewReader("body"), closed: closed},
+	}
+	return res, closed
+}
+
+func TestShadowClosesResponse(t *testing.T) {
+	var tests = []struct {
+		name string
+		// primary is the content type of the primary response, the request
+		// to the primary upstream failed if it is empty
+		primary, shadow string
+	}{
+		{"primary-failed", "", "text/plain"},
+		{"primary-passthrough", "text/event-stream", "text/plain"},
+		{"shadow-passthrough", "text/plain", "text/event-stream"},
+		{"compared", "text/plain", "text/plain"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := &Shadow{}
+			res, closed := newTrackedResponse(test.shadow)
+			ch := make(chan shadowResponse, 1)
+			ch <- shadowResponse{res: res}
+
+			if test.primary == "" {
+				s.discard(ch)
+			} else {
+				primary, _ := newTrackedResponse(test.primary)
+				_, err := s.finish(&Event{ID: 1}, primary, ch, isEventStream)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			select {
+			case <-closed:
+			case <-time.After(5 * time.Second):
+				t.Fatal("shadow response was not closed")
+			}
+		})
+	}
+}
+
+func isEventStream(res *http.Response) bool {
+	return res.Header.Get("Content-Type") == "text/event-stream"
+}
+
+func TestShadowResultsBounded(t *testing.T) {
+	s := &Shadow{}
+	for id := uint64(1); id <= shadowMaxResults+10; id++ {
+		s.record(&Event{ID: id}, &ShadowResult{ID: id})
+	}
+
+	if n := len(s.Results()); n != shadowMaxResults {
+		t.Errorf("want %d results, got %d", shadowMaxResults, n)
+	}
+	if _, ok := s.Result(1); ok {
+		t.Errorf("oldest result was not removed")
+	}
+	if _, ok := s.Result(shadowMaxResults + 10); !ok {
+		t.Errorf("newest result not found")
+	}
+}
+
+func TestProxyShadowPaused(t *testing.T) {
+	proxy, serve, shutdown := TestProxy(t, nil)
+	proxy.PauseCapture()
+	go serve()
+	defer shutdown()
+
+	primary := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		io.WriteString(rw, "primary")
+	}))
+	defer primary.Close()
+
+	shadowRequests := make(chan struct{}, 1)
+	shadow := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		shadowRequests <- struct{}{}
+	}))
+	defer shadow.Close()
+
+	shadowURL, err := url.Parse(shadow.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy.Shadow = &Shadow{Host: shadowURL.Host, Scheme: shadowURL.Scheme}
+
+	client := testClient(t, proxy.Addr, proxy.CertificateAuthority)
+	res, err := client.Get(primary.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantStatus(t, res, http.StatusOK)
+	wantBody(t, res, "primary")
+
+	select {
+	case <-shadowRequests:
+		t.Errorf("request sent while paused was sent to the shadow upstream")
+	default:
+	}
+	if results := proxy.Shadow.Results(); len(results) != 0 {
+		t.Errorf("results recorded while paused: %v", results)
+	}
+}
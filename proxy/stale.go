@@ -0,0 +1,98 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httputil"
+	"sync"
+)
+
+// StaleWarning is set as the Warning header on stale responses served with
+// StaleOnError.
+const StaleWarning = `110 osmosis "Response is Stale"`
+
+const (
+	// staleMaxEntries is the number of responses kept for StaleOnError, the
+	// oldest entry is removed first.
+	staleMaxEntries = 1000
+
+	// staleMaxBodySize is the size of the largest response body kept, and
+	// of the largest request body buffered for the request signature.
+	staleMaxBodySize = 1 << 20
+)
+
+// RequestSignature returns a signature for the request which is the same for
+// equivalent requests, consisting of the method, the URL and the body.
+func RequestSignature(req *http.Request, body []byte) string {
+	hash := sha256.New()
+	_, _ = hash.Write([]byte(req.Method + " " + req.URL.String() + "\n"))
+	_, _ = hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// staleCache keeps the last response for each request signature.
+type staleCache struct {
+	m       sync.Mutex
+	entries map[string][]byte
+	order   []string
+}
+
+// put records res for the request signature. Only responses with a known and
+// limited length are kept, their body remains readable.
+func (c *staleCache) put(signature string, res *http.Response) error {
+	if res.StatusCode >= 500 || res.ContentLength < 0 || res.ContentLength > staleMaxBodySize {
+		return nil
+	}
+
+	_, err := readWithoutClose(&res.Body)
+	if err != nil {
+		return err
+	}
+
+	dump, err := httputil.DumpResponse(res, true)
+	if err != nil {
+		return err
+	}
+
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if c.entries == nil {
+		c.entries = make(map[string][]byte)
+	}
+
+	if _, ok := c.entries[signature]; !ok {
+		c.order = append(c.order, signature)
+	}
+	c.entries[signature] = dump
+
+	for len(c.order) > staleMaxEntries {
+		delete(c.entries, c.order[0])
+		c.order = c.order[1:]
+	}
+
+	return nil
+}
+
+// get returns the response recorded for the request signature, marked with
+// the Warning header, or nil if there is none.
+func (c *staleCache) get(signature string, req *http.Request) *http.Response {
+	c.m.Lock()
+	dump, ok := c.entries[signature]
+	c.m.Unlock()
+
+	if !ok {
+		return nil
+	}
+
+	res, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(dump)), req)
+	if err != nil {
+		return nil
+	}
+
+	res.Header.Add("Warning", StaleWarning)
+	return res
+}
@@ -0,0 +1,100 @@
+package proxy
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestProxyStaleOnError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("X-Path", req.URL.Path)
+		_, _ = io.WriteString(rw, "fresh "+req.URL.Path)
+	}))
+
+	proxy, serve, shutdown := TestProxy(t, nil)
+	proxy.StaleOnError = true
+	go serve()
+	defer shutdown()
+
+	client := testClient(t, proxy.Addr, proxy.CertificateAuthority)
+
+	res, err := client.Get(srv.URL + "/cached")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantStatus(t, res, http.StatusOK)
+	wantBody(t, res, "fresh /cached")
+	if res.Header.Get("Warning") != "" {
+		t.Errorf("fresh response marked as stale: %v", res.Header)
+	}
+
+	// make the upstream unreachable
+	srv.Close()
+
+	res, err = client.Get(srv.URL + "/cached")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantStatus(t, res, http.StatusOK)
+	wantHeader(t, res, map[string]string{
+		"Warning": StaleWarning,
+		"X-Path":  "/cached",
+	})
+	wantBody(t, res, "fresh /cached")
+
+	res, err = client.Get(srv.URL + "/other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode == http.StatusOK {
+		t.Errorf("got successful response for uncached request while upstream is down")
+	}
+	_ = res.Body.Close()
+}
+
+func TestProxyStaleOnErrorSkipsLargeRequests(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		_, _ = io.WriteString(rw, req.URL.Path+" "+string(body))
+	}))
+	defer srv.Close()
+
+	proxy, serve, shutdown := TestProxy(t, nil)
+	proxy.StaleOnError = true
+	proxy.MaxRequestBodySize = 8
+	proxy.StreamLargeRequests = true
+	go serve()
+	defer shutdown()
+
+	client := testClient(t, proxy.Addr, proxy.CertificateAuthority)
+
+	var tests = []struct {
+		path string
+		body io.Reader
+	}{
+		// forwarded without the hooks
+		{"/large", strings.NewReader(strings.Repeat("x", 20))},
+		// unknown length
+		{"/chunked", ioutil.NopCloser(strings.NewReader("body"))},
+	}
+
+	for _, test := range tests {
+		res, err := client.Post(srv.URL+test.path, "text/plain", test.body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wantStatus(t, res, http.StatusOK)
+		_ = res.Body.Close()
+	}
+
+	proxy.stale.m.Lock()
+	n := len(proxy.stale.entries)
+	proxy.stale.m.Unlock()
+	if n != 0 {
+		t.Errorf("%d responses were kept for StaleOnError", n)
+	}
+}
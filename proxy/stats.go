@@ -0,0 +1,98 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"sync/atomic"
+)
+
+// Stats is a snapshot of the proxy's counters.
+type Stats struct {
+	// Requests is the number of requests received (not counting CONNECT),
+	// ActiveRequests the number of requests currently being processed.
+	Requests       uint64
+	ActiveRequests int64
+
+	// BytesIn is the number of request body bytes received from clients,
+	// BytesOut the number of response body bytes sent to clients.
+	BytesIn, BytesOut uint64
+
+	// Errors is the number of requests which could not be forwarded or whose
+	// response could not be sent to the client completely.
+	Errors uint64
+
+	// CachedCertificates is the number of certificates in the cache.
+	CachedCertificates int
+
+	// ActiveWebsockets is the number of websocket connections currently
+	// open, RejectedWebsockets the number of upgrade requests rejected
+	// because of MaxWebsockets.
+	ActiveWebsockets   int64
+	RejectedWebsockets uint64
+}
+
+// counters are updated atomically on the hot path, the struct is allocated
+// separately to guarantee the alignment of the 64 bit values.
+type counters struct {
+	requests uint64
+	active   int64
+	bytesIn  uint64
+	bytesOut uint64
+	errors   uint64
+
+	websockets         int64
+	rejectedWebsockets uint64
+}
+
+// Stats returns a snapshot of the proxy's counters.
+func (p *Proxy) Stats() Stats {
+	return Stats{
+		Requests:           atomic.LoadUint64(&p.counters.requests),
+		ActiveRequests:     atomic.LoadInt64(&p.counters.active),
+		BytesIn:            atomic.LoadUint64(&p.counters.bytesIn),
+		BytesOut:           atomic.LoadUint64(&p.counters.bytesOut),
+		Errors:             atomic.LoadUint64(&p.counters.errors),
+		CachedCertificates: p.Cache.Len(),
+		ActiveWebsockets:   atomic.LoadInt64(&p.counters.websockets),
+		RejectedWebsockets: atomic.LoadUint64(&p.counters.rejectedWebsockets),
+	}
+}
+
+// acquireWebsocket counts a new websocket connection, it returns false if
+// this would exceed MaxWebsockets. Otherwise releaseWebsocket must be called
+// when the connection is closed.
+func (p *Proxy) acquireWebsocket() bool {
+	n := atomic.AddInt64(&p.counters.websockets, 1)
+	if p.MaxWebsockets > 0 && n > int64(p.MaxWebsockets) {
+		atomic.AddInt64(&p.counters.websockets, -1)
+		atomic.AddUint64(&p.counters.rejectedWebsockets, 1)
+		return false
+	}
+	return true
+}
+
+// releaseWebsocket marks a websocket connection as closed.
+func (p *Proxy) releaseWebsocket() {
+	atomic.AddInt64(&p.counters.websockets, -1)
+}
+
+// countingBody counts the bytes read from a request body.
+type countingBody struct {
+	io.ReadCloser
+	n *uint64
+}
+
+func (b countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	atomic.AddUint64(b.n, uint64(n))
+	return n, err
+}
+
+// countRequestBody wraps the body of req so that the bytes read from it are
+// added to BytesIn.
+func (p *Proxy) countRequestBody(req *http.Request) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return
+	}
+	req.Body = countingBody{ReadCloser: req.Body, n: &p.counters.bytesIn}
+}
@@ -0,0 +1,200 @@
+package proxy
+
+import (
+	"bufio"
+	"crypto/tls"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// newRequestLineServer runs a server on a new listener which answers every
+// request with an empty response and sends the request line it received to
+// the returned channel. If tlsConfig is not nil, the server uses TLS.
+func newRequestLineServer(t testing.TB, tlsConfig *tls.Config) (addr string, lines <-chan string, cleanup func()) {
+	listener := newLocalListener(t)
+	if tlsConfig != nil {
+		listener = tls.NewListener(listener, tlsConfig)
+	}
+
+	ch := make(chan string, 10)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go func(conn net.Conn) {
+				defer conn.Close()
+				rd := bufio.NewReader(conn)
+
+				line, err := rd.ReadString('\n')
+				if err != nil {
+					// e.g. the connection used for cloning the certificate
+					return
+				}
+
+				// skip the header
+				for {
+					hdr, err := rd.ReadString('\n')
+					if err != nil || hdr == "\r\n" {
+						break
+					}
+				}
+
+				ch <- strings.TrimSpace(line)
+				io.WriteString(conn, "HTTP/1.1 200 OK\r\nContent-Length: 0\r\nConnection: close\r\n\r\n")
+			}(conn)
+		}
+	}()
+
+	return listener.Addr().String(), ch, func() { listener.Close() }
+}
+
+func TestProxyRequestTarget(t *testing.T) {
+	t.Run("direct", func(t *testing.T) {
+		proxy, serve, shutdown := TestProxy(t, nil)
+		go serve()
+		defer shutdown()
+
+		addr, lines, cleanup := newRequestLineServer(t, nil)
+		defer cleanup()
+
+		client := testClient(t, proxy.Addr, proxy.CertificateAuthority)
+		res, err := client.Get("http://" + addr + "/a%2Fb?x=1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+
+		want := "GET /a%2Fb?x=1 HTTP/1.1"
+		if line := <-lines; line != want {
+			t.Errorf("wrong request line, want %q, got %q", want, line)
+		}
+	})
+
+	t.Run("tunneled", func(t *testing.T) {
+		proxy, serve, shutdown := TestProxy(t, &tls.Config{InsecureSkipVerify: true})
+		go serve()
+		defer shutdown()
+
+		crt, err := proxy.CertificateAuthority.NewCertificate("127.0.0.1", []string{"127.0.0.1"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		addr, lines, cleanup := newRequestLineServer(t, &tls.Config{
+			Certificates: []tls.Certificate{*proxy.CertificateAuthority.TLSCert(crt)},
+		})
+		defer cleanup()
+
+		client := testClient(t, proxy.Addr, proxy.CertificateAuthority)
+		res, err := client.Get("https://" + addr + "/a%2Fb?x=1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+
+		want := "GET /a%2Fb?x=1 HTTP/1.1"
+		if line := <-lines; line != want {
+			t.Errorf("wrong request line, want %q, got %q", want, line)
+		}
+	})
+
+	t.Run("upstream-proxy", func(t *testing.T) {
+		proxy, serve, shutdown := TestProxy(t, nil)
+		go serve()
+		defer shutdown()
+
+		addr, lines, cleanup := newRequestLineServer(t, nil)
+		defer cleanup()
+
+		proxy.SetUpstreamProxy(&url.URL{Scheme: "http", Host: addr})
+
+		client := testClient(t, proxy.Addr, proxy.CertificateAuthority)
+		res, err := client.Get("http://example.com/a%2Fb?x=1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		wantStatus(t, res, http.StatusOK)
+
+		want := "GET http://example.com/a%2Fb?x=1 HTTP/1.1"
+		if line := <-lines; line != want {
+			t.Errorf("wrong request line, want %q, got %q", want, line)
+		}
+	})
+}
+
+// sendRawRequest sends raw to the proxy and returns the response status and
+// body.
+func sendRawRequest(t testing.TB, proxyAddr, raw string) (int, string) {
+	conn, err := net.Dial("tcp", proxyAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	_, err = io.WriteString(conn, raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = res.Body.Close()
+
+	return res.StatusCode, string(body)
+}
+
+func TestProxyMissingHost(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		_, _ = io.WriteString(rw, "host "+req.Host+" path "+req.URL.Path)
+	}))
+	defer srv.Close()
+
+	srvURL, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	proxy, serve, shutdown := TestProxy(t, nil)
+	go serve()
+	defer shutdown()
+
+	// HTTP/1.0 allows requests without Host header
+	noHost := "GET /path HTTP/1.0\r\n\r\n"
+
+	status, body := sendRawRequest(t, proxy.Addr, noHost)
+	if status != http.StatusBadRequest || !strings.Contains(body, ErrNoTarget.Error()) {
+		t.Errorf("unexpected response for request without host: %v %q", status, body)
+	}
+
+	// origin-form requests are sent to the host from the Host header
+	status, body = sendRawRequest(t, proxy.Addr, "GET /path HTTP/1.0\r\nHost: "+srvURL.Host+"\r\n\r\n")
+	if want := "host " + srvURL.Host + " path /path"; status != http.StatusOK || body != want {
+		t.Errorf("unexpected response for origin-form request: %v %q, want %q", status, body, want)
+	}
+
+	proxy.UpdateConfig(func(cfg *Config) {
+		cfg.DefaultHost = srvURL.Host
+	})
+
+	status, body = sendRawRequest(t, proxy.Addr, noHost)
+	if want := "host " + srvURL.Host + " path /path"; status != http.StatusOK || body != want {
+		t.Errorf("unexpected response with DefaultHost: %v %q, want %q", status, body, want)
+	}
+}
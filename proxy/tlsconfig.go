@@ -0,0 +1,115 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"strings"
+	"time"
+)
+
+// SetHostClientConfig sets the TLS client configuration used for connections
+// to host (a host name or IP address without port), overriding the
+// configuration passed to New. It is used for requests, websockets and when
+// cloning the host's certificate. A nil cfg removes the override. Requests
+// sent through an upstream proxy always use the default configuration. It may
+// be called while the proxy is running, existing connections to the host are
+// reused.
+func (p *Proxy) SetHostClientConfig(host string, cfg *tls.Config) {
+	host = strings.ToLower(host)
+
+	p.hostConfigMu.Lock()
+	defer p.hostConfigMu.Unlock()
+
+	if cfg == nil {
+		delete(p.hostConfigs, host)
+		return
+	}
+
+	if p.hostConfigs == nil {
+		p.hostConfigs = make(map[string]*tls.Config)
+	}
+	p.hostConfigs[host] = cfg
+}
+
+// hostClientConfig returns the TLS client configuration registered for host,
+// or nil if there is none.
+func (p *Proxy) hostClientConfig(host string) *tls.Config {
+	p.hostConfigMu.Lock()
+	defer p.hostConfigMu.Unlock()
+
+	return p.hostConfigs[strings.ToLower(host)]
+}
+
+// clientConfigFor returns the TLS client configuration to use for host.
+func (p *Proxy) clientConfigFor(host string) *tls.Config {
+	if cfg := p.hostClientConfig(host); cfg != nil {
+		return cfg
+	}
+	return p.clientConfig
+}
+
+// dialTLS establishes a TLS connection to addr for the HTTP client, using the
+// configuration registered for the host if there is one.
+func (p *Proxy) dialTLS(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	tr := p.transport
+
+	cfg := p.hostClientConfig(host)
+	if cfg == nil {
+		cfg = tr.TLSClientConfig
+	}
+
+	if cfg == nil {
+		cfg = &tls.Config{}
+	} else {
+		cfg = cfg.Clone()
+	}
+
+	if cfg.ServerName == "" {
+		cfg.ServerName = host
+	}
+
+	p.hostConfigMu.Lock()
+	p.cipherProfileConfig.apply(cfg)
+	p.hostConfigMu.Unlock()
+
+	// offer the protocols configured for the transport (e.g. HTTP2)
+	if len(cfg.NextProtos) == 0 && tr.TLSClientConfig != nil {
+		cfg.NextProtos = tr.TLSClientConfig.NextProtos
+	}
+
+	dial := (&net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}).DialContext
+
+	// use the dialer set with SetUpstreamDialer
+	if tr.DialContext != nil {
+		dial = tr.DialContext
+	}
+
+	conn, err := dial(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	if tr.TLSHandshakeTimeout > 0 {
+		_ = conn.SetDeadline(time.Now().Add(tr.TLSHandshakeTimeout))
+	}
+
+	tlsConn := tls.Client(conn, cfg)
+	err = tlsConn.Handshake()
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	_ = conn.SetDeadline(time.Time{})
+
+	return tlsConn, nil
+}
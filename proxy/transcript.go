@@ -0,0 +1,86 @@
+package proxy
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Direction labels used in connection transcripts.
+const (
+	TranscriptClientToProxy = "client->proxy"
+	TranscriptProxyToClient = "proxy->client"
+)
+
+// transcriptConn records all data read from and written to the connection in
+// a transcript. Each chunk is preceded by a line with the time, the direction
+// and the length:
+//
+//	# 2019-07-01T12:00:00.123456789Z client->proxy 78 bytes
+type transcriptConn struct {
+	net.Conn
+
+	m sync.Mutex
+	w io.WriteCloser
+}
+
+// newTranscriptConn creates a transcript file for the connection with the
+// given ID to host in dir.
+func newTranscriptConn(conn net.Conn, dir string, id uint64, host string) (*transcriptConn, error) {
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, err
+	}
+
+	name := fmt.Sprintf("%d-%s.txt", id, strings.NewReplacer(":", "_", "/", "_", `\`, "_").Replace(host))
+	f, err := os.OpenFile(filepath.Join(dir, name), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	return &transcriptConn{Conn: conn, w: f}, nil
+}
+
+func (c *transcriptConn) record(direction string, p []byte) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	_, _ = fmt.Fprintf(c.w, "# %v %v %d bytes\n", time.Now().UTC().Format(time.RFC3339Nano), direction, len(p))
+	_, _ = c.w.Write(p)
+	_, _ = io.WriteString(c.w, "\n")
+}
+
+func (c *transcriptConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		c.record(TranscriptClientToProxy, p[:n])
+	}
+	return n, err
+}
+
+func (c *transcriptConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	if n > 0 {
+		c.record(TranscriptProxyToClient, p[:n])
+	}
+	return n, err
+}
+
+// Close closes the connection and the transcript.
+func (c *transcriptConn) Close() error {
+	err := c.Conn.Close()
+
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	ferr := c.w.Close()
+	if err == nil {
+		err = ferr
+	}
+	return err
+}
@@ -0,0 +1,126 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestProxyTranscript(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		_, _ = rw.Write([]byte("transcribed response"))
+	}))
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "osmosis-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	proxy, serve, shutdown := TestProxy(t, &tls.Config{InsecureSkipVerify: true})
+	proxy.TranscriptDir = filepath.Join(dir, "transcripts")
+	go serve()
+
+	var clientTLS *tls.ConnectionState
+	proxy.Register(func(event *Event) (*Response, error) {
+		clientTLS = event.ClientTLS
+		return event.ForwardRequest()
+	})
+
+	client := testClient(t, proxy.Addr, proxy.CertificateAuthority)
+	res, err := client.Get(srv.URL + "/secret-path")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantStatus(t, res, http.StatusOK)
+	wantBody(t, res, "transcribed response")
+
+	if clientTLS == nil {
+		t.Errorf("TLS state of the client connection is missing")
+	}
+
+	// closing the connections finishes the transcript
+	client.Transport.(*http.Transport).CloseIdleConnections()
+	shutdown()
+
+	files, err := filepath.Glob(filepath.Join(proxy.TranscriptDir, "*.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Fatalf("wrong number of transcripts, want 1, got %v", files)
+	}
+
+	fi, err := os.Stat(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0600 {
+		t.Errorf("transcript is not private: %v", fi.Mode())
+	}
+
+	buf, err := ioutil.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	transcript := string(buf)
+
+	for _, want := range []string{
+		"client->proxy",
+		"GET /secret-path HTTP/1.1",
+		"proxy->client",
+		"HTTP/1.1 200 OK",
+		"transcribed response",
+	} {
+		if !strings.Contains(transcript, want) {
+			t.Errorf("transcript does not contain %q:\n%s", want, transcript)
+		}
+	}
+
+	if strings.Index(transcript, "GET /secret-path") > strings.Index(transcript, "HTTP/1.1 200 OK") {
+		t.Errorf("request and response are in the wrong order:\n%s", transcript)
+	}
+}
+
+func TestProxyTranscriptPaused(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		_, _ = rw.Write([]byte("response"))
+	}))
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "osmosis-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	proxy, serve, shutdown := TestProxy(t, &tls.Config{InsecureSkipVerify: true})
+	proxy.TranscriptDir = filepath.Join(dir, "transcripts")
+	proxy.PauseCapture()
+	go serve()
+
+	client := testClient(t, proxy.Addr, proxy.CertificateAuthority)
+	res, err := client.Get(srv.URL + "/secret-path")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantStatus(t, res, http.StatusOK)
+	wantBody(t, res, "response")
+
+	client.Transport.(*http.Transport).CloseIdleConnections()
+	shutdown()
+
+	files, err := filepath.Glob(filepath.Join(proxy.TranscriptDir, "*.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 0 {
+		t.Errorf("tunnel established while paused was transcribed: %v", files)
+	}
+}
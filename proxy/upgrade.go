@@ -2,18 +2,87 @@ package proxy
 
 import (
 	"crypto/tls"
+	"fmt"
 	"net/http"
 	"net/url"
 	"strings"
+	"time"
 
 	"github.com/gorilla/websocket"
 
 	"golang.org/x/sync/errgroup"
 )
 
-func copyWSMessages(src, dst *websocket.Conn) error {
+// WebsocketLogLevel selects how much websocket activity is logged.
+type WebsocketLogLevel int
+
+// These are the websocket log levels.
+const (
+	// WebsocketLogConnections only logs establishing and closing the
+	// connections, this is the default.
+	WebsocketLogConnections WebsocketLogLevel = iota
+
+	// WebsocketLogMessages logs the direction, type and length of each
+	// message copied and the close messages.
+	WebsocketLogMessages
+
+	// WebsocketLogPayloads logs the payload of each message in addition.
+	WebsocketLogPayloads
+)
+
+// wsMessageTypes contains names for the websocket message types.
+var wsMessageTypes = map[int]string{
+	websocket.TextMessage:   "text",
+	websocket.BinaryMessage: "binary",
+	websocket.CloseMessage:  "close",
+	websocket.PingMessage:   "ping",
+	websocket.PongMessage:   "pong",
+}
+
+// wsLogFunc is called for each message copied and for errors reading a
+// message (e.g. close messages).
+type wsLogFunc func(msgType int, buf []byte, err error)
+
+// wsLogger returns a function which logs messages copied in direction dir
+// (e.g. "client -> upstream") according to level, or nil if messages are not
+// logged.
+func wsLogger(event *Event, level WebsocketLogLevel, dir string) wsLogFunc {
+	if level < WebsocketLogMessages {
+		return nil
+	}
+
+	return func(msgType int, buf []byte, err error) {
+		if err != nil {
+			if closeErr, ok := err.(*websocket.CloseError); ok {
+				event.Log("websocket %v: close (code %d) %v", dir, closeErr.Code, closeErr.Text)
+			}
+			return
+		}
+
+		if level >= WebsocketLogPayloads {
+			event.Log("websocket %v: %v message, %d bytes: %q", dir, wsMessageTypes[msgType], len(buf), buf)
+			return
+		}
+		event.Log("websocket %v: %v message, %d bytes", dir, wsMessageTypes[msgType], len(buf))
+	}
+}
+
+// wsWriteError is returned by copyWSMessages if writing a message to the
+// destination failed, as opposed to reading from the source.
+type wsWriteError struct {
+	err error
+}
+
+func (e wsWriteError) Error() string {
+	return fmt.Sprintf("writing message: %v", e.err)
+}
+
+func copyWSMessages(src, dst *websocket.Conn, logMessage wsLogFunc) error {
 	for {
 		msgType, buf, err := src.ReadMessage()
+		if logMessage != nil {
+			logMessage(msgType, buf, err)
+		}
 		if websocket.IsCloseError(err, websocket.CloseNormalClosure) {
 			return nil
 		}
@@ -23,20 +92,20 @@ func copyWSMessages(src, dst *websocket.Conn) error {
 
 		err = dst.WriteMessage(msgType, buf)
 		if err != nil {
-			return err
+			return wsWriteError{err}
 		}
 	}
 }
 
-func copyWSUntilError(c1, c2 *websocket.Conn) error {
+func copyWSUntilError(c1, c2 *websocket.Conn, log1, log2 wsLogFunc) error {
 	var g errgroup.Group
 	g.Go(func() error {
 		defer c2.Close()
-		return copyWSMessages(c1, c2)
+		return copyWSMessages(c1, c2, log1)
 	})
 	g.Go(func() error {
 		defer c1.Close()
-		return copyWSMessages(c2, c1)
+		return copyWSMessages(c2, c1, log2)
 	})
 
 	return g.Wait()
@@ -73,8 +142,173 @@ func prepareWSHeader(src http.Header) http.Header {
 	return hdr
 }
 
-// HandleUpgradeRequest handles an upgraded connection (e.g. websockets).
-func HandleUpgradeRequest(event *Event, clientConfig *tls.Config) {
+// WebsocketReconnect configures whether the proxy tries to re-establish a
+// dropped upstream websocket connection while keeping the connection to the
+// client open. A message which could not be sent on the failed connection is
+// sent again on the new one, other messages are neither buffered nor
+// replayed, so this only works for upstreams which don't depend on state from
+// earlier messages.
+type WebsocketReconnect struct {
+	// Attempts is the maximum number of reconnects per connection, zero
+	// disables reconnecting.
+	Attempts int
+
+	// Delay is the duration to wait before each reconnect.
+	Delay time.Duration
+}
+
+// copyWSWithReconnect forwards messages between the client connection inConn
+// and the upstream connection outConn. If the upstream connection fails, dial
+// is used to establish a new one according to the reconnect policy. If the
+// connection to the client fails, both connections are closed.
+func copyWSWithReconnect(event *Event, inConn, outConn *websocket.Conn, policy WebsocketReconnect,
+	dial func() (*websocket.Conn, error), logIn, logOut wsLogFunc) error {
+
+	type message struct {
+		msgType int
+		buf     []byte
+	}
+
+	// read messages from the client in the background
+	fromClient := make(chan message)
+	clientErr := make(chan error, 1)
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		for {
+			msgType, buf, err := inConn.ReadMessage()
+			if logIn != nil {
+				logIn(msgType, buf, err)
+			}
+			if err != nil {
+				clientErr <- err
+				return
+			}
+
+			select {
+			case fromClient <- message{msgType, buf}:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	// copy messages from the upstream connection to the client
+	upstreamErr := make(chan error, 1)
+	readUpstream := func(conn *websocket.Conn) {
+		upstreamErr <- copyWSMessages(conn, inConn, logOut)
+	}
+	go readUpstream(outConn)
+
+	closeClient := func(err error) error {
+		_ = inConn.Close()
+		if err == nil || websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+			return nil
+		}
+		return err
+	}
+
+	// pending is the message from the client which could not be sent to the
+	// failed upstream connection, it is sent again after reconnecting
+	var pending *message
+	dropPending := func() {
+		if pending != nil {
+			event.Log("dropping %v message from the client (%d bytes), upstream connection failed",
+				wsMessageTypes[pending.msgType], len(pending.buf))
+		}
+	}
+
+	var attempts int
+	for {
+		var err error
+		select {
+		case msg := <-fromClient:
+			err = outConn.WriteMessage(msg.msgType, msg.buf)
+			if err == nil {
+				continue
+			}
+			pending = &msg
+
+			// the reader will notice the failed connection, too
+			_ = outConn.Close()
+			if rerr := <-upstreamErr; rerr != nil {
+				if _, ok := rerr.(wsWriteError); ok {
+					err = rerr
+				}
+			}
+
+		case err = <-clientErr:
+			_ = outConn.Close()
+			<-upstreamErr
+			return closeClient(err)
+
+		case err = <-upstreamErr:
+		}
+
+		// writing to the client failed, reconnecting won't help
+		if werr, ok := err.(wsWriteError); ok {
+			_ = outConn.Close()
+			return closeClient(fmt.Errorf("client connection failed: %v", werr.err))
+		}
+
+		// upstream connection closed normally, or no attempts left
+		if err == nil || attempts >= policy.Attempts {
+			dropPending()
+			_ = outConn.Close()
+			_ = inConn.Close()
+			return err
+		}
+
+		// try to reconnect
+		_ = outConn.Close()
+		for {
+			attempts++
+			event.Log("upstream websocket connection failed (%v), reconnecting (attempt %d/%d)",
+				err, attempts, policy.Attempts)
+
+			timer := time.NewTimer(policy.Delay)
+			select {
+			case <-timer.C:
+			case cerr := <-clientErr:
+				timer.Stop()
+				dropPending()
+				return closeClient(cerr)
+			case <-event.Req.Context().Done():
+				timer.Stop()
+				dropPending()
+				return closeClient(event.Req.Context().Err())
+			}
+
+			outConn, err = dial()
+			if err == nil && pending != nil {
+				err = outConn.WriteMessage(pending.msgType, pending.buf)
+				if err != nil {
+					_ = outConn.Close()
+				}
+			}
+			if err == nil {
+				pending = nil
+				break
+			}
+
+			if attempts >= policy.Attempts {
+				dropPending()
+				_ = inConn.Close()
+				return fmt.Errorf("reconnecting failed: %v", err)
+			}
+		}
+
+		event.Log("re-established upstream websocket connection")
+		go readUpstream(outConn)
+	}
+}
+
+// HandleUpgradeRequest handles an upgraded connection (e.g. websockets). If
+// the upstream connection fails, it is re-established according to reconnect.
+// The messages are logged to the event's logger according to logLevel and
+// passed on to feed, if it is not nil.
+func HandleUpgradeRequest(event *Event, clientConfig *tls.Config, reconnect WebsocketReconnect, logLevel WebsocketLogLevel, feed *WebsocketFeed) {
 	reqUpgrade := event.Req.Header.Get("upgrade")
 	event.Log("handle upgrade request to %v", reqUpgrade)
 
@@ -128,10 +362,20 @@ func HandleUpgradeRequest(event *Event, clientConfig *tls.Config) {
 	var dialer = *websocket.DefaultDialer
 	dialer.TLSClientConfig = clientConfig
 
-	outConn, res, err := dialer.DialContext(event.Req.Context(), wsURL.String(), hdr)
+	dial := func() (*websocket.Conn, error) {
+		conn, res, err := dialer.DialContext(event.Req.Context(), wsURL.String(), hdr)
+		if err != nil {
+			event.Log("connecting to %v failed: %v", wsURL, err)
+			if res != nil {
+				dumpResponse(res)
+			}
+			return nil, err
+		}
+		return conn, nil
+	}
+
+	outConn, err := dial()
 	if err != nil {
-		event.Log("connecting to %v failed: %v", wsURL, err)
-		dumpResponse(res)
 		return
 	}
 
@@ -139,7 +383,22 @@ func HandleUpgradeRequest(event *Event, clientConfig *tls.Config) {
 
 	event.Log("established outogoing connection to %v", wsURL)
 
-	err = copyWSUntilError(inConn, outConn)
+	logIn := wsLogger(event, logLevel, "client -> upstream")
+	logOut := wsLogger(event, logLevel, "upstream -> client")
+
+	if feed != nil {
+		feed.open(event.ID, wsURL)
+		defer feed.close(event.ID)
+
+		logIn = feed.logger(event.ID, "client -> upstream", logIn)
+		logOut = feed.logger(event.ID, "upstream -> client", logOut)
+	}
+
+	if reconnect.Attempts > 0 {
+		err = copyWSWithReconnect(event, inConn, outConn, reconnect, dial, logIn, logOut)
+	} else {
+		err = copyWSUntilError(inConn, outConn, logIn, logOut)
+	}
 	if err != nil {
 		event.Log("error copying messages: %v", err)
 		return
@@ -4,11 +4,17 @@ import (
 	"bytes"
 	"crypto/tls"
 	"crypto/x509"
+	"errors"
 	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -202,3 +208,400 @@ func TestProxyWebsocket(t *testing.T) {
 		})
 	}
 }
+
+func TestProxyWebsocketReconnect(t *testing.T) {
+	var connections int
+	srv, cleanup := newWebsocktTestServer(t, func(req *http.Request, conn *websocket.Conn) {
+		connections++
+		if connections == 1 {
+			// echo the first message, then drop the connection
+			msgType, buf, err := conn.ReadMessage()
+			if err != nil {
+				t.Errorf("handler: error receiving message: %v", err)
+				return
+			}
+			err = conn.WriteMessage(msgType, buf)
+			if err != nil {
+				t.Errorf("handler: error sending message: %v", err)
+			}
+			conn.UnderlyingConn().Close()
+			return
+		}
+
+		sendMessage(t, conn, websocket.TextMessage, []byte("reconnected"))
+		echoHandler(t)(req, conn)
+	})
+	defer cleanup()
+
+	proxy, serve, shutdown := TestProxy(t, nil)
+	proxy.WebsocketReconnect = WebsocketReconnect{Attempts: 2, Delay: 10 * time.Millisecond}
+	go serve()
+	defer shutdown()
+
+	wsDialer := newWebsocketDialer(t, proxy.Addr, proxy.CertificateAuthority)
+	conn, _, err := wsDialer.Dial(strings.Replace(srv.URL, "http", "ws", 1), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	sendMessage(t, conn, websocket.TextMessage, []byte("first"))
+	wantNextMessage(t, conn, websocket.TextMessage, []byte("first"))
+
+	// the upstream connection is dropped and re-established by the proxy
+	wantNextMessage(t, conn, websocket.TextMessage, []byte("reconnected"))
+
+	sendMessage(t, conn, websocket.TextMessage, []byte("second"))
+	wantNextMessage(t, conn, websocket.TextMessage, []byte("second"))
+
+	err = conn.WriteMessage(
+		websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseNormalClosure, "done"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// failingWriteConn is a net.Conn whose writes fail once fail is closed.
+type failingWriteConn struct {
+	net.Conn
+	fail chan struct{}
+}
+
+func (c failingWriteConn) Write(p []byte) (int, error) {
+	select {
+	case <-c.fail:
+		return 0, errors.New("write failed")
+	default:
+	}
+	return c.Conn.Write(p)
+}
+
+// reconnectTest runs copyWSWithReconnect for a websocket client connected to
+// it and an upstream server running handler. The upstream connection is
+// dialed with netDial, which receives the number of the connection.
+type reconnectTest struct {
+	client *websocket.Conn
+	result chan error
+	dials  int32
+}
+
+func newReconnectTest(t testing.TB, handler func(*http.Request, *websocket.Conn), policy WebsocketReconnect,
+	netDial func(n int32, network, addr string) (net.Conn, error)) (test *reconnectTest, cleanup func()) {
+
+	upstream, upstreamCleanup := newWebsocktTestServer(t, handler)
+	test = &reconnectTest{result: make(chan error, 1)}
+
+	dialer := websocket.Dialer{
+		NetDial: func(network, addr string) (net.Conn, error) {
+			return netDial(atomic.AddInt32(&test.dials, 1), network, addr)
+		},
+	}
+	upstreamURL := strings.Replace(upstream.URL, "http", "ws", 1)
+
+	upgrader := websocket.Upgrader{}
+	front := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		inConn, err := upgrader.Upgrade(rw, req, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer inConn.Close()
+
+		dial := func() (*websocket.Conn, error) {
+			conn, _, err := dialer.Dial(upstreamURL, nil)
+			return conn, err
+		}
+		outConn, err := dial()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		event := newEvent(rw, req, log.New(ioutil.Discard, "", 0), 1)
+		test.result <- copyWSWithReconnect(event, inConn, outConn, policy, dial, nil, nil)
+	}))
+
+	var err error
+	test.client, _, err = websocket.DefaultDialer.Dial(strings.Replace(front.URL, "http", "ws", 1), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cleanup = func() {
+		_ = test.client.Close()
+		front.CloseClientConnections()
+		front.Close()
+		upstreamCleanup()
+	}
+	return test, cleanup
+}
+
+// wait returns the result of copyWSWithReconnect.
+func (test *reconnectTest) wait(t testing.TB) error {
+	select {
+	case err := <-test.result:
+		return err
+	case <-time.After(5 * time.Second):
+		t.Fatal("copyWSWithReconnect did not return")
+		return nil
+	}
+}
+
+// simpleEcho sends back all messages until reading fails.
+func simpleEcho(req *http.Request, conn *websocket.Conn) {
+	for {
+		msgType, buf, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		err = conn.WriteMessage(msgType, buf)
+		if err != nil {
+			return
+		}
+	}
+}
+
+func TestWebsocketReconnectResendsMessage(t *testing.T) {
+	fail := make(chan struct{})
+	test, cleanup := newReconnectTest(t, simpleEcho, WebsocketReconnect{Attempts: 2, Delay: time.Millisecond},
+		func(n int32, network, addr string) (net.Conn, error) {
+			conn, err := net.Dial(network, addr)
+			if err != nil || n > 1 {
+				return conn, err
+			}
+			// writes to the first upstream connection fail later
+			return failingWriteConn{Conn: conn, fail: fail}, nil
+		})
+	defer cleanup()
+
+	sendMessage(t, test.client, websocket.TextMessage, []byte("first"))
+	wantNextMessage(t, test.client, websocket.TextMessage, []byte("first"))
+
+	close(fail)
+
+	// the message is sent again on the new upstream connection
+	sendMessage(t, test.client, websocket.TextMessage, []byte("second"))
+	_ = test.client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	wantNextMessage(t, test.client, websocket.TextMessage, []byte("second"))
+
+	if n := atomic.LoadInt32(&test.dials); n != 2 {
+		t.Errorf("want 2 upstream connections, got %d", n)
+	}
+}
+
+func TestWebsocketReconnectClientFailed(t *testing.T) {
+	// the upstream keeps sending messages to the client
+	handler := func(req *http.Request, conn *websocket.Conn) {
+		for {
+			err := conn.WriteMessage(websocket.TextMessage, []byte("message"))
+			if err != nil {
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	test, cleanup := newReconnectTest(t, handler, WebsocketReconnect{Attempts: 5, Delay: time.Millisecond},
+		func(n int32, network, addr string) (net.Conn, error) {
+			return net.Dial(network, addr)
+		})
+	defer cleanup()
+
+	wantNextMessage(t, test.client, websocket.TextMessage, []byte("message"))
+	_ = test.client.UnderlyingConn().Close()
+
+	err := test.wait(t)
+	if err == nil {
+		t.Errorf("no error returned for the failed client connection")
+	}
+	if n := atomic.LoadInt32(&test.dials); n != 1 {
+		t.Errorf("reconnected to the upstream after the client failed, %d connections", n)
+	}
+}
+
+func TestWebsocketReconnectDelayInterrupted(t *testing.T) {
+	// the upstream drops the connection right away
+	handler := func(req *http.Request, conn *websocket.Conn) {
+		_ = conn.UnderlyingConn().Close()
+	}
+
+	test, cleanup := newReconnectTest(t, handler, WebsocketReconnect{Attempts: 1, Delay: time.Hour},
+		func(n int32, network, addr string) (net.Conn, error) {
+			return net.Dial(network, addr)
+		})
+	defer cleanup()
+
+	// give the proxy time to notice the failed upstream connection
+	time.Sleep(50 * time.Millisecond)
+
+	// closing the client connection ends waiting for the reconnect
+	err := test.client.WriteMessage(websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseNormalClosure, "done"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = test.wait(t)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+// syncBuffer is a bytes.Buffer safe for concurrent use.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestProxyWebsocketLog(t *testing.T) {
+	var tests = []struct {
+		level    WebsocketLogLevel
+		want     []string
+		notWant  []string
+		closeLog bool
+	}{
+		{
+			WebsocketLogConnections,
+			nil,
+			[]string{"text message"},
+			false,
+		},
+		{
+			WebsocketLogMessages,
+			[]string{
+				"websocket client -> upstream: text message, 6 bytes\n",
+				"websocket upstream -> client: text message, 6 bytes\n",
+				"websocket client -> upstream: close (code 1000) done",
+			},
+			[]string{"foobar"},
+			true,
+		},
+		{
+			WebsocketLogPayloads,
+			[]string{
+				`websocket client -> upstream: text message, 6 bytes: "foobar"`,
+				`websocket upstream -> client: text message, 6 bytes: "foobar"`,
+			},
+			nil,
+			true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run("", func(t *testing.T) {
+			srv, cleanup := newWebsocktTestServer(t, echoHandler(t))
+			defer cleanup()
+
+			proxy, serve, shutdown := TestProxy(t, nil)
+			go serve()
+			defer shutdown()
+
+			var buf syncBuffer
+			proxy.logger = log.New(&buf, "", 0)
+			proxy.WebsocketLog = test.level
+
+			wsDialer := newWebsocketDialer(t, proxy.Addr, proxy.CertificateAuthority)
+			conn, _, err := wsDialer.Dial(strings.Replace(srv.URL, "http", "ws", 1), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			sendMessage(t, conn, websocket.TextMessage, []byte("foobar"))
+			wantNextMessage(t, conn, websocket.TextMessage, []byte("foobar"))
+
+			err = conn.WriteMessage(
+				websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.CloseNormalClosure, "done"),
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// wait for the close message to be processed by the proxy
+			if test.closeLog {
+				deadline := time.Now().Add(5 * time.Second)
+				for !strings.Contains(buf.String(), "close (code") && time.Now().Before(deadline) {
+					time.Sleep(10 * time.Millisecond)
+				}
+			}
+			_ = conn.Close()
+
+			output := buf.String()
+			for _, s := range test.want {
+				if !strings.Contains(output, s) {
+					t.Errorf("log does not contain %q:\n%s", s, output)
+				}
+			}
+			for _, s := range test.notWant {
+				if strings.Contains(output, s) {
+					t.Errorf("log contains %q:\n%s", s, output)
+				}
+			}
+		})
+	}
+}
+
+func TestProxyMaxWebsockets(t *testing.T) {
+	srv, cleanup := newWebsocktTestServer(t, echoHandler(t))
+	defer cleanup()
+
+	proxy, serve, shutdown := TestProxy(t, nil)
+	proxy.MaxWebsockets = 1
+	go serve()
+	defer shutdown()
+
+	wsURL := strings.Replace(srv.URL, "http", "ws", 1)
+	wsDialer := newWebsocketDialer(t, proxy.Addr, proxy.CertificateAuthority)
+
+	first, _, err := wsDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sendMessage(t, first, websocket.TextMessage, []byte("foobar"))
+	wantNextMessage(t, first, websocket.TextMessage, []byte("foobar"))
+
+	if n := proxy.Stats().ActiveWebsockets; n != 1 {
+		t.Errorf("want 1 active websocket, got %d", n)
+	}
+
+	second, res, err := wsDialer.Dial(wsURL, nil)
+	if err == nil {
+		_ = second.Close()
+		t.Fatal("second websocket connection was accepted")
+	}
+	if res == nil || res.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("want status 503 for the second connection, got %v (%v)", res, err)
+	}
+	if n := proxy.Stats().RejectedWebsockets; n != 1 {
+		t.Errorf("want 1 rejected websocket, got %d", n)
+	}
+
+	// the first connection still works
+	sendMessage(t, first, websocket.TextMessage, []byte("baz"))
+	wantNextMessage(t, first, websocket.TextMessage, []byte("baz"))
+	_ = first.Close()
+
+	if !waitFor(5*time.Second, func() bool { return proxy.Stats().ActiveWebsockets == 0 }) {
+		t.Fatalf("websocket still counted as active after close")
+	}
+
+	third, _, err := wsDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("connection after closing the first one failed: %v", err)
+	}
+	_ = third.Close()
+}
@@ -0,0 +1,199 @@
+package proxy
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// WebsocketMessage describes a message copied on a websocket connection.
+type WebsocketMessage struct {
+	Time time.Time `json:"time"`
+
+	// Direction is either "client -> upstream" or "upstream -> client".
+	Direction string `json:"direction"`
+	Type      string `json:"type"`
+	Length    int    `json:"length"`
+
+	// Payload contains at most MaxPayload bytes of the message.
+	Payload string `json:"payload,omitempty"`
+
+	// Closed is set for the last message of a connection, which is sent
+	// when the connection is closed.
+	Closed bool `json:"closed,omitempty"`
+}
+
+// WebsocketConn describes an active websocket connection.
+type WebsocketConn struct {
+	ID      uint64    `json:"id"`
+	URL     string    `json:"url"`
+	Started time.Time `json:"started"`
+}
+
+// wsFeedConn is an active connection and its subscribers.
+type wsFeedConn struct {
+	info WebsocketConn
+	subs map[chan WebsocketMessage]struct{}
+}
+
+// WebsocketFeed passes the messages of active websocket connections on to
+// subscribers, e.g. a live view. Messages are never blocked by subscribers,
+// they are dropped for a subscriber which doesn't keep up.
+type WebsocketFeed struct {
+	// MaxPayload limits the number of payload bytes passed on per message.
+	MaxPayload int
+
+	// Buffer is the capacity of the channel returned by Subscribe.
+	Buffer int
+
+	m     sync.Mutex
+	conns map[uint64]*wsFeedConn
+}
+
+// NewWebsocketFeed returns a feed which passes on up to 256 bytes of payload
+// per message.
+func NewWebsocketFeed() *WebsocketFeed {
+	return &WebsocketFeed{
+		MaxPayload: 256,
+		Buffer:     100,
+	}
+}
+
+// Connections returns the active connections ordered by ID.
+func (f *WebsocketFeed) Connections() []WebsocketConn {
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	list := make([]WebsocketConn, 0, len(f.conns))
+	for _, conn := range f.conns {
+		list = append(list, conn.info)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list
+}
+
+// Subscribe returns a channel which receives the messages of the connection
+// with the given ID. When the connection is closed, a message with Closed set
+// is sent and the channel is closed. The function cancel must be called when
+// the subscriber is not interested any more.
+func (f *WebsocketFeed) Subscribe(id uint64) (messages <-chan WebsocketMessage, cancel func(), err error) {
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	conn, ok := f.conns[id]
+	if !ok {
+		return nil, nil, fmt.Errorf("websocket connection %d not found", id)
+	}
+
+	ch := make(chan WebsocketMessage, f.Buffer)
+	conn.subs[ch] = struct{}{}
+
+	cancel = func() {
+		f.m.Lock()
+		defer f.m.Unlock()
+
+		if _, ok := conn.subs[ch]; ok {
+			delete(conn.subs, ch)
+			close(ch)
+		}
+	}
+
+	return ch, cancel, nil
+}
+
+// open registers a new connection.
+func (f *WebsocketFeed) open(id uint64, u *url.URL) {
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	if f.conns == nil {
+		f.conns = make(map[uint64]*wsFeedConn)
+	}
+	f.conns[id] = &wsFeedConn{
+		info: WebsocketConn{ID: id, URL: u.String(), Started: time.Now()},
+		subs: make(map[chan WebsocketMessage]struct{}),
+	}
+}
+
+// send passes msg on to all subscribers of the connection.
+func (f *WebsocketFeed) send(id uint64, msg WebsocketMessage) {
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	conn, ok := f.conns[id]
+	if !ok {
+		return
+	}
+
+	for ch := range conn.subs {
+		select {
+		case ch <- msg:
+		default:
+			// subscriber is too slow
+		}
+	}
+}
+
+// close removes the connection and notifies the subscribers.
+func (f *WebsocketFeed) close(id uint64) {
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	conn, ok := f.conns[id]
+	if !ok {
+		return
+	}
+	delete(f.conns, id)
+
+	for ch := range conn.subs {
+		select {
+		case ch <- WebsocketMessage{Time: time.Now(), Closed: true}:
+		default:
+		}
+		close(ch)
+	}
+	conn.subs = nil
+}
+
+// logger returns a function which passes messages copied in direction dir on
+// to the subscribers, and calls next (if any).
+func (f *WebsocketFeed) logger(id uint64, dir string, next wsLogFunc) wsLogFunc {
+	return func(msgType int, buf []byte, err error) {
+		if next != nil {
+			next(msgType, buf, err)
+		}
+
+		if err != nil {
+			if closeErr, ok := err.(*websocket.CloseError); ok {
+				f.send(id, WebsocketMessage{
+					Time:      time.Now(),
+					Direction: dir,
+					Type:      wsMessageTypes[websocket.CloseMessage],
+					Length:    len(closeErr.Text),
+					Payload:   f.truncate([]byte(closeErr.Text)),
+				})
+			}
+			return
+		}
+
+		f.send(id, WebsocketMessage{
+			Time:      time.Now(),
+			Direction: dir,
+			Type:      wsMessageTypes[msgType],
+			Length:    len(buf),
+			Payload:   f.truncate(buf),
+		})
+	}
+}
+
+// truncate returns at most MaxPayload bytes of buf as a string.
+func (f *WebsocketFeed) truncate(buf []byte) string {
+	if len(buf) > f.MaxPayload {
+		buf = buf[:f.MaxPayload]
+	}
+	return string(buf)
+}
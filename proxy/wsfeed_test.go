@@ -0,0 +1,139 @@
+package proxy
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// nextFeedMessage returns the next message from the feed.
+func nextFeedMessage(t testing.TB, ch <-chan WebsocketMessage) WebsocketMessage {
+	select {
+	case msg, ok := <-ch:
+		if !ok {
+			t.Fatal("feed channel closed")
+		}
+		return msg
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for feed message")
+	}
+	return WebsocketMessage{}
+}
+
+func TestProxyWebsocketFeed(t *testing.T) {
+	srv, cleanup := newWebsocktTestServer(t, echoHandler(t))
+	defer cleanup()
+
+	proxy, serve, shutdown := TestProxy(t, nil)
+	feed := NewWebsocketFeed()
+	feed.MaxPayload = 4
+	proxy.WebsocketFeed = feed
+	go serve()
+	defer shutdown()
+
+	wsDialer := newWebsocketDialer(t, proxy.Addr, proxy.CertificateAuthority)
+	conn, _, err := wsDialer.Dial(strings.Replace(srv.URL, "http", "ws", 1), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// the connection is registered after the upstream connection is established
+	var conns []WebsocketConn
+	deadline := time.Now().Add(5 * time.Second)
+	for len(conns) == 0 && time.Now().Before(deadline) {
+		conns = feed.Connections()
+		time.Sleep(10 * time.Millisecond)
+	}
+	if len(conns) != 1 {
+		t.Fatalf("wrong number of connections in feed: %v", conns)
+	}
+	if want := strings.Replace(srv.URL, "http", "ws", 1) + "/"; conns[0].URL != want {
+		t.Errorf("wrong URL for connection, want %v, got %v", want, conns[0].URL)
+	}
+
+	messages, cancel, err := feed.Subscribe(conns[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cancel()
+
+	if _, _, err := feed.Subscribe(conns[0].ID + 1); err == nil {
+		t.Error("subscribing to unknown connection did not return an error")
+	}
+
+	sendMessage(t, conn, websocket.TextMessage, []byte("foobar"))
+	wantNextMessage(t, conn, websocket.TextMessage, []byte("foobar"))
+
+	want := []WebsocketMessage{
+		{Direction: "client -> upstream", Type: "text", Length: 6, Payload: "foob"},
+		{Direction: "upstream -> client", Type: "text", Length: 6, Payload: "foob"},
+	}
+	for _, w := range want {
+		msg := nextFeedMessage(t, messages)
+		msg.Time = time.Time{}
+		if msg != w {
+			t.Errorf("wrong message in feed, want %+v, got %+v", w, msg)
+		}
+	}
+
+	err = conn.WriteMessage(
+		websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseNormalClosure, "done"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg := nextFeedMessage(t, messages)
+	if msg.Type != "close" || msg.Direction != "client -> upstream" || msg.Payload != "done" {
+		t.Errorf("wrong close message in feed: %+v", msg)
+	}
+
+	// the view is marked as closed and the channel is closed afterwards
+	for !msg.Closed {
+		msg = nextFeedMessage(t, messages)
+	}
+
+	select {
+	case _, ok := <-messages:
+		if ok {
+			t.Error("feed channel not closed after the connection was closed")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for feed channel to be closed")
+	}
+
+	if conns := feed.Connections(); len(conns) != 0 {
+		t.Errorf("closed connection still listed: %v", conns)
+	}
+}
+
+func TestProxyWebsocketFeedPaused(t *testing.T) {
+	srv, cleanup := newWebsocktTestServer(t, echoHandler(t))
+	defer cleanup()
+
+	proxy, serve, shutdown := TestProxy(t, nil)
+	feed := NewWebsocketFeed()
+	proxy.WebsocketFeed = feed
+	proxy.PauseCapture()
+	go serve()
+	defer shutdown()
+
+	wsDialer := newWebsocketDialer(t, proxy.Addr, proxy.CertificateAuthority)
+	conn, _, err := wsDialer.Dial(strings.Replace(srv.URL, "http", "ws", 1), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// the connection works, but it is not added to the feed
+	sendMessage(t, conn, websocket.TextMessage, []byte("foobar"))
+	wantNextMessage(t, conn, websocket.TextMessage, []byte("foobar"))
+
+	if conns := feed.Connections(); len(conns) != 0 {
+		t.Errorf("connection established while paused is listed: %v", conns)
+	}
+}
@@ -0,0 +1,232 @@
+// Package redact masks credentials and other secrets in requests and
+// responses before they are exported or logged.
+package redact
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// DefaultPlaceholder replaces redacted values if no other placeholder is set.
+const DefaultPlaceholder = "[REDACTED]"
+
+// DefaultHeaders contains the headers which usually contain credentials.
+var DefaultHeaders = []string{
+	"Authorization",
+	"Proxy-Authorization",
+	"Cookie",
+	"Set-Cookie",
+}
+
+// Redactor replaces the values of headers, JSON fields and parts of bodies
+// matching a pattern with a placeholder, keeping the structure intact. The
+// zero value does not redact anything.
+type Redactor struct {
+	// Headers contains the names of the headers to mask. For Authorization
+	// headers the scheme is kept, for cookies the names are kept.
+	Headers []string
+
+	// JSONFields contains names of fields in JSON bodies, their values are
+	// masked regardless of the nesting level.
+	JSONFields []string
+
+	// Patterns are applied to all bodies, matches are replaced.
+	Patterns []*regexp.Regexp
+
+	Placeholder string
+}
+
+func (r *Redactor) placeholder() string {
+	if r.Placeholder == "" {
+		return DefaultPlaceholder
+	}
+	return r.Placeholder
+}
+
+// Enabled returns true if r redacts anything.
+func (r *Redactor) Enabled() bool {
+	return r != nil && (len(r.Headers) > 0 || len(r.JSONFields) > 0 || len(r.Patterns) > 0)
+}
+
+// redactHeader returns the masked value of the header name.
+func (r *Redactor) redactHeader(name, value string) string {
+	switch http.CanonicalHeaderKey(name) {
+	case "Authorization", "Proxy-Authorization":
+		// keep the scheme
+		if i := strings.IndexByte(value, ' '); i > 0 {
+			return value[:i+1] + r.placeholder()
+		}
+	case "Cookie":
+		// keep the cookie names
+		cookies := strings.Split(value, ";")
+		for i, cookie := range cookies {
+			if j := strings.IndexByte(cookie, '='); j >= 0 {
+				cookies[i] = cookie[:j+1] + r.placeholder()
+			}
+		}
+		return strings.Join(cookies, ";")
+	case "Set-Cookie":
+		// keep the name and the attributes
+		if i := strings.IndexByte(value, '='); i >= 0 {
+			end := strings.IndexByte(value, ';')
+			if end < 0 {
+				end = len(value)
+			}
+			if end > i {
+				return value[:i+1] + r.placeholder() + value[end:]
+			}
+		}
+	}
+	return r.placeholder()
+}
+
+// isRedactedHeader returns true if the header name is to be redacted.
+func (r *Redactor) isRedactedHeader(name string) bool {
+	for _, h := range r.Headers {
+		if strings.EqualFold(h, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Header returns a copy of h with the values of the configured headers masked.
+func (r *Redactor) Header(h http.Header) http.Header {
+	res := make(http.Header, len(h))
+	for name, values := range h {
+		copied := make([]string, len(values))
+		for i, value := range values {
+			if r.isRedactedHeader(name) {
+				value = r.redactHeader(name, value)
+			}
+			copied[i] = value
+		}
+		res[name] = copied
+	}
+	return res
+}
+
+// redactJSON masks the values of the configured fields in data.
+func (r *Redactor) redactJSON(data interface{}) interface{} {
+	switch v := data.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			if r.isRedactedField(key) {
+				v[key] = r.placeholder()
+				continue
+			}
+			v[key] = r.redactJSON(value)
+		}
+	case []interface{}:
+		for i, value := range v {
+			v[i] = r.redactJSON(value)
+		}
+	}
+	return data
+}
+
+func (r *Redactor) isRedactedField(name string) bool {
+	for _, field := range r.JSONFields {
+		if field == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Body returns a copy of body with the configured JSON fields and patterns
+// masked, contentType is used to detect JSON documents. The body itself is
+// not modified.
+func (r *Redactor) Body(contentType string, body []byte) []byte {
+	res := append([]byte{}, body...)
+
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	isJSON := mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+	if isJSON && len(r.JSONFields) > 0 {
+		var data interface{}
+		dec := json.NewDecoder(bytes.NewReader(body))
+		dec.UseNumber()
+		if dec.Decode(&data) == nil {
+			if buf, err := json.Marshal(r.redactJSON(data)); err == nil {
+				res = buf
+			}
+		}
+	}
+
+	for _, pattern := range r.Patterns {
+		res = pattern.ReplaceAllLiteral(res, []byte(r.placeholder()))
+	}
+
+	return res
+}
+
+// Transform masks secrets in the body like Body, so that r can be used in a
+// display.Pipeline. If r is nil, the body is returned unchanged.
+func (r *Redactor) Transform(contentType string, in []byte) ([]byte, string, error) {
+	if !r.Enabled() {
+		return in, contentType, nil
+	}
+	return r.Body(contentType, in), contentType, nil
+}
+
+// Raw redacts a raw HTTP request or response (header and body) as produced
+// by e.g. httputil.DumpRequest. The header lines are kept as they are except
+// for the masked values, a body in chunked encoding is not parsed.
+func (r *Redactor) Raw(raw []byte) []byte {
+	if !r.Enabled() {
+		return raw
+	}
+
+	sep := []byte("\r\n\r\n")
+	end := bytes.Index(raw, sep)
+	if end < 0 {
+		sep = []byte("\n\n")
+		end = bytes.Index(raw, sep)
+	}
+
+	head, body := raw, []byte(nil)
+	if end >= 0 {
+		head, body = raw[:end], raw[end+len(sep):]
+	}
+
+	var contentType string
+	lines := bytes.Split(head, []byte("\n"))
+	for i, line := range lines {
+		if i == 0 {
+			// request or status line
+			continue
+		}
+
+		colon := bytes.IndexByte(line, ':')
+		if colon < 0 {
+			continue
+		}
+
+		name := string(line[:colon])
+		value := strings.TrimSpace(strings.TrimSuffix(string(line[colon+1:]), "\r"))
+
+		if strings.EqualFold(name, "Content-Type") {
+			contentType = value
+		}
+
+		if r.isRedactedHeader(name) {
+			line = []byte(name + ": " + r.redactHeader(name, value))
+			if bytes.HasSuffix(lines[i], []byte("\r")) {
+				line = append(line, '\r')
+			}
+			lines[i] = line
+		}
+	}
+
+	res := bytes.Join(lines, []byte("\n"))
+	if end < 0 {
+		return res
+	}
+
+	res = append(res, sep...)
+	return append(res, r.Body(contentType, body)...)
+}
@@ -0,0 +1,127 @@
+package redact
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestRedactorRaw(t *testing.T) {
+	raw := "POST /login HTTP/1.1\r\n" +
+		"Host: example.com\r\n" +
+		"Authorization: Bearer secret-token\r\n" +
+		"Cookie: session=abc; theme=dark\r\n" +
+		"Content-Type: application/json\r\n" +
+		"X-Request-Id: 1234\r\n" +
+		"\r\n" +
+		`{"user":"foo","password":"hunter2","nested":[{"password":"x"}]}`
+
+	var tests = []struct {
+		r    *Redactor
+		want string
+	}{
+		{
+			nil,
+			raw,
+		},
+		{
+			&Redactor{},
+			raw,
+		},
+		{
+			&Redactor{Headers: []string{"authorization"}},
+			"POST /login HTTP/1.1\r\n" +
+				"Host: example.com\r\n" +
+				"Authorization: Bearer [REDACTED]\r\n" +
+				"Cookie: session=abc; theme=dark\r\n" +
+				"Content-Type: application/json\r\n" +
+				"X-Request-Id: 1234\r\n" +
+				"\r\n" +
+				`{"user":"foo","password":"hunter2","nested":[{"password":"x"}]}`,
+		},
+		{
+			&Redactor{Headers: DefaultHeaders, JSONFields: []string{"password"}, Placeholder: "***"},
+			"POST /login HTTP/1.1\r\n" +
+				"Host: example.com\r\n" +
+				"Authorization: Bearer ***\r\n" +
+				"Cookie: session=***; theme=***\r\n" +
+				"Content-Type: application/json\r\n" +
+				"X-Request-Id: 1234\r\n" +
+				"\r\n" +
+				`{"nested":[{"password":"***"}],"password":"***","user":"foo"}`,
+		},
+		{
+			&Redactor{Patterns: []*regexp.Regexp{regexp.MustCompile(`hunter\d`)}},
+			"POST /login HTTP/1.1\r\n" +
+				"Host: example.com\r\n" +
+				"Authorization: Bearer secret-token\r\n" +
+				"Cookie: session=abc; theme=dark\r\n" +
+				"Content-Type: application/json\r\n" +
+				"X-Request-Id: 1234\r\n" +
+				"\r\n" +
+				`{"user":"foo","password":"[REDACTED]","nested":[{"password":"x"}]}`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run("", func(t *testing.T) {
+			orig := []byte(raw)
+			res := test.r.Raw(orig)
+			if string(res) != test.want {
+				t.Errorf("wrong result, want\n%s\ngot\n%s", test.want, res)
+			}
+
+			if string(orig) != raw {
+				t.Errorf("input was modified")
+			}
+		})
+	}
+}
+
+func TestRedactorHeader(t *testing.T) {
+	r := &Redactor{Headers: DefaultHeaders}
+
+	h := http.Header{
+		"Set-Cookie":    []string{"sid=abc; Path=/; HttpOnly"},
+		"Authorization": []string{"token"},
+		"Content-Type":  []string{"text/plain"},
+	}
+
+	res := r.Header(h)
+
+	want := map[string]string{
+		"Set-Cookie":    "sid=[REDACTED]; Path=/; HttpOnly",
+		"Authorization": "[REDACTED]",
+		"Content-Type":  "text/plain",
+	}
+	for name, value := range want {
+		if res.Get(name) != value {
+			t.Errorf("wrong value for %v, want %q, got %q", name, value, res.Get(name))
+		}
+	}
+
+	if h.Get("Authorization") != "token" {
+		t.Errorf("original header was modified")
+	}
+}
+
+func TestRedactorTransform(t *testing.T) {
+	var r *Redactor
+	out, _, err := r.Transform("application/json", []byte(`{"password":"secret"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != `{"password":"secret"}` {
+		t.Errorf("nil redactor changed the body: %s", out)
+	}
+
+	r = &Redactor{JSONFields: []string{"password"}}
+	out, contentType, err := r.Transform("application/json", []byte(`{"password":"secret"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(out), "secret") || contentType != "application/json" {
+		t.Errorf("secret not masked: %s (%v)", out, contentType)
+	}
+}
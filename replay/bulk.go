@@ -0,0 +1,118 @@
+package replay
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// BulkResult is the outcome of replaying one transaction in a bulk operation.
+type BulkResult struct {
+	// ID is the transaction the request was taken from.
+	ID uint64
+
+	// Skipped is set if the request was not sent, e.g. because it did not
+	// contain the search string.
+	Skipped bool
+
+	// NewID is the ID of the recorded transaction, StatusCode the status of
+	// the response received.
+	NewID      uint64
+	StatusCode int
+
+	Err error
+}
+
+// replaceInRequest replaces all occurrences of old with new in the URL, the
+// header values and the body of req. It returns false if old was not found.
+func replaceInRequest(req *http.Request, old, new string) (bool, error) {
+	var found bool
+	replace := func(s string) string {
+		if strings.Contains(s, old) {
+			found = true
+			return strings.Replace(s, old, new, -1)
+		}
+		return s
+	}
+
+	u, err := url.Parse(replace(req.URL.String()))
+	if err != nil {
+		return false, fmt.Errorf("parsing URL after replacement: %v", err)
+	}
+	req.URL = u
+	req.Host = replace(req.Host)
+
+	for name, values := range req.Header {
+		for i, value := range values {
+			values[i] = replace(value)
+		}
+		req.Header[name] = values
+	}
+
+	var body []byte
+	if req.Body != nil {
+		body, err = ioutil.ReadAll(req.Body)
+		if err != nil {
+			return false, err
+		}
+		_ = req.Body.Close()
+	}
+
+	if bytes.Contains(body, []byte(old)) {
+		found = true
+		body = bytes.Replace(body, []byte(old), []byte(new), -1)
+	}
+
+	req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	req.ContentLength = int64(len(body))
+	if req.Header.Get("Content-Length") != "" {
+		req.Header.Set("Content-Length", fmt.Sprint(len(body)))
+	}
+
+	return found, nil
+}
+
+// ReplaceAndReplay replaces old with new in the stored requests of the given
+// transactions (in URL, headers and body) and replays them as new
+// transactions. Requests which don't contain old are skipped. A result is
+// returned for each ID in order.
+func (r *Replayer) ReplaceAndReplay(ids []uint64, old, new string) []BulkResult {
+	results := make([]BulkResult, 0, len(ids))
+	for _, id := range ids {
+		result := BulkResult{ID: id}
+
+		req, err := r.storedRequest(id)
+		if err != nil {
+			result.Err = fmt.Errorf("loading request %d: %v", id, err)
+			results = append(results, result)
+			continue
+		}
+
+		found, err := replaceInRequest(req, old, new)
+		if err != nil {
+			result.Err = err
+			results = append(results, result)
+			continue
+		}
+
+		if !found {
+			result.Skipped = true
+			results = append(results, result)
+			continue
+		}
+
+		newID, res, err := r.Do(req)
+		result.NewID = newID
+		if err != nil {
+			result.Err = err
+		} else {
+			result.StatusCode = res.StatusCode
+		}
+		results = append(results, result)
+	}
+
+	return results
+}
@@ -0,0 +1,78 @@
+package replay
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestReplaceAndReplay(t *testing.T) {
+	s, cleanup := testStore(t)
+	defer cleanup()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("Authorization") != "Bearer fresh" {
+			rw.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		rw.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	r := New(s, nil)
+
+	var ids []uint64
+	for _, token := range []string{"expired", "", "expired"} {
+		req, err := http.NewRequest(http.MethodGet, srv.URL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+
+		id, res, err := r.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != http.StatusUnauthorized {
+			t.Fatalf("unexpected status for request %d: %v", id, res.Status)
+		}
+		ids = append(ids, id)
+	}
+
+	results := r.ReplaceAndReplay(ids, "Bearer expired", "Bearer fresh")
+	if len(results) != len(ids) {
+		t.Fatalf("wrong number of results, want %d, got %d", len(ids), len(results))
+	}
+
+	for i, result := range results {
+		if result.Err != nil {
+			t.Errorf("replaying %d failed: %v", result.ID, result.Err)
+			continue
+		}
+
+		if result.ID != ids[i] {
+			t.Errorf("result %d has wrong ID, want %d, got %d", i, ids[i], result.ID)
+		}
+
+		if i == 1 {
+			if !result.Skipped {
+				t.Errorf("request without the token was not skipped")
+			}
+			continue
+		}
+
+		if result.Skipped {
+			t.Errorf("request %d was skipped", result.ID)
+		}
+
+		if result.StatusCode != http.StatusOK {
+			t.Errorf("replayed request %d has wrong status %v", result.ID, result.StatusCode)
+		}
+
+		if _, err := s.GetSummary(result.NewID); err != nil {
+			t.Errorf("replayed transaction %d was not recorded: %v", result.NewID, err)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package replay
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Assertion checks a response, it returns an error describing the mismatch
+// if the response does not meet the expectation.
+type Assertion func(res *http.Response, body []byte) error
+
+// ExpectStatus requires the response to have the status code.
+func ExpectStatus(code int) Assertion {
+	return func(res *http.Response, _ []byte) error {
+		if res.StatusCode != code {
+			return fmt.Errorf("status: want %d, got %d", code, res.StatusCode)
+		}
+		return nil
+	}
+}
+
+// ExpectHeader requires the header name to contain the string substr.
+func ExpectHeader(name, substr string) Assertion {
+	return func(res *http.Response, _ []byte) error {
+		value := res.Header.Get(name)
+		if !strings.Contains(value, substr) {
+			return fmt.Errorf("header %v: %q does not contain %q", name, value, substr)
+		}
+		return nil
+	}
+}
+
+// ExpectBody requires the body to match the regular expression.
+func ExpectBody(re *regexp.Regexp) Assertion {
+	return func(_ *http.Response, body []byte) error {
+		if !re.Match(body) {
+			return fmt.Errorf("body does not match %v", re)
+		}
+		return nil
+	}
+}
+
+// ExpectJSON requires the JSON body to contain value at path. The path
+// consists of object keys and array indexes separated by dots, e.g.
+// "items.0.name". The value is compared after decoding it from JSON, so
+// numbers need to be given as float64.
+func ExpectJSON(path string, value interface{}) Assertion {
+	return func(_ *http.Response, body []byte) error {
+		var data interface{}
+		err := json.Unmarshal(body, &data)
+		if err != nil {
+			return fmt.Errorf("body is not JSON: %v", err)
+		}
+
+		v, err := lookupJSON(data, path)
+		if err != nil {
+			return err
+		}
+
+		if !reflect.DeepEqual(v, value) {
+			return fmt.Errorf("JSON %v: want %#v, got %#v", path, value, v)
+		}
+		return nil
+	}
+}
+
+// lookupJSON returns the value at path in the decoded JSON data.
+func lookupJSON(data interface{}, path string) (interface{}, error) {
+	if path == "" {
+		return data, nil
+	}
+
+	for _, elem := range strings.Split(path, ".") {
+		switch d := data.(type) {
+		case map[string]interface{}:
+			v, ok := d[elem]
+			if !ok {
+				return nil, fmt.Errorf("JSON %v: key %q not found", path, elem)
+			}
+			data = v
+		case []interface{}:
+			i, err := strconv.Atoi(elem)
+			if err != nil || i < 0 || i >= len(d) {
+				return nil, fmt.Errorf("JSON %v: invalid index %q", path, elem)
+			}
+			data = d[i]
+		default:
+			return nil, fmt.Errorf("JSON %v: cannot descend into %q", path, elem)
+		}
+	}
+
+	return data, nil
+}
+
+// Check describes a stored request and the expectations on the response
+// received when it is replayed.
+type Check struct {
+	Name       string
+	ID         uint64
+	Assertions []Assertion
+}
+
+// CheckResult is the outcome of a check.
+type CheckResult struct {
+	Name string
+
+	// NewID is the ID of the transaction recorded during the check.
+	NewID uint64
+
+	// Failures contains the assertions which did not hold.
+	Failures []error
+
+	// Err is set if the request could not be replayed.
+	Err error
+}
+
+// Passed returns true if the request was replayed and all assertions held.
+func (c CheckResult) Passed() bool {
+	return c.Err == nil && len(c.Failures) == 0
+}
+
+// RunChecks replays the requests of the checks in order and evaluates the
+// assertions against the responses.
+func (r *Replayer) RunChecks(checks []Check) []CheckResult {
+	results := make([]CheckResult, 0, len(checks))
+	for _, check := range checks {
+		result := CheckResult{Name: check.Name}
+
+		newID, res, err := r.Replay(check.ID)
+		result.NewID = newID
+		if err != nil {
+			result.Err = err
+			results = append(results, result)
+			continue
+		}
+
+		// the body of responses returned by Replay can be read again
+		body, err := ioutil.ReadAll(res.Body)
+		if err != nil {
+			result.Err = fmt.Errorf("reading response body: %v", err)
+			results = append(results, result)
+			continue
+		}
+
+		for _, assert := range check.Assertions {
+			err := assert(res, body)
+			if err != nil {
+				result.Failures = append(result.Failures, err)
+			}
+		}
+
+		results = append(results, result)
+	}
+
+	return results
+}
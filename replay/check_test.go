@@ -0,0 +1,89 @@
+package replay
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+)
+
+func TestRunChecks(t *testing.T) {
+	s, cleanup := testStore(t)
+	defer cleanup()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Content-Type", "application/json; charset=utf-8")
+		io.WriteString(rw, `{"user": {"name": "alice", "roles": ["admin", "dev"]}, "count": 2}`)
+	}))
+	defer srv.Close()
+
+	r := New(s, nil)
+
+	req, err := http.NewRequest(http.MethodGet, srv.URL+"/user", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id, _, err := r.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checks := []Check{
+		{
+			Name: "pass",
+			ID:   id,
+			Assertions: []Assertion{
+				ExpectStatus(http.StatusOK),
+				ExpectHeader("Content-Type", "application/json"),
+				ExpectBody(regexp.MustCompile(`"name":\s*"alice"`)),
+				ExpectJSON("user.roles.0", "admin"),
+				ExpectJSON("count", float64(2)),
+			},
+		},
+		{
+			Name: "fail",
+			ID:   id,
+			Assertions: []Assertion{
+				ExpectStatus(http.StatusOK),
+				ExpectStatus(http.StatusCreated),
+				ExpectJSON("user.roles.1", "admin"),
+				ExpectJSON("user.email", "alice@example.com"),
+			},
+		},
+		{
+			Name: "missing",
+			ID:   id + 100,
+		},
+	}
+
+	results := r.RunChecks(checks)
+	if len(results) != len(checks) {
+		t.Fatalf("wrong number of results, want %d, got %d", len(checks), len(results))
+	}
+
+	pass := results[0]
+	if !pass.Passed() {
+		t.Errorf("check %v failed: err %v, failures %v", pass.Name, pass.Err, pass.Failures)
+	}
+	if pass.NewID == 0 || pass.NewID == id {
+		t.Errorf("check %v has wrong new ID %v", pass.Name, pass.NewID)
+	}
+
+	fail := results[1]
+	if fail.Passed() {
+		t.Errorf("check %v passed", fail.Name)
+	}
+	if fail.Err != nil {
+		t.Errorf("check %v returned error: %v", fail.Name, fail.Err)
+	}
+	if len(fail.Failures) != 3 {
+		t.Errorf("check %v: want 3 failures, got %v", fail.Name, fail.Failures)
+	}
+
+	missing := results[2]
+	if missing.Passed() || missing.Err == nil {
+		t.Errorf("check %v: expected error not found", missing.Name)
+	}
+}
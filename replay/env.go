@@ -0,0 +1,149 @@
+package replay
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// EnvBaseURL is the name of the variable which selects the target of
+// requests replayed with an Environment.
+const EnvBaseURL = "base_url"
+
+// Environment maps variable names to values for replaying stored requests
+// against a particular deployment, e.g. staging or production. Placeholders
+// of the form {{name}} in the path, the query, the header values and the body
+// of a request are replaced by the value of the variable. Host names can't
+// contain placeholders, so if the variable EnvBaseURL is set, its scheme and
+// host replace those of the request and its path is prepended.
+type Environment map[string]string
+
+// placeholder matches "{{name}}", the name is the first submatch.
+var placeholder = regexp.MustCompile(`\{\{\s*([A-Za-z0-9_.-]+)\s*\}\}`)
+
+// expand replaces the placeholders in s, undefined variables are an error.
+func (env Environment) expand(s string) (string, error) {
+	var err error
+	res := placeholder.ReplaceAllStringFunc(s, func(match string) string {
+		name := placeholder.FindStringSubmatch(match)[1]
+		value, ok := env[name]
+		if !ok {
+			if err == nil {
+				err = fmt.Errorf("variable %q is not defined", name)
+			}
+			return match
+		}
+		return value
+	})
+	return res, err
+}
+
+// Apply replaces the placeholders in req and sets the target to the base URL,
+// if any. The body is read into memory and Content-Length is updated.
+func (env Environment) Apply(req *http.Request) error {
+	u := *req.URL
+
+	if base, ok := env[EnvBaseURL]; ok {
+		b, err := url.Parse(base)
+		if err != nil {
+			return fmt.Errorf("invalid %v: %v", EnvBaseURL, err)
+		}
+		if b.Scheme == "" || b.Host == "" {
+			return fmt.Errorf("invalid %v %q: scheme or host missing", EnvBaseURL, base)
+		}
+
+		u.Scheme = b.Scheme
+		u.Host = b.Host
+		if prefix := strings.TrimSuffix(b.Path, "/"); prefix != "" {
+			u.Path = prefix + u.Path
+			u.RawPath = ""
+		}
+		req.Host = b.Host
+	}
+
+	path, err := env.expand(u.Path)
+	if err != nil {
+		return fmt.Errorf("path: %v", err)
+	}
+	if path != u.Path {
+		u.Path = path
+		u.RawPath = ""
+	}
+
+	u.RawQuery, err = env.expand(u.RawQuery)
+	if err != nil {
+		return fmt.Errorf("query: %v", err)
+	}
+	req.URL = &u
+
+	for name, values := range req.Header {
+		for i, value := range values {
+			values[i], err = env.expand(value)
+			if err != nil {
+				return fmt.Errorf("header %v: %v", name, err)
+			}
+		}
+	}
+
+	if req.Body == nil {
+		return nil
+	}
+
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return fmt.Errorf("reading body: %v", err)
+	}
+	_ = req.Body.Close()
+
+	expanded, err := env.expand(string(body))
+	if err != nil {
+		return fmt.Errorf("body: %v", err)
+	}
+
+	req.Body = ioutil.NopCloser(strings.NewReader(expanded))
+	req.ContentLength = int64(len(expanded))
+	req.TransferEncoding = nil
+	return nil
+}
+
+// ReadEnvironment parses an environment from a JSON object mapping the
+// variable names to the values.
+func ReadEnvironment(r io.Reader) (Environment, error) {
+	var env Environment
+	err := json.NewDecoder(r).Decode(&env)
+	if err != nil {
+		return nil, fmt.Errorf("parsing environment: %v", err)
+	}
+	return env, nil
+}
+
+// LoadEnvironment reads an environment from the JSON file filename.
+func LoadEnvironment(filename string) (Environment, error) {
+	buf, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return ReadEnvironment(bytes.NewReader(buf))
+}
+
+// ReplayEnv sends the request of the stored transaction again like Replay,
+// with the variables of env applied.
+func (r *Replayer) ReplayEnv(id uint64, env Environment) (newID uint64, res *http.Response, err error) {
+	req, err := r.storedRequest(id)
+	if err != nil {
+		return 0, nil, fmt.Errorf("loading request %d: %v", id, err)
+	}
+
+	err = env.Apply(req)
+	if err != nil {
+		return 0, nil, fmt.Errorf("applying environment to request %d: %v", id, err)
+	}
+
+	return r.Do(req)
+}
@@ -0,0 +1,131 @@
+package replay
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestReplayEnv(t *testing.T) {
+	s, cleanup := testStore(t)
+	defer cleanup()
+
+	type received struct {
+		url, auth, body string
+	}
+
+	newServer := func(ch chan<- received) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			body, _ := ioutil.ReadAll(req.Body)
+			ch <- received{
+				url:  req.URL.String(),
+				auth: req.Header.Get("Authorization"),
+				body: string(body),
+			}
+		}))
+	}
+
+	stagingCh := make(chan received, 1)
+	staging := newServer(stagingCh)
+	defer staging.Close()
+
+	prodCh := make(chan received, 1)
+	prod := newServer(prodCh)
+	defer prod.Close()
+
+	req, err := http.NewRequest(http.MethodPost, "http://captured.invalid/api/{{version}}/users?token={{token}}",
+		strings.NewReader(`{"env": "{{name}}"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer {{ token }}")
+
+	err = s.AddRequest(1, req, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := New(s, nil)
+
+	var tests = []struct {
+		env  Environment
+		ch   chan received
+		want received
+	}{
+		{
+			Environment{EnvBaseURL: staging.URL, "version": "v2", "token": "staging-token", "name": "staging"},
+			stagingCh,
+			received{
+				url:  "/api/v2/users?token=staging-token",
+				auth: "Bearer staging-token",
+				body: `{"env": "staging"}`,
+			},
+		},
+		{
+			Environment{EnvBaseURL: prod.URL + "/prefix/", "version": "v1", "token": "prod-token", "name": "production"},
+			prodCh,
+			received{
+				url:  "/prefix/api/v1/users?token=prod-token",
+				auth: "Bearer prod-token",
+				body: `{"env": "production"}`,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		newID, res, err := r.ReplayEnv(1, test.env)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != http.StatusOK {
+			t.Errorf("wrong status %v", res.Status)
+		}
+
+		got := <-test.ch
+		if got != test.want {
+			t.Errorf("wrong request received for %v, want %+v, got %+v", test.env[EnvBaseURL], test.want, got)
+		}
+
+		// the new transaction contains the request as sent
+		stored, err := s.GetRequest(newID, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(test.env[EnvBaseURL], stored.URL.Scheme+"://"+stored.URL.Host) {
+			t.Errorf("wrong target stored: %v", stored.URL)
+		}
+	}
+
+	// the stored request is not modified
+	orig, err := s.GetRequest(1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if orig.URL.Host != "captured.invalid" || orig.Header.Get("Authorization") != "Bearer {{ token }}" {
+		t.Errorf("stored request was modified: %v %v", orig.URL, orig.Header)
+	}
+
+	// undefined variables are not sent
+	_, _, err = r.ReplayEnv(1, Environment{EnvBaseURL: staging.URL, "version": "v2"})
+	if err == nil || !strings.Contains(err.Error(), `"token"`) {
+		t.Errorf("replay with undefined variable returned wrong error: %v", err)
+	}
+}
+
+func TestReadEnvironment(t *testing.T) {
+	env, err := ReadEnvironment(strings.NewReader(`{"base_url": "https://example.com", "token": "secret"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if env[EnvBaseURL] != "https://example.com" || env["token"] != "secret" {
+		t.Errorf("wrong environment parsed: %v", env)
+	}
+
+	_, err = ReadEnvironment(strings.NewReader(`["list"]`))
+	if err == nil {
+		t.Error("invalid environment was accepted")
+	}
+}
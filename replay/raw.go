@@ -0,0 +1,111 @@
+package replay
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"time"
+)
+
+var defaultPorts = map[string]string{
+	"http":  "80",
+	"https": "443",
+}
+
+// ReplayRaw sends the exact bytes stored for the request of the transaction
+// with SetRawRequest (e.g. captured with proxy.Proxy.CaptureRaw) to the
+// target server on a new connection, so that signatures over the raw request
+// still match. The parsed request selects the target. Both are recorded as a
+// new transaction together with the response.
+func (r *Replayer) ReplayRaw(id uint64) (newID uint64, res *http.Response, err error) {
+	raw, err := r.Store.GetRawRequest(id)
+	if err != nil {
+		return 0, nil, fmt.Errorf("loading raw request %d: %v", id, err)
+	}
+
+	req, err := r.Store.GetRequest(id, false)
+	if err != nil {
+		return 0, nil, fmt.Errorf("loading request %d: %v", id, err)
+	}
+
+	conn, err := r.dialRaw(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer conn.Close()
+
+	newID, err = r.Store.NextID()
+	if err == nil {
+		err = r.Store.AddRequest(newID, req, false)
+	}
+	if err == nil {
+		err = r.Store.SetRawRequest(newID, raw)
+	}
+	if err != nil {
+		return 0, nil, fmt.Errorf("recording request: %v", err)
+	}
+
+	_, err = conn.Write(raw)
+	if err != nil {
+		return newID, nil, err
+	}
+
+	res, err = http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		return newID, nil, fmt.Errorf("reading response: %v", err)
+	}
+
+	resBody, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return newID, nil, fmt.Errorf("reading response body: %v", err)
+	}
+	_ = res.Body.Close()
+
+	err = r.Store.AddResponse(newID, res, resBody, false)
+	if err != nil {
+		return newID, nil, fmt.Errorf("recording response: %v", err)
+	}
+
+	return newID, res, nil
+}
+
+// dialRaw connects to the target of req, with TLS for https. The TLS client
+// configuration of the client's transport is used if there is one.
+func (r *Replayer) dialRaw(req *http.Request) (net.Conn, error) {
+	port := req.URL.Port()
+	if port == "" {
+		port = defaultPorts[req.URL.Scheme]
+	}
+	addr := net.JoinHostPort(req.URL.Hostname(), port)
+
+	dialer := &net.Dialer{Timeout: 30 * time.Second}
+	conn, err := dialer.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.URL.Scheme != "https" {
+		return conn, nil
+	}
+
+	cfg := &tls.Config{}
+	if tr, ok := r.Client.Transport.(*http.Transport); ok && tr.TLSClientConfig != nil {
+		cfg = tr.TLSClientConfig.Clone()
+	}
+	if cfg.ServerName == "" {
+		cfg.ServerName = req.URL.Hostname()
+	}
+	// the raw bytes are HTTP/1.x
+	cfg.NextProtos = []string{"http/1.1"}
+
+	tlsConn := tls.Client(conn, cfg)
+	err = tlsConn.Handshake()
+	if err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("TLS handshake with %v failed: %v", addr, err)
+	}
+	return tlsConn, nil
+}
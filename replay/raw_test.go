@@ -0,0 +1,167 @@
+package replay
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/fd0/osmosis/proxy"
+)
+
+// rawServer answers each HTTP/1.1 request with "ok" and records the bytes
+// received on each connection.
+type rawServer struct {
+	net.Listener
+
+	m     sync.Mutex
+	conns []*bytes.Buffer
+}
+
+func newRawServer(t testing.TB) *rawServer {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := &rawServer{Listener: l}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			buf := &bytes.Buffer{}
+			srv.m.Lock()
+			srv.conns = append(srv.conns, buf)
+			srv.m.Unlock()
+
+			go srv.serve(conn, buf)
+		}
+	}()
+	return srv
+}
+
+func (srv *rawServer) serve(conn net.Conn, buf *bytes.Buffer) {
+	defer conn.Close()
+
+	rd := bufio.NewReader(io.TeeReader(conn, writerFunc(func(p []byte) (int, error) {
+		srv.m.Lock()
+		defer srv.m.Unlock()
+		return buf.Write(p)
+	})))
+
+	for {
+		req, err := http.ReadRequest(rd)
+		if err != nil {
+			return
+		}
+		_, _ = io.Copy(ioutil.Discard, req.Body)
+		_, err = io.WriteString(conn, "HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok")
+		if err != nil {
+			return
+		}
+	}
+}
+
+// received returns the bytes received on connection i.
+func (srv *rawServer) received(i int) string {
+	srv.m.Lock()
+	defer srv.m.Unlock()
+	if i >= len(srv.conns) {
+		return ""
+	}
+	return srv.conns[i].String()
+}
+
+type writerFunc func([]byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) { return f(p) }
+
+func TestReplayRaw(t *testing.T) {
+	s, cleanup := testStore(t)
+	defer cleanup()
+
+	upstream := newRawServer(t)
+	defer upstream.Close()
+
+	p, serve, shutdown := proxy.TestProxy(t, nil)
+	p.CaptureRaw = true
+	go serve()
+	defer shutdown()
+
+	// record transactions in the store, the request is forwarded verbatim
+	p.Register(func(event *proxy.Event) (*proxy.Response, error) {
+		err := s.AddRequest(event.ID, event.Req, false)
+		if err != nil {
+			return nil, err
+		}
+		err = s.SetRawRequest(event.ID, event.RawClientRequest)
+		if err != nil {
+			return nil, err
+		}
+		event.RawUpstream = event.RawClientRequest
+		return event.ForwardRequest()
+	})
+
+	addr := upstream.Addr().String()
+	raw := "POST http://" + addr + "/sign HTTP/1.1\r\n" +
+		"host: " + addr + "\r\n" +
+		"X-Signature:  abc def \r\n" +
+		"x-date: today\r\n" +
+		"Content-Length: 5\r\n" +
+		"\r\n" +
+		"hello"
+
+	conn, err := net.Dial("tcp", p.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	_, err = io.WriteString(conn, raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status %v", res.StatusCode)
+	}
+
+	if upstream.received(0) != raw {
+		t.Fatalf("request not forwarded verbatim:\nwant %q\n got %q", raw, upstream.received(0))
+	}
+
+	newID, res, err := New(s, nil).ReplayRaw(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("unexpected status %v for replayed request", res.StatusCode)
+	}
+
+	if upstream.received(1) != raw {
+		t.Errorf("request not replayed verbatim:\nwant %q\n got %q", raw, upstream.received(1))
+	}
+
+	stored, err := s.GetRawRequest(newID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(stored) != raw {
+		t.Errorf("wrong raw request recorded for replay: %q", stored)
+	}
+
+	if _, err := s.GetResponse(newID, false); err != nil {
+		t.Errorf("response of replay not recorded: %v", err)
+	}
+}
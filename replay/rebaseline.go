@@ -0,0 +1,172 @@
+package replay
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/fd0/osmosis/store"
+)
+
+// RebaselineOptions configures Rebaseline.
+type RebaselineOptions struct {
+	// Scope lists host patterns like those of the store scopes, a pattern
+	// starting with "*." also matches all subdomains. Only transactions for
+	// matching hosts are replayed, all if Scope is empty.
+	Scope []string
+
+	// Concurrency is the maximum number of requests in flight, the default
+	// is one.
+	Concurrency int
+
+	// Progress, if set, is called after each transaction with the number of
+	// transactions done so far and the total. Calls are not concurrent.
+	Progress func(done, total int, result BulkResult)
+}
+
+// inScope returns true if the host of the transaction (without the port)
+// matches one of the patterns in scope.
+func inScope(scope []string, summary *store.TxnSummary) bool {
+	if len(scope) == 0 {
+		return true
+	}
+
+	host := summary.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	for _, pattern := range scope {
+		if store.MatchHost(pattern, host) {
+			return true
+		}
+	}
+	return false
+}
+
+// isWebsocket returns true if the transaction is a websocket handshake.
+func isWebsocket(summary *store.TxnSummary, req *http.Request) bool {
+	if summary.StatusCode == http.StatusSwitchingProtocols {
+		return true
+	}
+	return strings.EqualFold(req.Header.Get("Upgrade"), "websocket")
+}
+
+// Rebaseline sends the requests of all stored transactions again and stores
+// each new response as the edited response of the transaction, so that the
+// original responses can be compared to the current state of the server. No
+// new transactions are recorded. Transactions outside of the scope and
+// websocket handshakes are skipped. A result (without NewID) is returned for
+// each transaction ordered by ID. If ctx is cancelled, the remaining
+// transactions are not sent and the error of ctx is returned.
+func (r *Replayer) Rebaseline(ctx context.Context, opts RebaselineOptions) ([]BulkResult, error) {
+	summaries, err := r.Store.TxnSummaries()
+	if err != nil {
+		return nil, fmt.Errorf("listing transactions: %v", err)
+	}
+
+	concurrency := opts.Concurrency
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+
+	results := make([]BulkResult, len(summaries))
+	var mu sync.Mutex
+	var done int
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				result := r.rebaseline(ctx, summaries[i], opts.Scope)
+
+				mu.Lock()
+				results[i] = result
+				done++
+				if opts.Progress != nil {
+					opts.Progress(done, len(summaries), result)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	sent := len(summaries)
+dispatch:
+	for i := range summaries {
+		// select picks randomly if a worker is ready as well
+		if ctx.Err() != nil {
+			err = ctx.Err()
+			sent = i
+			break
+		}
+
+		select {
+		case jobs <- i:
+		case <-ctx.Done():
+			err = ctx.Err()
+			sent = i
+			break dispatch
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	return results[:sent], err
+}
+
+// rebaseline replays the request of a single transaction and stores the
+// response as the edited response.
+func (r *Replayer) rebaseline(ctx context.Context, summary *store.TxnSummary, scope []string) BulkResult {
+	result := BulkResult{ID: summary.ID}
+
+	if !inScope(scope, summary) {
+		result.Skipped = true
+		return result
+	}
+
+	req, err := r.storedRequest(summary.ID)
+	if err != nil {
+		result.Err = fmt.Errorf("loading request %d: %v", summary.ID, err)
+		return result
+	}
+
+	if isWebsocket(summary, req) {
+		result.Skipped = true
+		return result
+	}
+
+	// RequestURI can't be set for client requests
+	req.RequestURI = ""
+
+	res, err := r.Client.Do(req.WithContext(ctx))
+	if err != nil {
+		result.Err = err
+		return result
+	}
+
+	body, err := ioutil.ReadAll(res.Body)
+	_ = res.Body.Close()
+	if err != nil {
+		result.Err = fmt.Errorf("reading response body: %v", err)
+		return result
+	}
+	res.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	err = r.Store.AddResponse(summary.ID, res, body, true)
+	if err != nil {
+		result.Err = fmt.Errorf("storing response %d: %v", summary.ID, err)
+		return result
+	}
+
+	result.StatusCode = res.StatusCode
+	return result
+}
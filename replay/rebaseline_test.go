@@ -0,0 +1,183 @@
+package replay
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/dgraph-io/badger"
+)
+
+func TestRebaseline(t *testing.T) {
+	s, cleanup := testStore(t)
+	defer cleanup()
+
+	var mu sync.Mutex
+	version := 1
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("Upgrade") != "" {
+			t.Errorf("websocket handshake was replayed")
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		fmt.Fprintf(rw, "version %d of %v", version, req.URL.Path)
+	}))
+	defer srv.Close()
+
+	r := New(s, nil)
+
+	var ids []uint64
+	for _, path := range []string{"/a", "/b", "/c"} {
+		req, err := http.NewRequest(http.MethodGet, srv.URL+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		id, _, err := r.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+
+	// a websocket handshake and a transaction outside of the scope
+	var skipped []uint64
+	for i, raw := range []string{
+		"GET " + srv.URL + "/ws HTTP/1.1\r\nHost: " + strings.TrimPrefix(srv.URL, "http://") + "\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n",
+		"GET http://other.example.com/ HTTP/1.1\r\nHost: other.example.com\r\n\r\n",
+	} {
+		req, err := http.ReadRequest(bufio.NewReader(strings.NewReader(raw)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		id := uint64(100 + i)
+		err = s.AddRequest(id, req, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		skipped = append(skipped, id)
+	}
+
+	mu.Lock()
+	version = 2
+	mu.Unlock()
+
+	maxID, err := s.MaxID()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	host, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var progress []int
+	results, err := r.Rebaseline(context.Background(), RebaselineOptions{
+		Scope:       []string{host.Hostname()},
+		Concurrency: 2,
+		Progress: func(done, total int, result BulkResult) {
+			if total != 5 {
+				t.Errorf("wrong total %d", total)
+			}
+			progress = append(progress, done)
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(results) != 5 {
+		t.Fatalf("want 5 results, got %d", len(results))
+	}
+	if len(progress) != 5 || progress[4] != 5 {
+		t.Errorf("wrong progress reported: %v", progress)
+	}
+
+	for i, id := range ids {
+		result := results[i]
+		if result.ID != id || result.Err != nil || result.Skipped || result.StatusCode != http.StatusOK {
+			t.Errorf("wrong result for transaction %d: %+v", id, result)
+		}
+
+		for _, edited := range []bool{false, true} {
+			res, err := s.GetResponse(id, edited)
+			if err != nil {
+				t.Fatalf("response %d (edited %v): %v", id, edited, err)
+			}
+			body, err := ioutil.ReadAll(res.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			want := "version 1"
+			if edited {
+				want = "version 2"
+			}
+			if !strings.HasPrefix(string(body), want) {
+				t.Errorf("response %d (edited %v): want %q, got %q", id, edited, want, body)
+			}
+		}
+	}
+
+	for i, id := range skipped {
+		result := results[len(ids)+i]
+		if result.ID != id || !result.Skipped {
+			t.Errorf("transaction %d was not skipped: %+v", id, result)
+		}
+		_, err := s.GetResponse(id, true)
+		if err != badger.ErrKeyNotFound {
+			t.Errorf("edited response stored for skipped transaction %d: %v", id, err)
+		}
+	}
+
+	newMaxID, err := s.MaxID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if newMaxID != maxID {
+		t.Errorf("rebaseline recorded new transactions, max ID %d -> %d", maxID, newMaxID)
+	}
+}
+
+func TestRebaselineCancel(t *testing.T) {
+	s, cleanup := testStore(t)
+	defer cleanup()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		_, _ = io.WriteString(rw, "ok")
+	}))
+	defer srv.Close()
+
+	r := New(s, nil)
+	for i := 0; i < 3; i++ {
+		req, err := http.NewRequest(http.MethodGet, srv.URL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _, err = r.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	results, err := r.Rebaseline(ctx, RebaselineOptions{
+		Progress: func(done, total int, result BulkResult) {
+			cancel()
+		},
+	})
+	if err != context.Canceled {
+		t.Fatalf("want context.Canceled, got %v", err)
+	}
+	if len(results) == 0 || len(results) == 3 {
+		t.Errorf("unexpected number of results after cancel: %d", len(results))
+	}
+}
@@ -0,0 +1,289 @@
+// Package replay sends stored requests again and records the new
+// transactions in the store.
+package replay
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/cookiejar"
+
+	"github.com/dgraph-io/badger"
+	"github.com/fd0/osmosis/proxy"
+	"github.com/fd0/osmosis/store"
+)
+
+// Replayer sends requests and records them as new transactions in a store.
+type Replayer struct {
+	Store  *store.TxnStore
+	Client *http.Client
+
+	// StripConditional removes conditional headers like If-None-Match from
+	// stored requests before they are sent again, so that the server sends
+	// the full response instead of 304 Not Modified. StripCache removes the
+	// headers controlling caches (Cache-Control and Pragma) in addition.
+	StripConditional bool
+	StripCache       bool
+}
+
+// conditionalHeaders are removed from replayed requests with StripConditional.
+var conditionalHeaders = []string{
+	"If-None-Match",
+	"If-Modified-Since",
+	"If-Match",
+	"If-Unmodified-Since",
+	"If-Range",
+}
+
+// cacheHeaders are removed from replayed requests with StripCache.
+var cacheHeaders = []string{
+	"Cache-Control",
+	"Pragma",
+}
+
+// New returns a new Replayer which records transactions in s. If client is
+// nil, a client which does not follow redirects is used. Conditional headers
+// are removed from replayed requests by default.
+func New(s *store.TxnStore, client *http.Client) *Replayer {
+	if client == nil {
+		client = &http.Client{
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		}
+	}
+
+	return &Replayer{
+		Store:            s,
+		Client:           client,
+		StripConditional: true,
+	}
+}
+
+// Do sends req and records the request and the response as a new transaction.
+// The body of the returned response can be read again.
+func (r *Replayer) Do(req *http.Request) (id uint64, res *http.Response, err error) {
+	return r.do(r.Client, req)
+}
+
+// do works like Do, but sends the request with client.
+func (r *Replayer) do(client *http.Client, req *http.Request) (id uint64, res *http.Response, err error) {
+	var body []byte
+	if req.Body != nil {
+		body, err = ioutil.ReadAll(req.Body)
+		if err != nil {
+			return 0, nil, fmt.Errorf("reading request body: %v", err)
+		}
+		_ = req.Body.Close()
+	}
+
+	// RequestURI can't be set for client requests
+	req.RequestURI = ""
+
+	id, err = r.Store.NextID()
+	if err == nil {
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+		err = r.Store.AddRequest(id, req, false)
+	}
+	if err != nil {
+		return 0, nil, fmt.Errorf("recording request: %v", err)
+	}
+
+	req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	res, err = client.Do(req)
+	if err != nil {
+		return id, nil, err
+	}
+
+	resBody, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return id, nil, fmt.Errorf("reading response body: %v", err)
+	}
+	_ = res.Body.Close()
+	res.Body = ioutil.NopCloser(bytes.NewReader(resBody))
+
+	err = r.Store.AddResponse(id, res, resBody, false)
+	if err != nil {
+		return id, nil, fmt.Errorf("recording response: %v", err)
+	}
+
+	return id, res, nil
+}
+
+// storedRequest returns the request of the transaction, the edited version
+// is preferred. Headers are removed according to StripConditional and
+// StripCache.
+func (r *Replayer) storedRequest(id uint64) (*http.Request, error) {
+	req, err := r.Store.GetRequest(id, true)
+	if err == badger.ErrKeyNotFound {
+		req, err = r.Store.GetRequest(id, false)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if r.StripConditional {
+		for _, name := range conditionalHeaders {
+			req.Header.Del(name)
+		}
+	}
+	if r.StripCache {
+		for _, name := range cacheHeaders {
+			req.Header.Del(name)
+		}
+	}
+	return req, nil
+}
+
+// storedResponse returns the response of the transaction, the edited version
+// is preferred.
+func (r *Replayer) storedResponse(id uint64) (*http.Response, error) {
+	res, err := r.Store.GetResponse(id, true)
+	if err == badger.ErrKeyNotFound {
+		res, err = r.Store.GetResponse(id, false)
+	}
+	return res, err
+}
+
+// Replay sends the request of the stored transaction again and records it as
+// a new transaction.
+func (r *Replayer) Replay(id uint64) (newID uint64, res *http.Response, err error) {
+	req, err := r.storedRequest(id)
+	if err != nil {
+		return 0, nil, fmt.Errorf("loading request %d: %v", id, err)
+	}
+
+	return r.Do(req)
+}
+
+// ReplayDiff sends the request of the stored transaction again like Replay
+// and compares the new response with the response originally stored for id.
+// The returned diff lists changes in status, headers and body, it is empty if
+// the responses match.
+func (r *Replayer) ReplayDiff(id uint64) (newID uint64, res *http.Response, diff []string, err error) {
+	orig, err := r.Store.GetResponse(id, false)
+	if err != nil {
+		return 0, nil, nil, fmt.Errorf("loading response %d: %v", id, err)
+	}
+
+	newID, res, err = r.Replay(id)
+	if err != nil {
+		return newID, nil, nil, err
+	}
+
+	return newID, res, proxy.DiffResponses(orig, res), nil
+}
+
+// isRedirect returns true if the status code is a redirect with a location.
+func isRedirect(res *http.Response) bool {
+	switch res.StatusCode {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther,
+		http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return res.Header.Get("Location") != ""
+	}
+	return false
+}
+
+// redirectRequest returns the request for following the redirect res which
+// was received for req.
+func redirectRequest(req *http.Request, body []byte, res *http.Response, jar http.CookieJar) (*http.Request, error) {
+	loc, err := req.URL.Parse(res.Header.Get("Location"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid location: %v", err)
+	}
+
+	method := req.Method
+	switch res.StatusCode {
+	case http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		// method and body are kept
+	default:
+		if method != http.MethodHead {
+			method = http.MethodGet
+		}
+		body = nil
+	}
+
+	next, err := http.NewRequest(method, loc.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	for name, values := range req.Header {
+		switch name {
+		case "Cookie":
+			// cookies are taken from the jar
+			continue
+		case "Content-Type", "Content-Length":
+			if body == nil {
+				continue
+			}
+		}
+		next.Header[name] = values
+	}
+
+	for _, cookie := range jar.Cookies(loc) {
+		next.AddCookie(cookie)
+	}
+
+	return next, nil
+}
+
+// FollowRedirects follows the redirect chain starting at the stored response
+// of the transaction id. Each redirect is sent as a new transaction, carrying
+// along cookies set in the responses. At most maxHops redirects are followed.
+// The IDs of the new transactions are returned in order.
+func (r *Replayer) FollowRedirects(id uint64, maxHops int) ([]uint64, error) {
+	req, err := r.storedRequest(id)
+	if err != nil {
+		return nil, fmt.Errorf("loading request %d: %v", id, err)
+	}
+
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := r.storedResponse(id)
+	if err != nil {
+		return nil, fmt.Errorf("loading response %d: %v", id, err)
+	}
+
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// seed the jar with the cookies of the original request
+	jar.SetCookies(req.URL, req.Cookies())
+
+	var ids []uint64
+	for hop := 0; isRedirect(res); hop++ {
+		if hop >= maxHops {
+			return ids, fmt.Errorf("stopped after %d redirects", maxHops)
+		}
+
+		jar.SetCookies(req.URL, res.Cookies())
+
+		next, err := redirectRequest(req, body, res, jar)
+		if err != nil {
+			return ids, err
+		}
+
+		body, err = ioutil.ReadAll(next.Body)
+		if err != nil {
+			return ids, err
+		}
+		next.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+		id, res, err = r.Do(next)
+		if err != nil {
+			return ids, err
+		}
+		ids = append(ids, id)
+
+		req = next
+	}
+
+	return ids, nil
+}
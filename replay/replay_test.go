@@ -0,0 +1,323 @@
+package replay
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/fd0/osmosis/store"
+)
+
+// testStore returns a new store in a temporary directory.
+func testStore(t testing.TB) (s *store.TxnStore, cleanup func()) {
+	dir, err := ioutil.TempDir(os.TempDir(), "osmosis.testing.replay.")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = store.New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cleanup = func() {
+		s.Close()
+		os.RemoveAll(dir)
+	}
+
+	return s, cleanup
+}
+
+func TestFollowRedirects(t *testing.T) {
+	s, cleanup := testStore(t)
+	defer cleanup()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/start":
+			http.SetCookie(rw, &http.Cookie{Name: "session", Value: "secret", Path: "/"})
+			http.Redirect(rw, req, "/first", http.StatusFound)
+		case "/first":
+			cookie, err := req.Cookie("session")
+			if err != nil || cookie.Value != "secret" {
+				http.Error(rw, "no session", http.StatusForbidden)
+				return
+			}
+			http.Redirect(rw, req, "/second", http.StatusFound)
+		case "/second":
+			io.WriteString(rw, "done")
+		default:
+			http.NotFound(rw, req)
+		}
+	}))
+	defer srv.Close()
+
+	r := New(s, nil)
+
+	req, err := http.NewRequest(http.MethodGet, srv.URL+"/start", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id, res, err := r.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res.StatusCode != http.StatusFound {
+		t.Fatalf("wrong status for first request: %v", res.Status)
+	}
+
+	ids, err := r.FollowRedirects(id, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(ids) != 2 {
+		t.Fatalf("wrong number of transactions recorded, want 2, got %v", ids)
+	}
+
+	var wantStatus = []int{http.StatusFound, http.StatusOK}
+	for i, id := range ids {
+		summary, err := s.GetSummary(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if summary.StatusCode != wantStatus[i] {
+			t.Errorf("transaction %d has wrong status, want %v, got %v", id, wantStatus[i], summary.StatusCode)
+		}
+	}
+
+	res, err = s.GetResponse(ids[1], false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(body) != "done" {
+		t.Errorf("wrong final body, want %q, got %q", "done", body)
+	}
+}
+
+func TestFollowRedirectsMaxHops(t *testing.T) {
+	s, cleanup := testStore(t)
+	defer cleanup()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		http.Redirect(rw, req, "/loop", http.StatusFound)
+	}))
+	defer srv.Close()
+
+	r := New(s, nil)
+
+	req, err := http.NewRequest(http.MethodGet, srv.URL+"/loop", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id, _, err := r.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ids, err := r.FollowRedirects(id, 3)
+	if err == nil {
+		t.Fatalf("redirect loop was not detected")
+	}
+
+	if len(ids) != 3 {
+		t.Errorf("wrong number of transactions recorded, want 3, got %v", ids)
+	}
+}
+
+func TestReplayDiff(t *testing.T) {
+	s, cleanup := testStore(t)
+	defer cleanup()
+
+	body := "version 1"
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("X-Version", body)
+		io.WriteString(rw, body)
+	}))
+	defer srv.Close()
+
+	r := New(s, nil)
+
+	req, err := http.NewRequest(http.MethodGet, srv.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id, _, err := r.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, _, diff, err := r.ReplayDiff(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diff) != 0 {
+		t.Errorf("unexpected diff for unchanged response: %v", diff)
+	}
+
+	body = "version 2 with more text"
+	newID, res, diff, err := r.ReplayDiff(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if newID <= id {
+		t.Errorf("replay was not recorded as a new transaction: %v", newID)
+	}
+
+	want := []string{
+		`header X-Version: ["version 1"] != ["version 2 with more text"]`,
+		"body: 9 bytes != 24 bytes",
+	}
+	if strings.Join(diff, "\n") != strings.Join(want, "\n") {
+		t.Errorf("wrong diff, want:\n  %v\ngot:\n  %v", strings.Join(want, "\n  "), strings.Join(diff, "\n  "))
+	}
+
+	buf, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != body {
+		t.Errorf("body of the new response not readable after diff, got %q", buf)
+	}
+}
+
+func TestReplayCustomMethod(t *testing.T) {
+	s, cleanup := testStore(t)
+	defer cleanup()
+
+	var methods, bodies []string
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		buf, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			t.Error(err)
+		}
+		methods = append(methods, req.Method)
+		bodies = append(bodies, string(buf))
+		io.WriteString(rw, "ok")
+	}))
+	defer srv.Close()
+
+	r := New(s, nil)
+
+	req, err := http.NewRequest("PURGE", srv.URL+"/cache", strings.NewReader("key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id, _, err := r.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, _, err = r.Replay(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// an edited request with a different custom method takes precedence
+	edited, err := http.NewRequest("x-invalidate", srv.URL+"/cache", strings.NewReader("other"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.UpdateRequest(id, edited)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, _, err = r.Replay(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantMethods := []string{"PURGE", "PURGE", "x-invalidate"}
+	wantBodies := []string{"key", "key", "other"}
+	if strings.Join(methods, ",") != strings.Join(wantMethods, ",") {
+		t.Errorf("wrong methods received, want %v, got %v", wantMethods, methods)
+	}
+	if strings.Join(bodies, ",") != strings.Join(wantBodies, ",") {
+		t.Errorf("wrong bodies received, want %v, got %v", wantBodies, bodies)
+	}
+}
+
+func TestReplayStripConditional(t *testing.T) {
+	s, cleanup := testStore(t)
+	defer cleanup()
+
+	var cacheControl []string
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		cacheControl = append(cacheControl, req.Header.Get("Cache-Control"))
+		rw.Header().Set("ETag", `"v1"`)
+		if req.Header.Get("If-None-Match") == `"v1"` {
+			rw.WriteHeader(http.StatusNotModified)
+			return
+		}
+		io.WriteString(rw, "content")
+	}))
+	defer srv.Close()
+
+	r := New(s, nil)
+	r.StripConditional = false
+
+	req, err := http.NewRequest(http.MethodGet, srv.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("If-None-Match", `"v1"`)
+	req.Header.Set("Cache-Control", "max-age=0")
+
+	id, res, err := r.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusNotModified {
+		t.Fatalf("conditional request returned %v", res.Status)
+	}
+
+	var tests = []struct {
+		stripConditional, stripCache bool
+		status                       int
+		cacheControl                 string
+	}{
+		{false, false, http.StatusNotModified, "max-age=0"},
+		{true, false, http.StatusOK, "max-age=0"},
+		{true, true, http.StatusOK, ""},
+	}
+
+	for _, test := range tests {
+		r.StripConditional = test.stripConditional
+		r.StripCache = test.stripCache
+		cacheControl = nil
+
+		_, res, err := r.Replay(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if res.StatusCode != test.status {
+			t.Errorf("StripConditional %v: wrong status, want %v, got %v", test.stripConditional, test.status, res.Status)
+		}
+		if len(cacheControl) != 1 || cacheControl[0] != test.cacheControl {
+			t.Errorf("StripCache %v: wrong Cache-Control received: %q", test.stripCache, cacheControl)
+		}
+	}
+
+	if !New(s, nil).StripConditional {
+		t.Error("conditional headers are not removed by default")
+	}
+}
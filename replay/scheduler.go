@@ -0,0 +1,187 @@
+package replay
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Schedule describes a load test: each of the stored requests is sent
+// Copies times.
+type Schedule struct {
+	IDs    []uint64
+	Copies int
+
+	// RPS is the rate of requests per second. If it is zero, the requests
+	// are sent as fast as Concurrency allows.
+	RPS float64
+
+	// RampUp is the time over which the rate increases linearly from zero
+	// to RPS.
+	RampUp time.Duration
+
+	// Concurrency is the maximum number of requests in flight, the default
+	// is one.
+	Concurrency int
+}
+
+// at returns the time the ith request is due relative to the start.
+func (s Schedule) at(i int) time.Duration {
+	if s.RPS <= 0 {
+		return 0
+	}
+
+	n := float64(i)
+	ramp := s.RampUp.Seconds()
+
+	// number of requests sent during the ramp-up
+	rampRequests := s.RPS * ramp / 2
+
+	var secs float64
+	if ramp > 0 && n < rampRequests {
+		secs = math.Sqrt(2 * n * ramp / s.RPS)
+	} else {
+		secs = ramp + (n-rampRequests)/s.RPS
+	}
+
+	return time.Duration(secs * float64(time.Second))
+}
+
+// LoadResult summarizes a load test run.
+type LoadResult struct {
+	Sent     int
+	Errors   int
+	Status   map[int]int // number of responses per status code
+	Duration time.Duration
+
+	// Latencies of all requests answered, in ascending order
+	Latencies []time.Duration
+}
+
+// Percentile returns the latency below which p percent of the requests were
+// answered.
+func (r *LoadResult) Percentile(p float64) time.Duration {
+	if len(r.Latencies) == 0 {
+		return 0
+	}
+
+	i := int(math.Ceil(p/100*float64(len(r.Latencies)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(r.Latencies) {
+		i = len(r.Latencies) - 1
+	}
+	return r.Latencies[i]
+}
+
+// Histogram returns the number of latencies up to each of the bucket limits
+// (in ascending order), the last element counts the remaining latencies.
+func (r *LoadResult) Histogram(buckets []time.Duration) []int {
+	res := make([]int, len(buckets)+1)
+	for _, latency := range r.Latencies {
+		i := sort.Search(len(buckets), func(i int) bool { return latency <= buckets[i] })
+		res[i]++
+	}
+	return res
+}
+
+// template is a stored request which can be sent several times.
+type template struct {
+	req  *http.Request
+	body []byte
+}
+
+func (t template) request(ctx context.Context) *http.Request {
+	req := t.req.Clone(ctx)
+	req.Body = ioutil.NopCloser(bytes.NewReader(t.body))
+	return req
+}
+
+// Run sends the requests according to the schedule, all are recorded as new
+// transactions. Run returns when all requests have been answered or ctx is
+// cancelled, the results collected so far are returned in the latter case
+// together with the error of ctx.
+func (r *Replayer) Run(ctx context.Context, sched Schedule) (*LoadResult, error) {
+	var templates []template
+	for _, id := range sched.IDs {
+		req, err := r.storedRequest(id)
+		if err != nil {
+			return nil, fmt.Errorf("loading request %d: %v", id, err)
+		}
+
+		body, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			return nil, fmt.Errorf("reading request %d: %v", id, err)
+		}
+		templates = append(templates, template{req: req, body: body})
+	}
+
+	concurrency := sched.Concurrency
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+
+	result := &LoadResult{Status: make(map[int]int)}
+	var mu sync.Mutex
+
+	jobs := make(chan template)
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for t := range jobs {
+				start := time.Now()
+				_, res, err := r.Do(t.request(ctx))
+				latency := time.Since(start)
+
+				mu.Lock()
+				result.Sent++
+				if err != nil {
+					result.Errors++
+				} else {
+					result.Status[res.StatusCode]++
+					result.Latencies = append(result.Latencies, latency)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	start := time.Now()
+	total := len(templates) * sched.Copies
+
+	var err error
+dispatch:
+	for i := 0; i < total; i++ {
+		if wait := sched.at(i) - time.Since(start); wait > 0 {
+			select {
+			case <-time.After(wait):
+			case <-ctx.Done():
+				err = ctx.Err()
+				break dispatch
+			}
+		}
+
+		select {
+		case jobs <- templates[i%len(templates)]:
+		case <-ctx.Done():
+			err = ctx.Err()
+			break dispatch
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	result.Duration = time.Since(start)
+	sort.Slice(result.Latencies, func(i, j int) bool { return result.Latencies[i] < result.Latencies[j] })
+
+	return result, err
+}
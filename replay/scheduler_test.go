@@ -0,0 +1,82 @@
+package replay
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSchedulerFixedRate(t *testing.T) {
+	s, cleanup := testStore(t)
+	defer cleanup()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	r := New(s, nil)
+
+	req, err := http.NewRequest(http.MethodGet, srv.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, _, err := r.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+
+	result, err := r.Run(ctx, Schedule{
+		IDs:         []uint64{id},
+		Copies:      1000,
+		RPS:         40,
+		Concurrency: 4,
+	})
+	if err != context.DeadlineExceeded {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	// at 40 requests per second, about 20 requests are sent in 500ms
+	if result.Sent < 12 || result.Sent > 28 {
+		t.Errorf("unexpected number of requests sent: %d", result.Sent)
+	}
+
+	if result.Status[http.StatusNoContent] != result.Sent-result.Errors {
+		t.Errorf("wrong status distribution %v for %d requests", result.Status, result.Sent)
+	}
+
+	if len(result.Latencies) > 0 && result.Percentile(100) != result.Latencies[len(result.Latencies)-1] {
+		t.Errorf("wrong maximum latency %v", result.Percentile(100))
+	}
+}
+
+func TestScheduleRampUp(t *testing.T) {
+	sched := Schedule{RPS: 10, RampUp: 2 * time.Second}
+
+	// 10 requests are sent during the ramp-up, then 10 per second
+	var tests = []struct {
+		i    int
+		want time.Duration
+	}{
+		{0, 0},
+		{10, 2 * time.Second},
+		{20, 3 * time.Second},
+	}
+
+	for _, test := range tests {
+		got := sched.at(test.i)
+		if got != test.want {
+			t.Errorf("request %d: want %v, got %v", test.i, test.want, got)
+		}
+	}
+
+	// the interval between requests decreases during the ramp-up
+	if sched.at(2)-sched.at(1) <= sched.at(9)-sched.at(8) {
+		t.Errorf("rate does not increase during ramp-up")
+	}
+}
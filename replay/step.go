@@ -0,0 +1,144 @@
+package replay
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/fd0/osmosis/proxy"
+)
+
+// NumberLocation marks a number in the URL of a request for stepping through
+// values with a Stepper.
+type NumberLocation struct {
+	// Param is the name of the query parameter holding the number. If it is
+	// empty, the path segment with the index Segment (starting at zero) is
+	// used instead.
+	Param   string
+	Segment int
+}
+
+func (loc NumberLocation) String() string {
+	if loc.Param != "" {
+		return fmt.Sprintf("query parameter %q", loc.Param)
+	}
+	return fmt.Sprintf("path segment %d", loc.Segment)
+}
+
+// lastNumber matches the last run of digits in a string.
+var lastNumber = regexp.MustCompile(`(\d+)(\D*)$`)
+
+// get returns the value at loc in u.
+func (loc NumberLocation) get(u *url.URL) (string, error) {
+	if loc.Param != "" {
+		values, ok := u.Query()[loc.Param]
+		if !ok {
+			return "", fmt.Errorf("query parameter %q not found", loc.Param)
+		}
+		return values[0], nil
+	}
+
+	segments := strings.Split(strings.TrimPrefix(u.Path, "/"), "/")
+	if loc.Segment < 0 || loc.Segment >= len(segments) {
+		return "", fmt.Errorf("path %q has no segment %d", u.Path, loc.Segment)
+	}
+	return segments[loc.Segment], nil
+}
+
+// set returns a copy of u with the value at loc replaced by s.
+func (loc NumberLocation) set(u *url.URL, s string) *url.URL {
+	n := *u
+
+	if loc.Param != "" {
+		proxy.SetQueryParam(&n, loc.Param, s)
+		return &n
+	}
+
+	segments := strings.Split(strings.TrimPrefix(n.Path, "/"), "/")
+	segments[loc.Segment] = s
+	n.Path = "/" + strings.Join(segments, "/")
+	n.RawPath = ""
+	return &n
+}
+
+// Stepper resends a stored request while changing a number in the URL, e.g.
+// for enumerating IDs manually.
+type Stepper struct {
+	r    *Replayer
+	req  *http.Request
+	body []byte
+	loc  NumberLocation
+
+	// prefix, suffix and width of the value, the number is padded with
+	// zeroes to width
+	prefix, suffix string
+	width          int
+
+	// Value is the current number.
+	Value int64
+}
+
+// NewStepper returns a Stepper for the request of the stored transaction id.
+// The last number in the value at loc is used, e.g. 42 for "user42.json".
+func (r *Replayer) NewStepper(id uint64, loc NumberLocation) (*Stepper, error) {
+	req, err := r.storedRequest(id)
+	if err != nil {
+		return nil, fmt.Errorf("loading request %d: %v", id, err)
+	}
+
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	value, err := loc.get(req.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	match := lastNumber.FindStringSubmatchIndex(value)
+	if match == nil {
+		return nil, fmt.Errorf("no number found in %v (%q)", loc, value)
+	}
+
+	digits := value[match[2]:match[3]]
+	n, err := strconv.ParseInt(digits, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Stepper{
+		r:      r,
+		req:    req,
+		body:   body,
+		loc:    loc,
+		prefix: value[:match[2]],
+		suffix: value[match[3]:],
+		width:  len(digits),
+		Value:  n,
+	}, nil
+}
+
+// URL returns the URL of the request for the current value.
+func (s *Stepper) URL() *url.URL {
+	value := fmt.Sprintf("%s%0*d%s", s.prefix, s.width, s.Value, s.suffix)
+	return s.loc.set(s.req.URL, value)
+}
+
+// Step changes the value by delta (e.g. 1 or -1) and sends the request,
+// which is recorded as a new transaction.
+func (s *Stepper) Step(delta int64) (newID uint64, res *http.Response, err error) {
+	s.Value += delta
+
+	req := s.req.WithContext(s.req.Context())
+	req.URL = s.URL()
+	req.Header = s.req.Header.Clone()
+	req.Body = ioutil.NopCloser(bytes.NewReader(s.body))
+
+	return s.r.Do(req)
+}
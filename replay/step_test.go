@@ -0,0 +1,96 @@
+package replay
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestStepper(t *testing.T) {
+	s, cleanup := testStore(t)
+	defer cleanup()
+
+	var received []string
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		received = append(received, req.URL.RequestURI())
+		io.WriteString(rw, "ok")
+	}))
+	defer srv.Close()
+
+	r := New(s, nil)
+
+	req, err := http.NewRequest(http.MethodGet, srv.URL+"/users/user007.json/profile?page=9&sort=name", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id, _, err := r.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		loc   NumberLocation
+		steps []int64
+		want  []string
+	}{
+		{
+			NumberLocation{Segment: 1},
+			[]int64{1, 1, 1, -1, 10},
+			[]string{
+				"/users/user008.json/profile?page=9&sort=name",
+				"/users/user009.json/profile?page=9&sort=name",
+				"/users/user010.json/profile?page=9&sort=name",
+				"/users/user009.json/profile?page=9&sort=name",
+				"/users/user019.json/profile?page=9&sort=name",
+			},
+		},
+		{
+			NumberLocation{Param: "page"},
+			[]int64{1, 1, -3},
+			[]string{
+				"/users/user007.json/profile?page=10&sort=name",
+				"/users/user007.json/profile?page=11&sort=name",
+				"/users/user007.json/profile?page=8&sort=name",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.loc.String(), func(t *testing.T) {
+			received = nil
+
+			stepper, err := r.NewStepper(id, test.loc)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, delta := range test.steps {
+				_, res, err := stepper.Step(delta)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if res.StatusCode != http.StatusOK {
+					t.Errorf("unexpected status %v", res.Status)
+				}
+			}
+
+			if len(received) != len(test.want) {
+				t.Fatalf("wrong number of requests, want %d, got %v", len(test.want), received)
+			}
+			for i := range test.want {
+				if received[i] != test.want[i] {
+					t.Errorf("request %d: want %v, got %v", i, test.want[i], received[i])
+				}
+			}
+		})
+	}
+
+	for _, loc := range []NumberLocation{{Segment: 2}, {Segment: 5}, {Param: "sort"}, {Param: "missing"}} {
+		_, err := r.NewStepper(id, loc)
+		if err == nil {
+			t.Errorf("expected error for %v", loc)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package replay
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+)
+
+// TLSProfiles contains named TLS client configurations for ReplayTLS.
+var TLSProfiles = map[string]*tls.Config{
+	"tls10": {MinVersion: tls.VersionTLS10, MaxVersion: tls.VersionTLS10},
+	"tls11": {MinVersion: tls.VersionTLS11, MaxVersion: tls.VersionTLS11},
+	"tls12": {MinVersion: tls.VersionTLS12, MaxVersion: tls.VersionTLS12},
+	"tls13": {MinVersion: tls.VersionTLS13, MaxVersion: tls.VersionTLS13},
+	"http1": {NextProtos: []string{"http/1.1"}},
+	"h2":    {NextProtos: []string{"h2", "http/1.1"}},
+}
+
+// TLSResult is the outcome of replaying a transaction with ReplayTLS.
+type TLSResult struct {
+	// NewID is the ID of the recorded transaction.
+	NewID    uint64
+	Response *http.Response
+
+	// TLS contains the parameters negotiated with the server, e.g. the
+	// version, cipher suite and ALPN protocol.
+	TLS *tls.ConnectionState
+}
+
+// tlsClient returns a client like r.Client which uses cfg for new TLS
+// connections and does not share connections with r.Client.
+func (r *Replayer) tlsClient(cfg *tls.Config) *http.Client {
+	var tr *http.Transport
+	if t, ok := r.Client.Transport.(*http.Transport); ok {
+		tr = t.Clone()
+	} else {
+		tr = http.DefaultTransport.(*http.Transport).Clone()
+	}
+
+	tr.TLSClientConfig = cfg.Clone()
+
+	// a custom TLS config disables HTTP2 unless requested explicitly
+	tr.ForceAttemptHTTP2 = false
+	for _, proto := range cfg.NextProtos {
+		if proto == "h2" {
+			tr.ForceAttemptHTTP2 = true
+		}
+	}
+
+	client := *r.Client
+	client.Transport = tr
+	return &client
+}
+
+// ReplayTLS sends the request of the stored transaction again like Replay,
+// but uses a new connection established with the TLS client configuration cfg
+// (e.g. from TLSProfiles). This allows comparing how a server reacts to
+// different TLS versions, cipher suites or ALPN protocols.
+func (r *Replayer) ReplayTLS(id uint64, cfg *tls.Config) (*TLSResult, error) {
+	req, err := r.storedRequest(id)
+	if err != nil {
+		return nil, fmt.Errorf("loading request %d: %v", id, err)
+	}
+
+	client := r.tlsClient(cfg)
+	defer client.CloseIdleConnections()
+
+	newID, res, err := r.do(client, req)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TLSResult{
+		NewID:    newID,
+		Response: res,
+		TLS:      res.TLS,
+	}, nil
+}
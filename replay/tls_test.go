@@ -0,0 +1,87 @@
+package replay
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestReplayTLS(t *testing.T) {
+	s, cleanup := testStore(t)
+	defer cleanup()
+
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.TLS.Version < tls.VersionTLS13 {
+			rw.WriteHeader(http.StatusUpgradeRequired)
+			io.WriteString(rw, "legacy TLS")
+			return
+		}
+		io.WriteString(rw, "modern TLS")
+	}))
+	defer srv.Close()
+
+	r := New(s, srv.Client())
+
+	req, err := http.NewRequest(http.MethodGet, srv.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id, _, err := r.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(srv.Certificate())
+
+	var tests = []struct {
+		version uint16
+		status  int
+		body    string
+	}{
+		{tls.VersionTLS12, http.StatusUpgradeRequired, "legacy TLS"},
+		{tls.VersionTLS13, http.StatusOK, "modern TLS"},
+	}
+
+	for _, test := range tests {
+		cfg := &tls.Config{
+			RootCAs:    roots,
+			MinVersion: test.version,
+			MaxVersion: test.version,
+		}
+
+		result, err := r.ReplayTLS(id, cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if result.TLS == nil || result.TLS.Version != test.version {
+			t.Errorf("wrong TLS version negotiated, want %x, got %+v", test.version, result.TLS)
+		}
+
+		if result.Response.StatusCode != test.status {
+			t.Errorf("wrong status for version %x, want %v, got %v", test.version, test.status, result.Response.StatusCode)
+		}
+
+		body, err := ioutil.ReadAll(result.Response.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(body) != test.body {
+			t.Errorf("wrong body for version %x, want %q, got %q", test.version, test.body, body)
+		}
+
+		stored, err := s.GetResponse(result.NewID, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stored.StatusCode != test.status {
+			t.Errorf("wrong status recorded for version %x: %v", test.version, stored.Status)
+		}
+	}
+}
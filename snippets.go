@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// snippetSkipHeaders are set by the tools themselves.
+var snippetSkipHeaders = map[string]struct{}{
+	"Content-Length": struct{}{},
+	"Connection":     struct{}{},
+}
+
+// snippetHeaders returns the header names of req to include in a snippet in
+// sorted order. The Host header is only included if it differs from the URL.
+func snippetHeaders(req *http.Request) []string {
+	var names []string
+	for name := range req.Header {
+		if _, ok := snippetSkipHeaders[name]; ok {
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	if req.Host != "" && req.Host != req.URL.Host {
+		names = append([]string{"Host"}, names...)
+	}
+
+	return names
+}
+
+// headerValue returns the value of the header name in req, multiple values
+// are joined with commas.
+func headerValue(req *http.Request, name string) string {
+	if name == "Host" {
+		return req.Host
+	}
+	return strings.Join(req.Header[name], ", ")
+}
+
+// pythonBytes returns body as a Python bytes literal.
+func pythonBytes(body []byte) string {
+	var buf bytes.Buffer
+	buf.WriteString(`b"`)
+	for _, c := range body {
+		switch {
+		case c == '"' || c == '\\':
+			buf.WriteByte('\\')
+			buf.WriteByte(c)
+		case c == '\n':
+			buf.WriteString(`\n`)
+		case c == '\r':
+			buf.WriteString(`\r`)
+		case c == '\t':
+			buf.WriteString(`\t`)
+		case c >= 0x20 && c < 0x7f:
+			buf.WriteByte(c)
+		default:
+			fmt.Fprintf(&buf, `\x%02x`, c)
+		}
+	}
+	buf.WriteString(`"`)
+	return buf.String()
+}
+
+// buildPythonRequests returns a Python script which sends req with the given
+// body using the requests library.
+func buildPythonRequests(req *http.Request, body []byte) string {
+	var buf bytes.Buffer
+
+	buf.WriteString("import requests\n\n")
+	buf.WriteString("response = requests.request(\n")
+	fmt.Fprintf(&buf, "    %v,\n", strconv.Quote(req.Method))
+	fmt.Fprintf(&buf, "    %v,\n", strconv.Quote(req.URL.String()))
+
+	if names := snippetHeaders(req); len(names) > 0 {
+		buf.WriteString("    headers={\n")
+		for _, name := range names {
+			fmt.Fprintf(&buf, "        %v: %v,\n", strconv.Quote(name), strconv.Quote(headerValue(req, name)))
+		}
+		buf.WriteString("    },\n")
+	}
+
+	if len(body) > 0 {
+		fmt.Fprintf(&buf, "    data=%v,\n", pythonBytes(body))
+	}
+
+	// redirects are not followed by the proxy either
+	buf.WriteString("    allow_redirects=False,\n")
+	buf.WriteString(")\n\n")
+	buf.WriteString("print(response.status_code)\n")
+	buf.WriteString("print(response.text)\n")
+
+	return buf.String()
+}
+
+// shellQuote returns s quoted for a POSIX shell.
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// buildHTTPie returns an HTTPie command line which sends req with the given
+// body. The body is passed on stdin.
+func buildHTTPie(req *http.Request, body []byte) string {
+	var args []string
+
+	if len(body) > 0 {
+		if utf8.Valid(body) && bytes.IndexByte(body, 0) < 0 {
+			args = append(args, "printf", "'%s'", shellQuote(string(body)), "|")
+		} else {
+			// printf interprets octal escapes in the format string
+			var format bytes.Buffer
+			for _, c := range body {
+				fmt.Fprintf(&format, `\%03o`, c)
+			}
+			args = append(args, "printf", shellQuote(format.String()), "|")
+		}
+	}
+
+	args = append(args, "http")
+	if len(body) == 0 {
+		args = append(args, "--ignore-stdin")
+	}
+	args = append(args, req.Method, shellQuote(req.URL.String()))
+
+	for _, name := range snippetHeaders(req) {
+		value := headerValue(req, name)
+		if value == "" {
+			// "Name:" removes the header in HTTPie, "Name;" sends it empty
+			args = append(args, shellQuote(name+";"))
+			continue
+		}
+		args = append(args, shellQuote(name+":"+value))
+	}
+
+	return strings.Join(args, " ")
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func sampleRequest(t testing.TB) (*http.Request, []byte) {
+	body := []byte(`{"name": "it's \"quoted\""}` + "\n")
+	req, err := http.NewRequest(http.MethodPost, "https://api.example.com/v1/users?debug=1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer secret")
+	req.Header.Set("Content-Length", "29")
+	return req, body
+}
+
+func wantContains(t testing.TB, s string, want ...string) {
+	for _, w := range want {
+		if !strings.Contains(s, w) {
+			t.Errorf("%q not found in:\n%s", w, s)
+		}
+	}
+}
+
+func TestBuildPythonRequests(t *testing.T) {
+	req, body := sampleRequest(t)
+	snippet := buildPythonRequests(req, body)
+
+	wantContains(t, snippet,
+		"import requests",
+		`    "POST",`,
+		`    "https://api.example.com/v1/users?debug=1",`,
+		`        "Authorization": "Bearer secret",`,
+		`        "Content-Type": "application/json",`,
+		`    data=b"{\"name\": \"it's \\\"quoted\\\"\"}\n",`,
+	)
+
+	if strings.Contains(snippet, "Content-Length") {
+		t.Errorf("Content-Length should be computed by requests:\n%s", snippet)
+	}
+
+	snippet = buildPythonRequests(req, []byte{0, 0xff, 'a'})
+	wantContains(t, snippet, `data=b"\x00\xffa",`)
+}
+
+func TestBuildHTTPie(t *testing.T) {
+	req, body := sampleRequest(t)
+	req.Host = "internal.example.com"
+	snippet := buildHTTPie(req, body)
+
+	want := `printf '%s' '{"name": "it'\''s \"quoted\""}` + "\n" + `' | ` +
+		`http POST 'https://api.example.com/v1/users?debug=1' ` +
+		`'Host:internal.example.com' 'Authorization:Bearer secret' 'Content-Type:application/json'`
+	if snippet != want {
+		t.Errorf("wrong snippet, want:\n  %s\ngot:\n  %s", want, snippet)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, "http://example.com/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("X-Empty", "")
+	snippet = buildHTTPie(req, nil)
+
+	want = `http --ignore-stdin GET 'http://example.com/' 'X-Empty;'`
+	if snippet != want {
+		t.Errorf("wrong snippet, want:\n  %s\ngot:\n  %s", want, snippet)
+	}
+
+	snippet = buildHTTPie(req, []byte{0, 'a'})
+	wantContains(t, snippet, `printf '\000\141' | http GET`)
+}
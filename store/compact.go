@@ -0,0 +1,40 @@
+package store
+
+import (
+	"fmt"
+
+	"github.com/dgraph-io/badger"
+)
+
+// compactDiscardRatio is the share of a value log file which must be
+// obsolete before Compact rewrites it.
+const compactDiscardRatio = 0.5
+
+// compactAttempts is the number of times in a row badger must find no file
+// to rewrite before Compact stops. Badger checks a randomly chosen file and a
+// random sample of its entries, so a single miss does not mean that there is
+// nothing left to reclaim.
+const compactAttempts = 5
+
+// Compact reclaims the disk space of deleted and overwritten transactions by
+// rewriting value log files which are at least half obsolete. It returns the
+// number of files rewritten. Compacting is worth it after many transactions
+// were replaced, e.g. by rebaseline or by loading a session over an existing
+// store. It is safe to call while the store is in use, but causes a lot of
+// disk I/O.
+func (s *TxnStore) Compact() (int, error) {
+	var rewritten int
+	for misses := 0; misses < compactAttempts; {
+		err := s.DB.RunValueLogGC(compactDiscardRatio)
+		switch {
+		case err == badger.ErrNoRewrite:
+			misses++
+		case err != nil:
+			return rewritten, fmt.Errorf("compacting value log: %v", err)
+		default:
+			rewritten++
+			misses = 0
+		}
+	}
+	return rewritten, nil
+}
@@ -0,0 +1,116 @@
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/dgraph-io/badger"
+)
+
+// openCompactTestStore opens the store in dir with small value log files, so
+// that the test data fills several of them.
+func openCompactTestStore(t testing.TB, dir string) *TxnStore {
+	opts := badger.DefaultOptions
+	opts.Dir = dir
+	opts.ValueDir = dir
+	opts.ValueLogFileSize = 1 << 20
+	// badger only rewrites a file after sampling 1% of this many entries
+	opts.ValueLogMaxEntries = 1000
+	db, err := badger.Open(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &TxnStore{DB: db}
+}
+
+func TestStoreCompact(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "osmosis.testing.store.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s := openCompactTestStore(t, dir)
+	body := []byte(strings.Repeat("x", 16*1024))
+	for id := uint64(0); id < 400; id++ {
+		request, err := http.ReadRequest(bufio.NewReader(bytes.NewReader([]byte(req))))
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = s.AddRequest(id, request, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		response, err := http.ReadResponse(bufio.NewReader(bytes.NewReader([]byte(res))), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = s.AddResponse(id, response, body, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// keep every tenth transaction
+	for id := uint64(0); id < 400; id++ {
+		if id%10 == 0 {
+			continue
+		}
+		err = s.Update(func(txn *badger.Txn) error {
+			for _, typ := range []KeyType{ReqType, ResType, ReqSizeType, ResSizeType, ReqRawType, ResRawType} {
+				err := txn.Delete(Key{ID: id, Type: typ}.Bytes())
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the value log files become candidates for compaction after the
+	// memtable is flushed, which happens on close
+	err = s.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s = openCompactTestStore(t, dir)
+	defer s.Close()
+
+	n, err := s.Compact()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n == 0 {
+		t.Errorf("no value log files were rewritten")
+	}
+
+	for id := uint64(0); id < 400; id++ {
+		res, err := s.GetResponse(id, false)
+		if id%10 != 0 {
+			if err != badger.ErrKeyNotFound {
+				t.Errorf("deleted transaction %d: want ErrKeyNotFound, got %v", id, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("transaction %d: %v", id, err)
+		}
+		wantBody(t, res, string(body))
+	}
+
+	// badger picks the files to check at random, another run may or may not
+	// find more to rewrite
+	_, err = s.Compact()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
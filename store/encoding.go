@@ -0,0 +1,96 @@
+package store
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+)
+
+// Encoding selects how requests, responses and formatted bodies are encoded
+// in the store.
+type Encoding int
+
+// These are the supported encodings.
+const (
+	// EncodingRaw stores the values as they are (after a marker byte), this
+	// is the default.
+	EncodingRaw Encoding = iota
+
+	// EncodingBase64 and EncodingHex store text-safe values, prefixed with a
+	// marker so that they are decoded on read regardless of the current
+	// setting.
+	EncodingBase64
+	EncodingHex
+)
+
+// ParseEncoding returns the encoding for the name "raw", "base64" or "hex".
+func ParseEncoding(name string) (Encoding, error) {
+	switch name {
+	case "raw":
+		return EncodingRaw, nil
+	case "base64":
+		return EncodingBase64, nil
+	case "hex":
+		return EncodingHex, nil
+	}
+	return 0, fmt.Errorf("unknown store encoding %q", name)
+}
+
+// Markers prepended to the values. Raw values start with a zero byte, so that
+// a value which starts with the marker of an encoding (e.g. a decompressed
+// body or the raw bytes of a request) is never mistaken for an encoded one.
+// Values without a marker were written before it was introduced and are
+// returned as they are.
+var (
+	rawMarker    = []byte{0}
+	base64Marker = []byte("base64:")
+	hexMarker    = []byte("hex:")
+)
+
+// encodeValue encodes buf with enc.
+func encodeValue(enc Encoding, buf []byte) []byte {
+	switch enc {
+	case EncodingBase64:
+		out := make([]byte, len(base64Marker)+base64.StdEncoding.EncodedLen(len(buf)))
+		copy(out, base64Marker)
+		base64.StdEncoding.Encode(out[len(base64Marker):], buf)
+		return out
+	case EncodingHex:
+		out := make([]byte, len(hexMarker)+hex.EncodedLen(len(buf)))
+		copy(out, hexMarker)
+		hex.Encode(out[len(hexMarker):], buf)
+		return out
+	default:
+		out := make([]byte, len(rawMarker)+len(buf))
+		copy(out, rawMarker)
+		copy(out[len(rawMarker):], buf)
+		return out
+	}
+}
+
+// decodeValue returns the raw value, the encoding is taken from the marker.
+func decodeValue(buf []byte) ([]byte, error) {
+	switch {
+	case bytes.HasPrefix(buf, rawMarker):
+		return buf[len(rawMarker):], nil
+	case bytes.HasPrefix(buf, base64Marker):
+		buf = buf[len(base64Marker):]
+		out := make([]byte, base64.StdEncoding.DecodedLen(len(buf)))
+		n, err := base64.StdEncoding.Decode(out, buf)
+		if err != nil {
+			return nil, fmt.Errorf("decoding base64 value: %v", err)
+		}
+		return out[:n], nil
+	case bytes.HasPrefix(buf, hexMarker):
+		buf = buf[len(hexMarker):]
+		out := make([]byte, hex.DecodedLen(len(buf)))
+		_, err := hex.Decode(out, buf)
+		if err != nil {
+			return nil, fmt.Errorf("decoding hex value: %v", err)
+		}
+		return out, nil
+	default:
+		return buf, nil
+	}
+}
@@ -0,0 +1,198 @@
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/dgraph-io/badger"
+)
+
+func TestStoreEncoding(t *testing.T) {
+	var tests = []struct {
+		enc    Encoding
+		marker string
+	}{
+		{EncodingRaw, "\x00HTTP/1.1"},
+		{EncodingBase64, "base64:"},
+		{EncodingHex, "hex:"},
+	}
+
+	body := []byte("\x00\x01\xff binary \r\n\x7f")
+
+	for _, test := range tests {
+		t.Run(test.marker, func(t *testing.T) {
+			dir, err := ioutil.TempDir(os.TempDir(), "osmosis.testing.store.")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			store, err := New(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer store.Close()
+			store.Encoding = test.enc
+			store.Beautify = true
+
+			request, err := http.ReadRequest(bufio.NewReader(bytes.NewReader([]byte(req))))
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = store.AddRequest(1, request, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			response := &http.Response{
+				StatusCode: http.StatusOK,
+				ProtoMajor: 1,
+				ProtoMinor: 1,
+				Header:     http.Header{"Content-Type": []string{"application/octet-stream"}},
+			}
+			err = store.AddResponse(1, response, body, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			jsonResponse := &http.Response{
+				StatusCode: http.StatusOK,
+				ProtoMajor: 1,
+				ProtoMinor: 1,
+				Header:     http.Header{"Content-Type": []string{"application/json"}},
+			}
+			err = store.AddResponse(1, jsonResponse, []byte(`{"a":1}`), true)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// check the marker of the value in the database
+			err = store.View(func(txn *badger.Txn) error {
+				item, err := txn.Get(Key{ID: 1, Type: ResType}.Bytes())
+				if err != nil {
+					return err
+				}
+				value, err := item.ValueCopy(nil)
+				if err != nil {
+					return err
+				}
+				if !bytes.HasPrefix(value, []byte(test.marker)) {
+					t.Errorf("stored value does not start with %q: %q", test.marker, value)
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// the marker is honored even if the setting changes
+			store.Encoding = EncodingRaw
+
+			res, err := store.GetResponse(1, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			wantBody(t, res, string(body))
+
+			r, err := store.GetRequest(1, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if r.URL.String() != request.URL.String() {
+				t.Errorf("wrong request URL, want %v, got %v", request.URL, r.URL)
+			}
+
+			formatted, err := store.GetFormattedBody(1, ResType, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(formatted) != "{\n  \"a\": 1\n}" {
+				t.Errorf("wrong formatted body %q", formatted)
+			}
+		})
+	}
+}
+
+func TestStoreRawValueWithMarker(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "osmosis.testing.store.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	store.Beautify = true
+
+	request, err := http.ReadRequest(bufio.NewReader(bytes.NewReader([]byte(req))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.AddRequest(1, request, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the decompressed copy of the body is stored as the formatted body
+	text := "hex:not encoded"
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	_, _ = zw.Write([]byte(text))
+	_ = zw.Close()
+
+	response := &http.Response{
+		StatusCode: http.StatusOK,
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header: http.Header{
+			"Content-Type":     []string{"text/plain"},
+			"Content-Encoding": []string{"gzip"},
+		},
+	}
+	err = store.AddResponse(1, response, buf.Bytes(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	formatted, err := store.GetFormattedBody(1, ResType, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(formatted) != text {
+		t.Errorf("wrong formatted body, want %q, got %q", text, formatted)
+	}
+
+	raw := "hex:41 / HTTP/1.1\r\nHost: example.com\r\n\r\n"
+	err = store.SetRawRequest(1, []byte(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := store.GetRawRequest(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != raw {
+		t.Errorf("wrong raw request, want %q, got %q", raw, got)
+	}
+}
+
+func TestDecodeValueUnmarked(t *testing.T) {
+	// values written before the raw marker was introduced
+	value := []byte("HTTP/1.1 200 OK\r\n\r\n")
+	got, err := decodeValue(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, value) {
+		t.Errorf("wrong value returned, want %q, got %q", value, got)
+	}
+}
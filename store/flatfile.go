@@ -0,0 +1,170 @@
+package store
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/dgraph-io/badger"
+)
+
+// FlatFileIDs returns the sorted IDs of the transactions in the log directory
+// dir, which contains an N.request and optionally an N.response file for each
+// transaction. The directory is listed, so gaps in the IDs don't matter.
+func FlatFileIDs(dir string) ([]uint64, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []uint64
+	for _, fi := range entries {
+		if fi.IsDir() || !strings.HasSuffix(fi.Name(), ".request") {
+			continue
+		}
+
+		id, err := strconv.ParseUint(strings.TrimSuffix(fi.Name(), ".request"), 10, 64)
+		if err != nil {
+			continue
+		}
+		ids = append(ids, id)
+	}
+
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids, nil
+}
+
+// ReadFlatFiles parses the request and the response (if any) of transaction
+// id from the log directory dir. The response body is read completely.
+func ReadFlatFiles(dir string, id uint64) (req *http.Request, res *http.Response, err error) {
+	filename, err := flatFilePath(dir, id, ".request")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	req, err = http.ReadRequest(bufio.NewReader(f))
+	if err != nil {
+		return nil, nil, fmt.Errorf("parsing request %d: %v", id, err)
+	}
+	_, err = readBody(&req.Body)
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading request body %d: %v", id, err)
+	}
+
+	// RequestURI can't be set for client requests
+	req.RequestURI = ""
+
+	filename, err = flatFilePath(dir, id, ".response")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	f, err = os.Open(filename)
+	if os.IsNotExist(err) {
+		return req, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	res, err = http.ReadResponse(bufio.NewReader(f), req)
+	if err != nil {
+		return nil, nil, fmt.Errorf("parsing response %d: %v", id, err)
+	}
+	_, err = readBody(&res.Body)
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading response body %d: %v", id, err)
+	}
+
+	return req, res, nil
+}
+
+// ImportFlatFiles adds the transactions from the log directory dir (see
+// FlatFileIDs) to the store, keeping their IDs. Transactions which are
+// already complete in the store are skipped, so an interrupted import can be
+// resumed by calling ImportFlatFiles again. The import stops when ctx is
+// cancelled. The number of transactions added is returned.
+func (s *TxnStore) ImportFlatFiles(ctx context.Context, dir string) (int, error) {
+	ids, err := FlatFileIDs(dir)
+	if err != nil {
+		return 0, err
+	}
+
+	imported := 0
+	for _, id := range ids {
+		if err := ctx.Err(); err != nil {
+			return imported, err
+		}
+
+		req, res, err := ReadFlatFiles(dir, id)
+		if err != nil {
+			return imported, err
+		}
+
+		done, err := s.hasTxn(id, res != nil)
+		if err != nil {
+			return imported, err
+		}
+		if done {
+			continue
+		}
+
+		err = s.AddRequest(id, req, false)
+		if err != nil {
+			return imported, fmt.Errorf("adding request %d: %v", id, err)
+		}
+
+		if res != nil {
+			body, err := readBody(&res.Body)
+			if err != nil {
+				return imported, err
+			}
+			err = s.AddResponse(id, res, body, false)
+			if err != nil {
+				return imported, fmt.Errorf("adding response %d: %v", id, err)
+			}
+		}
+
+		imported++
+	}
+
+	return imported, nil
+}
+
+// hasTxn returns true if the store contains the request of transaction id and,
+// if withResponse is set, also the response.
+func (s *TxnStore) hasTxn(id uint64, withResponse bool) (bool, error) {
+	keys := []Key{{ID: id, Type: ReqType}}
+	if withResponse {
+		keys = append(keys, Key{ID: id, Type: ResType})
+	}
+
+	found := true
+	err := s.DB.View(func(txn *badger.Txn) error {
+		for _, key := range keys {
+			_, err := txn.Get(key.Bytes())
+			if err == badger.ErrKeyNotFound {
+				found = false
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return found, err
+}
@@ -0,0 +1,104 @@
+package store
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func writeFlatFile(t testing.TB, dir, name, data string) {
+	err := ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestImportFlatFiles(t *testing.T) {
+	logdir, err := ioutil.TempDir(os.TempDir(), "osmosis.testing.logdir.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(logdir)
+
+	// a large gap between the IDs, the last request has no response
+	for _, id := range []string{"1", "2", "100"} {
+		writeFlatFile(t, logdir, id+".request", "GET http://example.com/"+id+" HTTP/1.1\r\nHost: example.com\r\n\r\n")
+	}
+	writeFlatFile(t, logdir, "1.response", "HTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\nfirst")
+	writeFlatFile(t, logdir, "2.response", "HTTP/1.1 404 Not Found\r\nContent-Length: 6\r\n\r\nsecond")
+	writeFlatFile(t, logdir, "notes.txt", "not a transaction")
+
+	ids, err := FlatFileIDs(logdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []uint64{1, 2, 100}; !reflect.DeepEqual(ids, want) {
+		t.Fatalf("wrong IDs found, want %v, got %v", want, ids)
+	}
+
+	dir, err := ioutil.TempDir(os.TempDir(), "osmosis.testing.store.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	// a cancelled context stops the import before anything is added
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	n, err := store.ImportFlatFiles(ctx, logdir)
+	if err != context.Canceled || n != 0 {
+		t.Fatalf("unexpected result for cancelled import: %v, %v", n, err)
+	}
+
+	n, err = store.ImportFlatFiles(context.Background(), logdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 3 {
+		t.Errorf("wrong number of transactions imported, want 3, got %v", n)
+	}
+
+	res, err := store.GetResponse(2, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusNotFound {
+		t.Errorf("wrong status for response 2: %v", res.StatusCode)
+	}
+	wantBody(t, res, "second")
+
+	req, err := store.GetRequest(100, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.URL.String() != "http://example.com/100" {
+		t.Errorf("wrong URL for request 100: %v", req.URL)
+	}
+
+	// importing again resumes, only the transaction which got a response in
+	// the meantime is added
+	writeFlatFile(t, logdir, "100.response", "HTTP/1.1 200 OK\r\nContent-Length: 4\r\n\r\nlast")
+	n, err = store.ImportFlatFiles(context.Background(), logdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("wrong number of transactions imported on resume, want 1, got %v", n)
+	}
+
+	res, err = store.GetResponse(100, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantBody(t, res, "last")
+}
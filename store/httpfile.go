@@ -0,0 +1,140 @@
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// HTTPFileSeparator separates the request from the response in an .http
+// file.
+const HTTPFileSeparator = "###"
+
+// WriteHTTPFile writes the request and the response (if any) of txn to w in
+// a single file: the request, a blank line, a line containing
+// HTTPFileSeparator and the response. The edited variants are preferred.
+func WriteHTTPFile(w io.Writer, txn *Txn) error {
+	req := txn.Req
+	if txn.ReqE != nil {
+		req = txn.ReqE
+	}
+	res := txn.Res
+	if txn.ResE != nil {
+		res = txn.ResE
+	}
+
+	var buf bytes.Buffer
+	err := req.WriteProxy(&buf)
+	if err != nil {
+		return fmt.Errorf("writing request: %v", err)
+	}
+
+	if res != nil {
+		body, err := readBody(&res.Body)
+		if err != nil {
+			return fmt.Errorf("reading response body: %v", err)
+		}
+
+		// write the body with a fixed length, like AddResponse
+		resCopy := *res
+		resCopy.Body = ioutil.NopCloser(bytes.NewReader(body))
+		resCopy.ContentLength = int64(len(body))
+		resCopy.TransferEncoding = nil
+		resCopy.Trailer = nil
+
+		buf.WriteString("\r\n" + HTTPFileSeparator + "\r\n")
+		err = resCopy.Write(&buf)
+		if err != nil {
+			return fmt.Errorf("writing response: %v", err)
+		}
+	}
+
+	_, err = w.Write(buf.Bytes())
+	return err
+}
+
+// ReadHTTPFile parses a file written by WriteHTTPFile. If the file does not
+// contain a response, res is nil. The response body is read completely.
+func ReadHTTPFile(r io.Reader) (req *http.Request, res *http.Response, err error) {
+	rd := bufio.NewReader(r)
+	req, err = http.ReadRequest(rd)
+	if err != nil {
+		return nil, nil, fmt.Errorf("parsing request: %v", err)
+	}
+
+	// read the body so that the response can be parsed afterwards
+	_, err = readBody(&req.Body)
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading request body: %v", err)
+	}
+
+	// skip blank lines up to the separator
+	for {
+		line, err := rd.ReadString('\n')
+		trimmed := strings.TrimSpace(line)
+		if err == io.EOF && trimmed == "" {
+			return req, nil, nil
+		}
+		if err != nil && err != io.EOF {
+			return nil, nil, err
+		}
+
+		if trimmed == HTTPFileSeparator {
+			break
+		}
+		if trimmed != "" {
+			return nil, nil, fmt.Errorf("unexpected data after request: %q", line)
+		}
+	}
+
+	res, err = http.ReadResponse(rd, req)
+	if err != nil {
+		return nil, nil, fmt.Errorf("parsing response: %v", err)
+	}
+
+	_, err = readBody(&res.Body)
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading response body: %v", err)
+	}
+
+	return req, res, nil
+}
+
+// ImportHTTPFile reads a file written by WriteHTTPFile and adds it to the
+// store as a new transaction, the ID is returned.
+func (s *TxnStore) ImportHTTPFile(r io.Reader) (uint64, error) {
+	req, res, err := ReadHTTPFile(r)
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := s.NextID()
+	if err != nil {
+		return 0, err
+	}
+
+	// RequestURI can't be set for client requests
+	req.RequestURI = ""
+
+	err = s.AddRequest(id, req, false)
+	if err != nil {
+		return 0, err
+	}
+
+	if res != nil {
+		body, err := readBody(&res.Body)
+		if err != nil {
+			return 0, err
+		}
+		err = s.AddResponse(id, res, body, false)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return id, nil
+}
@@ -0,0 +1,118 @@
+package store
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestHTTPFileRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "osmosis.testing.store.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	request, err := http.NewRequest(http.MethodPost, "https://example.com/login?next=%2F", strings.NewReader("user=foo&pass=bar"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	err = store.AddRequest(1, request, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	response := &http.Response{
+		StatusCode:       http.StatusOK,
+		ProtoMajor:       1,
+		ProtoMinor:       1,
+		Header:           http.Header{"Content-Type": []string{"text/plain"}},
+		TransferEncoding: []string{"chunked"},
+	}
+	err = store.AddResponse(1, response, []byte("welcome\r\n\r\n###\r\n"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	txn, err := store.GetTxn(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	err = WriteHTTPFile(&buf, txn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exported := buf.Bytes()
+
+	req, res, err := ReadHTTPFile(bytes.NewReader(exported))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.Method != http.MethodPost || req.URL.String() != "https://example.com/login?next=%2F" {
+		t.Errorf("wrong request read: %v %v", req.Method, req.URL)
+	}
+	if res == nil || res.StatusCode != http.StatusOK {
+		t.Fatalf("wrong response read: %v", res)
+	}
+	wantBody(t, res, "welcome\r\n\r\n###\r\n")
+
+	id, err := store.ImportHTTPFile(bytes.NewReader(exported))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != 2 {
+		t.Errorf("wrong ID for imported transaction, want 2, got %d", id)
+	}
+
+	imported, err := store.GetTxn(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf.Reset()
+	err = WriteHTTPFile(&buf, imported)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(buf.Bytes(), exported) {
+		t.Errorf("transaction changed in round trip, want\n%s\ngot\n%s", exported, buf.Bytes())
+	}
+}
+
+func TestHTTPFileRequestOnly(t *testing.T) {
+	request, err := http.NewRequest(http.MethodGet, "http://example.com/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	err = WriteHTTPFile(&buf, &Txn{Req: request})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, res, err := ReadHTTPFile(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.URL.String() != "http://example.com/" {
+		t.Errorf("wrong URL %v", req.URL)
+	}
+	if res != nil {
+		t.Errorf("unexpected response %v", res)
+	}
+}
@@ -17,6 +17,16 @@ const (
 	KeyTemplate             = "%d-%s-%s"
 	ReqType         KeyType = "Req"
 	ResType         KeyType = "Res"
+	ConnType        KeyType = "Conn"
+	NoteType        KeyType = "Note"
+	ErrType         KeyType = "Err"
+	ReqFmtType      KeyType = "ReqFmt"
+	ResFmtType      KeyType = "ResFmt"
+	ReqSizeType     KeyType = "ReqSize"
+	ResSizeType     KeyType = "ResSize"
+	ReqRawType      KeyType = "ReqRaw"
+	ResRawType      KeyType = "ResRaw"
+	ShadowType      KeyType = "Shadow"
 	EditedPostfix           = "E"
 	OriginalPostfix         = "O"
 )
@@ -59,7 +69,9 @@ func ParseKey(storeKey []byte) (key *Key, err error) {
 	}
 
 	keyType := KeyType(rawType)
-	if keyType != ReqType && keyType != ResType {
+	switch keyType {
+	case ReqType, ResType, ConnType, NoteType, ErrType, ReqFmtType, ResFmtType, ReqSizeType, ResSizeType, ReqRawType, ResRawType, ShadowType:
+	default:
 		return nil, fmt.Errorf("invalid key kind: %s", rawType)
 	}
 	key.Type = keyType
@@ -0,0 +1,87 @@
+package store
+
+import "sync"
+
+// notifier delivers update events to the callback set with
+// TxnStore.SetOnUpdate. Events are queued, so storing is never blocked by a
+// slow callback, and delivered from a single goroutine in the order the
+// transactions were first updated. While an event for a transaction is still
+// queued, further updates of the same transaction are merged into it.
+type notifier struct {
+	m       sync.Mutex
+	idle    *sync.Cond
+	fn      func(uint64)
+	pending []uint64
+	queued  map[uint64]struct{}
+	running bool
+}
+
+// set replaces the callback, nil disables the events.
+func (n *notifier) set(fn func(uint64)) {
+	n.m.Lock()
+	defer n.m.Unlock()
+
+	n.fn = fn
+}
+
+// notify queues an event for the transaction id.
+func (n *notifier) notify(id uint64) {
+	n.m.Lock()
+	defer n.m.Unlock()
+
+	if n.fn == nil {
+		return
+	}
+
+	if n.queued == nil {
+		n.queued = make(map[uint64]struct{})
+	}
+	if _, ok := n.queued[id]; ok {
+		return
+	}
+	n.queued[id] = struct{}{}
+	n.pending = append(n.pending, id)
+
+	if !n.running {
+		n.running = true
+		go n.run()
+	}
+}
+
+// run delivers the queued events until the queue is empty.
+func (n *notifier) run() {
+	n.m.Lock()
+	defer n.m.Unlock()
+
+	for len(n.pending) > 0 && n.fn != nil {
+		id := n.pending[0]
+		n.pending = n.pending[1:]
+		delete(n.queued, id)
+		fn := n.fn
+
+		n.m.Unlock()
+		fn(id)
+		n.m.Lock()
+	}
+
+	// events queued while the callback was removed are dropped
+	n.pending = nil
+	n.queued = nil
+	n.running = false
+	if n.idle != nil {
+		n.idle.Broadcast()
+	}
+}
+
+// wait blocks until all queued events have been delivered.
+func (n *notifier) wait() {
+	n.m.Lock()
+	defer n.m.Unlock()
+
+	if n.idle == nil {
+		n.idle = sync.NewCond(&n.m)
+	}
+	for n.running {
+		n.idle.Wait()
+	}
+}
@@ -0,0 +1,105 @@
+package store
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestStoreOnUpdateConcurrent(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "osmosis.testing.store.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	var m sync.Mutex
+	seen := make(map[uint64]int)
+	var order []uint64
+	store.SetOnUpdate(func(id uint64) {
+		// a slow consumer must not block storing
+		time.Sleep(time.Millisecond)
+
+		m.Lock()
+		seen[id]++
+		order = append(order, id)
+		m.Unlock()
+	})
+
+	const n = 20
+	var wg sync.WaitGroup
+	for i := 1; i <= n; i++ {
+		wg.Add(1)
+		go func(id uint64) {
+			defer wg.Done()
+
+			res := &http.Response{
+				StatusCode: http.StatusOK,
+				ProtoMajor: 1,
+				ProtoMinor: 1,
+				Header:     http.Header{},
+			}
+			err := store.AddResponse(id, res, []byte(fmt.Sprintf("body %d", id)), false)
+			if err != nil {
+				t.Error(err)
+			}
+		}(uint64(i))
+	}
+
+	// replacing the callback concurrently is safe
+	store.SetOnUpdate(func(id uint64) {
+		m.Lock()
+		seen[id]++
+		m.Unlock()
+	})
+
+	wg.Wait()
+	store.WaitUpdates()
+
+	m.Lock()
+	defer m.Unlock()
+	for id := uint64(1); id <= n; id++ {
+		if seen[id] != 1 {
+			t.Errorf("transaction %d reported %d times", id, seen[id])
+		}
+	}
+}
+
+func TestNotifierOrder(t *testing.T) {
+	var n notifier
+
+	// events are dropped while no callback is set
+	n.notify(23)
+
+	block := make(chan struct{})
+	var got []uint64
+	n.set(func(id uint64) {
+		if id == 1 {
+			<-block
+		}
+		got = append(got, id)
+	})
+
+	// while the callback for 1 is blocked, the other events are queued and
+	// the repeated update for 2 is merged
+	for _, id := range []uint64{1, 2, 3, 2, 4} {
+		n.notify(id)
+	}
+	close(block)
+	n.wait()
+
+	want := []uint64{1, 2, 3, 4}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("wrong order of events, want %v, got %v", want, got)
+	}
+}
@@ -0,0 +1,34 @@
+package store
+
+import (
+	"errors"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ErrUnsafeName is returned by SafeJoin for names which could select a file
+// outside of the directory.
+var ErrUnsafeName = errors.New("unsafe file name")
+
+// SafeJoin returns the path of the file name in dir. The name may come from
+// an external source (e.g. a file to import), so it must be a plain file
+// name: names which are empty or ".", contain a path separator, ".." or a
+// NUL byte, or start with a volume name are rejected with ErrUnsafeName.
+func SafeJoin(dir, name string) (string, error) {
+	if name == "" || name == "." ||
+		strings.ContainsAny(name, `/\`+"\x00") ||
+		strings.ContainsRune(name, filepath.Separator) ||
+		strings.Contains(name, "..") ||
+		filepath.VolumeName(name) != "" {
+		return "", ErrUnsafeName
+	}
+
+	return filepath.Join(dir, name), nil
+}
+
+// flatFilePath returns the path of the file with the given suffix for
+// transaction id in the log directory dir.
+func flatFilePath(dir string, id uint64, suffix string) (string, error) {
+	return SafeJoin(dir, strconv.FormatUint(id, 10)+suffix)
+}
@@ -0,0 +1,45 @@
+package store
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSafeJoin(t *testing.T) {
+	dir := filepath.Join("log", "dir")
+
+	for _, name := range []string{
+		"",
+		".",
+		"..",
+		"../5.request",
+		"../../etc/passwd",
+		"..\\5.request",
+		"sub/5.request",
+		"/etc/passwd",
+		"5..request",
+		"5.request\x00.txt",
+	} {
+		p, err := SafeJoin(dir, name)
+		if err != ErrUnsafeName {
+			t.Errorf("name %q was not rejected: %q, %v", name, p, err)
+		}
+	}
+
+	for _, name := range []string{"5.request", "23.response", "log.txt"} {
+		p, err := SafeJoin(dir, name)
+		if err != nil {
+			t.Errorf("name %q: %v", name, err)
+			continue
+		}
+
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rel != name || strings.HasPrefix(rel, "..") {
+			t.Errorf("name %q: path %q is not in %q", name, p, dir)
+		}
+	}
+}
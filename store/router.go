@@ -0,0 +1,87 @@
+package store
+
+import (
+	"strings"
+	"sync"
+)
+
+// route maps a host pattern to a store.
+type route struct {
+	pattern string
+	store   *TxnStore
+}
+
+// Router selects the store for a transaction by the target host, so that the
+// transactions for different targets are kept in separate stores. Routes are
+// checked in the order they were added.
+type Router struct {
+	// Default receives the transactions for hosts without a matching route,
+	// if it is nil they are not stored.
+	Default *TxnStore
+
+	m      sync.RWMutex
+	routes []route
+}
+
+// Add routes the transactions for hosts matching pattern to s. A pattern
+// starting with "*." also matches all subdomains.
+func (r *Router) Add(pattern string, s *TxnStore) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	r.routes = append(r.routes, route{pattern: strings.ToLower(pattern), store: s})
+}
+
+// Lookup returns the store for host, which may be nil.
+func (r *Router) Lookup(host string) *TxnStore {
+	r.m.RLock()
+	defer r.m.RUnlock()
+
+	host = strings.ToLower(host)
+	for _, route := range r.routes {
+		if MatchHost(route.pattern, host) {
+			return route.store
+		}
+	}
+	return r.Default
+}
+
+// Close closes all stores, including the default store.
+func (r *Router) Close() error {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	closed := make(map[*TxnStore]struct{})
+	var firstErr error
+	for _, s := range append(r.stores(), r.Default) {
+		if _, ok := closed[s]; ok || s == nil {
+			continue
+		}
+		closed[s] = struct{}{}
+
+		err := s.Close()
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// stores returns the stores of all routes. The caller must hold r.m.
+func (r *Router) stores() []*TxnStore {
+	list := make([]*TxnStore, 0, len(r.routes))
+	for _, route := range r.routes {
+		list = append(list, route.store)
+	}
+	return list
+}
+
+// MatchHost returns true if host matches pattern, like the patterns of a
+// Router. The comparison is case-insensitive.
+func MatchHost(pattern, host string) bool {
+	pattern, host = strings.ToLower(pattern), strings.ToLower(host)
+	if strings.HasPrefix(pattern, "*.") {
+		return strings.HasSuffix(host, pattern[1:]) || host == pattern[2:]
+	}
+	return host == pattern
+}
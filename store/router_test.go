@@ -0,0 +1,35 @@
+package store
+
+import "testing"
+
+func TestRouterLookup(t *testing.T) {
+	a, b, def := &TxnStore{}, &TxnStore{}, &TxnStore{}
+
+	var r Router
+	r.Add("*.Example.com", a)
+	r.Add("api.example.org", b)
+	r.Add("*.example.org", a)
+
+	var tests = []struct {
+		host string
+		want *TxnStore
+	}{
+		{"example.com", a},
+		{"www.example.com", a},
+		{"API.example.org", b},
+		{"www.example.org", a},
+		{"example.net", nil},
+		{"badexample.com", nil},
+	}
+
+	for _, test := range tests {
+		if got := r.Lookup(test.host); got != test.want {
+			t.Errorf("wrong store for %v", test.host)
+		}
+	}
+
+	r.Default = def
+	if r.Lookup("example.net") != def {
+		t.Error("default store not used for unmatched host")
+	}
+}
@@ -2,11 +2,16 @@ package store
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
 	"net/http"
-	"net/http/httputil"
 	"net/url"
 	"sort"
+	"strconv"
+	"sync"
 
 	"github.com/dgraph-io/badger"
 )
@@ -33,6 +38,74 @@ type TxnSummary struct {
 	ReqEdited   bool
 	ResEdited   bool
 	HasResponse bool
+	HasNote     bool
+	HasShadow   bool
+	Conn        *ConnInfo
+
+	// Error is set if the request could not be forwarded, see SetError.
+	Error string
+
+	// ReqSize and ResSize are the sizes of the raw request and response
+	// (header and body) in bytes, the edited versions take precedence.
+	ReqSize, ResSize int64
+}
+
+// ConnInfo describes the client connection a request was received on.
+type ConnInfo struct {
+	Proto              string
+	ViaCONNECT         bool
+	TLS                bool
+	TLSVersion         uint16
+	CipherSuite        uint16
+	ServerName         string
+	NegotiatedProtocol string
+	JA3                string
+
+	// ClientIP is the address of the client which sent the request, see
+	// ClientIP.
+	ClientIP string
+}
+
+// ShadowResult is the comparison of the response with the response of a
+// shadow upstream for the same request, see proxy.Shadow.
+type ShadowResult struct {
+	// Primary and Shadow contain the raw responses.
+	Primary, Shadow []byte
+
+	// Err is set if the shadow response could not be compared.
+	Err string
+
+	// Diff lists the differences between both responses, it is empty if the
+	// responses are the same.
+	Diff []string
+}
+
+// ClientIP returns the IP address from the remote address of a request
+// (e.g. http.Request.RemoteAddr), with or without port. It returns the empty
+// string if remoteAddr does not contain an IP address.
+func ClientIP(remoteAddr string) string {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return ""
+	}
+	return ip.String()
+}
+
+// FilterClientIP returns the summaries of transactions sent by the client
+// with the given IP address.
+func FilterClientIP(summaries []*TxnSummary, ip string) []*TxnSummary {
+	ip = ClientIP(ip)
+	var list []*TxnSummary
+	for _, summary := range summaries {
+		if ip != "" && summary.Conn != nil && summary.Conn.ClientIP == ip {
+			list = append(list, summary)
+		}
+	}
+	return list
 }
 
 // TxnStore is a key value store mapping
@@ -40,7 +113,19 @@ type TxnSummary struct {
 type TxnStore struct {
 	*badger.DB
 
-	OnUpdate func(uint64)
+	updates notifier
+
+	idMu   sync.Mutex
+	lastID uint64
+
+	// Beautify enables storing a decompressed copy of the body with JSON and
+	// XML indented in addition to the raw body, see GetFormattedBody.
+	Beautify bool
+
+	// Encoding selects how requests and responses are written to the store,
+	// e.g. base64 for text-safe values. Values are decoded on read
+	// regardless of this setting.
+	Encoding Encoding
 }
 
 // New returns a new TxnStore.
@@ -55,53 +140,426 @@ func New(storeDir string) (*TxnStore, error) {
 	return &TxnStore{DB: db}, nil
 }
 
-// Close closes the underlying database gracefully.
+// SetOnUpdate sets the function called with the ID of a transaction after it
+// was modified, nil removes it. It is safe to call while the store is in use.
+// The calls happen asynchronously from a single goroutine, in the order the
+// transactions were modified. Several updates of a transaction made before
+// the call for it happened are reported once. The function must not call
+// WaitUpdates or Close.
+func (s *TxnStore) SetOnUpdate(fn func(id uint64)) {
+	s.updates.set(fn)
+}
+
+// WaitUpdates blocks until the function set with SetOnUpdate has been called
+// for all modifications made so far.
+func (s *TxnStore) WaitUpdates() {
+	s.updates.wait()
+}
+
+// Close waits for pending update events and closes the underlying database
+// gracefully.
 func (s *TxnStore) Close() error {
+	s.updates.wait()
 	return s.DB.Close()
 }
 
-// AddRequest adds a new request to the store and triggers an OnUpdate event.
+// Backup writes a snapshot of all transactions in the store to w, including
+// the edited variants. The snapshot can be loaded again with Restore.
+func (s *TxnStore) Backup(w io.Writer) error {
+	_, err := s.DB.Backup(w, 0)
+	return err
+}
+
+// Restore loads a snapshot written by Backup into the store. Transactions
+// with the same IDs as in the snapshot are overwritten.
+func (s *TxnStore) Restore(r io.Reader) error {
+	err := s.DB.Load(r)
+
+	// the snapshot may contain higher IDs
+	s.idMu.Lock()
+	s.lastID = 0
+	s.idMu.Unlock()
+
+	return err
+}
+
+// AddRequest adds a new request to the store and triggers an update event.
 func (s *TxnStore) AddRequest(id uint64, req *http.Request, edited bool) error {
-	var reqDump bytes.Buffer
-	err := req.WriteProxy(&reqDump)
+	return s.putRequest(id, req, edited, false)
+}
+
+// UpdateRequest overwrites the original request of an existing transaction
+// and triggers an update event. The edited request is not modified. If the
+// transaction has no request, badger.ErrKeyNotFound is returned.
+func (s *TxnStore) UpdateRequest(id uint64, req *http.Request) error {
+	return s.putRequest(id, req, false, true)
+}
+
+func (s *TxnStore) putRequest(id uint64, req *http.Request, edited, mustExist bool) error {
+	data, err := dumpRequest(req)
+	if err != nil {
+		return err
+	}
+	var formatted []byte
+	if s.Beautify {
+		formatted = formatRequest(data)
+	}
+
+	return s.put(Key{ID: id, Type: ReqType, Edited: edited}, ReqFmtType, ReqSizeType, data, formatted, mustExist)
+}
+
+// dumpRequest returns req as it is written to the store.
+func dumpRequest(req *http.Request) ([]byte, error) {
+	var buf bytes.Buffer
+	err := req.WriteProxy(&buf)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// AddResponse adds a new response to the store and triggers an update event.
+func (s *TxnStore) AddResponse(id uint64, res *http.Response, body []byte, edited bool) error {
+	return s.putResponse(id, res, body, edited, false)
+}
+
+// UpdateResponse overwrites the original response of an existing transaction
+// and triggers an update event. The edited response is not modified. If the
+// transaction has no response, badger.ErrKeyNotFound is returned.
+func (s *TxnStore) UpdateResponse(id uint64, res *http.Response, body []byte) error {
+	return s.putResponse(id, res, body, false, true)
+}
+
+func (s *TxnStore) putResponse(id uint64, res *http.Response, body []byte, edited, mustExist bool) error {
+	data, err := dumpResponse(res, body)
+	if err != nil {
+		return err
+	}
+
+	var formatted []byte
+	if s.Beautify {
+		formatted = formatBody(res.Header, body)
+	}
+
+	return s.put(Key{ID: id, Type: ResType, Edited: edited}, ResFmtType, ResSizeType, data, formatted, mustExist)
+}
+
+// dumpResponse returns res with body as it is written to the store.
+func dumpResponse(res *http.Response, body []byte) ([]byte, error) {
+	// Body is already read and closed, store it with a fixed length so that
+	// it can be parsed again regardless of the original transfer encoding
+	resCopy := *res
+	resCopy.Body = ioutil.NopCloser(bytes.NewReader(body))
+	resCopy.ContentLength = int64(len(body))
+	resCopy.TransferEncoding = nil
+	resCopy.Trailer = nil
+
+	var buf bytes.Buffer
+	err := resCopy.Write(&buf)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// put stores data at key, its size at the key of type sizeType and the
+// formatted copy (if any) at the key of type fmtType, then triggers an
+// update event. If mustExist is set, key must be present already and a stale
+// formatted copy is removed.
+func (s *TxnStore) put(key Key, fmtType, sizeType KeyType, data, formatted []byte, mustExist bool) error {
+	err := s.Update(func(txn *badger.Txn) error {
+		return s.putTxn(txn, key, fmtType, sizeType, data, formatted, mustExist)
+	})
+	if err != nil {
+		return err
+	}
+	s.updates.notify(key.ID)
+	return nil
+}
+
+// putTxn works like put, but within the badger transaction txn and without
+// triggering an update event.
+func (s *TxnStore) putTxn(txn *badger.Txn, key Key, fmtType, sizeType KeyType, data, formatted []byte, mustExist bool) error {
+	fmtKey := Key{ID: key.ID, Type: fmtType, Edited: key.Edited}
+	sizeKey := Key{ID: key.ID, Type: sizeType, Edited: key.Edited}
+
+	if mustExist {
+		_, err := txn.Get(key.Bytes())
+		if err != nil {
+			return err
+		}
+	}
+
+	// TODO: what if the key already exists?
+	err := txn.Set(key.Bytes(), encodeValue(s.Encoding, data))
+	if err != nil {
+		return err
+	}
+
+	err = txn.Set(sizeKey.Bytes(), []byte(strconv.Itoa(len(data))))
+	if err != nil {
+		return err
+	}
+
+	if formatted == nil {
+		if mustExist {
+			return txn.Delete(fmtKey.Bytes())
+		}
+		return nil
+	}
+	return txn.Set(fmtKey.Bytes(), encodeValue(s.Encoding, formatted))
+}
+
+// AddConnInfo stores information about the client connection of the
+// transaction and triggers an update event.
+func (s *TxnStore) AddConnInfo(id uint64, info ConnInfo) error {
+	buf, err := json.Marshal(info)
 	if err != nil {
 		return err
 	}
 	err = s.Update(func(txn *badger.Txn) error {
-		// TODO: what if the key already exists?
-		return txn.Set(Key{ID: id, Type: ReqType, Edited: edited}.Bytes(), reqDump.Bytes())
+		return txn.Set(Key{ID: id, Type: ConnType}.Bytes(), buf)
 	})
 	if err != nil {
 		return err
 	}
-	if s.OnUpdate != nil {
-		s.OnUpdate(id)
+	s.updates.notify(id)
+	return nil
+}
+
+// GetConnInfo fetches the client connection information for the transaction
+// with the specified ID.
+func (s *TxnStore) GetConnInfo(id uint64) (info *ConnInfo, e error) {
+	err := s.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(Key{ID: id, Type: ConnType}.Bytes())
+		if err != nil {
+			return err
+		}
+		info, err = parseConnInfo(item)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// SetNote stores a freeform note for the transaction and triggers an
+// update event. An empty note removes it.
+func (s *TxnStore) SetNote(id uint64, note string) error {
+	err := s.Update(func(txn *badger.Txn) error {
+		key := Key{ID: id, Type: NoteType}.Bytes()
+		if note == "" {
+			return txn.Delete(key)
+		}
+		return txn.Set(key, []byte(note))
+	})
+	if err != nil {
+		return err
 	}
+	s.updates.notify(id)
 	return nil
 }
 
-// AddResponse adds a new response to the store and triggers an OnUpdate event.
-func (s *TxnStore) AddResponse(id uint64, res *http.Response, body []byte, edited bool) error {
-	// Body is already read and closed, we will add it later
-	resDump, err := httputil.DumpResponse(res, false)
+// GetNote fetches the note for the transaction with the specified ID.
+func (s *TxnStore) GetNote(id uint64) (note string, e error) {
+	err := s.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(Key{ID: id, Type: NoteType}.Bytes())
+		if err != nil {
+			return err
+		}
+		buf, err := item.ValueCopy(nil)
+		if err != nil {
+			return err
+		}
+		note = string(buf)
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return note, nil
+}
+
+// SetError records why the request of the transaction could not be forwarded,
+// such transactions usually have no response. It triggers an update event.
+// An empty message removes it.
+func (s *TxnStore) SetError(id uint64, msg string) error {
+	err := s.Update(func(txn *badger.Txn) error {
+		key := Key{ID: id, Type: ErrType}.Bytes()
+		if msg == "" {
+			return txn.Delete(key)
+		}
+		return txn.Set(key, []byte(msg))
+	})
 	if err != nil {
 		return err
 	}
-	resDump = append(resDump, body...)
+	s.updates.notify(id)
+	return nil
+}
+
+// GetError fetches the error recorded for the transaction with the specified
+// ID.
+func (s *TxnStore) GetError(id uint64) (msg string, e error) {
+	err := s.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(Key{ID: id, Type: ErrType}.Bytes())
+		if err != nil {
+			return err
+		}
+		buf, err := item.ValueCopy(nil)
+		if err != nil {
+			return err
+		}
+		msg = string(buf)
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return msg, nil
+}
 
+// SetShadowResult stores the comparison with the response of a shadow
+// upstream for the transaction and triggers an update event.
+func (s *TxnStore) SetShadowResult(id uint64, result ShadowResult) error {
+	buf, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
 	err = s.Update(func(txn *badger.Txn) error {
-		// TODO: what if the key already exists
-		return txn.Set(Key{ID: id, Type: ResType, Edited: edited}.Bytes(), resDump)
+		return txn.Set(Key{ID: id, Type: ShadowType}.Bytes(), buf)
+	})
+	if err != nil {
+		return err
+	}
+	s.updates.notify(id)
+	return nil
+}
+
+// GetShadowResult fetches the comparison stored with SetShadowResult. If
+// there is none, badger.ErrKeyNotFound is returned.
+func (s *TxnStore) GetShadowResult(id uint64) (result *ShadowResult, e error) {
+	err := s.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(Key{ID: id, Type: ShadowType}.Bytes())
+		if err != nil {
+			return err
+		}
+		buf, err := item.ValueCopy(nil)
+		if err != nil {
+			return err
+		}
+		result = &ShadowResult{}
+		return json.Unmarshal(buf, result)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// SetRawRequest stores the exact bytes of the request as they were received
+// from the client, in addition to the parsed request which is normalized when
+// it is written. It triggers an update event.
+func (s *TxnStore) SetRawRequest(id uint64, raw []byte) error {
+	err := s.Update(func(txn *badger.Txn) error {
+		return txn.Set(Key{ID: id, Type: ReqRawType}.Bytes(), encodeValue(s.Encoding, raw))
 	})
 	if err != nil {
 		return err
 	}
-	if s.OnUpdate != nil {
-		s.OnUpdate(id)
+	s.updates.notify(id)
+	return nil
+}
+
+// GetRawRequest fetches the bytes stored with SetRawRequest. If there are
+// none, badger.ErrKeyNotFound is returned.
+func (s *TxnStore) GetRawRequest(id uint64) (raw []byte, e error) {
+	err := s.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(Key{ID: id, Type: ReqRawType}.Bytes())
+		if err != nil {
+			return err
+		}
+		buf, err := item.ValueCopy(nil)
+		if err != nil {
+			return err
+		}
+		raw, err = decodeValue(buf)
+		return err
+	})
+	if err != nil {
+		return nil, err
 	}
+	return raw, nil
+}
+
+// SetRawResponse stores the exact bytes of the response as they were
+// received from the upstream server, in addition to the parsed response which
+// is normalized when it is written. It triggers an update event.
+func (s *TxnStore) SetRawResponse(id uint64, raw []byte) error {
+	err := s.Update(func(txn *badger.Txn) error {
+		return txn.Set(Key{ID: id, Type: ResRawType}.Bytes(), encodeValue(s.Encoding, raw))
+	})
+	if err != nil {
+		return err
+	}
+	s.updates.notify(id)
 	return nil
 }
 
+// GetRawResponse fetches the bytes stored with SetRawResponse. If there are
+// none, badger.ErrKeyNotFound is returned.
+func (s *TxnStore) GetRawResponse(id uint64) (raw []byte, e error) {
+	err := s.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(Key{ID: id, Type: ResRawType}.Bytes())
+		if err != nil {
+			return err
+		}
+		buf, err := item.ValueCopy(nil)
+		if err != nil {
+			return err
+		}
+		raw, err = decodeValue(buf)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return raw, nil
+}
+
+// GetFormattedBody fetches the indented copy of the request (typ is ReqType)
+// or response (ResType) body stored if Beautify is enabled. If no formatted
+// copy was stored, badger.ErrKeyNotFound is returned.
+func (s *TxnStore) GetFormattedBody(id uint64, typ KeyType, edited bool) (body []byte, e error) {
+	var fmtType KeyType
+	switch typ {
+	case ReqType:
+		fmtType = ReqFmtType
+	case ResType:
+		fmtType = ResFmtType
+	default:
+		return nil, fmt.Errorf("invalid key type for formatted body: %v", typ)
+	}
+
+	err := s.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(Key{ID: id, Type: fmtType, Edited: edited}.Bytes())
+		if err != nil {
+			return err
+		}
+		body, err = item.ValueCopy(nil)
+		if err != nil {
+			return err
+		}
+		body, err = decodeValue(body)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return body, nil
+}
+
 // GetRequest fetches the original or edited request with the specified ID from the store.
 func (s *TxnStore) GetRequest(id uint64, edited bool) (request *http.Request, e error) {
 	err := s.View(func(txn *badger.Txn) error {
@@ -122,6 +580,7 @@ func (s *TxnStore) GetRequest(id uint64, edited bool) (request *http.Request, e
 }
 
 // GetResponse fetches the original or edited response with the specified ID from the store.
+// The body of the returned response contains the stored body.
 func (s *TxnStore) GetResponse(id uint64, edited bool) (response *http.Response, e error) {
 	err := s.View(func(txn *badger.Txn) error {
 		item, err := txn.Get(Key{ID: id, Type: ResType, Edited: edited}.Bytes())
@@ -180,6 +639,49 @@ func (s *TxnStore) GetSummary(id uint64) (*TxnSummary, error) {
 		return nil, err
 	}
 
+	err = s.View(func(txn *badger.Txn) error {
+		for _, edited := range []bool{false, true} {
+			err := setSize(txn, Key{ID: id, Type: ReqSizeType, Edited: edited}, &summary.ReqSize)
+			if err != nil {
+				return err
+			}
+			err = setSize(txn, Key{ID: id, Type: ResSizeType, Edited: edited}, &summary.ResSize)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := s.GetConnInfo(id)
+	if err == nil {
+		summary.Conn = conn
+	} else if err != badger.ErrKeyNotFound {
+		return nil, err
+	}
+
+	_, err = s.GetNote(id)
+	if err == nil {
+		summary.HasNote = true
+	} else if err != badger.ErrKeyNotFound {
+		return nil, err
+	}
+
+	_, err = s.GetShadowResult(id)
+	if err == nil {
+		summary.HasShadow = true
+	} else if err != badger.ErrKeyNotFound {
+		return nil, err
+	}
+
+	summary.Error, err = s.GetError(id)
+	if err != nil && err != badger.ErrKeyNotFound {
+		return nil, err
+	}
+
 	return summary, nil
 }
 
@@ -210,6 +712,24 @@ func (s *TxnStore) GetTxn(id uint64) (*Txn, error) {
 	}, nil
 }
 
+// NextID returns a new ID for a transaction, which has not been returned
+// before and is higher than all IDs stored. The highest ID is read from the
+// store once, later IDs are counted up from it.
+func (s *TxnStore) NextID() (uint64, error) {
+	s.idMu.Lock()
+	defer s.idMu.Unlock()
+
+	if s.lastID == 0 {
+		max, err := s.MaxID()
+		if err != nil {
+			return 0, err
+		}
+		s.lastID = max
+	}
+	s.lastID++
+	return s.lastID, nil
+}
+
 // MaxID returns the highest ID stored.
 func (s *TxnStore) MaxID() (max uint64, e error) {
 	err := s.View(func(txn *badger.Txn) error {
@@ -235,9 +755,12 @@ func (s *TxnStore) MaxID() (max uint64, e error) {
 	return max, nil
 }
 
-// TxnSummaries returns TxnSummaries for all items in the databse.
+// TxnSummaries returns TxnSummaries for all items in the databse. Like
+// GetSummary, it skips IDs for which no request is stored, e.g. when only a
+// note or an error was written.
 func (s *TxnStore) TxnSummaries() ([]*TxnSummary, error) {
 	summaryMap := make(map[uint64]*TxnSummary)
+	hasRequest := make(map[uint64]bool)
 
 	err := s.View(func(txn *badger.Txn) error {
 		opts := badger.DefaultIteratorOptions
@@ -267,6 +790,8 @@ func (s *TxnStore) TxnSummaries() ([]*TxnSummary, error) {
 
 				if key.Edited {
 					summary.ReqEdited = true
+				} else {
+					hasRequest[key.ID] = true
 				}
 				// only update summary if the fields were not overwritten
 				// by the edited request in reqe
@@ -294,6 +819,36 @@ func (s *TxnStore) TxnSummaries() ([]*TxnSummary, error) {
 				if key.Edited || summary.StatusCode == 0 {
 					summary.StatusCode = res.StatusCode
 				}
+			case ConnType: // client connection
+				conn, err := parseConnInfo(item)
+				if err != nil {
+					return err
+				}
+				summary.Conn = conn
+			case NoteType: // note
+				summary.HasNote = true
+			case ShadowType: // comparison with a shadow upstream
+				summary.HasShadow = true
+			case ErrType: // forwarding the request failed
+				buf, err := item.ValueCopy(nil)
+				if err != nil {
+					return err
+				}
+				summary.Error = string(buf)
+			case ReqSizeType, ResSizeType: // size of the raw request or response
+				size, err := parseSize(item)
+				if err != nil {
+					return err
+				}
+
+				field := &summary.ReqSize
+				if key.Type == ResSizeType {
+					field = &summary.ResSize
+				}
+				// the size of the edited version takes precedence
+				if key.Edited || *field == 0 {
+					*field = size
+				}
 			}
 		}
 		return nil
@@ -304,6 +859,9 @@ func (s *TxnStore) TxnSummaries() ([]*TxnSummary, error) {
 
 	summaries := make([]*TxnSummary, 0, len(summaryMap))
 	for k := range summaryMap {
+		if !hasRequest[k] {
+			continue
+		}
 		summaries = append(summaries, summaryMap[k])
 	}
 
@@ -7,6 +7,8 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"path/filepath"
+	"reflect"
 	"testing"
 
 	"github.com/dgraph-io/badger"
@@ -47,6 +49,17 @@ var testCases = []struct {
 	{body: "seventh", editedReq: true, editedRes: true, hasRes: true},
 }
 
+func wantBody(t testing.TB, res *http.Response, body string) {
+	buf, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(buf) != body {
+		t.Errorf("unexpected body: want %q, got %q", body, buf)
+	}
+}
+
 func TestStore(t *testing.T) {
 	dir, err := ioutil.TempDir(os.TempDir(), "osmosis.testing.store.")
 	if err != nil {
@@ -162,19 +175,20 @@ func TestStore(t *testing.T) {
 	})
 
 	t.Run("GetResponse", func(t *testing.T) {
-		// TODO: check response body
 		for i, tc := range testCases {
 			if tc.hasRes {
-				_, err := store.GetResponse(uint64(i), false)
+				res, err := store.GetResponse(uint64(i), false)
 				if err != nil {
 					t.Fatalf("could not get response (id=%d): %s", i, err)
 				}
+				wantBody(t, res, tc.body)
 
 				if tc.editedRes {
-					_, err := store.GetResponse(uint64(i), true)
+					res, err := store.GetResponse(uint64(i), true)
 					if err != nil {
 						t.Fatalf("could not get edited response (id=%d): %s", i, err)
 					}
+					wantBody(t, res, tc.body)
 				}
 
 			}
@@ -238,6 +252,9 @@ func TestStore(t *testing.T) {
 				t.Fatalf("txn%d has response is %t (should be %t)",
 					i, (txn.Res != nil), testCases[i].hasRes)
 			}
+			if txn.Res != nil {
+				wantBody(t, txn.Res, tc.body)
+			}
 		}
 	})
 
@@ -248,3 +265,829 @@ func TestStore(t *testing.T) {
 		}
 	})
 }
+
+func TestStoreBackupRestore(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "osmosis.testing.store.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	request, err := http.ReadRequest(bufio.NewReader(bytes.NewReader([]byte(req))))
+	if err != nil {
+		t.Fatalf("could not setup test request: %s", err)
+	}
+	response, err := http.ReadResponse(bufio.NewReader(bytes.NewReader([]byte(res))), nil)
+	if err != nil {
+		t.Fatalf("could not setup test response: %s", err)
+	}
+
+	src, err := New(filepath.Join(dir, "src"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+
+	for i, tc := range testCases {
+		err = src.AddRequest(uint64(i), request, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tc.editedReq {
+			err = src.AddRequest(uint64(i), request, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		if tc.hasRes {
+			err = src.AddResponse(uint64(i), response, []byte(tc.body), false)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		if tc.editedRes {
+			err = src.AddResponse(uint64(i), response, []byte(tc.body), true)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	err = src.Backup(&buf)
+	if err != nil {
+		t.Fatalf("Backup failed: %v", err)
+	}
+
+	dst, err := New(filepath.Join(dir, "dst"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dst.Close()
+
+	err = dst.Restore(&buf)
+	if err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+
+	want, err := src.TxnSummaries()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := dst.TxnSummaries()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(want, got) {
+		t.Fatalf("restored summaries differ:\nwant %v\ngot  %v", want, got)
+	}
+}
+
+func TestStoreConnInfo(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "osmosis.testing.store.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	request, err := http.ReadRequest(bufio.NewReader(bytes.NewReader([]byte(req))))
+	if err != nil {
+		t.Fatalf("could not setup test request: %s", err)
+	}
+
+	err = store.AddRequest(1, request, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info := ConnInfo{
+		Proto:              "HTTP/2.0",
+		ViaCONNECT:         true,
+		TLS:                true,
+		TLSVersion:         0x0304,
+		ServerName:         "golang.org",
+		NegotiatedProtocol: "h2",
+	}
+
+	err = store.AddConnInfo(1, info)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	summary, err := store.GetSummary(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if summary.Conn == nil || *summary.Conn != info {
+		t.Errorf("wrong connection info in summary, want %+v, got %+v", info, summary.Conn)
+	}
+
+	summaries, err := store.TxnSummaries()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(summaries) != 1 {
+		t.Fatalf("wrong number of summaries, want 1, got %d", len(summaries))
+	}
+
+	if summaries[0].Conn == nil || *summaries[0].Conn != info {
+		t.Errorf("wrong connection info in summaries, want %+v, got %+v", info, summaries[0].Conn)
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	var tests = []struct {
+		addr string
+		want string
+	}{
+		{"192.0.2.1:1234", "192.0.2.1"},
+		{"[2001:db8::1]:443", "2001:db8::1"},
+		{"[2001:db8:0::1]:443", "2001:db8::1"},
+		{"192.0.2.1", "192.0.2.1"},
+		{"2001:db8::1", "2001:db8::1"},
+		{"localhost:80", ""},
+		{"", ""},
+		{"@", ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.addr, func(t *testing.T) {
+			got := ClientIP(test.addr)
+			if got != test.want {
+				t.Errorf("wrong client IP, want %q, got %q", test.want, got)
+			}
+		})
+	}
+}
+
+func TestStoreFilterClientIP(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "osmosis.testing.store.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	clients := []string{"192.0.2.1:40000", "[2001:db8::1]:40001", "192.0.2.1:40002", "@"}
+	for i, addr := range clients {
+		id := uint64(i + 1)
+		request, err := http.ReadRequest(bufio.NewReader(bytes.NewReader([]byte(req))))
+		if err != nil {
+			t.Fatalf("could not setup test request: %s", err)
+		}
+
+		err = store.AddRequest(id, request, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = store.AddConnInfo(id, ConnInfo{Proto: "HTTP/1.1", ClientIP: ClientIP(addr)})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	summaries, err := store.TxnSummaries()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"192.0.2.1", "2001:db8::1", "192.0.2.1", ""}
+	for i, summary := range summaries {
+		if summary.Conn == nil || summary.Conn.ClientIP != want[i] {
+			t.Errorf("summary %d: wrong client IP, want %q, got %+v", summary.ID, want[i], summary.Conn)
+		}
+	}
+
+	ids := func(list []*TxnSummary) (res []uint64) {
+		for _, summary := range list {
+			res = append(res, summary.ID)
+		}
+		return res
+	}
+
+	var filters = []struct {
+		ip   string
+		want []uint64
+	}{
+		{"192.0.2.1", []uint64{1, 3}},
+		{"2001:db8:0:0::1", []uint64{2}},
+		{"192.0.2.2", nil},
+		{"", nil},
+	}
+
+	for _, test := range filters {
+		got := ids(FilterClientIP(summaries, test.ip))
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("filter %q: want %v, got %v", test.ip, test.want, got)
+		}
+	}
+}
+
+func TestStoreNotes(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "osmosis.testing.store.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	request, err := http.ReadRequest(bufio.NewReader(bytes.NewReader([]byte(req))))
+	if err != nil {
+		t.Fatalf("could not setup test request: %s", err)
+	}
+
+	for id := uint64(1); id <= 2; id++ {
+		err = store.AddRequest(id, request, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, err = store.GetNote(1)
+	if err != badger.ErrKeyNotFound {
+		t.Fatalf("GetNote for transaction without note returned wrong error: %v", err)
+	}
+
+	err = store.SetNote(1, "possible IDOR here")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	note, err := store.GetNote(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if note != "possible IDOR here" {
+		t.Errorf("wrong note returned: %q", note)
+	}
+
+	// the request is not affected by the note
+	_, err = store.GetRequest(1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = store.GetRequest(1, true)
+	if err != badger.ErrKeyNotFound {
+		t.Errorf("note created an edited request: %v", err)
+	}
+
+	summaries, err := store.TxnSummaries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(summaries) != 2 {
+		t.Fatalf("wrong number of summaries, want 2, got %d", len(summaries))
+	}
+	if !summaries[0].HasNote || summaries[1].HasNote {
+		t.Errorf("wrong HasNote values: %v %v", summaries[0].HasNote, summaries[1].HasNote)
+	}
+
+	// an empty note removes it
+	err = store.SetNote(1, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	summary, err := store.GetSummary(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.HasNote {
+		t.Errorf("note was not removed")
+	}
+}
+
+func TestStoreErrors(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "osmosis.testing.store.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	request, err := http.ReadRequest(bufio.NewReader(bytes.NewReader([]byte(req))))
+	if err != nil {
+		t.Fatalf("could not setup test request: %s", err)
+	}
+
+	for id := uint64(1); id <= 2; id++ {
+		err = store.AddRequest(id, request, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, err = store.GetError(1)
+	if err != badger.ErrKeyNotFound {
+		t.Fatalf("GetError for transaction without error returned wrong error: %v", err)
+	}
+
+	err = store.SetError(1, "dial tcp: connection refused")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := store.GetError(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg != "dial tcp: connection refused" {
+		t.Errorf("wrong error returned: %q", msg)
+	}
+
+	summaries, err := store.TxnSummaries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(summaries) != 2 {
+		t.Fatalf("wrong number of summaries, want 2, got %d", len(summaries))
+	}
+	if summaries[0].Error != msg || summaries[1].Error != "" {
+		t.Errorf("wrong errors in summaries: %q %q", summaries[0].Error, summaries[1].Error)
+	}
+	if summaries[0].HasResponse {
+		t.Errorf("error created a response")
+	}
+
+	// an empty message removes it
+	err = store.SetError(1, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	summary, err := store.GetSummary(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Error != "" {
+		t.Errorf("error was not removed")
+	}
+}
+
+func TestStoreSummariesWithoutRequest(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "osmosis.testing.store.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	request, err := http.ReadRequest(bufio.NewReader(bytes.NewReader([]byte(req))))
+	if err != nil {
+		t.Fatalf("could not setup test request: %s", err)
+	}
+
+	err = store.AddRequest(1, request, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// only other data is stored for these IDs
+	err = store.SetNote(2, "note")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.AddConnInfo(3, ConnInfo{Proto: "HTTP/1.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.SetError(4, "dial tcp: connection refused")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	summaries, err := store.TxnSummaries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(summaries) != 1 || summaries[0].ID != 1 {
+		for _, summary := range summaries {
+			t.Logf("summary %+v", summary)
+		}
+		t.Fatalf("wrong summaries returned, want only ID 1, got %d", len(summaries))
+	}
+}
+
+func TestStoreShadowResult(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "osmosis.testing.store.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	request, err := http.ReadRequest(bufio.NewReader(bytes.NewReader([]byte(req))))
+	if err != nil {
+		t.Fatalf("could not setup test request: %s", err)
+	}
+
+	for id := uint64(1); id <= 2; id++ {
+		err = store.AddRequest(id, request, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, err = store.GetShadowResult(1)
+	if err != badger.ErrKeyNotFound {
+		t.Fatalf("GetShadowResult for transaction without result returned wrong error: %v", err)
+	}
+
+	want := ShadowResult{
+		Primary: []byte("HTTP/1.1 200 OK\r\n\r\nprimary"),
+		Shadow:  []byte("HTTP/1.1 404 Not Found\r\n\r\nshadow"),
+		Diff:    []string{"status: 200 != 404", "body: 7 bytes != 6 bytes"},
+	}
+	err = store.SetShadowResult(1, want)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := store.GetShadowResult(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(*result, want) {
+		t.Errorf("wrong result returned:\nwant %+v\ngot  %+v", want, *result)
+	}
+
+	summaries, err := store.TxnSummaries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(summaries) != 2 || !summaries[0].HasShadow || summaries[1].HasShadow {
+		t.Errorf("wrong summaries returned: %+v", summaries)
+	}
+
+	summary, err := store.GetSummary(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !summary.HasShadow {
+		t.Errorf("summary has no shadow result")
+	}
+}
+
+func TestStoreBeautify(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "osmosis.testing.store.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	store.Beautify = true
+
+	request, err := http.ReadRequest(bufio.NewReader(bytes.NewReader([]byte(req))))
+	if err != nil {
+		t.Fatalf("could not setup test request: %s", err)
+	}
+	err = store.AddRequest(1, request, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	raw := `{"user":"foo","roles":["admin"]}`
+	response := &http.Response{
+		StatusCode: http.StatusOK,
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+	}
+	err = store.AddResponse(1, response, []byte(raw), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := store.GetResponse(1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantBody(t, res, raw)
+
+	formatted, err := store.GetFormattedBody(1, ResType, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "{\n  \"user\": \"foo\",\n  \"roles\": [\n    \"admin\"\n  ]\n}"
+	if string(formatted) != want {
+		t.Errorf("wrong formatted body, want\n%s\ngot\n%s", want, formatted)
+	}
+
+	// the request has no JSON body, so no formatted variant is stored
+	_, err = store.GetFormattedBody(1, ReqType, false)
+	if err != badger.ErrKeyNotFound {
+		t.Errorf("unexpected error for request without formatted body: %v", err)
+	}
+
+	summaries, err := store.TxnSummaries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(summaries) != 1 || summaries[0].StatusCode != http.StatusOK {
+		t.Errorf("wrong summaries returned: %v", summaries)
+	}
+}
+
+func TestStoreUpdate(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "osmosis.testing.store.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	newResponse := func() *http.Response {
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			ProtoMajor: 1,
+			ProtoMinor: 1,
+			Header:     http.Header{"Content-Type": []string{"text/plain"}},
+		}
+	}
+
+	request, err := http.ReadRequest(bufio.NewReader(bytes.NewReader([]byte(req))))
+	if err != nil {
+		t.Fatalf("could not setup test request: %s", err)
+	}
+
+	// updating a transaction which does not exist fails
+	err = store.UpdateRequest(1, request)
+	if err != badger.ErrKeyNotFound {
+		t.Fatalf("UpdateRequest for missing transaction returned wrong error: %v", err)
+	}
+	err = store.UpdateResponse(1, newResponse(), []byte("x"))
+	if err != badger.ErrKeyNotFound {
+		t.Fatalf("UpdateResponse for missing transaction returned wrong error: %v", err)
+	}
+
+	err = store.AddRequest(1, request, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.AddResponse(1, newResponse(), []byte("original"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.AddResponse(1, newResponse(), []byte("edited"), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var updates int
+	store.SetOnUpdate(func(uint64) { updates++ })
+
+	request.Header.Set("User-Agent", "fixed")
+	err = store.UpdateRequest(1, request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.WaitUpdates()
+	err = store.UpdateResponse(1, newResponse(), []byte("fixed"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.WaitUpdates()
+
+	if updates != 2 {
+		t.Errorf("wrong number of OnUpdate calls, want 2, got %d", updates)
+	}
+
+	r, err := store.GetRequest(1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ua := r.Header.Get("User-Agent"); ua != "fixed" {
+		t.Errorf("request was not updated, User-Agent is %q", ua)
+	}
+
+	res, err := store.GetResponse(1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantBody(t, res, "fixed")
+
+	// the edited variant is untouched
+	res, err = store.GetResponse(1, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantBody(t, res, "edited")
+
+	_, err = store.GetRequest(1, true)
+	if err != badger.ErrKeyNotFound {
+		t.Errorf("update created an edited request: %v", err)
+	}
+}
+
+func TestStoreSizes(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "osmosis.testing.store.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	// sizes refer to the raw data, regardless of the encoding
+	store.Encoding = EncodingBase64
+
+	request, err := http.ReadRequest(bufio.NewReader(bytes.NewReader([]byte(req))))
+	if err != nil {
+		t.Fatalf("could not setup test request: %s", err)
+	}
+
+	var reqDump bytes.Buffer
+	err = request.WriteProxy(&reqDump)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// responseSize returns the size of the response as written to the store
+	responseSize := func(body string) int64 {
+		res := &http.Response{
+			StatusCode:    http.StatusOK,
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        http.Header{"Content-Type": []string{"text/plain"}},
+			ContentLength: int64(len(body)),
+			Body:          ioutil.NopCloser(bytes.NewReader([]byte(body))),
+		}
+		var buf bytes.Buffer
+		err := res.Write(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return int64(buf.Len())
+	}
+
+	addResponse := func(body string, edited bool) {
+		res := &http.Response{
+			StatusCode: http.StatusOK,
+			ProtoMajor: 1,
+			ProtoMinor: 1,
+			Header:     http.Header{"Content-Type": []string{"text/plain"}},
+		}
+		err := store.AddResponse(1, res, []byte(body), edited)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	wantSizes := func(reqSize, resSize int64) {
+		summary, err := store.GetSummary(1)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		summaries, err := store.TxnSummaries()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(summaries) != 1 {
+			t.Fatalf("wrong number of summaries: %v", len(summaries))
+		}
+
+		for _, s := range []*TxnSummary{summary, summaries[0]} {
+			if s.ReqSize != reqSize || s.ResSize != resSize {
+				t.Errorf("wrong sizes, want %d/%d, got %d/%d", reqSize, resSize, s.ReqSize, s.ResSize)
+			}
+		}
+	}
+
+	err = store.AddRequest(1, request, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantSizes(int64(reqDump.Len()), 0)
+
+	addResponse("original body", false)
+	wantSizes(int64(reqDump.Len()), responseSize("original body"))
+
+	// the edited response takes precedence
+	addResponse("a much longer edited body", true)
+	wantSizes(int64(reqDump.Len()), responseSize("a much longer edited body"))
+}
+
+func TestStoreRawRequest(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "osmosis.testing.store.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	store.Encoding = EncodingBase64
+
+	_, err = store.GetRawRequest(1)
+	if err != badger.ErrKeyNotFound {
+		t.Fatalf("GetRawRequest for transaction without raw request returned wrong error: %v", err)
+	}
+
+	raw := "GET /  HTTP/1.1\r\nhost:example.com\r\nX-Sig:  a\tb \r\n\r\n"
+	err = store.SetRawRequest(1, []byte(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf, err := store.GetRawRequest(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != raw {
+		t.Errorf("wrong raw request returned, want %q, got %q", raw, buf)
+	}
+
+	// the key is known when listing the transactions
+	_, err = store.TxnSummaries()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStoreNextID(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "osmosis.testing.store.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	request, err := http.ReadRequest(bufio.NewReader(bytes.NewReader([]byte(req))))
+	if err != nil {
+		t.Fatalf("could not setup test request: %s", err)
+	}
+	err = store.AddRequest(5, request, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// IDs are not reused even if nothing was stored under them
+	for _, want := range []uint64{6, 7} {
+		id, err := store.NextID()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if id != want {
+			t.Errorf("want ID %d, got %d", want, id)
+		}
+	}
+}
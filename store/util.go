@@ -3,17 +3,28 @@ package store
 import (
 	"bufio"
 	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
 	"net/http"
+	"strconv"
 
 	"github.com/dgraph-io/badger"
+	"github.com/fd0/osmosis/display"
 )
 
+// valueBufioReader returns a reader over a copy of the item's value, so that
+// it remains valid after the badger transaction is finished.
 func valueBufioReader(item *badger.Item) (*bufio.Reader, error) {
-	reqBytes, err := item.Value()
+	buf, err := item.ValueCopy(nil)
 	if err != nil {
 		return nil, err
 	}
-	return bufio.NewReader(bytes.NewReader(reqBytes)), nil
+	buf, err = decodeValue(buf)
+	if err != nil {
+		return nil, err
+	}
+	return bufio.NewReader(bytes.NewReader(buf)), nil
 }
 
 func parseRequest(item *badger.Item) (*http.Request, error) {
@@ -29,6 +40,93 @@ func parseResponse(item *badger.Item) (*http.Response, error) {
 	if err != nil {
 		return nil, err
 	}
-	// TODO: also add body
-	return http.ReadResponse(reader, nil)
+	res, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// read the body so that it is available to the caller
+	_, err = readBody(&res.Body)
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func parseConnInfo(item *badger.Item) (*ConnInfo, error) {
+	buf, err := item.ValueCopy(nil)
+	if err != nil {
+		return nil, err
+	}
+	var info ConnInfo
+	err = json.Unmarshal(buf, &info)
+	if err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+func parseSize(item *badger.Item) (int64, error) {
+	buf, err := item.ValueCopy(nil)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(string(buf), 10, 64)
+}
+
+// setSize sets *size to the size stored at key, if present.
+func setSize(txn *badger.Txn, key Key, size *int64) error {
+	item, err := txn.Get(key.Bytes())
+	if err == badger.ErrKeyNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	*size, err = parseSize(item)
+	return err
+}
+
+// readBody reads the body fully and replaces it with a NopCloser over the
+// same bytes.
+func readBody(body *io.ReadCloser) ([]byte, error) {
+	buf, err := ioutil.ReadAll(*body)
+	if err != nil {
+		return nil, err
+	}
+	err = (*body).Close()
+	if err != nil {
+		return nil, err
+	}
+	*body = ioutil.NopCloser(bytes.NewReader(buf))
+	return buf, nil
+}
+
+// formatBody returns a decompressed and indented copy of body, or nil if the
+// body is left unchanged by the transformation.
+func formatBody(header http.Header, body []byte) []byte {
+	formatted, _, err := display.Pipeline{
+		display.Decompress{Encoding: header.Get("Content-Encoding")},
+		display.Pretty{},
+	}.Transform(header.Get("Content-Type"), body)
+	if err != nil || bytes.Equal(formatted, body) {
+		return nil
+	}
+	return formatted
+}
+
+// formatRequest returns an indented copy of the body of the raw request, or
+// nil if it cannot be formatted.
+func formatRequest(rawRequest []byte) []byte {
+	req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(rawRequest)))
+	if err != nil {
+		return nil
+	}
+
+	body, err := readBody(&req.Body)
+	if err != nil {
+		return nil
+	}
+	return formatBody(req.Header, body)
 }
@@ -0,0 +1,326 @@
+package store
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+
+	"github.com/dgraph-io/badger"
+)
+
+// ErrWriterClosed is returned when requests or responses are added to a
+// Writer after Close was called.
+var ErrWriterClosed = errors.New("writer is closed")
+
+// maxBatch is the maximum number of writes a worker combines into a single
+// badger transaction.
+const maxBatch = 128
+
+// Writer adds requests and responses to a store asynchronously, so that the
+// caller does not have to wait for the database. Writes are queued and
+// stored by a fixed number of workers, which combine queued writes into a
+// single badger transaction. When the queue is full, adding blocks until a
+// worker is ready. All writes for the same transaction ID are handled by the
+// same worker and stored in the order they were added.
+//
+// Errors are collected and returned by Flush and Close.
+type Writer struct {
+	store  *TxnStore
+	queues []chan write
+	wg     sync.WaitGroup
+
+	// closed is protected by m, the lock is held for reading while writes
+	// are queued
+	m      sync.RWMutex
+	closed bool
+
+	errMu sync.Mutex
+	err   error
+}
+
+// write is a single request or response to be stored, or a flush marker.
+type write struct {
+	key      Key
+	fmtType  KeyType
+	sizeType KeyType
+	data     []byte
+	format   func() []byte
+	flushed  chan struct{}
+}
+
+// NewWriter starts a writer with the given number of workers which queue up
+// to queueSize writes each.
+func (s *TxnStore) NewWriter(workers, queueSize int) *Writer {
+	if workers < 1 {
+		workers = 1
+	}
+
+	w := &Writer{
+		store:  s,
+		queues: make([]chan write, workers),
+	}
+
+	for i := range w.queues {
+		w.queues[i] = make(chan write, queueSize)
+		w.wg.Add(1)
+		go w.run(w.queues[i])
+	}
+
+	return w
+}
+
+// AddRequest queues req to be stored like TxnStore.AddRequest. The request
+// is serialized before AddRequest returns, so it may be modified afterwards.
+func (w *Writer) AddRequest(id uint64, req *http.Request, edited bool) error {
+	data, err := dumpRequest(req)
+	if err != nil {
+		return err
+	}
+
+	var format func() []byte
+	if w.store.Beautify {
+		format = func() []byte { return formatRequest(data) }
+	}
+
+	return w.add(write{
+		key:      Key{ID: id, Type: ReqType, Edited: edited},
+		fmtType:  ReqFmtType,
+		sizeType: ReqSizeType,
+		data:     data,
+		format:   format,
+	})
+}
+
+// AddResponse queues res to be stored like TxnStore.AddResponse. The
+// response is serialized before AddResponse returns.
+func (w *Writer) AddResponse(id uint64, res *http.Response, body []byte, edited bool) error {
+	data, err := dumpResponse(res, body)
+	if err != nil {
+		return err
+	}
+
+	var format func() []byte
+	if w.store.Beautify {
+		header := res.Header.Clone()
+		format = func() []byte { return formatBody(header, body) }
+	}
+
+	return w.add(write{
+		key:      Key{ID: id, Type: ResType, Edited: edited},
+		fmtType:  ResFmtType,
+		sizeType: ResSizeType,
+		data:     data,
+		format:   format,
+	})
+}
+
+// add queues wr for the worker responsible for the transaction.
+func (w *Writer) add(wr write) error {
+	w.m.RLock()
+	defer w.m.RUnlock()
+
+	if w.closed {
+		return ErrWriterClosed
+	}
+
+	w.queues[wr.key.ID%uint64(len(w.queues))] <- wr
+	return nil
+}
+
+// Flush waits until all writes queued so far are stored. It returns the first
+// error which occurred since the last call to Flush.
+func (w *Writer) Flush() error {
+	w.m.RLock()
+	if !w.closed {
+		var done []chan struct{}
+		for _, queue := range w.queues {
+			ch := make(chan struct{})
+			queue <- write{flushed: ch}
+			done = append(done, ch)
+		}
+		w.m.RUnlock()
+
+		for _, ch := range done {
+			<-ch
+		}
+	} else {
+		w.m.RUnlock()
+	}
+
+	w.errMu.Lock()
+	defer w.errMu.Unlock()
+
+	err := w.err
+	w.err = nil
+	return err
+}
+
+// Close stores all queued writes and stops the workers. It returns the first
+// error which occurred since the last call to Flush. The store is not closed.
+func (w *Writer) Close() error {
+	w.m.Lock()
+	if !w.closed {
+		w.closed = true
+		for _, queue := range w.queues {
+			close(queue)
+		}
+	}
+	w.m.Unlock()
+
+	w.wg.Wait()
+	return w.Flush()
+}
+
+// setErr records err if no other error was recorded before.
+func (w *Writer) setErr(err error) {
+	w.errMu.Lock()
+	defer w.errMu.Unlock()
+
+	if w.err == nil {
+		w.err = err
+	}
+}
+
+// run stores the writes from queue until it is closed.
+func (w *Writer) run(queue chan write) {
+	defer w.wg.Done()
+
+	batch := make([]write, 0, maxBatch)
+	for wr := range queue {
+		batch = append(batch[:0], wr)
+
+		// collect the writes which are already queued
+	collect:
+		for len(batch) < maxBatch {
+			select {
+			case wr, ok := <-queue:
+				if !ok {
+					break collect
+				}
+				batch = append(batch, wr)
+			default:
+				break collect
+			}
+		}
+
+		w.store.writeBatch(batch, w.setErr)
+	}
+}
+
+// writeBatch stores the writes in as few badger transactions as possible
+// and triggers the update events, errors are passed to fail. Flush markers
+// are released when all writes before them are stored.
+func (s *TxnStore) writeBatch(batch []write, fail func(error)) {
+	txn := s.NewTransaction(true)
+	var pending []uint64
+	var flushed []chan struct{}
+
+	commit := func() {
+		err := txn.Commit(nil)
+		if err != nil {
+			fail(err)
+		} else {
+			for _, id := range pending {
+				s.updates.notify(id)
+			}
+		}
+		pending = pending[:0]
+
+		for _, ch := range flushed {
+			close(ch)
+		}
+		flushed = flushed[:0]
+	}
+
+	for _, wr := range batch {
+		if wr.flushed != nil {
+			flushed = append(flushed, wr.flushed)
+			continue
+		}
+
+		var formatted []byte
+		if wr.format != nil {
+			formatted = wr.format()
+		}
+
+		err := s.putTxn(txn, wr.key, wr.fmtType, wr.sizeType, wr.data, formatted, false)
+		if err == badger.ErrTxnTooBig {
+			// store the writes so far and retry in a new transaction
+			commit()
+			txn = s.NewTransaction(true)
+			err = s.putTxn(txn, wr.key, wr.fmtType, wr.sizeType, wr.data, formatted, false)
+		}
+		if err != nil {
+			fail(err)
+			continue
+		}
+		pending = append(pending, wr.key.ID)
+	}
+
+	commit()
+}
+
+// Writers keeps a Writer for each store it is used with, e.g. for the stores
+// of a Router. The writers are started on first use.
+type Writers struct {
+	// Workers and QueueSize are passed to TxnStore.NewWriter.
+	Workers, QueueSize int
+
+	m       sync.Mutex
+	writers map[*TxnStore]*Writer
+	closed  bool
+}
+
+// Get returns the writer for s. After Close, nil is returned.
+func (ws *Writers) Get(s *TxnStore) *Writer {
+	ws.m.Lock()
+	defer ws.m.Unlock()
+
+	if ws.closed {
+		return nil
+	}
+
+	if ws.writers == nil {
+		ws.writers = make(map[*TxnStore]*Writer)
+	}
+
+	w, ok := ws.writers[s]
+	if !ok {
+		w = s.NewWriter(ws.Workers, ws.QueueSize)
+		ws.writers[s] = w
+	}
+	return w
+}
+
+// Flush waits until all writes queued so far are stored, see Writer.Flush.
+// It returns the first error.
+func (ws *Writers) Flush() error {
+	ws.m.Lock()
+	defer ws.m.Unlock()
+
+	var firstErr error
+	for _, w := range ws.writers {
+		err := w.Flush()
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// Close stores all queued writes and stops the writers, see Writer.Close. The
+// stores are not closed. It returns the first error.
+func (ws *Writers) Close() error {
+	ws.m.Lock()
+	defer ws.m.Unlock()
+
+	ws.closed = true
+	var firstErr error
+	for _, w := range ws.writers {
+		err := w.Close()
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,189 @@
+package store
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+	"testing"
+)
+
+func TestWriter(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "osmosis.testing.store.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	var m sync.Mutex
+	updated := make(map[uint64]struct{})
+	store.SetOnUpdate(func(id uint64) {
+		m.Lock()
+		updated[id] = struct{}{}
+		m.Unlock()
+	})
+
+	const clients, txns = 8, 100
+
+	// use a small queue so that adding blocks during the burst
+	w := store.NewWriter(3, 4)
+
+	var wg sync.WaitGroup
+	for c := 0; c < clients; c++ {
+		wg.Add(1)
+		go func(c int) {
+			defer wg.Done()
+			for i := 0; i < txns; i++ {
+				id := uint64(c*txns + i + 1)
+
+				// every version overwrites the previous one, so only the
+				// last is found if the order is preserved
+				for version := 1; version <= 3; version++ {
+					req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("http://example.com/%d/v%d", id, version), nil)
+					if err != nil {
+						t.Error(err)
+						return
+					}
+					err = w.AddRequest(id, req, false)
+					if err != nil {
+						t.Error(err)
+						return
+					}
+				}
+
+				res := &http.Response{
+					StatusCode: testStatus(id),
+					ProtoMajor: 1,
+					ProtoMinor: 1,
+					Header:     http.Header{},
+				}
+				err := w.AddResponse(id, res, []byte("body"), false)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(c)
+	}
+	wg.Wait()
+
+	err = w.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	summaries, err := store.TxnSummaries()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(summaries) != clients*txns {
+		t.Fatalf("wrong number of transactions stored, want %d, got %d", clients*txns, len(summaries))
+	}
+
+	for _, summary := range summaries {
+		want := fmt.Sprintf("/%d/v3", summary.ID)
+		if summary.URL == nil || summary.URL.Path != want {
+			t.Errorf("transaction %d: wrong URL stored, want %v, got %v", summary.ID, want, summary.URL)
+		}
+		if summary.StatusCode != testStatus(summary.ID) {
+			t.Errorf("transaction %d: wrong status %v", summary.ID, summary.StatusCode)
+		}
+		if summary.ResSize == 0 {
+			t.Errorf("transaction %d: response size missing", summary.ID)
+		}
+	}
+
+	err = w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store.WaitUpdates()
+	m.Lock()
+	if len(updated) != clients*txns {
+		t.Errorf("wrong number of update events, want %d, got %d", clients*txns, len(updated))
+	}
+	m.Unlock()
+
+	req, err := http.NewRequest(http.MethodGet, "http://example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = w.AddRequest(1, req, false)
+	if err != ErrWriterClosed {
+		t.Errorf("adding to closed writer returned %v", err)
+	}
+}
+
+// testStatus returns a status code which is different for adjacent IDs.
+func testStatus(id uint64) int {
+	return 200 + int(id%7)
+}
+
+func TestWriters(t *testing.T) {
+	var stores []*TxnStore
+	for i := 0; i < 2; i++ {
+		dir, err := ioutil.TempDir(os.TempDir(), "osmosis.testing.store.")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		s, err := New(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer s.Close()
+		stores = append(stores, s)
+	}
+
+	ws := &Writers{Workers: 2, QueueSize: 4}
+	if ws.Get(stores[0]) != ws.Get(stores[0]) {
+		t.Errorf("different writers returned for the same store")
+	}
+	if ws.Get(stores[0]) == ws.Get(stores[1]) {
+		t.Errorf("same writer returned for different stores")
+	}
+
+	for i, s := range stores {
+		req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("http://example.com/%d", i), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = ws.Get(s).AddRequest(1, req, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := ws.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, s := range stores {
+		req, err := s.GetRequest(1, false)
+		if err != nil {
+			t.Fatalf("store %d: %v", i, err)
+		}
+		if want := fmt.Sprintf("/%d", i); req.URL.Path != want {
+			t.Errorf("store %d: wrong request stored, want %v, got %v", i, want, req.URL.Path)
+		}
+	}
+
+	err = ws.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w := ws.Get(stores[0]); w != nil {
+		t.Errorf("writer returned after Close")
+	}
+}
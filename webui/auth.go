@@ -0,0 +1,80 @@
+package webui
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// tokenCookie is the name of the cookie holding the access token after
+// logging in with ?token=.
+const tokenCookie = "osmosis-token"
+
+// sameOrigin returns false if the request was sent by a page of another site,
+// according to the Origin or Referer header. When served by the proxy, the
+// host of the web UI is "proxy". Requests without both headers (e.g. from
+// scripts) are allowed.
+func sameOrigin(req *http.Request) bool {
+	source := req.Header.Get("Origin")
+	if source == "" {
+		source = req.Header.Get("Referer")
+	}
+	if source == "" {
+		return true
+	}
+
+	u, err := url.Parse(source)
+	if err != nil {
+		return false
+	}
+	return (u.Scheme == "http" || u.Scheme == "https") && u.Host == req.Host
+}
+
+// validToken returns true if token matches the token of the handler.
+func (h *Handler) validToken(token string) bool {
+	return subtle.ConstantTimeCompare([]byte(token), []byte(h.Token)) == 1
+}
+
+// authorize checks the access token sent in the cookie or in the
+// Authorization header ("Bearer <token>") and responds with an error if it is
+// missing or wrong. A GET request for the index with ?token= sets the cookie
+// and redirects to the index.
+func (h *Handler) authorize(rw http.ResponseWriter, req *http.Request) bool {
+	if !sameOrigin(req) {
+		http.Error(rw, "cross-origin request rejected", http.StatusForbidden)
+		return false
+	}
+
+	if h.Token == "" {
+		return true
+	}
+
+	if token := req.URL.Query().Get("token"); token != "" && req.Method == http.MethodGet && strings.Trim(req.URL.Path, "/") == "" {
+		if !h.validToken(token) {
+			http.Error(rw, "invalid token", http.StatusForbidden)
+			return false
+		}
+		http.SetCookie(rw, &http.Cookie{
+			Name:     tokenCookie,
+			Value:    token,
+			Path:     "/",
+			HttpOnly: true,
+			SameSite: http.SameSiteStrictMode,
+		})
+		http.Redirect(rw, req, "/", http.StatusSeeOther)
+		return false
+	}
+
+	if auth := req.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		if h.validToken(strings.TrimPrefix(auth, "Bearer ")) {
+			return true
+		}
+	}
+	if cookie, err := req.Cookie(tokenCookie); err == nil && h.validToken(cookie.Value) {
+		return true
+	}
+
+	http.Error(rw, "access token required, open the URL with the token logged at startup", http.StatusUnauthorized)
+	return false
+}
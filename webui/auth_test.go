@@ -0,0 +1,137 @@
+package webui
+
+import (
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/fd0/osmosis/proxy"
+)
+
+func TestHandlerToken(t *testing.T) {
+	hooks := &testHooks{list: []proxy.HookInfo{
+		{Name: "record", Scope: "all requests", Enabled: true},
+	}}
+	srv := httptest.NewServer(&Handler{Hooks: hooks, Token: "secret"})
+	defer srv.Close()
+
+	do := func(client *http.Client, method, path string, header http.Header) int {
+		req, err := http.NewRequest(method, srv.URL+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for name, values := range header {
+			req.Header[name] = values
+		}
+		res, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = res.Body.Close()
+		return res.StatusCode
+	}
+
+	if code := do(http.DefaultClient, http.MethodGet, "/api/hooks", nil); code != http.StatusUnauthorized {
+		t.Errorf("request without token returned status %v", code)
+	}
+	if code := do(http.DefaultClient, http.MethodGet, "/?token=wrong", nil); code != http.StatusForbidden {
+		t.Errorf("login with wrong token returned status %v", code)
+	}
+
+	bearer := http.Header{"Authorization": []string{"Bearer secret"}}
+	if code := do(http.DefaultClient, http.MethodGet, "/api/hooks", bearer); code != http.StatusOK {
+		t.Errorf("request with bearer token returned status %v", code)
+	}
+
+	// logging in stores the token in a cookie
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	browser := &http.Client{Jar: jar}
+	if code := do(browser, http.MethodGet, "/?token=secret", nil); code != http.StatusOK {
+		t.Fatalf("login returned status %v", code)
+	}
+	if code := do(browser, http.MethodGet, "/api/hooks", nil); code != http.StatusOK {
+		t.Errorf("request with cookie returned status %v", code)
+	}
+
+	// requests from the web UI itself are allowed, from other sites not
+	sameSite := http.Header{"Origin": []string{srv.URL}}
+	if code := do(browser, http.MethodPost, "/api/hooks/record?enabled=false", sameSite); code != http.StatusOK {
+		t.Errorf("request from the web UI returned status %v", code)
+	}
+	for _, header := range []http.Header{
+		{"Origin": []string{"http://evil.example.com"}},
+		{"Origin": []string{"null"}},
+		{"Referer": []string{"http://evil.example.com/form.html"}},
+	} {
+		if code := do(browser, http.MethodPost, "/api/hooks/record?enabled=true", header); code != http.StatusForbidden {
+			t.Errorf("cross-site request with %v returned status %v", header, code)
+		}
+	}
+	if hooks.list[0].Enabled {
+		t.Errorf("cross-site request enabled the hook")
+	}
+}
+
+func TestHandlerCrossOriginWithoutToken(t *testing.T) {
+	srv := httptest.NewServer(&Handler{})
+	defer srv.Close()
+
+	req, err := http.NewRequest(http.MethodPost, srv.URL+"/api/compact", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Origin", "http://evil.example.com")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = res.Body.Close()
+	if res.StatusCode != http.StatusForbidden {
+		t.Errorf("cross-site request returned status %v", res.StatusCode)
+	}
+}
+
+func TestHandlerThroughProxy(t *testing.T) {
+	p, serve, shutdown := proxy.TestProxy(t, nil)
+	go serve()
+	defer shutdown()
+
+	p.AdminHandler = &Handler{Hooks: &testHooks{}, Token: "secret"}
+
+	proxyURL, err := url.Parse("http://" + p.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+
+	var tests = []struct {
+		origin string
+		status int
+	}{
+		{"http://proxy", http.StatusOK},
+		{"http://evil.example.com", http.StatusForbidden},
+	}
+
+	for _, test := range tests {
+		req, err := http.NewRequest(http.MethodGet, "http://proxy/api/hooks", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "Bearer secret")
+		req.Header.Set("Origin", test.origin)
+
+		res, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = res.Body.Close()
+		if res.StatusCode != test.status {
+			t.Errorf("origin %v: want status %v, got %v", test.origin, test.status, res.StatusCode)
+		}
+	}
+}
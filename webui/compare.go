@@ -0,0 +1,137 @@
+package webui
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/dgraph-io/badger"
+	"github.com/fd0/osmosis/proxy"
+)
+
+// Marks selects two transactions for comparison: the first call to Mark
+// remembers the transaction, the second one completes the pair and starts
+// over.
+type Marks struct {
+	m      sync.Mutex
+	first  uint64
+	marked bool
+}
+
+// Mark marks the transaction id. If another transaction was marked before,
+// both IDs are returned with complete set to true and the marks are cleared.
+// Marking the same transaction twice unmarks it.
+func (m *Marks) Mark(id uint64) (first, second uint64, complete bool) {
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	switch {
+	case !m.marked:
+		m.first, m.marked = id, true
+		return id, 0, false
+	case m.first == id:
+		m.first, m.marked = 0, false
+		return 0, 0, false
+	}
+
+	first = m.first
+	m.first, m.marked = 0, false
+	return first, id, true
+}
+
+// Marked returns the transaction marked first, if any.
+func (m *Marks) Marked() (id uint64, ok bool) {
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	return m.first, m.marked
+}
+
+// Comparison lists the differences between the requests and responses of two
+// transactions, the edited versions are preferred.
+type Comparison struct {
+	A        uint64   `json:"a"`
+	B        uint64   `json:"b"`
+	Request  []string `json:"request"`
+	Response []string `json:"response"`
+}
+
+// MarkResult is returned for marking a transaction. Comparison is set when
+// the second transaction was marked.
+type MarkResult struct {
+	Marked     uint64      `json:"marked,omitempty"`
+	Comparison *Comparison `json:"comparison,omitempty"`
+}
+
+// Compare returns the differences between the transactions a and b.
+func (h *Handler) Compare(a, b uint64) (*Comparison, error) {
+	txnA, err := h.Store.GetTxn(a)
+	if err != nil {
+		return nil, fmt.Errorf("loading transaction %d: %v", a, err)
+	}
+	txnB, err := h.Store.GetTxn(b)
+	if err != nil {
+		return nil, fmt.Errorf("loading transaction %d: %v", b, err)
+	}
+
+	cmp := &Comparison{A: a, B: b}
+
+	reqA, reqB := txnA.Req, txnB.Req
+	if txnA.ReqE != nil {
+		reqA = txnA.ReqE
+	}
+	if txnB.ReqE != nil {
+		reqB = txnB.ReqE
+	}
+	cmp.Request = proxy.DiffRequests(reqA, reqB)
+
+	resA, resB := txnA.Res, txnB.Res
+	if txnA.ResE != nil {
+		resA = txnA.ResE
+	}
+	if txnB.ResE != nil {
+		resB = txnB.ResE
+	}
+
+	switch {
+	case resA != nil && resB != nil:
+		cmp.Response = proxy.DiffResponses(resA, resB)
+	case resA != nil || resB != nil:
+		cmp.Response = []string{"only one of the transactions has a response"}
+	}
+
+	return cmp, nil
+}
+
+func (h *Handler) mark(rw http.ResponseWriter, rawID string) {
+	id, err := strconv.ParseUint(rawID, 10, 64)
+	if err != nil {
+		http.Error(rw, "invalid ID", http.StatusBadRequest)
+		return
+	}
+
+	_, err = h.Store.GetRequest(id, false)
+	if err == badger.ErrKeyNotFound {
+		http.Error(rw, "not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	first, second, complete := h.marks.Mark(id)
+	if !complete {
+		writeJSON(rw, MarkResult{Marked: first})
+		return
+	}
+
+	cmp, err := h.Compare(first, second)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(rw, MarkResult{Comparison: cmp})
+}
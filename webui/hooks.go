@@ -0,0 +1,43 @@
+package webui
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/fd0/osmosis/proxy"
+)
+
+// HookManager lists the named hooks of a proxy and enables or disables them.
+type HookManager interface {
+	Hooks() []proxy.HookInfo
+	SetHookEnabled(name string, enabled bool) error
+}
+
+func (h *Handler) listHooks(rw http.ResponseWriter) {
+	if h.Hooks == nil {
+		writeJSON(rw, []proxy.HookInfo{})
+		return
+	}
+	writeJSON(rw, h.Hooks.Hooks())
+}
+
+func (h *Handler) toggleHook(rw http.ResponseWriter, req *http.Request, name string) {
+	if h.Hooks == nil {
+		http.Error(rw, "not found", http.StatusNotFound)
+		return
+	}
+
+	enabled, err := strconv.ParseBool(req.URL.Query().Get("enabled"))
+	if err != nil {
+		http.Error(rw, "invalid value for enabled", http.StatusBadRequest)
+		return
+	}
+
+	err = h.Hooks.SetHookEnabled(name, enabled)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	h.listHooks(rw)
+}
@@ -0,0 +1,265 @@
+package webui
+
+// indexHTML is the user interface, it uses the API to list the transactions,
+// show the details, resend requests, toggle hooks and follow websockets.
+const indexHTML = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>osmosis</title>
+<style>
+body { font-family: sans-serif; margin: 0; display: flex; height: 100vh; }
+#list { width: 40%; overflow: auto; border-right: 1px solid #ccc; }
+#detail { flex: 1; overflow: auto; padding: 0 1em; }
+table { border-collapse: collapse; width: 100%; font-size: 90%; }
+td, th { padding: 2px 6px; text-align: left; white-space: nowrap; }
+tr.txn:hover, tr.selected { background: #def; cursor: pointer; }
+.expired { color: #c00; font-weight: bold; }
+.valid { color: #080; }
+.error { color: #c00; }
+pre { background: #f6f6f6; padding: 0.5em; white-space: pre-wrap; word-break: break-all; }
+</style>
+</head>
+<body>
+<div id="list">
+<p><input id="client" placeholder="Client IP" onchange="load()"></p>
+<table>
+<thead><tr><th>ID</th><th>Client</th><th>Method</th><th>Status</th><th>Req</th><th>Res</th><th>URL</th></tr></thead>
+<tbody id="txns"></tbody>
+<tfoot><tr><th colspan="4">Total</th><th id="req-total"></th><th id="res-total"></th><th></th></tr></tfoot>
+</table>
+</div>
+<div id="detail"><p>Select a transaction, <a href="#" onclick="hooks(); return false">manage hooks</a>,
+<a href="#" onclick="websockets(); return false">follow a websocket</a>
+or <a href="#" onclick="compact(); return false">compact the store</a>.</p></div>
+<script>
+function text(s) {
+	var el = document.createElement("div");
+	el.textContent = s;
+	return el.innerHTML;
+}
+
+function section(title, msg) {
+	if (!msg) {
+		return "<h3>" + title + "</h3><p>none</p>";
+	}
+	var html = "<h3>" + title + "</h3><pre>" + text(msg.raw) + "</pre>";
+	if (msg.formatted) {
+		html += "<details open><summary>formatted body</summary><pre>" + text(msg.formatted) + "</pre></details>";
+	}
+	(msg.jwts || []).forEach(function(jwt) {
+		html += "<details><summary>JWT " + text(jwt.token.substring(0, 24)) + "&hellip; " + expiry(jwt) + "</summary><pre>" +
+			text(JSON.stringify(jwt.header, null, 2)) + "\n" + text(JSON.stringify(jwt.payload, null, 2)) + "</pre></details>";
+	});
+	return html;
+}
+
+function expiry(jwt) {
+	if (!jwt.expires) {
+		return "(no expiry)";
+	}
+	var exp = new Date(jwt.expires);
+	if (exp <= new Date()) {
+		return "<span class=\"expired\">expired " + text(exp.toLocaleString()) + "</span>";
+	}
+	return "<span class=\"valid\">expires " + text(exp.toLocaleString()) + "</span>";
+}
+
+function show(id) {
+	fetch("api/txns/" + id).then(function(res) { return res.json(); }).then(function(txn) {
+		var html = "<h2>Transaction " + txn.id + "</h2>";
+		html += "<button onclick=\"resend(" + txn.id + ")\">Resend</button> ";
+		html += "<button onclick=\"mark(" + txn.id + ")\">Mark for comparison</button>";
+		if (txn.note) {
+			html += "<p>Note: " + text(txn.note) + "</p>";
+		}
+		if (txn.error) {
+			html += "<p class=\"error\">Forwarding failed: " + text(txn.error) + "</p>";
+		}
+		html += section("Request", txn.request) + section("Response", txn.response);
+		if (txn.shadow) {
+			if (txn.shadow.error) {
+				html += "<h3>Shadow</h3><p class=\"error\">Not compared: " + text(txn.shadow.error) + "</p>";
+			} else {
+				html += differences("Shadow differences", txn.shadow.diff);
+			}
+			if (txn.shadow.response) {
+				html += section("Shadow response", txn.shadow.response);
+			}
+		}
+		document.getElementById("detail").innerHTML = html;
+	});
+}
+
+function resend(id) {
+	fetch("api/txns/" + id + "/resend", {method: "POST"}).then(function(res) {
+		return res.json();
+	}).then(function(txn) {
+		load();
+		show(txn.id);
+	});
+}
+
+function status(txn) {
+	if (txn.error && !txn.status) {
+		return "<td class=\"error\">error</td>";
+	}
+	return "<td>" + (txn.status || "") + "</td>";
+}
+
+function size(n) {
+	if (!n) {
+		return "";
+	}
+	var units = ["B", "KiB", "MiB", "GiB"];
+	var i = 0;
+	while (n >= 1024 && i < units.length - 1) {
+		n /= 1024;
+		i++;
+	}
+	return (i == 0 ? n : n.toFixed(1)) + " " + units[i];
+}
+
+function differences(title, diff) {
+	if (!diff || diff.length == 0) {
+		return "<h3>" + title + "</h3><p>identical</p>";
+	}
+	return "<h3>" + title + "</h3><pre>" + text(diff.join("\n")) + "</pre>";
+}
+
+function mark(id) {
+	fetch("api/txns/" + id + "/mark", {method: "POST"}).then(function(res) {
+		return res.json();
+	}).then(function(result) {
+		var cmp = result.comparison;
+		if (!cmp) {
+			document.title = result.marked ? "osmosis (marked " + result.marked + ")" : "osmosis";
+			return;
+		}
+		document.title = "osmosis";
+		var html = "<h2>Transaction " + cmp.a + " vs. " + cmp.b + "</h2>";
+		html += differences("Request", cmp.request) + differences("Response", cmp.response);
+		document.getElementById("detail").innerHTML = html;
+	});
+}
+
+function showHooks(list) {
+	var html = "<h2>Hooks</h2><table><thead><tr><th>Enabled</th><th>Name</th><th>Scope</th></tr></thead><tbody>";
+	list.forEach(function(hook) {
+		html += "<tr><td><input type=\"checkbox\"" + (hook.enabled ? " checked" : "") +
+			" onchange=\"toggleHook('" + encodeURIComponent(hook.name) + "', this.checked)\"></td>" +
+			"<td>" + text(hook.name) + "</td><td>" + text(hook.scope) + "</td></tr>";
+	});
+	html += "</tbody></table>";
+	if (list.length == 0) {
+		html += "<p>No named hooks registered.</p>";
+	}
+	document.getElementById("detail").innerHTML = html;
+}
+
+function hooks() {
+	fetch("api/hooks").then(function(res) { return res.json(); }).then(showHooks);
+}
+
+function toggleHook(name, enabled) {
+	fetch("api/hooks/" + name + "?enabled=" + enabled, {method: "POST"}).then(function(res) {
+		return res.json();
+	}).then(showHooks);
+}
+
+function compact() {
+	fetch("api/compact", {method: "POST"}).then(function(res) {
+		if (!res.ok) {
+			return res.text().then(function(msg) { throw new Error(msg); });
+		}
+		return res.json();
+	}).then(function(result) {
+		document.getElementById("detail").innerHTML = "<h2>Compact</h2><p>Rewrote " +
+			result.rewritten + " value log files.</p>";
+	}, function(err) {
+		document.getElementById("detail").innerHTML = "<h2>Compact</h2><p>" + text(err.message) + "</p>";
+	});
+}
+
+var following = null;
+
+function websockets() {
+	fetch("api/websockets").then(function(res) { return res.json(); }).then(function(list) {
+		var html = "<h2>Websockets</h2><table><thead><tr><th>ID</th><th>Started</th><th>URL</th></tr></thead><tbody>";
+		list.forEach(function(conn) {
+			html += "<tr class=\"txn\" onclick=\"follow(" + conn.id + ")\"><td>" + conn.id + "</td><td>" +
+				text(new Date(conn.started).toLocaleTimeString()) + "</td><td>" + text(conn.url) + "</td></tr>";
+		});
+		html += "</tbody></table>";
+		if (list.length == 0) {
+			html += "<p>No active websocket connections.</p>";
+		}
+		document.getElementById("detail").innerHTML = html;
+	});
+}
+
+function follow(id) {
+	if (following) {
+		following.close();
+	}
+	document.getElementById("detail").innerHTML = "<h2>Websocket " + id + " <span id=\"ws-state\">(live)</span></h2>" +
+		"<table><thead><tr><th>Time</th><th>Direction</th><th>Type</th><th>Length</th><th>Payload</th></tr></thead>" +
+		"<tbody id=\"ws-messages\"></tbody></table>";
+
+	var source = new EventSource("api/websockets/" + id);
+	var stop = function() {
+		source.close();
+		if (following == source) {
+			following = null;
+			var state = document.getElementById("ws-state");
+			if (state) {
+				state.textContent = "(closed)";
+			}
+		}
+	};
+	source.onmessage = function(ev) {
+		var tbody = document.getElementById("ws-messages");
+		var msg = JSON.parse(ev.data);
+		if (msg.closed || !tbody) {
+			stop();
+			return;
+		}
+		var row = document.createElement("tr");
+		row.innerHTML = "<td>" + text(new Date(msg.time).toLocaleTimeString()) + "</td><td>" + text(msg.direction) +
+			"</td><td>" + text(msg.type) + "</td><td>" + msg.length + "</td><td>" + text(msg.payload || "") + "</td>";
+		tbody.appendChild(row);
+		row.scrollIntoView();
+	};
+	source.onerror = stop;
+	following = source;
+}
+
+function load() {
+	var url = "api/txns";
+	var client = document.getElementById("client").value.trim();
+	if (client) {
+		url += "?client=" + encodeURIComponent(client);
+	}
+	fetch(url).then(function(res) { return res.json(); }).then(function(txns) {
+		var rows = "";
+		var reqTotal = 0, resTotal = 0;
+		txns.forEach(function(txn) {
+			rows += "<tr class=\"txn\" onclick=\"show(" + txn.id + ")\"><td>" + txn.id +
+				"</td><td>" + text(txn.client_ip || "") +
+				"</td><td>" + text(txn.method) + "</td>" + status(txn) +
+				"<td>" + size(txn.req_size) + "</td><td>" + size(txn.res_size) +
+				"</td><td>" + text(txn.url) + "</td></tr>";
+			reqTotal += txn.req_size || 0;
+			resTotal += txn.res_size || 0;
+		});
+		document.getElementById("txns").innerHTML = rows;
+		document.getElementById("req-total").textContent = size(reqTotal);
+		document.getElementById("res-total").textContent = size(resTotal);
+	});
+}
+
+load();
+</script>
+</body>
+</html>
+`
@@ -0,0 +1,67 @@
+package webui
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/fd0/osmosis/proxy"
+)
+
+func (h *Handler) listWebsockets(rw http.ResponseWriter) {
+	if h.Websockets == nil {
+		writeJSON(rw, []proxy.WebsocketConn{})
+		return
+	}
+	writeJSON(rw, h.Websockets.Connections())
+}
+
+// followWebsocket streams the messages of a websocket connection as server-sent
+// events until the connection or the request is closed.
+func (h *Handler) followWebsocket(rw http.ResponseWriter, req *http.Request, rawID string) {
+	id, err := strconv.ParseUint(rawID, 10, 64)
+	if err != nil || h.Websockets == nil {
+		http.Error(rw, "not found", http.StatusNotFound)
+		return
+	}
+
+	messages, cancel, err := h.Websockets.Subscribe(id)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusNotFound)
+		return
+	}
+	defer cancel()
+
+	flusher, _ := rw.(http.Flusher)
+
+	rw.Header().Set("Content-Type", "text/event-stream")
+	rw.Header().Set("Cache-Control", "no-cache")
+	rw.WriteHeader(http.StatusOK)
+	if flusher != nil {
+		flusher.Flush()
+	}
+
+	for {
+		select {
+		case msg, ok := <-messages:
+			if !ok {
+				return
+			}
+
+			buf, err := json.Marshal(msg)
+			if err != nil {
+				return
+			}
+			_, err = fmt.Fprintf(rw, "data: %s\n\n", buf)
+			if err != nil {
+				return
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		case <-req.Context().Done():
+			return
+		}
+	}
+}
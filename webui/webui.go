@@ -0,0 +1,411 @@
+// Package webui implements a minimal browser interface for the transactions
+// in a store, backed by a small JSON API.
+package webui
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httputil"
+	"strconv"
+	"strings"
+
+	"github.com/dgraph-io/badger"
+	"github.com/fd0/osmosis/display"
+	"github.com/fd0/osmosis/proxy"
+	"github.com/fd0/osmosis/redact"
+	"github.com/fd0/osmosis/replay"
+	"github.com/fd0/osmosis/store"
+)
+
+// Handler serves the web UI and the API, access requires the token (see
+// Handler.Token):
+//
+//	GET  /                       the user interface, with ?token=<token>
+//	                             the token is stored in a cookie
+//	GET  /api/txns               summaries of all transactions, with
+//	                             ?client=<ip> only those sent by the client
+//	GET  /api/txns/<id>          request and response of a transaction, and
+//	                             the comparison with a shadow upstream
+//	POST /api/txns/<id>/resend   send the request again, with ?diff=1 the new
+//	                             response is compared to the stored one, with
+//	                             ?env=<name> the variables of the environment
+//	                             are applied
+//	POST /api/txns/<id>/mark     mark the transaction, the second one marked
+//	                             is compared to the first
+//	GET  /api/hooks              the named hooks of the proxy
+//	POST /api/hooks/<name>       enable or disable a hook with ?enabled=true
+//	                             or ?enabled=false
+//	GET  /api/websockets         the active websocket connections
+//	GET  /api/websockets/<id>    the messages of a websocket connection as
+//	                             server-sent events
+//	POST /api/compact            reclaim the disk space of deleted and
+//	                             overwritten transactions
+type Handler struct {
+	Store    *store.TxnStore
+	Replayer *replay.Replayer
+
+	// Redactor, if set, masks secrets in the requests and responses shown.
+	Redactor *redact.Redactor
+
+	// Hooks, if set, allows enabling and disabling the hooks of the proxy.
+	Hooks HookManager
+
+	// Websockets, if set, allows following the messages of websocket
+	// connections live.
+	Websockets *proxy.WebsocketFeed
+
+	// Environments can be selected by name when resending requests.
+	Environments map[string]replay.Environment
+
+	// Token is required to access the web UI and the API, either from the
+	// cookie set by opening /?token=<token> or in the header
+	// "Authorization: Bearer <token>". If it is empty, no token is needed.
+	// Requests from pages of other sites are always rejected.
+	Token string
+
+	marks Marks
+}
+
+// New returns a new handler for the transactions in s, requests are resent
+// with r.
+func New(s *store.TxnStore, r *replay.Replayer) *Handler {
+	return &Handler{
+		Store:    s,
+		Replayer: r,
+	}
+}
+
+// Message is the detail view of a request or response.
+type Message struct {
+	// Raw contains the header and the body converted to UTF-8.
+	Raw string `json:"raw"`
+
+	// Formatted contains the body decompressed, converted to UTF-8, redacted
+	// and with JSON and XML indented, if any of this changed it.
+	Formatted string `json:"formatted,omitempty"`
+
+	// JWTs contains the decoded JSON Web Tokens found in the headers or the
+	// body. Tokens masked by the redactor are not decoded.
+	JWTs []display.JWT `json:"jwts,omitempty"`
+}
+
+// TxnDetail is returned for a single transaction.
+type TxnDetail struct {
+	ID       uint64   `json:"id"`
+	Note     string   `json:"note,omitempty"`
+	Error    string   `json:"error,omitempty"`
+	Request  *Message `json:"request"`
+	Response *Message `json:"response,omitempty"`
+
+	// Shadow is set if the response was compared with a shadow upstream.
+	Shadow *ShadowDetail `json:"shadow,omitempty"`
+}
+
+// ShadowDetail is the comparison with the response of a shadow upstream.
+type ShadowDetail struct {
+	Response *Message `json:"response,omitempty"`
+	Error    string   `json:"error,omitempty"`
+	Diff     []string `json:"diff,omitempty"`
+}
+
+// TxnInfo is the summary of a transaction in the list.
+type TxnInfo struct {
+	ID         uint64 `json:"id"`
+	Method     string `json:"method"`
+	URL        string `json:"url"`
+	StatusCode int    `json:"status,omitempty"`
+	Edited     bool   `json:"edited"`
+	HasNote    bool   `json:"note"`
+	Error      string `json:"error,omitempty"`
+	ReqSize    int64  `json:"req_size,omitempty"`
+	ResSize    int64  `json:"res_size,omitempty"`
+	ClientIP   string `json:"client_ip,omitempty"`
+}
+
+// ResendResult is returned for a resent transaction.
+type ResendResult struct {
+	TxnInfo
+
+	// Diff lists the changes to the originally stored response, it is only
+	// filled when requested.
+	Diff []string `json:"diff,omitempty"`
+}
+
+func (h *Handler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	if !h.authorize(rw, req) {
+		return
+	}
+
+	path := strings.Trim(req.URL.Path, "/")
+	parts := strings.Split(path, "/")
+
+	switch {
+	case path == "" && req.Method == http.MethodGet:
+		rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_, _ = rw.Write([]byte(indexHTML))
+	case path == "api/txns" && req.Method == http.MethodGet:
+		h.list(rw, req.URL.Query().Get("client"))
+	case len(parts) == 3 && parts[0] == "api" && parts[1] == "txns" && req.Method == http.MethodGet:
+		h.detail(rw, parts[2])
+	case len(parts) == 4 && parts[0] == "api" && parts[1] == "txns" && parts[3] == "resend" && req.Method == http.MethodPost:
+		h.resend(rw, parts[2], req.URL.Query().Get("diff") != "", req.URL.Query().Get("env"))
+	case len(parts) == 4 && parts[0] == "api" && parts[1] == "txns" && parts[3] == "mark" && req.Method == http.MethodPost:
+		h.mark(rw, parts[2])
+	case path == "api/hooks" && req.Method == http.MethodGet:
+		h.listHooks(rw)
+	case len(parts) == 3 && parts[0] == "api" && parts[1] == "hooks" && req.Method == http.MethodPost:
+		h.toggleHook(rw, req, parts[2])
+	case path == "api/websockets" && req.Method == http.MethodGet:
+		h.listWebsockets(rw)
+	case len(parts) == 3 && parts[0] == "api" && parts[1] == "websockets" && req.Method == http.MethodGet:
+		h.followWebsocket(rw, req, parts[2])
+	case path == "api/compact" && req.Method == http.MethodPost:
+		h.compact(rw)
+	default:
+		http.Error(rw, "not found", http.StatusNotFound)
+	}
+}
+
+// CompactResult is returned after compacting the store.
+type CompactResult struct {
+	Rewritten int `json:"rewritten"`
+}
+
+func (h *Handler) compact(rw http.ResponseWriter) {
+	n, err := h.Store.Compact()
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(rw, CompactResult{Rewritten: n})
+}
+
+func writeJSON(rw http.ResponseWriter, data interface{}) {
+	rw.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(rw).Encode(data)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (h *Handler) list(rw http.ResponseWriter, client string) {
+	summaries, err := h.Store.TxnSummaries()
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if client != "" {
+		summaries = store.FilterClientIP(summaries, client)
+	}
+
+	list := make([]TxnInfo, 0, len(summaries))
+	for _, summary := range summaries {
+		info := TxnInfo{
+			ID:         summary.ID,
+			Method:     summary.Method,
+			StatusCode: summary.StatusCode,
+			Edited:     summary.ReqEdited || summary.ResEdited,
+			HasNote:    summary.HasNote,
+			Error:      summary.Error,
+			ReqSize:    summary.ReqSize,
+			ResSize:    summary.ResSize,
+		}
+		if summary.Conn != nil {
+			info.ClientIP = summary.Conn.ClientIP
+		}
+		if summary.URL != nil {
+			info.URL = summary.URL.String()
+		}
+		list = append(list, info)
+	}
+
+	writeJSON(rw, list)
+}
+
+// readBody reads the body fully and replaces it with a reader over the same
+// bytes.
+func readBody(body *io.ReadCloser) ([]byte, error) {
+	if *body == nil {
+		return nil, nil
+	}
+
+	buf, err := ioutil.ReadAll(*body)
+	if err != nil {
+		return nil, err
+	}
+	_ = (*body).Close()
+	*body = ioutil.NopCloser(bytes.NewReader(buf))
+	return buf, nil
+}
+
+// message returns the detail view for a raw request or response.
+func (h *Handler) message(raw []byte, header http.Header, body []byte) *Message {
+	contentType := header.Get("Content-Type")
+	if h.Redactor.Enabled() {
+		raw = h.Redactor.Raw(raw)
+	}
+
+	msg := &Message{Raw: string(raw)}
+	if text, _, err := display.ToUTF8(contentType, raw); err == nil {
+		msg.Raw = string(text)
+	}
+
+	formatted, _, err := display.Pipeline{
+		display.Decompress{Encoding: header.Get("Content-Encoding")},
+		display.UTF8{},
+		h.Redactor,
+		display.Pretty{},
+	}.Transform(contentType, body)
+	if err == nil && !bytes.Equal(formatted, body) {
+		msg.Formatted = string(formatted)
+	}
+
+	// search the text shown, so that redacted tokens are skipped and tokens
+	// in compressed bodies are found
+	msg.JWTs = display.FindJWTs([]byte(msg.Raw + "\n" + msg.Formatted))
+
+	return msg
+}
+
+func (h *Handler) detail(rw http.ResponseWriter, rawID string) {
+	id, err := strconv.ParseUint(rawID, 10, 64)
+	if err != nil {
+		http.Error(rw, "invalid ID", http.StatusBadRequest)
+		return
+	}
+
+	txn, err := h.Store.GetTxn(id)
+	if err == badger.ErrKeyNotFound {
+		http.Error(rw, "not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	detail := TxnDetail{ID: id}
+	detail.Note, _ = h.Store.GetNote(id)
+	detail.Error, _ = h.Store.GetError(id)
+
+	req := txn.Req
+	if txn.ReqE != nil {
+		req = txn.ReqE
+	}
+
+	reqBody, err := readBody(&req.Body)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	raw, err := httputil.DumpRequest(req, true)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	detail.Request = h.message(raw, req.Header, reqBody)
+
+	res := txn.Res
+	if txn.ResE != nil {
+		res = txn.ResE
+	}
+
+	if res != nil {
+		resBody, err := readBody(&res.Body)
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		raw, err := httputil.DumpResponse(res, true)
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		detail.Response = h.message(raw, res.Header, resBody)
+	}
+
+	if result, err := h.Store.GetShadowResult(id); err == nil {
+		detail.Shadow = h.shadow(result)
+	}
+
+	writeJSON(rw, detail)
+}
+
+// shadow returns the detail view of a comparison with a shadow upstream.
+func (h *Handler) shadow(result *store.ShadowResult) *ShadowDetail {
+	detail := &ShadowDetail{
+		Error: result.Err,
+		Diff:  result.Diff,
+	}
+	if len(result.Shadow) == 0 {
+		return detail
+	}
+
+	res, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(result.Shadow)), nil)
+	if err != nil {
+		detail.Response = h.message(result.Shadow, http.Header{}, nil)
+		return detail
+	}
+	// the body is complete, it was read for the comparison
+	body, _ := readBody(&res.Body)
+	detail.Response = h.message(result.Shadow, res.Header, body)
+	return detail
+}
+
+func (h *Handler) resend(rw http.ResponseWriter, rawID string, diff bool, envName string) {
+	id, err := strconv.ParseUint(rawID, 10, 64)
+	if err != nil {
+		http.Error(rw, "invalid ID", http.StatusBadRequest)
+		return
+	}
+
+	var env replay.Environment
+	if envName != "" {
+		var ok bool
+		env, ok = h.Environments[envName]
+		if !ok {
+			http.Error(rw, fmt.Sprintf("unknown environment %q", envName), http.StatusBadRequest)
+			return
+		}
+		if diff {
+			http.Error(rw, "diff is not supported with an environment", http.StatusBadRequest)
+			return
+		}
+	}
+
+	var (
+		newID   uint64
+		res     *http.Response
+		changes []string
+	)
+	switch {
+	case env != nil:
+		newID, res, err = h.Replayer.ReplayEnv(id, env)
+	case diff:
+		newID, res, changes, err = h.Replayer.ReplayDiff(id)
+	default:
+		newID, res, err = h.Replayer.Replay(id)
+	}
+	if err != nil {
+		http.Error(rw, fmt.Sprintf("resending %d failed: %v", id, err), http.StatusBadGateway)
+		return
+	}
+
+	writeJSON(rw, ResendResult{
+		TxnInfo: TxnInfo{
+			ID:         newID,
+			Method:     res.Request.Method,
+			URL:        res.Request.URL.String(),
+			StatusCode: res.StatusCode,
+		},
+		Diff: changes,
+	})
+}
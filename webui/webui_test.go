@@ -0,0 +1,535 @@
+package webui
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/fd0/osmosis/proxy"
+	"github.com/fd0/osmosis/redact"
+	"github.com/fd0/osmosis/replay"
+	"github.com/fd0/osmosis/store"
+)
+
+func TestHandler(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "osmosis.testing.webui.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := store.New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Content-Type", "application/json")
+		_, _ = rw.Write([]byte(`{"hello":"world"}`))
+	}))
+	defer upstream.Close()
+
+	r := replay.New(s, nil)
+
+	req, err := http.NewRequest(http.MethodGet, upstream.URL+"/greeting", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, _, err := r.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewServer(New(s, r))
+	defer srv.Close()
+
+	get := func(path string) (*http.Response, []byte) {
+		res, err := http.Get(srv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf, err := ioutil.ReadAll(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = res.Body.Close()
+		return res, buf
+	}
+
+	res, body := get("/")
+	if res.StatusCode != http.StatusOK || !strings.Contains(string(body), "<title>osmosis</title>") {
+		t.Errorf("unexpected index page %v: %s", res.Status, body)
+	}
+
+	res, body = get("/api/txns/1")
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status %v: %s", res.Status, body)
+	}
+
+	var detail TxnDetail
+	err = json.Unmarshal(body, &detail)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if detail.ID != id {
+		t.Errorf("wrong ID, want %d, got %d", id, detail.ID)
+	}
+	if !strings.HasPrefix(detail.Request.Raw, "GET "+upstream.URL+"/greeting HTTP/1.1") {
+		t.Errorf("unexpected request: %q", detail.Request.Raw)
+	}
+	if detail.Response == nil || detail.Response.Formatted != "{\n  \"hello\": \"world\"\n}" {
+		t.Errorf("unexpected response: %+v", detail.Response)
+	}
+
+	res, _ = get("/api/txns/23")
+	if res.StatusCode != http.StatusNotFound {
+		t.Errorf("unexpected status for missing transaction: %v", res.Status)
+	}
+
+	res, err = http.Post(srv.URL+"/api/txns/1/resend", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var info TxnInfo
+	err = json.NewDecoder(res.Body).Decode(&info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = res.Body.Close()
+
+	if info.ID != id+1 || info.StatusCode != http.StatusOK {
+		t.Errorf("unexpected resend result: %+v", info)
+	}
+
+	res, err = http.Post(srv.URL+"/api/txns/1/resend?diff=1", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var result ResendResult
+	err = json.NewDecoder(res.Body).Decode(&result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = res.Body.Close()
+
+	if result.ID != id+2 || len(result.Diff) != 0 {
+		t.Errorf("unexpected resend result with diff: %+v", result)
+	}
+
+	res, err = http.Post(srv.URL+"/api/txns/1/resend?env=missing", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = res.Body.Close()
+	if res.StatusCode != http.StatusBadRequest {
+		t.Errorf("unexpected status for unknown environment: %v", res.Status)
+	}
+
+	var list []TxnInfo
+	_, body = get("/api/txns")
+	err = json.Unmarshal(body, &list)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 3 {
+		t.Errorf("wrong number of transactions listed: %v", list)
+	}
+	for _, info := range list {
+		if info.ReqSize == 0 || info.ResSize == 0 {
+			t.Errorf("sizes missing for transaction: %+v", info)
+		}
+	}
+
+	err = s.AddConnInfo(id+1, store.ConnInfo{ClientIP: "192.0.2.7"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	list = nil
+	_, body = get("/api/txns?client=192.0.2.7")
+	err = json.Unmarshal(body, &list)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0].ID != id+1 || list[0].ClientIP != "192.0.2.7" {
+		t.Errorf("wrong transactions listed for client: %+v", list)
+	}
+
+	mark := func(id string) (int, MarkResult) {
+		res, err := http.Post(srv.URL+"/api/txns/"+id+"/mark", "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+
+		var result MarkResult
+		if res.StatusCode == http.StatusOK {
+			err = json.NewDecoder(res.Body).Decode(&result)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		return res.StatusCode, result
+	}
+
+	if code, _ := mark("23"); code != http.StatusNotFound {
+		t.Errorf("marking unknown transaction returned status %v", code)
+	}
+
+	if _, result := mark("1"); result.Marked != 1 || result.Comparison != nil {
+		t.Errorf("unexpected result for first mark: %+v", result)
+	}
+
+	_, marked := mark("2")
+	cmp := marked.Comparison
+	if cmp == nil || cmp.A != 1 || cmp.B != 2 {
+		t.Fatalf("unexpected result for second mark: %+v", marked)
+	}
+	if len(cmp.Request) != 0 {
+		t.Errorf("identical requests reported as different: %v", cmp.Request)
+	}
+}
+
+func TestMarks(t *testing.T) {
+	var m Marks
+
+	if _, ok := m.Marked(); ok {
+		t.Fatal("new Marks has a transaction marked")
+	}
+
+	if first, _, complete := m.Mark(3); first != 3 || complete {
+		t.Fatalf("unexpected result for first mark: %v %v", first, complete)
+	}
+
+	// marking the same transaction again removes the mark
+	m.Mark(3)
+	if _, ok := m.Marked(); ok {
+		t.Fatal("transaction still marked")
+	}
+
+	m.Mark(5)
+	first, second, complete := m.Mark(2)
+	if first != 5 || second != 2 || !complete {
+		t.Fatalf("unexpected result for second mark: %v %v %v", first, second, complete)
+	}
+
+	if _, ok := m.Marked(); ok {
+		t.Fatal("marks not cleared after completing the pair")
+	}
+}
+
+type testHooks struct {
+	list []proxy.HookInfo
+}
+
+func (h *testHooks) Hooks() []proxy.HookInfo {
+	return h.list
+}
+
+func (h *testHooks) SetHookEnabled(name string, enabled bool) error {
+	for i := range h.list {
+		if h.list[i].Name == name {
+			h.list[i].Enabled = enabled
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown hook %q", name)
+}
+
+func TestHandlerHooks(t *testing.T) {
+	hooks := &testHooks{list: []proxy.HookInfo{
+		{Name: "user-agent", Scope: "all requests", Enabled: true},
+	}}
+
+	srv := httptest.NewServer(&Handler{Hooks: hooks})
+	defer srv.Close()
+
+	post := func(path string) (int, []proxy.HookInfo) {
+		res, err := http.Post(srv.URL+path, "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+
+		var list []proxy.HookInfo
+		if res.StatusCode == http.StatusOK {
+			err = json.NewDecoder(res.Body).Decode(&list)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		return res.StatusCode, list
+	}
+
+	code, list := post("/api/hooks/user-agent?enabled=false")
+	if code != http.StatusOK {
+		t.Fatalf("unexpected status %v", code)
+	}
+	if len(list) != 1 || list[0].Enabled {
+		t.Errorf("hook not disabled: %+v", list)
+	}
+	if hooks.list[0].Enabled {
+		t.Error("hook manager was not called")
+	}
+
+	if code, _ := post("/api/hooks/unknown?enabled=true"); code != http.StatusNotFound {
+		t.Errorf("toggling an unknown hook returned status %v", code)
+	}
+
+	if code, _ := post("/api/hooks/user-agent?enabled=maybe"); code != http.StatusBadRequest {
+		t.Errorf("invalid value returned status %v", code)
+	}
+}
+
+func TestHandlerWebsockets(t *testing.T) {
+	srv := httptest.NewServer(&Handler{Websockets: proxy.NewWebsocketFeed()})
+	defer srv.Close()
+
+	res, err := http.Get(srv.URL + "/api/websockets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var list []proxy.WebsocketConn
+	err = json.NewDecoder(res.Body).Decode(&list)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = res.Body.Close()
+	if list == nil || len(list) != 0 {
+		t.Errorf("unexpected list of websockets: %v", list)
+	}
+
+	for _, id := range []string{"23", "foo"} {
+		res, err = http.Get(srv.URL + "/api/websockets/" + id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = res.Body.Close()
+		if res.StatusCode != http.StatusNotFound {
+			t.Errorf("following unknown websocket %v returned status %v", id, res.Status)
+		}
+	}
+}
+
+func TestHandlerJWT(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "osmosis.testing.webui.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := store.New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	const token = "eyJhbGciOiJIUzI1NiIsInR5cCI6IkpXVCJ9." +
+		"eyJzdWIiOiIxMjM0NTY3ODkwIiwibmFtZSI6IkpvaG4gRG9lIiwiaWF0IjoxNTE2MjM5MDIyfQ." +
+		"SflKxwRJSMeKKF2QT4fwpMeJf36POk6yJV_adQssw5c"
+
+	req, err := http.NewRequest(http.MethodGet, "http://example.com/profile", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	err = s.AddRequest(1, req, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := New(s, replay.New(s, nil))
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	detail := func() TxnDetail {
+		res, err := http.Get(srv.URL + "/api/txns/1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+
+		var detail TxnDetail
+		err = json.NewDecoder(res.Body).Decode(&detail)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return detail
+	}
+
+	jwts := detail().Request.JWTs
+	if len(jwts) != 1 || jwts[0].Token != token || jwts[0].Payload["name"] != "John Doe" {
+		t.Errorf("wrong JWTs returned: %+v", jwts)
+	}
+
+	// redacted tokens are not decoded
+	h.Redactor = &redact.Redactor{Headers: redact.DefaultHeaders}
+	jwts = detail().Request.JWTs
+	if len(jwts) != 0 {
+		t.Errorf("redacted JWT was decoded: %+v", jwts)
+	}
+}
+
+func TestHandlerCompact(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "osmosis.testing.webui.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := store.New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	srv := httptest.NewServer(New(s, nil))
+	defer srv.Close()
+
+	res, err := http.Get(srv.URL + "/api/compact")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = res.Body.Close()
+	if res.StatusCode != http.StatusNotFound {
+		t.Errorf("GET returned status %v", res.StatusCode)
+	}
+
+	res, err = http.Post(srv.URL+"/api/compact", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status %v", res.StatusCode)
+	}
+
+	var result CompactResult
+	err = json.NewDecoder(res.Body).Decode(&result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Rewritten != 0 {
+		t.Errorf("empty store: want no files rewritten, got %d", result.Rewritten)
+	}
+}
+
+func TestHandlerError(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "osmosis.testing.webui.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := store.New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	req, err := http.NewRequest(http.MethodGet, "http://unreachable.example.com/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.AddRequest(1, req, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.SetError(1, "dial tcp: connection refused")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewServer(New(s, nil))
+	defer srv.Close()
+
+	res, err := http.Get(srv.URL + "/api/txns")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var list []TxnInfo
+	err = json.NewDecoder(res.Body).Decode(&list)
+	_ = res.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0].Error != "dial tcp: connection refused" || list[0].StatusCode != 0 {
+		t.Errorf("wrong list returned: %+v", list)
+	}
+
+	res, err = http.Get(srv.URL + "/api/txns/1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var detail TxnDetail
+	err = json.NewDecoder(res.Body).Decode(&detail)
+	_ = res.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if detail.Error != "dial tcp: connection refused" || detail.Request == nil || detail.Response != nil {
+		t.Errorf("wrong detail returned: %+v", detail)
+	}
+}
+
+func TestHandlerShadow(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "osmosis.testing.webui.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := store.New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	req, err := http.NewRequest(http.MethodGet, "http://example.com/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.AddRequest(1, req, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.SetShadowResult(1, store.ShadowResult{
+		Primary: []byte("HTTP/1.1 200 OK\r\nContent-Length: 7\r\n\r\nprimary"),
+		Shadow:  []byte("HTTP/1.1 404 Not Found\r\nContent-Length: 6\r\n\r\nshadow"),
+		Diff:    []string{"status: 200 != 404"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewServer(New(s, nil))
+	defer srv.Close()
+
+	res, err := http.Get(srv.URL + "/api/txns/1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var detail TxnDetail
+	err = json.NewDecoder(res.Body).Decode(&detail)
+	_ = res.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	shadow := detail.Shadow
+	if shadow == nil {
+		t.Fatalf("no shadow result returned: %+v", detail)
+	}
+	if strings.Join(shadow.Diff, "\n") != "status: 200 != 404" || shadow.Error != "" {
+		t.Errorf("wrong shadow result returned: %+v", shadow)
+	}
+	if shadow.Response == nil || !strings.HasSuffix(shadow.Response.Raw, "shadow") {
+		t.Errorf("wrong shadow response returned: %+v", shadow.Response)
+	}
+}